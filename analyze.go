@@ -0,0 +1,332 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"image/color"
+	"io"
+	"math"
+	"os"
+	"slices"
+	"time"
+
+	"golang.org/x/exp/maps"
+	"gonum.org/v1/gonum/stat"
+	"gonum.org/v1/plot"
+	"gonum.org/v1/plot/plotter"
+	"gonum.org/v1/plot/vg"
+
+	"sleep-stats/sleep"
+)
+
+type analyzeOptions struct {
+	maxLag    int
+	maxPeriod int
+	chart     string // the file the chart is written to, empty for none
+	date      string // the night of the analyses that can look at a single night
+	covariate string // the CSV of the daily variables compared with the nights
+	on        string // the date column of the covariates
+	screen    string // the Screen Time or RescueTime export
+	evening   int    // the hour the evening on screen starts
+	intake    string // the log of caffeine or alcohol
+	// the shortest gap between the episodes of sleep
+	episodeGap time.Duration
+}
+
+// the analyses of the analyze command, each prints its findings and writes a chart
+var analyses = map[string]func(ctx context.Context, w io.Writer, data *nightData, opts analyzeOptions) error{
+	"patterns":    analyzePatterns,
+	"cycles":      analyzeCycles,
+	"transitions": analyzeTransitions,
+	"awakenings":  analyzeAwakenings,
+	"hours":       analyzeHours,
+	"covariates":  analyzeCovariates,
+	"screen":      analyzeScreen,
+	"intake":      analyzeIntake,
+	"episodes":    analyzeEpisodes,
+}
+
+// analyzeCommand runs a statistical analysis of the nights, e.g. sleep-stats analyze patterns
+func analyzeCommand(fs *flag.FlagSet) func(ctx context.Context) error {
+	input := addInputFlags(fs)
+	maxLag := fs.Int("max-lag", 28, "the most nights apart the autocorrelation compares")
+	maxPeriod := fs.Int("max-period", 60, "the longest cycle in days the periodogram looks for")
+	chart := fs.String("chart", "", "file the chart is written to, defaults to <analysis>.<plot>, - writes it to stdout and the findings to stderr")
+	plotFormat := addPlotFlag(fs)
+	paletteName := addPaletteFlag(fs)
+	resolution := addResolutionFlags(fs)
+	open := addOpenFlag(fs)
+	date := fs.String("date", "", "night of the transitions in YYYY-MM-DD format, defaults to all nights")
+	covariate := fs.String("covariate", "", "CSV with a row per day of variables like temperature or pollen to correlate with the nights")
+	on := fs.String("on", "date", "the column of the dates in the -covariate CSV")
+	screen := fs.String("screen", "", "iOS Screen Time or RescueTime CSV export to correlate the evening on screen with the nights")
+	intake := fs.String("intake", "", "CSV log of caffeine or alcohol with the columns date, substance, amount and time")
+	evening := fs.Int("evening", 18, "the hour from which the time on screen counts as the evening")
+	episodeGap := fs.Duration("episode-gap", 30*time.Minute, "the shortest gap without sleep between two episodes")
+	return func(ctx context.Context) error {
+		if fs.NArg() == 0 {
+			return &exitError{fmt.Errorf("Usage: %s analyze <analysis> [flags], the analyses are %v", os.Args[0], sortedAnalyses()), exitUsage}
+		}
+		name := fs.Arg(0)
+		analyze, ok := analyses[name]
+		if !ok {
+			return &exitError{fmt.Errorf("unknown analysis %q, known analyses are %v", name, sortedAnalyses()), exitUsage}
+		}
+		// the flags can also follow the analysis
+		fs.Parse(fs.Args()[1:])
+		if err := checkPlotFormat(*plotFormat); err != nil {
+			return &exitError{err, exitUsage}
+		}
+		if err := usePalette(*paletteName); err != nil {
+			return &exitError{err, exitUsage}
+		}
+		if err := resolution.apply(); err != nil {
+			return &exitError{err, exitUsage}
+		}
+
+		data, err := input.analyze(ctx)
+		if err != nil {
+			return err
+		}
+		opts := analyzeOptions{maxLag: *maxLag, maxPeriod: *maxPeriod, chart: *chart, date: *date, covariate: *covariate, on: *on,
+			screen: *screen, evening: *evening, intake: *intake, episodeGap: *episodeGap}
+		switch {
+		case *plotFormat == "none":
+			opts.chart = ""
+		case opts.chart == "":
+			opts.chart = name + "." + *plotFormat
+		}
+		stdoutFormat = *plotFormat
+		findings := io.Writer(os.Stdout)
+		if opts.chart == stdoutFile {
+			findings = os.Stderr
+		}
+		if err := analyze(ctx, findings, data, opts); err != nil {
+			return err
+		}
+		data.printExcluded(input.diagnostics())
+		openAfterRun(*open, opts.chart)
+		return skippedRows(data.skipped)
+	}
+}
+
+func sortedAnalyses() []string {
+	names := maps.Keys(analyses)
+	slices.Sort(names)
+	return names
+}
+
+// autocorrelation returns the correlation of the values with themselves shifted by 1 to maxLag
+func autocorrelation(values []float64, maxLag int) []float64 {
+	var mean float64
+	for _, v := range values {
+		mean += v / float64(len(values))
+	}
+	var variance float64
+	for _, v := range values {
+		variance += (v - mean) * (v - mean)
+	}
+	acf := make([]float64, min(maxLag, len(values)-1))
+	if variance == 0 {
+		return acf
+	}
+	for lag := range acf {
+		var sum float64
+		for i := 0; i+lag+1 < len(values); i++ {
+			sum += (values[i] - mean) * (values[i+lag+1] - mean)
+		}
+		acf[lag] = sum / variance
+	}
+	return acf
+}
+
+// analyzePatterns reports the lags at which the total sleep of the days correlates with itself
+// beyond the 95% bound of noise, like bad nights following each other or a weekly rhythm, and
+// charts the autocorrelation
+func analyzePatterns(ctx context.Context, w io.Writer, data *nightData, opts analyzeOptions) error {
+	_, totals := dailySeries(data.nights, func(night *sleep.Night) float64 { return night.TotalAsleep().Hours() })
+	if len(totals) < 14 {
+		return fmt.Errorf("need at least 14 days of nights to find patterns, got %d", len(totals))
+	}
+	if opts.maxLag < 1 {
+		return errors.New("-max-lag has to be at least 1")
+	}
+	acf := autocorrelation(totals, opts.maxLag)
+	bound := 1.96 / math.Sqrt(float64(len(totals)))
+
+	fmt.Fprintf(w, "Autocorrelation of total sleep over %d days, significant beyond ±%.2f:\n", len(totals), bound)
+	significant := 0
+	for i, r := range acf {
+		if math.Abs(r) > bound {
+			fmt.Fprintf(w, "  lag %2d  %+.2f\n", i+1, r)
+			significant++
+		}
+	}
+	if significant == 0 {
+		fmt.Fprintln(w, "  none")
+	}
+	fmt.Fprintln(w)
+	switch {
+	case acf[0] > bound:
+		fmt.Fprintf(w, "Bad and good nights cluster, a night is like the one before (lag 1 %+.2f).\n", acf[0])
+	case acf[0] < -bound:
+		fmt.Fprintf(w, "Nights alternate, a short night is followed by a long one and the other way round (lag 1 %+.2f).\n", acf[0])
+	default:
+		fmt.Fprintln(w, "Nights don't follow the one before.")
+	}
+	if len(acf) >= 7 && acf[6] > bound {
+		fmt.Fprintf(w, "There is a weekly rhythm (lag 7 %+.2f).\n", acf[6])
+	} else {
+		fmt.Fprintln(w, "There is no weekly rhythm.")
+	}
+
+	if opts.chart == "" {
+		return nil
+	}
+	p := plot.New()
+	p.Title.Text = "Autocorrelation of Total Sleep"
+	p.X.Label.Text = "Lag (days)"
+	p.Y.Label.Text = "Correlation"
+	bars, err := plotter.NewBarChart(plotter.Values(acf), vg.Points(8))
+	if err != nil {
+		return err
+	}
+	bars.XMin = 1
+	bars.Color = color.RGBA{R: 0, G: 90, B: 200, A: 255}
+	bars.LineStyle.Width = 0
+	p.Add(bars)
+	for _, y := range []float64{bound, -bound} {
+		line, err := plotter.NewLine(plotter.XYs{{X: 0.5, Y: y}, {X: float64(len(acf)) + 0.5, Y: y}})
+		if err != nil {
+			return err
+		}
+		line.LineStyle.Color = color.RGBA{R: 200, G: 30, B: 30, A: 255}
+		line.LineStyle.Dashes = []vg.Length{vg.Points(4), vg.Points(3)}
+		p.Add(line)
+	}
+
+	return writeChart(ctx, p, 10*vg.Inch, 5*vg.Inch, opts.chart)
+}
+
+// a period found by the periodogram with its normalized power and the probability of a peak as
+// high in noise
+type cycle struct {
+	days       float64
+	power      float64
+	falseAlarm float64
+}
+
+// lombScargle returns the normalized power of the frequencies in the values sampled at the times,
+// which unlike a Fourier transform doesn't need evenly spaced samples
+func lombScargle(times, values, freqs []float64) []float64 {
+	mean, variance := stat.MeanVariance(values, nil)
+	power := make([]float64, len(freqs))
+	if variance == 0 {
+		return power
+	}
+	for i, f := range freqs {
+		omega := 2 * math.Pi * f
+		var sin2, cos2 float64
+		for _, t := range times {
+			sin2 += math.Sin(2 * omega * t)
+			cos2 += math.Cos(2 * omega * t)
+		}
+		tau := math.Atan2(sin2, cos2) / (2 * omega)
+		var yc, ys, cc, ss float64
+		for j, t := range times {
+			c, s := math.Cos(omega*(t-tau)), math.Sin(omega*(t-tau))
+			yc += (values[j] - mean) * c
+			ys += (values[j] - mean) * s
+			cc += c * c
+			ss += s * s
+		}
+		power[i] = (yc*yc/cc + ys*ys/ss) / (2 * variance)
+	}
+	return power
+}
+
+// the highest local maxima of the power, at most n
+func topCycles(freqs, power []float64, samples, n int) []cycle {
+	var cycles []cycle
+	for i := 1; i+1 < len(power); i++ {
+		if power[i] > power[i-1] && power[i] >= power[i+1] {
+			// the number of independent frequencies is about the number of samples
+			falseAlarm := 1 - math.Pow(1-math.Exp(-power[i]), float64(samples))
+			cycles = append(cycles, cycle{days: 1 / freqs[i], power: power[i], falseAlarm: falseAlarm})
+		}
+	}
+	slices.SortFunc(cycles, func(a, b cycle) int { return cmp.Or(cmp.Compare(b.power, a.power), cmp.Compare(a.days, b.days)) })
+	return cycles[:min(n, len(cycles))]
+}
+
+// analyzeCycles ranks the periods of the dominant cycles in total sleep and bedtime by a
+// Lomb-Scargle periodogram of the nights, missing nights are simply left out, and charts the
+// periodograms
+func analyzeCycles(ctx context.Context, w io.Writer, data *nightData, opts analyzeOptions) error {
+	if len(data.nights) < 14 {
+		return fmt.Errorf("need at least 14 nights to find cycles, got %d", len(data.nights))
+	}
+	first := data.nights[0].Date
+	span := data.nights[len(data.nights)-1].Date.Sub(first).Hours() / 24
+	maxPeriod := min(float64(opts.maxPeriod), span/2)
+	if maxPeriod <= 2 {
+		return errors.New("the nights span too few days to find cycles")
+	}
+	// oversample the frequencies so the peaks aren't missed between them
+	var freqs []float64
+	step := 1 / (5 * span)
+	for f := 1 / maxPeriod; f <= 0.5; f += step {
+		freqs = append(freqs, f)
+	}
+
+	p := plot.New()
+	p.Title.Text = "Periodogram"
+	p.X.Label.Text = "Period (days)"
+	p.Y.Label.Text = "Normalized power"
+	p.Legend.Top = true
+	for i, series := range []struct {
+		name  string
+		value func(night *sleep.Night) float64
+	}{
+		{"Total sleep", func(night *sleep.Night) float64 { return night.TotalAsleep().Hours() }},
+		{"Bedtime", bedtime},
+	} {
+		times := make([]float64, len(data.nights))
+		values := make([]float64, len(data.nights))
+		for j, night := range data.nights {
+			times[j] = night.Date.Sub(first).Hours() / 24
+			values[j] = series.value(night)
+		}
+		power := lombScargle(times, values, freqs)
+
+		fmt.Fprintf(w, "%s cycles by strength:\n", series.name)
+		for _, c := range topCycles(freqs, power, len(values), 5) {
+			note := ""
+			if math.Abs(c.days-7) < 0.5 {
+				note = "  weekly"
+			}
+			fmt.Fprintf(w, "  %6.1f days  power %5.1f  false alarm %.3f%s\n", c.days, c.power, c.falseAlarm, note)
+		}
+		fmt.Fprintln(w)
+
+		points := make(plotter.XYs, len(freqs))
+		for j, f := range freqs {
+			points[j] = plotter.XY{X: 1 / f, Y: power[j]}
+		}
+		line, err := plotter.NewLine(points)
+		if err != nil {
+			return err
+		}
+		line.LineStyle.Color = derivedColors[i%len(derivedColors)]
+		p.Add(line)
+		p.Legend.Add(series.name, line)
+	}
+	fmt.Fprintln(w, "A false alarm probability below 0.01 is a cycle unlikely to be noise.")
+	if opts.chart == "" {
+		return nil
+	}
+	return writeChart(ctx, p, 10*vg.Inch, 5*vg.Inch, opts.chart)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"image"
+	"image/color/palette"
+	imgdraw "image/draw"
+	"image/gif"
+	"io"
+
+	"gonum.org/v1/plot/vg"
+	"gonum.org/v1/plot/vg/draw"
+	"gonum.org/v1/plot/vg/vgimg"
+
+	"sleep-stats/sleep"
+)
+
+const (
+	maxAnimationFrames = 60
+	frameDelay         = 10  // in 100ths of a second
+	lastFrameDelay     = 300 // hold the final frame so the end result can be read
+)
+
+// createAnimation writes an animated GIF of the plot. With a window of 0 the nights are revealed
+// chronologically, otherwise a window of that many nights slides across the data.
+func createAnimation(ctx context.Context, nights []*sleep.Night, derived derivedStats, opts plotOptions, window int, filename string) error {
+	if len(nights) < 2 {
+		return errors.New("need at least 2 nights to animate")
+	}
+
+	// use the axis ranges of the full plot so the frames don't jump around
+	full := buildPlot(nights, derived, opts)
+
+	first := 2 // need at least 2 points for the regression lines
+	if window > 0 {
+		first = max(2, min(window, len(nights)))
+	}
+	step := max(1, (len(nights)-first)/maxAnimationFrames)
+
+	anim := &gif.GIF{}
+	for end := first; end <= len(nights); end += step {
+		// drawing the frames is slow, stop between them when cancelled
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		begin := 0
+		if window > 0 {
+			begin = end - first
+		}
+		p := buildPlot(nights[begin:end], derived, opts)
+		if window == 0 {
+			p.X.Min, p.X.Max = full.X.Min, full.X.Max
+		}
+		p.Y.Min, p.Y.Max = full.Y.Min, full.Y.Max
+
+		c := vgimg.NewWith(vgimg.UseWH(15*vg.Inch, 8*vg.Inch), vgimg.UseDPI(rasterDPI))
+		p.Draw(draw.New(c))
+		img := c.Image()
+
+		frame := image.NewPaletted(img.Bounds(), palette.Plan9)
+		imgdraw.FloydSteinberg.Draw(frame, img.Bounds(), img, image.Point{})
+		anim.Image = append(anim.Image, frame)
+		anim.Delay = append(anim.Delay, frameDelay)
+
+		// make sure the last night always ends up in a frame
+		if end < len(nights) && end+step > len(nights) {
+			end = len(nights) - step
+		}
+	}
+	if len(anim.Delay) > 0 {
+		anim.Delay[len(anim.Delay)-1] = lastFrameDelay
+	}
+
+	return writeFile(ctx, filename, func(w io.Writer) error {
+		return gif.EncodeAll(w, anim)
+	})
+}
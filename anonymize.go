@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"io"
+	"math/rand/v2"
+	"os"
+	"time"
+
+	"sleep-stats/sleep"
+	"sleep-stats/source/apple"
+)
+
+// the most days -shift-dates moves the sessions either way
+const maxDateShift = 365
+
+// anonymizeCommand writes the sessions as an Apple Health export CSV that can be shared, without
+// the names of the devices and with the times rounded, e.g. sleep-stats anonymize -shift-dates -o shared.csv
+func anonymizeCommand(fs *flag.FlagSet) func(ctx context.Context) error {
+	input := addInputFlags(fs)
+	out := fs.String("o", "", "file the anonymized CSV is written to, defaults to stdout")
+	shift := fs.Bool("shift-dates", false, "move all sessions by the same random number of days, keeping the time of day")
+	round := fs.Duration("round", time.Minute, "round the start and end of the sessions to this, 0 keeps them")
+	return func(ctx context.Context) error {
+		sessions, skipped, err := input.load(ctx)
+		if err != nil {
+			return err
+		}
+		var days int
+		if *shift {
+			for days == 0 {
+				days = rand.IntN(2*maxDateShift+1) - maxDateShift
+			}
+		}
+		sessions = anonymize(sessions, days, *round)
+
+		write := func(w io.Writer) error { return apple.Write(w, sessions) }
+		if *out == "" {
+			err = write(os.Stdout)
+		} else {
+			err = writeFile(ctx, *out, write)
+		}
+		if err != nil {
+			return err
+		}
+		printSkipped(input.diagnostics(), skipped)
+		return skippedRows(skipped)
+	}
+}
+
+// anonymize replaces the source names and product types with generic ones, moves the sessions by
+// the days and rounds their times. Sessions rounded away are dropped.
+func anonymize(sessions []sleep.Session, days int, round time.Duration) []sleep.Session {
+	anonymized := make([]sleep.Session, 0, len(sessions))
+	for _, s := range sessions {
+		s.Start = s.Start.AddDate(0, 0, days).Round(round)
+		s.End = s.End.AddDate(0, 0, days).Round(round)
+		if !s.End.After(s.Start) {
+			continue
+		}
+		// the apple source only reads the sessions of a watch
+		s.SourceName, s.ProductType = "Apple Watch", "Watch"
+		anonymized = append(anonymized, s)
+	}
+	return anonymized
+}
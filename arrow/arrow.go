@@ -0,0 +1,165 @@
+// Package arrow writes tables of non-null columns in the Apache Arrow IPC streaming format, which
+// pyarrow, polars and R's arrow package read without copying.
+//
+// The whole table is written as a single record batch.
+package arrow
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+// the types of the Type union in the schema
+const (
+	typeInt           = 2
+	typeFloatingPoint = 3
+	typeUtf8          = 5
+	typeDate          = 8
+	typeTimestamp     = 10
+)
+
+const (
+	metadataV5          = 4
+	headerSchema        = 1
+	headerRecordBatch   = 3
+	precisionDouble     = 2
+	dateUnitDay         = 0
+	timeUnitMillisecond = 1
+)
+
+// Column is a named column of values
+type Column struct {
+	name    string
+	typ     fbTable // the type table
+	typeID  uint8
+	count   int
+	offsets []byte // the value offsets of variable length types
+	data    []byte
+}
+
+// String is a column of UTF-8 text
+func String(name string, values []string) Column {
+	c := Column{name: name, typ: fbTable{}, typeID: typeUtf8, count: len(values)}
+	c.offsets = binary.LittleEndian.AppendUint32(c.offsets, 0)
+	for _, v := range values {
+		c.data = append(c.data, v...)
+		c.offsets = binary.LittleEndian.AppendUint32(c.offsets, uint32(len(c.data)))
+	}
+	return c
+}
+
+// Double is a column of 64-bit floats
+func Double(name string, values []float64) Column {
+	c := Column{name: name, typ: fbTable{fbInt16(precisionDouble)}, typeID: typeFloatingPoint, count: len(values)}
+	for _, v := range values {
+		c.data = binary.LittleEndian.AppendUint64(c.data, math.Float64bits(v))
+	}
+	return c
+}
+
+// Int32 is a column of signed 32-bit integers
+func Int32(name string, values []int32) Column {
+	c := Column{name: name, typ: fbTable{fbInt32(32), fbBool(true)}, typeID: typeInt, count: len(values)}
+	for _, v := range values {
+		c.data = binary.LittleEndian.AppendUint32(c.data, uint32(v))
+	}
+	return c
+}
+
+// Date is a column of calendar dates, the time of day is ignored
+func Date(name string, values []time.Time) Column {
+	c := Column{name: name, typ: fbTable{fbInt16(dateUnitDay)}, typeID: typeDate, count: len(values)}
+	for _, v := range values {
+		y, m, d := v.Date()
+		days := time.Date(y, m, d, 0, 0, 0, 0, time.UTC).Unix() / (24 * 60 * 60)
+		c.data = binary.LittleEndian.AppendUint32(c.data, uint32(days))
+	}
+	return c
+}
+
+// Timestamp is a column of instants in UTC with millisecond precision
+func Timestamp(name string, values []time.Time) Column {
+	typ := fbTable{fbInt16(timeUnitMillisecond), fbRef(fbString("UTC"))}
+	c := Column{name: name, typ: typ, typeID: typeTimestamp, count: len(values)}
+	for _, v := range values {
+		c.data = binary.LittleEndian.AppendUint64(c.data, uint64(v.UnixMilli()))
+	}
+	return c
+}
+
+// WriteStream writes the columns as an Arrow IPC stream, every column has to have the same number
+// of values
+func WriteStream(w io.Writer, columns ...Column) error {
+	rows := 0
+	if len(columns) > 0 {
+		rows = columns[0].count
+	}
+	for _, c := range columns {
+		if c.count != rows {
+			return fmt.Errorf("arrow: column %s has %d values, expected %d", c.name, c.count, rows)
+		}
+	}
+
+	var stream bytes.Buffer
+	fields := make(fbVector, len(columns))
+	for i, c := range columns {
+		fields[i] = fbTable{
+			fbRef(fbString(c.name)),
+			fbBool(false), // not nullable
+			fbUint8(c.typeID),
+			fbRef(c.typ),
+			{},
+			fbRef(fbVector{}), // readers expect the children even when there are none
+		}
+	}
+	schema := fbTable{fbInt16(0), fbRef(fields)} // little endian
+	writeMessage(&stream, headerSchema, schema, nil)
+
+	// each column has an empty validity bitmap as nothing is null, then its offsets and data
+	var body bytes.Buffer
+	var nodes, buffers []int64
+	addBuffer := func(data []byte) {
+		buffers = append(buffers, int64(body.Len()), int64(len(data)))
+		body.Write(data)
+		for body.Len()%8 != 0 {
+			body.WriteByte(0)
+		}
+	}
+	for _, c := range columns {
+		nodes = append(nodes, int64(c.count), 0)
+		addBuffer(nil)
+		if c.offsets != nil {
+			addBuffer(c.offsets)
+		}
+		addBuffer(c.data)
+	}
+	batch := fbTable{
+		fbInt64(int64(rows)),
+		fbRef(fbStructs{len(columns), nodes}),
+		fbRef(fbStructs{len(buffers) / 2, buffers}),
+	}
+	writeMessage(&stream, headerRecordBatch, batch, body.Bytes())
+
+	// the end of the stream
+	stream.Write([]byte{0xff, 0xff, 0xff, 0xff, 0, 0, 0, 0})
+	_, err := stream.WriteTo(w)
+	return err
+}
+
+// a message is a continuation marker, the size of the metadata, the metadata and then the body
+func writeMessage(w *bytes.Buffer, headerType uint8, header fbTable, body []byte) {
+	metadata := finish(fbTable{
+		fbInt16(metadataV5),
+		fbUint8(headerType),
+		fbRef(header),
+		fbInt64(int64(len(body))),
+	})
+	w.Write([]byte{0xff, 0xff, 0xff, 0xff})
+	w.Write(binary.LittleEndian.AppendUint32(nil, uint32(len(metadata))))
+	w.Write(metadata)
+	w.Write(body)
+}
@@ -0,0 +1,155 @@
+package arrow
+
+import "encoding/binary"
+
+// a minimal FlatBuffers encoder for the IPC metadata. Unlike the usual builders that work back to
+// front, objects are laid out front to back: a table is followed by its vtable and then by the
+// objects it references, which keeps every offset pointing forward as the format requires.
+
+type fbObject interface {
+	// write appends the object and returns the position offsets to it point at
+	write(b *fbBuilder) int
+}
+
+type fbBuilder struct {
+	buf []byte
+}
+
+func (b *fbBuilder) pad(align int) {
+	for len(b.buf)%align != 0 {
+		b.buf = append(b.buf, 0)
+	}
+}
+
+// finish returns the buffer with the root table, padded to 8 bytes for the IPC framing
+func finish(root fbObject) []byte {
+	b := &fbBuilder{buf: make([]byte, 4)}
+	pos := root.write(b)
+	binary.LittleEndian.PutUint32(b.buf, uint32(pos))
+	b.pad(8)
+	return b.buf
+}
+
+// fbField is a field of a table, either an inline scalar or a reference to another object
+type fbField struct {
+	scalar []byte
+	ref    fbObject
+}
+
+// fbTable is a table with its fields by id, missing fields have their default values
+type fbTable []fbField
+
+func (t fbTable) write(b *fbBuilder) int {
+	b.pad(4)
+	start := len(b.buf)
+	b.buf = append(b.buf, 0, 0, 0, 0) // the offset to the vtable
+
+	type pending struct {
+		at  int
+		ref fbObject
+	}
+	var refs []pending
+	offsets := make([]uint16, len(t))
+	for id, f := range t {
+		switch {
+		case f.ref != nil:
+			b.pad(4)
+			offsets[id] = uint16(len(b.buf) - start)
+			refs = append(refs, pending{len(b.buf), f.ref})
+			b.buf = append(b.buf, 0, 0, 0, 0)
+		case f.scalar != nil:
+			b.pad(len(f.scalar))
+			offsets[id] = uint16(len(b.buf) - start)
+			b.buf = append(b.buf, f.scalar...)
+		}
+	}
+	size := len(b.buf) - start
+
+	b.pad(2)
+	vtable := len(b.buf)
+	b.buf = binary.LittleEndian.AppendUint16(b.buf, uint16(4+2*len(t)))
+	b.buf = binary.LittleEndian.AppendUint16(b.buf, uint16(size))
+	for _, offset := range offsets {
+		b.buf = binary.LittleEndian.AppendUint16(b.buf, offset)
+	}
+	// the vtable is found by subtracting this from the table position
+	binary.LittleEndian.PutUint32(b.buf[start:], uint32(int32(start-vtable)))
+
+	for _, p := range refs {
+		pos := p.ref.write(b)
+		binary.LittleEndian.PutUint32(b.buf[p.at:], uint32(pos-p.at))
+	}
+	return start
+}
+
+type fbString string
+
+func (s fbString) write(b *fbBuilder) int {
+	b.pad(4)
+	pos := len(b.buf)
+	b.buf = binary.LittleEndian.AppendUint32(b.buf, uint32(len(s)))
+	b.buf = append(append(b.buf, s...), 0)
+	return pos
+}
+
+// fbVector is a vector of tables or strings
+type fbVector []fbObject
+
+func (v fbVector) write(b *fbBuilder) int {
+	b.pad(4)
+	pos := len(b.buf)
+	b.buf = binary.LittleEndian.AppendUint32(b.buf, uint32(len(v)))
+	at := len(b.buf)
+	b.buf = append(b.buf, make([]byte, 4*len(v))...)
+	for i, item := range v {
+		itemPos := item.write(b)
+		slot := at + 4*i
+		binary.LittleEndian.PutUint32(b.buf[slot:], uint32(itemPos-slot))
+	}
+	return pos
+}
+
+// fbStructs is a vector of structs made of 64-bit values, the elements are aligned to 8 bytes
+type fbStructs struct {
+	count int
+	data  []int64
+}
+
+func (v fbStructs) write(b *fbBuilder) int {
+	for (len(b.buf)+4)%8 != 0 {
+		b.buf = append(b.buf, 0)
+	}
+	pos := len(b.buf)
+	b.buf = binary.LittleEndian.AppendUint32(b.buf, uint32(v.count))
+	for _, x := range v.data {
+		b.buf = binary.LittleEndian.AppendUint64(b.buf, uint64(x))
+	}
+	return pos
+}
+
+func fbInt16(v int16) fbField {
+	return fbField{scalar: binary.LittleEndian.AppendUint16(nil, uint16(v))}
+}
+
+func fbInt32(v int32) fbField {
+	return fbField{scalar: binary.LittleEndian.AppendUint32(nil, uint32(v))}
+}
+
+func fbInt64(v int64) fbField {
+	return fbField{scalar: binary.LittleEndian.AppendUint64(nil, uint64(v))}
+}
+
+func fbUint8(v uint8) fbField {
+	return fbField{scalar: []byte{v}}
+}
+
+func fbBool(v bool) fbField {
+	if v {
+		return fbUint8(1)
+	}
+	return fbUint8(0)
+}
+
+func fbRef(o fbObject) fbField {
+	return fbField{ref: o}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"time"
+
+	"gonum.org/v1/gonum/floats"
+	"gonum.org/v1/gonum/stat"
+)
+
+// assertFlags collects the repeated -assert flags
+type assertFlags []string
+
+func (a *assertFlags) String() string { return strings.Join(*a, ", ") }
+
+func (a *assertFlags) Set(value string) error {
+	*a = append(*a, value)
+	return nil
+}
+
+// the values of every night in date order for evaluating aggregates
+type nightSeries struct {
+	dates []time.Time
+	vars  []map[string]float64
+}
+
+func newNightSeries(data *nightData) *nightSeries {
+	series := &nightSeries{}
+	for _, night := range data.nights {
+		series.dates = append(series.dates, night.Date)
+		series.vars = append(series.vars, allNightVars(night, data.derived))
+	}
+	return series
+}
+
+// the values of x for the nights in the last days before and including the latest night
+func (s *nightSeries) window(x expr, days int) []float64 {
+	var values []float64
+	for i, date := range s.dates {
+		if days == 0 || date.After(s.dates[len(s.dates)-1].AddDate(0, 0, -days)) {
+			values = append(values, x.eval(s.vars[i]))
+		}
+	}
+	return values
+}
+
+// an aggregate call in an assertion, remembering its value for the failure message. The values
+// are in hours for durations like the other expressions.
+type aggExpr struct {
+	text   string
+	reduce func(values []float64) float64
+	x      expr
+	days   int
+	series *nightSeries
+	value  float64
+}
+
+func (e *aggExpr) eval(map[string]float64) float64 {
+	values := e.series.window(e.x, e.days)
+	if len(values) == 0 {
+		e.value = math.NaN()
+	} else {
+		e.value = e.reduce(values)
+	}
+	return e.value
+}
+
+var assertAggregates = map[string]func(values []float64) float64{
+	"avg":    func(values []float64) float64 { return stat.Mean(values, nil) },
+	"median": median,
+	"min":    slices.Min[[]float64],
+	"max":    slices.Max[[]float64],
+	"sum":    floats.Sum,
+	// the number of nights the expression is true (non zero) for, e.g. count(total < 6h, 14d)
+	"count": func(values []float64) float64 {
+		return float64(floats.Count(func(v float64) bool { return v != 0 }, values))
+	},
+}
+
+// checkAssertions evaluates every assertion against the nights and returns a message for each one
+// that doesn't hold, e.g. avg(total, 7d) >= 6h30m
+func checkAssertions(assertions []string, data *nightData) ([]string, error) {
+	series := newNightSeries(data)
+	known := append(slices.Clone(baseMetricNames), data.derived.names...)
+
+	var failures []string
+	for _, assertion := range assertions {
+		var calls []*aggExpr
+		aggregates := make(map[string]aggregateFunc, len(assertAggregates))
+		for name, reduce := range assertAggregates {
+			aggregates[name] = func(text string, x expr, days int) expr {
+				call := &aggExpr{text: text, reduce: reduce, x: x, days: days, series: series}
+				calls = append(calls, call)
+				return call
+			}
+		}
+		e, err := parseExprWith(assertion, func(name string) bool { return slices.Contains(known, name) }, aggregates)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -assert %q: %w", assertion, err)
+		}
+
+		if e.eval(nil) == 0 {
+			msg := "assertion failed: " + assertion
+			for i, call := range calls {
+				if i == 0 {
+					msg += " ("
+				} else {
+					msg += ", "
+				}
+				msg += fmt.Sprintf("%s = %.2f", call.text, call.value)
+			}
+			if len(calls) > 0 {
+				msg += ")"
+			}
+			failures = append(failures, msg)
+		}
+	}
+	return failures, nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"sleep-stats/sleep"
+)
+
+// assertData has four nights from 2024-01-01 asleep 6, 7, 8 and 5 hours
+func assertData() *nightData {
+	data := &nightData{}
+	for i, hours := range []int{6, 7, 8, 5} {
+		date := time.Date(2024, 1, 1+i, 0, 0, 0, 0, time.UTC)
+		start := date.Add(22 * time.Hour)
+		data.nights = append(data.nights, &sleep.Night{Date: date, Sessions: []sleep.Session{
+			{Start: start, End: start.Add(time.Duration(hours) * time.Hour), Stage: sleep.Core},
+		}})
+	}
+	return data
+}
+
+func TestCheckAssertions(t *testing.T) {
+	tests := []struct {
+		assertion string
+		failure   string // the message, empty when the assertion holds
+	}{
+		{"avg(total) >= 6h", ""},
+		{"avg(total, 2d) >= 6h30m", ""},
+		{"avg(total, 2d) >= 7h", "assertion failed: avg(total, 2d) >= 7h (avg(total, 2d) = 6.50)"},
+		{"min(total) > 4h && max(total) < 9h", ""},
+		{"sum(total) == 26", ""},
+		{"median(total, 3d) == 7", ""},
+		{"count(total < 6h, 14d) == 0", "assertion failed: count(total < 6h, 14d) == 0 (count(total < 6h, 14d) = 1.00)"},
+		{"count(total < 6h) + max(total, 1d) >= 6", ""},
+		{"max(weekday) == Sun", "assertion failed: max(weekday) == Sun (max(weekday) = 4.00)"},
+	}
+	for _, test := range tests {
+		failures, err := checkAssertions([]string{test.assertion}, assertData())
+		if err != nil {
+			t.Errorf("%s: %v", test.assertion, err)
+			continue
+		}
+		switch {
+		case test.failure == "" && len(failures) > 0:
+			t.Errorf("%s failed with %q, want it to hold", test.assertion, failures[0])
+		case test.failure != "" && len(failures) == 0:
+			t.Errorf("%s holds, want %q", test.assertion, test.failure)
+		case test.failure != "" && failures[0] != test.failure:
+			t.Errorf("%s failed with %q, want %q", test.assertion, failures[0], test.failure)
+		}
+	}
+}
+
+func TestCheckAssertionsErrors(t *testing.T) {
+	tests := []struct {
+		assertion string
+		err       string
+	}{
+		{"avg(total, 7) > 6h", `avg window "7" at position 11 should be a number of days like 7d`},
+		{"avg(total, 0d) > 6h", `avg window "0d" at position 11 should be a number of days like 7d`},
+		{"avg(avg(total)) > 6h", `unknown function "avg" at position 4`},
+		{"avg(total > 6h", "missing ) at position 14"},
+		{"avg(sleep) > 6h", `unknown name "sleep" at position 4`},
+	}
+	for _, test := range tests {
+		_, err := checkAssertions([]string{test.assertion}, assertData())
+		if err == nil {
+			t.Errorf("%s checked, want the error %q", test.assertion, test.err)
+		} else if !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: got the error %q, want %q", test.assertion, err, test.err)
+		}
+	}
+}
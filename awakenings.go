@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image/color"
+	"io"
+	"time"
+
+	"gonum.org/v1/plot"
+	"gonum.org/v1/plot/plotter"
+	"gonum.org/v1/plot/vg"
+
+	"sleep-stats/sleep"
+)
+
+const (
+	// awakenings shorter than this are micro-arousals that are hardly noticed
+	briefAwakening = 2 * time.Minute
+	// awakenings longer than this are remembered and cost sleep
+	longAwakening = 15 * time.Minute
+	// the histogram counts the longer awakenings in its last bin
+	histogramMinutes = 60
+)
+
+// awakeningCounts splits the awake sessions of nights by their length
+type awakeningCounts struct {
+	brief, medium, long int
+	longest             time.Duration
+}
+
+func countAwakenings(sessions []sleep.Session) awakeningCounts {
+	var c awakeningCounts
+	for _, session := range sessions {
+		if session.Stage != sleep.Awake {
+			continue
+		}
+		switch d := session.Duration(); {
+		case d < briefAwakening:
+			c.brief++
+		case d > longAwakening:
+			c.long++
+		default:
+			c.medium++
+		}
+		c.longest = max(c.longest, session.Duration())
+	}
+	return c
+}
+
+// analyzeAwakenings prints how many of the awakenings of each night and of all nights were brief,
+// medium or long and charts a histogram of their lengths
+func analyzeAwakenings(ctx context.Context, w io.Writer, data *nightData, opts analyzeOptions) error {
+	var lengths []float64
+	for _, night := range data.nights {
+		for _, session := range night.Sessions {
+			if session.Stage == sleep.Awake {
+				lengths = append(lengths, min(session.Duration().Minutes(), histogramMinutes))
+			}
+		}
+	}
+	if len(lengths) == 0 {
+		return errors.New("no awake sessions to analyze")
+	}
+
+	fmt.Fprintf(w, "Awakenings shorter than %s are brief and longer than %s long:\n", formatDuration(briefAwakening), formatDuration(longAwakening))
+	fmt.Fprintf(w, "%-10s  %6s  %6s  %6s  %8s\n", "Night", "brief", "medium", "long", "longest")
+	var total awakeningCounts
+	withLong := 0
+	for _, night := range data.nights {
+		c := countAwakenings(night.Sessions)
+		if c.long > 0 {
+			withLong++
+		}
+		fmt.Fprintf(w, "%-10s  %6d  %6d  %6d  %8s\n", night.Key(), c.brief, c.medium, c.long, formatDuration(c.longest))
+		total.brief += c.brief
+		total.medium += c.medium
+		total.long += c.long
+		total.longest = max(total.longest, c.longest)
+	}
+	n := float64(len(data.nights))
+	fmt.Fprintf(w, "%-10s  %6d  %6d  %6d  %8s\n", "Total", total.brief, total.medium, total.long, formatDuration(total.longest))
+	fmt.Fprintf(w, "%-10s  %6.1f  %6.1f  %6.1f\n", "Per night", float64(total.brief)/n, float64(total.medium)/n, float64(total.long)/n)
+	fmt.Fprintf(w, "\n%.0f%% of the nights had a long awakening.\n", 100*float64(withLong)/n)
+
+	if opts.chart == "" {
+		return nil
+	}
+	p := plot.New()
+	p.Title.Text = "Awakenings by Length"
+	p.X.Label.Text = fmt.Sprintf("Minutes (%d+ in the last bin)", histogramMinutes)
+	p.Y.Label.Text = "Awakenings"
+	hist, err := plotter.NewHist(plotter.Values(lengths), histogramMinutes/2)
+	if err != nil {
+		return err
+	}
+	hist.FillColor = color.RGBA{R: 0, G: 90, B: 200, A: 255}
+	hist.LineStyle.Width = 0
+	p.Add(hist)
+	highest := 0.0
+	for _, bin := range hist.Bins {
+		highest = max(highest, bin.Weight)
+	}
+	for _, limit := range []time.Duration{briefAwakening, longAwakening} {
+		line, err := plotter.NewLine(plotter.XYs{{X: limit.Minutes(), Y: 0}, {X: limit.Minutes(), Y: highest}})
+		if err != nil {
+			return err
+		}
+		line.LineStyle.Color = color.RGBA{R: 200, G: 30, B: 30, A: 255}
+		line.LineStyle.Dashes = []vg.Length{vg.Points(4), vg.Points(3)}
+		p.Add(line)
+	}
+	return writeChart(ctx, p, 10*vg.Inch, 5*vg.Inch, opts.chart)
+}
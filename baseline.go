@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"gonum.org/v1/gonum/stat"
+
+	"sleep-stats/sleep"
+)
+
+// parseDays parses a number of days like 14d, empty is 0
+func parseDays(s string) (int, error) {
+	if s == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(strings.TrimSuffix(s, "d"))
+	if err != nil || !strings.HasSuffix(s, "d") || n <= 0 {
+		return 0, fmt.Errorf("invalid number of days %q, use a number like 14d", s)
+	}
+	return n, nil
+}
+
+// baselineComparison is how a metric of the recent nights differs from the baseline before them
+type baselineComparison struct {
+	metric           string
+	recent, baseline float64
+	p                float64 // the probability of a difference at least this large by chance
+}
+
+// the relative change, NaN without a baseline to compare with
+func (c baselineComparison) change() float64 {
+	if c.baseline == 0 {
+		return math.NaN()
+	}
+	return (c.recent - c.baseline) / math.Abs(c.baseline)
+}
+
+// the flag of a significant change like "deep down 18% vs baseline, worse", empty for the others
+func (c baselineComparison) flag() string {
+	if c.p >= trendSignificance || math.IsNaN(c.change()) {
+		return ""
+	}
+	direction := "up"
+	if c.recent < c.baseline {
+		direction = "down"
+	}
+	flag := fmt.Sprintf("%s %s %.0f%% vs baseline", c.metric, direction, 100*math.Abs(c.change()))
+	if better, known := metricDirections[c.metric]; known {
+		if (c.recent-c.baseline)*better > 0 {
+			return flag + ", better"
+		}
+		return flag + ", worse"
+	}
+	return flag
+}
+
+// splitBaseline splits the nights into those of the last recent days up to the latest night and
+// those of the baseline days before them, all nights before them without baseline days
+func splitBaseline(nights []*sleep.Night, recentDays, baselineDays int) (recent, baseline []*sleep.Night) {
+	if len(nights) == 0 {
+		return nil, nil
+	}
+	recentStart := nights[len(nights)-1].Date.AddDate(0, 0, -recentDays)
+	baselineStart := recentStart.AddDate(0, 0, -baselineDays)
+	for _, night := range nights {
+		switch {
+		case night.Date.After(recentStart):
+			recent = append(recent, night)
+		case baselineDays == 0 || night.Date.After(baselineStart):
+			baseline = append(baseline, night)
+		}
+	}
+	return recent, baseline
+}
+
+// compareBaseline compares the mean of every metric of the recent nights with the baseline with
+// Welch's t-test
+func compareBaseline(data *nightData, recent, baseline []*sleep.Night) []baselineComparison {
+	values := func(nights []*sleep.Night, name string) []float64 {
+		values := make([]float64, len(nights))
+		for i, night := range nights {
+			values[i] = allNightVars(night, data.derived)[name]
+		}
+		return values
+	}
+	var comparisons []baselineComparison
+	for _, name := range comparedMetrics(data) {
+		r, b := values(recent, name), values(baseline, name)
+		_, p := welchTest(b, r)
+		comparisons = append(comparisons, baselineComparison{name, stat.Mean(r, nil), stat.Mean(b, nil), p})
+	}
+	return comparisons
+}
+
+// writeBaseline prints the means of the metrics of the last recent days next to those of the
+// baseline days before them, followed by the significant changes
+func writeBaseline(w io.Writer, data *nightData, recentDays, baselineDays int) {
+	recent, baseline := splitBaseline(data.nights, recentDays, baselineDays)
+	if len(recent) == 0 || len(baseline) == 0 {
+		fmt.Fprintf(w, "\nComparing the last %d days with a baseline needs nights in both.\n", recentDays)
+		return
+	}
+	dates := func(nights []*sleep.Night) string {
+		return fmt.Sprintf("%s to %s, %d nights", nights[0].Key(), nights[len(nights)-1].Key(), len(nights))
+	}
+	fmt.Fprintf(w, "\nThe last %d days (%s) vs the baseline (%s):\n", recentDays, dates(recent), dates(baseline))
+	comparisons := compareBaseline(data, recent, baseline)
+	for _, c := range comparisons {
+		change := "-"
+		if !math.IsNaN(c.change()) {
+			// adding zero turns a rounded -0 into 0
+			change = fmt.Sprintf("%+.0f%%", math.Round(100*c.change())+0)
+		}
+		diff := fmt.Sprintf("%+.2f", c.recent-c.baseline)
+		if isDuration(c.metric) {
+			diff = fmt.Sprintf("%+.0f min", (c.recent-c.baseline)*60)
+		}
+		fmt.Fprintf(w, "  %-12s %8s  vs %8s  %10s %6s  (p=%.3f)\n", c.metric, formatMetric(c.metric, c.recent),
+			formatMetric(c.metric, c.baseline), diff, change, c.p)
+	}
+	for _, c := range comparisons {
+		if flag := c.flag(); flag != "" {
+			fmt.Fprintf(w, "  ! %s\n", flag)
+		}
+	}
+}
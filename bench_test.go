@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"sleep-stats/sleep/sleeptest"
+	"sleep-stats/source/apple"
+)
+
+// the benchmarks of the analysis read an export of ten years of nights like the generate command
+// writes, e.g. profiled with go test -bench Analyze -cpuprofile cpu.prof
+const benchNights = 3650
+
+// benchInput writes the export and an empty config and returns the input flags reading them
+func benchInput(b *testing.B, args ...string) inputFlags {
+	dir := b.TempDir()
+	name := filepath.Join(dir, "export.csv")
+	f, err := os.Create(name)
+	if err != nil {
+		b.Fatal(err)
+	}
+	w := bufio.NewWriter(f)
+	if err := apple.Write(w, sleeptest.Generate(benchNights, 1)); err != nil {
+		b.Fatal(err)
+	}
+	if err := w.Flush(); err != nil {
+		b.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		b.Fatal(err)
+	}
+	config := filepath.Join(dir, "config.json")
+	if err := os.WriteFile(config, []byte("{}"), 0o644); err != nil {
+		b.Fatal(err)
+	}
+
+	fs := flag.NewFlagSet("bench", flag.ContinueOnError)
+	in := addInputFlags(fs)
+	if err := fs.Parse(append([]string{"-file", name, "-config", config}, args...)); err != nil {
+		b.Fatal(err)
+	}
+	return in
+}
+
+func benchAnalyze(b *testing.B, args ...string) {
+	in := benchInput(b, args...)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		data, err := in.analyze(context.Background())
+		if err != nil {
+			b.Fatal(err)
+		}
+		if len(data.nights) < benchNights {
+			b.Fatalf("analyzed %d nights, want %d", len(data.nights), benchNights)
+		}
+	}
+}
+
+func BenchmarkAnalyze(b *testing.B) {
+	benchAnalyze(b)
+}
+
+func BenchmarkAnalyzeSplitMidnight(b *testing.B) {
+	benchAnalyze(b, "-split-midnight")
+}
+
+func BenchmarkAnalyzeGaps(b *testing.B) {
+	benchAnalyze(b, "-gap", "4h")
+}
+
+func BenchmarkWriteJSON(b *testing.B) {
+	data, err := benchInput(b).analyze(context.Background())
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := writeJSON(io.Discard, data, outputOptions{level: "night", shape: "wide"}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"image/color"
+	"io"
+	"math"
+	"slices"
+	"time"
+
+	"gonum.org/v1/gonum/stat"
+	"gonum.org/v1/plot"
+	"gonum.org/v1/plot/font"
+	"gonum.org/v1/plot/text"
+	"gonum.org/v1/plot/vg"
+	"gonum.org/v1/plot/vg/draw"
+
+	"sleep-stats/sleep"
+)
+
+// the fewest nights between two changes, shorter shifts are left to the noise
+const minChangeNights = 14
+
+// changePoint is a night where the level of a metric shifted
+type changePoint struct {
+	metric        string
+	date          time.Time
+	before, after float64 // the mean of the segments on either side, in hours
+}
+
+// detectChanges finds the shifts in total sleep and bedtime by binary segmentation of the mean,
+// splitting as long as a split lowers the squared error by more than a penalty growing with the
+// noise and the number of nights
+func detectChanges(nights []*sleep.Night) []changePoint {
+	totals := make([]float64, len(nights))
+	bedtimes := make([]float64, len(nights))
+	for i, night := range nights {
+		totals[i] = night.TotalAsleep().Hours()
+		bedtimes[i] = bedtime(night)
+	}
+
+	var changes []changePoint
+	for _, series := range []struct {
+		metric string
+		values []float64
+	}{{"total", totals}, {"bedtime", bedtimes}} {
+		splits := segment(series.values, 0, len(series.values), changePenalty(series.values))
+		for i, split := range splits {
+			begin, end := 0, len(series.values)
+			if i > 0 {
+				begin = splits[i-1]
+			}
+			if i+1 < len(splits) {
+				end = splits[i+1]
+			}
+			changes = append(changes, changePoint{
+				metric: series.metric,
+				date:   nights[split].Date,
+				before: stat.Mean(series.values[begin:split], nil),
+				after:  stat.Mean(series.values[split:end], nil),
+			})
+		}
+	}
+	slices.SortStableFunc(changes, func(a, b changePoint) int { return a.date.Compare(b.date) })
+	return changes
+}
+
+// the hour the first session started, after midnight as hours past 24 so a night doesn't jump
+// from 23 to 0
+func bedtime(night *sleep.Night) float64 {
+	start := night.Sessions[0].Start
+	hour := float64(start.Hour()) + float64(start.Minute())/60
+	if hour < 12 {
+		hour += 24
+	}
+	return hour
+}
+
+// the penalty of the modified BIC, with the variance estimated from the differences between
+// consecutive nights so the shifts themselves don't inflate it
+func changePenalty(values []float64) float64 {
+	if len(values) < 2 {
+		return math.Inf(1)
+	}
+	diffs := make([]float64, len(values)-1)
+	for i := range diffs {
+		diffs[i] = math.Abs(values[i+1] - values[i])
+	}
+	slices.Sort(diffs)
+	sigma := stat.Quantile(0.5, stat.Empirical, diffs, nil) / (0.6745 * math.Sqrt2)
+	return 3 * sigma * sigma * math.Log(float64(len(values)))
+}
+
+// segment returns the indices where a new level starts in values[begin:end], in order
+func segment(values []float64, begin, end int, penalty float64) []int {
+	if end-begin < 2*minChangeNights {
+		return nil
+	}
+	best, bestGain := 0, 0.0
+	whole := squaredError(values[begin:end])
+	for split := begin + minChangeNights; split <= end-minChangeNights; split++ {
+		gain := whole - squaredError(values[begin:split]) - squaredError(values[split:end])
+		if gain > bestGain {
+			best, bestGain = split, gain
+		}
+	}
+	if bestGain <= penalty {
+		return nil
+	}
+	splits := segment(values, begin, best, penalty)
+	splits = append(splits, best)
+	return append(splits, segment(values, best, end, penalty)...)
+}
+
+func squaredError(values []float64) float64 {
+	mean := stat.Mean(values, nil)
+	total := 0.0
+	for _, v := range values {
+		total += (v - mean) * (v - mean)
+	}
+	return total
+}
+
+// formatChange shows the hours of a metric as a duration or a clock time
+func formatChange(metric string, hours float64) string {
+	d := time.Duration(hours * float64(time.Hour))
+	if metric == "bedtime" {
+		d = d.Round(time.Minute)
+		return fmt.Sprintf("%02d:%02d", int(d.Hours())%24, int(d.Minutes())%60)
+	}
+	return formatDuration(d)
+}
+
+// writeChanges prints the change points with the levels before and after
+func writeChanges(w io.Writer, changes []changePoint) {
+	if len(changes) == 0 {
+		fmt.Fprintln(w, "\nNo changes in total sleep or bedtime found.")
+		return
+	}
+	fmt.Fprintln(w, "\nChanges:")
+	for _, change := range changes {
+		fmt.Fprintf(w, "  %s  %-8s %s -> %s\n", change.date.Format(sleep.DateLayout), change.metric,
+			formatChange(change.metric, change.before), formatChange(change.metric, change.after))
+	}
+}
+
+// changeMarkers draws a dashed vertical line with the date and metric at each change
+type changeMarkers []changePoint
+
+func (m changeMarkers) Plot(c draw.Canvas, plt *plot.Plot) {
+	trX, _ := plt.Transforms(&c)
+	line := draw.LineStyle{Color: color.RGBA{A: 160}, Width: vg.Points(1), Dashes: []vg.Length{vg.Points(4), vg.Points(3)}}
+	style := text.Style{
+		Color:    color.Black,
+		Font:     font.From(plot.DefaultFont, vg.Points(9)),
+		Rotation: math.Pi / 2,
+		XAlign:   text.XRight,
+		YAlign:   text.YTop,
+		Handler:  plt.TextHandler,
+	}
+	for _, change := range m {
+		x := trX(float64(change.date.Unix()))
+		c.StrokeLine2(line, x, c.Min.Y, x, c.Max.Y)
+		c.FillText(style, vg.Point{X: x + vg.Points(2), Y: c.Max.Y}, change.date.Format(sleep.DateLayout)+" "+change.metric)
+	}
+}
@@ -0,0 +1,548 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"image/color"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"time"
+
+	"golang.org/x/exp/maps"
+	"golang.org/x/sync/errgroup"
+	"gonum.org/v1/plot"
+	"gonum.org/v1/plot/font"
+	"gonum.org/v1/plot/text"
+	"gonum.org/v1/plot/vg"
+	"gonum.org/v1/plot/vg/draw"
+	"gonum.org/v1/plot/vg/vgimg"
+
+	"sleep-stats/sleep"
+)
+
+type chartOptions struct {
+	file string // the file the chart is written to
+	date string // the night of the charts of a single night
+	// the trailing days of the charts of rolling sums
+	window int
+}
+
+// the charts of the chart command, each draws the sessions of the nights in its own way
+var charts = map[string]func(ctx context.Context, data *nightData, opts chartOptions) error{
+	"strip":       stripChart,
+	"actogram":    actogramChart,
+	"raster":      rasterChart,
+	"timeline":    timelineChart,
+	"hypnogram":   hypnogramChart,
+	"efficiency":  efficiencyChart,
+	"composition": compositionChart,
+	"debt":        debtChart,
+}
+
+// chartCommand draws a chart of the sessions, e.g. sleep-stats chart strip
+func chartCommand(fs *flag.FlagSet) func(ctx context.Context) error {
+	input := addInputFlags(fs)
+	file := fs.String("chart", "", "file the chart is written to, defaults to <chart>.<plot>, - writes it to stdout")
+	plotFormat := addPlotFlag(fs)
+	paletteName := addPaletteFlag(fs)
+	resolution := addResolutionFlags(fs)
+	open := addOpenFlag(fs)
+	window := fs.Int("window", 14, "days of the trailing window of the debt chart")
+	date := fs.String("date", "", "night of the timeline chart in YYYY-MM-DD format, defaults to the last night")
+	return func(ctx context.Context) error {
+		if fs.NArg() == 0 {
+			return &exitError{fmt.Errorf("Usage: %s chart <chart> [flags], the charts are %v", os.Args[0], sortedCharts()), exitUsage}
+		}
+		name := fs.Arg(0)
+		render, ok := charts[name]
+		if !ok {
+			return &exitError{fmt.Errorf("unknown chart %q, known charts are %v", name, sortedCharts()), exitUsage}
+		}
+		// the flags can also follow the chart
+		fs.Parse(fs.Args()[1:])
+		if err := checkPlotFormat(*plotFormat); err != nil || *plotFormat == "none" {
+			return &exitError{fmt.Errorf("unknown plot format %q, use svg, png or jpg", *plotFormat), exitUsage}
+		}
+		if err := usePalette(*paletteName); err != nil {
+			return &exitError{err, exitUsage}
+		}
+		if err := resolution.apply(); err != nil {
+			return &exitError{err, exitUsage}
+		}
+
+		data, err := input.analyze(ctx)
+		if err != nil {
+			return err
+		}
+		if len(data.nights) == 0 {
+			return &exitError{errors.New("no sleep data found"), exitNoNights}
+		}
+		stdoutFormat = *plotFormat
+		opts := chartOptions{file: *file, date: *date, window: *window}
+		if opts.file == "" {
+			opts.file = name + "." + *plotFormat
+		}
+		if err := render(ctx, data, opts); err != nil {
+			return err
+		}
+		data.printExcluded(input.diagnostics())
+		openAfterRun(*open, opts.file)
+		return skippedRows(data.skipped)
+	}
+}
+
+func sortedCharts() []string {
+	names := maps.Keys(charts)
+	slices.Sort(names)
+	return names
+}
+
+// band is a filled rectangle of a chart in data coordinates
+type band struct {
+	x0, x1, y0, y1 float64
+	color          color.Color
+}
+
+// bands draws rectangles like the sessions of a day on a row, which the other plotters can't
+type bands []band
+
+func (b bands) Plot(c draw.Canvas, plt *plot.Plot) {
+	trX, trY := plt.Transforms(&c)
+	for _, band := range b {
+		pts := []vg.Point{
+			{X: trX(band.x0), Y: trY(band.y0)},
+			{X: trX(band.x1), Y: trY(band.y0)},
+			{X: trX(band.x1), Y: trY(band.y1)},
+			{X: trX(band.x0), Y: trY(band.y1)},
+		}
+		c.FillPolygon(band.color, c.ClipPolygonXY(pts))
+	}
+}
+
+func (b bands) DataRange() (xmin, xmax, ymin, ymax float64) {
+	if len(b) == 0 {
+		return 0, 0, 0, 0
+	}
+	xmin, xmax, ymin, ymax = b[0].x0, b[0].x1, b[0].y0, b[0].y1
+	for _, band := range b[1:] {
+		xmin, xmax = min(xmin, band.x0), max(xmax, band.x1)
+		ymin, ymax = min(ymin, band.y0), max(ymax, band.y1)
+	}
+	return xmin, xmax, ymin, ymax
+}
+
+// nightSpan is a run of nights from the first to the last date, named on the plot
+type nightSpan struct {
+	name        string
+	first, last time.Time
+}
+
+// spanMarkers shade spans of nights on a plot of the nights, with their names along the left edge
+type spanMarkers struct {
+	spans       []nightSpan
+	fill, label color.Color
+}
+
+func (m spanMarkers) Plot(c draw.Canvas, plt *plot.Plot) {
+	trX, _ := plt.Transforms(&c)
+	style := text.Style{
+		Color:    m.label,
+		Font:     font.From(plot.DefaultFont, vg.Points(9)),
+		Rotation: math.Pi / 2,
+		XAlign:   text.XRight,
+		YAlign:   text.YTop,
+		Handler:  plt.TextHandler,
+	}
+	const halfDay = 12 * 60 * 60
+	for _, span := range m.spans {
+		x0 := trX(float64(span.first.Unix()) - halfDay)
+		x1 := trX(float64(span.last.Unix()) + halfDay)
+		if x1 < c.Min.X || x0 > c.Max.X {
+			continue
+		}
+		c.FillPolygon(m.fill, c.ClipPolygonXY([]vg.Point{{X: x0, Y: c.Min.Y}, {X: x1, Y: c.Min.Y}, {X: x1, Y: c.Max.Y}, {X: x0, Y: c.Max.Y}}))
+		if span.name != "" && x0 >= c.Min.X {
+			c.FillText(style, vg.Point{X: x0 + vg.Points(2), Y: c.Max.Y}, span.name)
+		}
+	}
+}
+
+// eachDay splits the session at the starts of the days, which begin offset after midnight, and
+// calls fn with the midnight of each day and the hours from its start the session covers
+func eachDay(session sleep.Session, offset time.Duration, fn func(day time.Time, from, to float64)) {
+	start := session.Start
+	for start.Before(session.End) {
+		day := time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, start.Location())
+		if clockHours(start, day) < offset.Hours() {
+			day = day.AddDate(0, 0, -1)
+		}
+		end := session.End
+		if next := time.Date(day.Year(), day.Month(), day.Day()+1, 0, 0, int(offset.Seconds()), 0, day.Location()); next.Before(end) {
+			end = next
+		}
+		fn(day, clockHours(start, day)-offset.Hours(), clockHours(end, day)-offset.Hours())
+		start = end
+	}
+}
+
+// clockHours returns the hours from the midnight of the day to the time on the clock, so on the
+// days the clocks change the times are placed where the clock showed them
+func clockHours(t, day time.Time) float64 {
+	t = t.In(day.Location())
+	days := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC).Sub(time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, time.UTC)).Hours() / 24
+	clock := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second + time.Duration(t.Nanosecond())
+	return days*24 + clock.Hours()
+}
+
+// clockTicks labels the hours of the x axis every step hours from the hour of the start
+func clockTicks(start, hours, step int) plot.ConstantTicks {
+	var ticks plot.ConstantTicks
+	for h := 0; h <= hours; h += step {
+		ticks = append(ticks, plot.Tick{Value: float64(h), Label: fmt.Sprintf("%02d:00", (start+h)%24)})
+	}
+	return ticks
+}
+
+// dayTicks labels the first of each month on the y axis of days counted from the first day
+func dayTicks(first time.Time, days int) plot.ConstantTicks {
+	var ticks plot.ConstantTicks
+	for i := 0; i < days; i++ {
+		if day := first.AddDate(0, 0, i); day.Day() == 1 || i == 0 {
+			ticks = append(ticks, plot.Tick{Value: float64(i), Label: day.Format(sleep.DateLayout)})
+		}
+	}
+	return ticks
+}
+
+// the height of a chart with a row per day, within sensible bounds
+func rowsHeight(rows int) vg.Length {
+	return min(max(vg.Length(rows)*vg.Points(4)+2*vg.Inch, 5*vg.Inch), 40*vg.Inch)
+}
+
+// the resolution of the PNG, JPEG and TIFF images in dots per inch, set by -dpi and -scale
+var rasterDPI = vgimg.DefaultDPI
+
+// the format of the charts written to stdout, set from -plot
+var stdoutFormat = "svg"
+
+// chartFormat is the format of the extension of the file, SVG without one
+func chartFormat(filename string) string {
+	if filename == stdoutFile {
+		return stdoutFormat
+	}
+	if format := strings.TrimPrefix(strings.ToLower(filepath.Ext(filename)), "."); format != "" {
+		return format
+	}
+	return "svg"
+}
+
+// newImage returns a canvas of the size in the format, SVGs are svgCanvases so the plots with
+// tooltips get them and the raster formats are at rasterDPI
+func newImage(width, height vg.Length, format string) (vg.CanvasWriterTo, error) {
+	raster := func() *vgimg.Canvas { return vgimg.NewWith(vgimg.UseWH(width, height), vgimg.UseDPI(rasterDPI)) }
+	switch format {
+	case "svg":
+		return newSVG(width, height), nil
+	case "png":
+		return vgimg.PngCanvas{Canvas: raster()}, nil
+	case "jpg", "jpeg":
+		return vgimg.JpegCanvas{Canvas: raster()}, nil
+	case "tif", "tiff":
+		return vgimg.TiffCanvas{Canvas: raster()}, nil
+	}
+	return draw.NewFormattedCanvas(width, height, format)
+}
+
+// writeChart writes the plot in the format of the extension of the file, SVG without one
+func writeChart(ctx context.Context, p *plot.Plot, width, height vg.Length, filename string) error {
+	img, err := newImage(width, height, chartFormat(filename))
+	if err != nil {
+		return err
+	}
+	applyFontSizes(p)
+	p.Draw(draw.New(img))
+	return writeFile(ctx, filename, func(w io.Writer) error {
+		_, err := img.WriteTo(w)
+		return err
+	})
+}
+
+// writePanels aligns the rows of plots in a grid and writes them like writeChart
+func writePanels(ctx context.Context, plots [][]*plot.Plot, width, height vg.Length, filename string) error {
+	img, err := drawPanels(plots, width, height, chartFormat(filename))
+	if err != nil {
+		return err
+	}
+	return writeFile(ctx, filename, func(w io.Writer) error {
+		_, err := img.WriteTo(w)
+		return err
+	})
+}
+
+// drawPanels aligns the rows of plots in a grid on an image in the format
+func drawPanels(plots [][]*plot.Plot, width, height vg.Length, format string) (vg.CanvasWriterTo, error) {
+	img, err := newImage(width, height, format)
+	if err != nil {
+		return nil, err
+	}
+	tiles := draw.Tiles{Rows: len(plots), Cols: len(plots[0]), PadX: vg.Points(10), PadY: vg.Points(10), PadTop: vg.Points(5), PadBottom: vg.Points(5)}
+	canvases := plot.Align(plots, tiles, draw.New(img))
+	for i := range plots {
+		for j := range plots[i] {
+			applyFontSizes(plots[i][j])
+			plots[i][j].Draw(canvases[i][j])
+		}
+	}
+	return img, nil
+}
+
+// renderAll runs the renders of the charts of a run concurrently, one per CPU at a time, and
+// returns the first error after all of them finished
+func renderAll(renders ...func() error) error {
+	var g errgroup.Group
+	g.SetLimit(runtime.GOMAXPROCS(0))
+	for _, render := range renders {
+		g.Go(render)
+	}
+	return g.Wait()
+}
+
+// stripChart draws a row per day from noon to noon with a band for each period asleep, showing
+// how the bedtime drifts and how regular the schedule is over the months
+func stripChart(ctx context.Context, data *nightData, opts chartOptions) error {
+	const noon = 12 * time.Hour
+	// the day before the first night, which the sessions after midnight and before noon belong to
+	first := sleep.NightDate(data.nights[0].Date, 0).AddDate(0, 0, -1)
+	var b bands
+	days := 0
+	for _, night := range data.nights {
+		for _, session := range night.Sessions {
+			if !session.Stage.IsAsleep() {
+				continue
+			}
+			eachDay(session, noon, func(day time.Time, from, to float64) {
+				row := int(day.Sub(first).Hours()/24 + 0.5)
+				days = max(days, row+1)
+				b = append(b, band{x0: from, x1: to, y0: float64(row) + 0.1, y1: float64(row) + 0.9, color: color.RGBA{R: 0, G: 90, B: 200, A: 255}})
+			})
+		}
+	}
+
+	p := plot.New()
+	p.Title.Text = "Sleep by Time of Day"
+	p.X.Label.Text = "Time"
+	p.X.Min, p.X.Max = 0, 24
+	p.X.Tick.Marker = clockTicks(12, 24, 3)
+	p.Y.Scale = plot.InvertedScale{Normalizer: plot.LinearScale{}}
+	p.Y.Tick.Marker = dayTicks(first, days)
+	p.Add(b, dstRows(data.nights, first, 0))
+	p.Y.Min, p.Y.Max = 0, float64(days)
+	return writeChart(ctx, p, 10*vg.Inch, rowsHeight(days), opts.file)
+}
+
+// actogramChart draws the double-plotted actogram of circadian research, each row is 48 hours of
+// a day followed by the next so the next row repeats its second half, which keeps a rhythm drifting
+// past midnight visible as a continuous slope
+func actogramChart(ctx context.Context, data *nightData, opts chartOptions) error {
+	first := sleep.NightDate(data.nights[0].Date, 0)
+	asleep := color.RGBA{A: 255}
+	var b bands
+	days := 0
+	for _, night := range data.nights {
+		for _, session := range night.Sessions {
+			if !session.Stage.IsAsleep() {
+				continue
+			}
+			eachDay(session, 0, func(day time.Time, from, to float64) {
+				row := int(day.Sub(first).Hours()/24 + 0.5)
+				days = max(days, row+1)
+				// the day on the right half of its own row and the left half of the next
+				b = append(b,
+					band{x0: from + 24, x1: to + 24, y0: float64(row) + 0.1, y1: float64(row) + 0.9, color: asleep},
+					band{x0: from, x1: to, y0: float64(row+1) + 0.1, y1: float64(row+1) + 0.9, color: asleep},
+				)
+			})
+		}
+	}
+	days++
+
+	p := plot.New()
+	p.Title.Text = "Double-Plotted Actogram"
+	p.X.Label.Text = "Time"
+	p.X.Min, p.X.Max = 0, 48
+	p.X.Tick.Marker = clockTicks(0, 48, 3)
+	p.Y.Scale = plot.InvertedScale{Normalizer: plot.LinearScale{}}
+	// each row is labeled with the day on its left half
+	p.Y.Tick.Marker = dayTicks(first.AddDate(0, 0, -1), days)
+	p.Add(b, dstRows(data.nights, first, 1))
+	p.Y.Min, p.Y.Max = 0, float64(days)
+	return writeChart(ctx, p, 12*vg.Inch, rowsHeight(days), opts.file)
+}
+
+// colorThumb is the legend entry of a color
+type colorThumb color.RGBA
+
+func (t colorThumb) Thumbnail(c *draw.Canvas) {
+	c.FillPolygon(color.RGBA(t), []vg.Point{c.Min, {X: c.Max.X, Y: c.Min.Y}, c.Max, {X: c.Min.X, Y: c.Max.Y}})
+}
+
+// add the stages that have a color to the legend
+func addStageLegend(p *plot.Plot) {
+	for _, stage := range sleep.Stages {
+		if c, ok := stageColors[stage]; ok {
+			p.Legend.Add(stage.String(), colorThumb(c))
+		}
+	}
+}
+
+// rasterChart draws a row per night with every session asleep or awake at its time of day in the
+// color of its stage, so the structure of each night and the naps show at the scale of the data
+func rasterChart(ctx context.Context, data *nightData, opts chartOptions) error {
+	// the dates of the nights, the start of those grouped by gaps is within the date
+	first := sleep.NightDate(data.nights[0].Date, 0)
+	var b bands
+	for _, night := range data.nights {
+		row := float64(int(sleep.NightDate(night.Date, 0).Sub(first).Hours()/24 + 0.5))
+		for _, session := range night.Sessions {
+			c, ok := stageColors[session.Stage]
+			if !ok {
+				continue
+			}
+			b = append(b, band{
+				x0:    clockHours(session.Start, night.Date),
+				x1:    clockHours(session.End, night.Date),
+				y0:    row + 0.1,
+				y1:    row + 0.9,
+				color: c,
+			})
+		}
+	}
+	if len(b) == 0 {
+		return errors.New("no sessions asleep or awake to chart")
+	}
+	xmin, xmax, _, _ := b.DataRange()
+	days := int(sleep.NightDate(data.nights[len(data.nights)-1].Date, 0).Sub(first).Hours()/24+0.5) + 1
+
+	p := plot.New()
+	p.Title.Text = "Sessions by Night"
+	p.X.Label.Text = "Time"
+	start, end := int(math.Floor(xmin/3))*3, int(math.Ceil(xmax/3))*3
+	p.X.Tick.Marker = clockTicks(start, end-start, 3)
+	p.Y.Scale = plot.InvertedScale{Normalizer: plot.LinearScale{}}
+	p.Y.Tick.Marker = dayTicks(first, days)
+	p.Legend.Top = true
+	addStageLegend(p)
+	// the hours are counted from the first tick so the ticks start at 0
+	for i := range b {
+		b[i].x0 -= float64(start)
+		b[i].x1 -= float64(start)
+	}
+	p.Add(b, dstRows(data.nights, first, 0))
+	p.X.Min, p.X.Max = 0, float64(end-start)
+	p.Y.Min, p.Y.Max = 0, float64(days)
+	return writeChart(ctx, p, 12*vg.Inch, rowsHeight(days), opts.file)
+}
+
+// timelineChart draws the sessions of the night of -date, the last night by default, on a lane
+// per stage at their time of day like the Watch app shows them
+func timelineChart(ctx context.Context, data *nightData, opts chartOptions) error {
+	night := data.nights[len(data.nights)-1]
+	if opts.date != "" {
+		i := slices.IndexFunc(data.nights, func(night *sleep.Night) bool { return night.Key() == opts.date || night.Day() == opts.date })
+		if i < 0 {
+			return fmt.Errorf("no night on %s", opts.date)
+		}
+		night = data.nights[i]
+	}
+
+	lanes := []sleep.Stage{sleep.Deep, sleep.Core, sleep.REM, sleep.Awake}
+	if night.Time(sleep.Asleep) > 0 {
+		lanes = append(lanes, sleep.Asleep)
+	}
+	var b bands
+	for _, session := range night.Sessions {
+		lane := slices.Index(lanes, session.Stage)
+		if lane < 0 {
+			continue
+		}
+		b = append(b, band{
+			x0:    clockHours(session.Start, night.Date),
+			x1:    clockHours(session.End, night.Date),
+			y0:    float64(lane) - 0.4,
+			y1:    float64(lane) + 0.4,
+			color: stageColors[session.Stage],
+		})
+	}
+	if len(b) == 0 {
+		return fmt.Errorf("no sessions asleep or awake on %s", night.Key())
+	}
+	xmin, xmax, _, _ := b.DataRange()
+	start, end := int(math.Floor(xmin)), int(math.Ceil(xmax))
+	for i := range b {
+		b[i].x0 -= float64(start)
+		b[i].x1 -= float64(start)
+	}
+
+	p := plot.New()
+	p.Title.Text = "Night of " + night.Key()
+	p.X.Label.Text = "Time"
+	p.X.Tick.Marker = clockTicks(start, end-start, 1)
+	names := make([]string, len(lanes))
+	for i, stage := range lanes {
+		names[i] = stage.String()
+	}
+	p.NominalY(names...)
+	p.Add(b)
+	p.X.Min, p.X.Max = 0, float64(end-start)
+	p.Y.Min, p.Y.Max = -0.5, float64(len(lanes))-0.5
+	return writeChart(ctx, p, 12*vg.Inch, 4*vg.Inch, opts.file)
+}
+
+// hypnogramChart stacks the share of the nights in each stage at each minute from noon to noon, the
+// shape of the typical night
+func hypnogramChart(ctx context.Context, data *nightData, opts chartOptions) error {
+	const day = 24 * 60
+	counts := make(map[sleep.Stage][]float64)
+	for _, night := range data.nights {
+		start, minutes := minuteStages(night.Sessions)
+		for i, stage := range minutes {
+			if stage == noStage {
+				continue
+			}
+			if counts[stage] == nil {
+				counts[stage] = make([]float64, day)
+			}
+			clock := start.Add(time.Duration(i) * time.Minute)
+			counts[stage][(clock.Hour()*60+clock.Minute()+day/2)%day]++
+		}
+	}
+
+	xs := make([]float64, day)
+	for i := range xs {
+		xs[i] = float64(i) / 60
+	}
+	for _, perMinute := range counts {
+		for i := range perMinute {
+			perMinute[i] /= float64(len(data.nights))
+		}
+	}
+	p := plot.New()
+	p.Title.Text = "Typical Night"
+	p.X.Label.Text = "Time"
+	p.Y.Label.Text = "Share of the nights"
+	p.Legend.Top = true
+	if err := stackedAreas(p, xs, counts); err != nil {
+		return err
+	}
+	p.X.Min, p.X.Max = 0, 24
+	p.X.Tick.Marker = clockTicks(12, 24, 3)
+	p.Y.Min, p.Y.Max = 0, 1
+	return writeChart(ctx, p, 12*vg.Inch, 5*vg.Inch, opts.file)
+}
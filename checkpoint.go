@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"time"
+
+	"sleep-stats/sleep"
+	"sleep-stats/source"
+)
+
+// checkpoint is the progress of reading a large file, written next to it with -checkpoint so an
+// interrupted run continues with -resume instead of reading the file again. The sessions read so far
+// are appended to a sidecar file as JSON lines, so a checkpoint only writes the new ones.
+type checkpoint struct {
+	// the file as it was read, a checkpoint of a changed file can't be resumed
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+	Format  string    `json:"format"`
+	// the options the sessions were read with, like the date filters
+	Options string `json:"options"`
+	// where the next row starts
+	Offset int64 `json:"offset"`
+	Line   int   `json:"line"`
+	// the size of the sidecar with the sessions read up to the offset, a run interrupted while
+	// appending to it may have written more
+	SessionsSize int64           `json:"sessionsSize"`
+	Skipped      []checkpointRow `json:"skipped"`
+}
+
+// checkpointRow is a skipped row, with the error as text
+type checkpointRow struct {
+	Line   int    `json:"line"`
+	Text   string `json:"text"`
+	Column string `json:"column,omitempty"`
+	Value  string `json:"value,omitempty"`
+	Err    string `json:"error"`
+}
+
+// the files next to the input the checkpoint and its sessions are written to
+func checkpointPaths(filename string) (string, string) {
+	return filename + ".checkpoint", filename + ".checkpoint.jsonl"
+}
+
+// readCheckpointed passes the sessions of the file to each like eachSource, writing a checkpoint
+// every interval and when the context is cancelled. With resume it first passes on the sessions
+// of the checkpoint and continues reading where it left off. The checkpoint is removed once the
+// whole file is read.
+func readCheckpointed(ctx context.Context, format, filename string, opts source.Options, startFilter, endFilter *time.Time, strict bool, interval time.Duration, resume bool, each func(sleep.Session) error) ([]*source.RowError, error) {
+	src, err := source.New(format, opts)
+	if err != nil {
+		return nil, err
+	}
+	r, ok := src.(source.Resumer)
+	if !ok {
+		return nil, fmt.Errorf("the %s format can't be resumed, -checkpoint and -resume are for the files of the apple and ndjson formats", format)
+	}
+	info, err := os.Stat(filename)
+	if err != nil {
+		return nil, err
+	}
+	cp := &checkpoint{
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+		Format:  format,
+		Options: fmt.Sprintf("start=%s end=%s strict=%t delimiter=%q fields=%+v", formatFilter(startFilter), formatFilter(endFilter), strict, opts.Delimiter, opts.Fields),
+	}
+	path, sessionsPath := checkpointPaths(filename)
+
+	var skipped []*source.RowError
+	var sessions *os.File
+	if resume {
+		saved, err := loadCheckpoint(path)
+		if err != nil {
+			return nil, err
+		}
+		if saved.Size != cp.Size || !saved.ModTime.Equal(cp.ModTime) || saved.Format != cp.Format || saved.Options != cp.Options {
+			return nil, fmt.Errorf("the checkpoint %s was written for another version of the file or other options, run without -resume to read it from the start", path)
+		}
+		if sessions, err = os.OpenFile(sessionsPath, os.O_RDWR, 0); err != nil {
+			return nil, err
+		}
+		defer sessions.Close()
+		// the sessions appended after the checkpoint are read again from the file
+		if err := sessions.Truncate(saved.SessionsSize); err != nil {
+			return nil, err
+		}
+		dec := json.NewDecoder(sessions)
+		for {
+			var session sleep.Session
+			err := dec.Decode(&session)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, fmt.Errorf("reading the sessions of the checkpoint %s: %w", sessionsPath, err)
+			}
+			if err := each(session); err != nil {
+				return nil, err
+			}
+		}
+		for _, row := range saved.Skipped {
+			skipped = append(skipped, &source.RowError{Line: row.Line, Text: row.Text, Column: row.Column, Value: row.Value, Err: errors.New(row.Err)})
+		}
+		cp.SessionsSize, cp.Skipped = saved.SessionsSize, saved.Skipped
+		if err := r.Resume(filename, saved.Offset, saved.Line); err != nil {
+			return nil, err
+		}
+	} else {
+		if sessions, err = os.Create(sessionsPath); err != nil {
+			return nil, err
+		}
+		defer sessions.Close()
+		if err := r.Open(filename); err != nil {
+			return nil, err
+		}
+	}
+	defer r.Close()
+
+	// the sessions read since the last checkpoint
+	var pending []sleep.Session
+	// the checkpoint is written after a row, so the sessions are those before the offset
+	save := func() error {
+		offset, line, ok := r.Offset()
+		if !ok {
+			return nil
+		}
+		w := bufio.NewWriter(sessions)
+		enc := json.NewEncoder(w)
+		for _, session := range pending {
+			if err := enc.Encode(session); err != nil {
+				return err
+			}
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+		pending = pending[:0]
+		size, err := sessions.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return err
+		}
+		cp.Offset, cp.Line, cp.SessionsSize = offset, line, size
+		// an interrupt cancels the context, the checkpoint is still written then
+		return writeFile(context.WithoutCancel(ctx), path, func(w io.Writer) error {
+			return json.NewEncoder(w).Encode(cp)
+		})
+	}
+	saved := time.Now()
+	for {
+		if err := ctx.Err(); err != nil {
+			if saveErr := save(); saveErr != nil {
+				return nil, errors.Join(err, saveErr)
+			}
+			return nil, fmt.Errorf("%w, continue reading %s with -resume", err, filename)
+		}
+		if interval > 0 && time.Since(saved) >= interval {
+			if err := save(); err != nil {
+				return nil, err
+			}
+			saved = time.Now()
+		}
+
+		session, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		var rowErr *source.RowError
+		if !strict && errors.As(err, &rowErr) {
+			skipped = append(skipped, rowErr)
+			cp.Skipped = append(cp.Skipped, checkpointRow{Line: rowErr.Line, Text: rowErr.Text, Column: rowErr.Column, Value: rowErr.Value, Err: rowErr.Err.Error()})
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if inDateFilters(session, startFilter, endFilter) {
+			pending = append(pending, session)
+			if err := each(session); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for _, name := range []string{path, sessionsPath} {
+		if err := os.Remove(name); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+	}
+	return skipped, nil
+}
+
+// the date filter as it is compared to the one of the checkpoint
+func formatFilter(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
+func loadCheckpoint(path string) (*checkpoint, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("no checkpoint %s to resume, -checkpoint writes one while reading the file", path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var cp checkpoint
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return nil, fmt.Errorf("reading the checkpoint %s: %w", path, err)
+	}
+	return &cp, nil
+}
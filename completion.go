@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"slices"
+	"strings"
+
+	"golang.org/x/exp/maps"
+
+	"sleep-stats/source"
+)
+
+// the scripts ask the hidden __complete command for the commands, flags and flag values so they
+// stay current as flags are added without regenerating the script. A flag without values to
+// complete, like -file, completes file names.
+var completionScripts = map[string]string{
+	"bash": `_sleep_stats() {
+    local cur="${COMP_WORDS[COMP_CWORD]}" prev="${COMP_WORDS[COMP_CWORD-1]}" cmd="" values=""
+    if [[ ${COMP_CWORD} -gt 1 ]]; then
+        cmd="${COMP_WORDS[1]}"
+    fi
+    if [[ "$prev" == -* && "$cur" != -* ]]; then
+        values="$(sleep-stats __complete -- "$cmd" "$prev")"
+    fi
+    if [[ ${COMP_CWORD} -eq 1 && "$cur" != -* ]]; then
+        COMPREPLY=($(compgen -W "$(sleep-stats __complete)" -- "$cur"))
+    elif [[ "$cur" == -* ]]; then
+        COMPREPLY=($(compgen -W "$(sleep-stats __complete -- "$cmd")" -- "$cur"))
+    elif [[ -n "$values" ]]; then
+        COMPREPLY=($(compgen -W "$values" -- "$cur"))
+    else
+        COMPREPLY=($(compgen -f -- "$cur"))
+    fi
+}
+complete -o filenames -F _sleep_stats sleep-stats
+`,
+	"zsh": `#compdef sleep-stats
+_sleep_stats() {
+    local -a opts values
+    if [[ $words[CURRENT-1] == -* && $words[CURRENT] != -* ]]; then
+        values=(${(f)"$(sleep-stats __complete -- $words[2] $words[CURRENT-1])"})
+    fi
+    if (( CURRENT == 2 )) && [[ $words[CURRENT] != -* ]]; then
+        opts=(${(f)"$(sleep-stats __complete)"})
+        compadd -- $opts
+    elif [[ $words[CURRENT] == -* ]]; then
+        opts=(${(f)"$(sleep-stats __complete -- $words[2])"})
+        compadd -- $opts
+    elif (( $#values )); then
+        compadd -- $values
+    else
+        _files
+    fi
+}
+compdef _sleep_stats sleep-stats
+`,
+	"fish": `function __sleep_stats_command
+    set -l tokens (commandline -opc)
+    if test (count $tokens) -gt 1
+        echo $tokens[2]
+    end
+end
+function __sleep_stats_values
+    set -l tokens (commandline -opc)
+    if string match -q -- "-*" $tokens[-1]
+        sleep-stats __complete -- (__sleep_stats_command) $tokens[-1]
+    end
+end
+complete -c sleep-stats -f -n '__fish_use_subcommand; and not string match -q -- "-*" (commandline -ct)' -a '(sleep-stats __complete)'
+complete -c sleep-stats -n 'string match -q -- "-*" (commandline -ct)' -a '(sleep-stats __complete -- (__sleep_stats_command))'
+complete -c sleep-stats -n 'count (__sleep_stats_values) >/dev/null' -f -a '(__sleep_stats_values)'
+`,
+}
+
+// completionCommand prints the completion script for a shell
+func completionCommand(fs *flag.FlagSet) func(ctx context.Context) error {
+	return func(context.Context) error {
+		script, ok := completionScripts[fs.Arg(0)]
+		if !ok {
+			return errors.New("usage: sleep-stats completion bash|zsh|fish")
+		}
+		fmt.Print(script)
+		return nil
+	}
+}
+
+// completeCommand lists the commands, the flags of the named command or with a flag after the
+// command the values of the flag. Anything that isn't a command name is a flag of the default
+// command.
+func completeCommand(fs *flag.FlagSet) func(ctx context.Context) error {
+	return func(context.Context) error {
+		if fs.NArg() == 0 {
+			for _, cmd := range commands {
+				if cmd.usage != "" {
+					fmt.Println(cmd.name)
+				}
+			}
+			return nil
+		}
+
+		setup := plotCommand
+		if cmd, ok := lookupCommand(fs.Arg(0)); ok {
+			setup = cmd.setup
+		}
+		flags := flag.NewFlagSet("", flag.ContinueOnError)
+		addProfileFlags(flags)
+		setup(flags)
+		if fs.NArg() > 1 {
+			name := strings.TrimLeft(fs.Arg(1), "-")
+			if flags.Lookup(name) != nil {
+				for _, value := range flagValues(fs.Arg(0), name) {
+					fmt.Println(value)
+				}
+			}
+			return nil
+		}
+		flags.VisitAll(func(f *flag.Flag) {
+			fmt.Println("-" + f.Name)
+		})
+		return nil
+	}
+}
+
+// flagValues lists the values of a flag of the command that takes one of a few, from the
+// registries where there is one, nil for the other flags
+func flagValues(cmd, name string) []string {
+	var values []string
+	switch name {
+	case "format":
+		values = source.Names()
+	case "output":
+		switch cmd {
+		case "night":
+			values = append(maps.Keys(nightWriters), "table")
+		case "summary":
+			values = []string{"table", "json-compact"}
+		default:
+			values = append(maps.Keys(outputWriters), "table")
+		}
+	case "plot":
+		values = []string{"svg", "png", "jpg", "none"}
+	case "palette":
+		values = maps.Keys(palettes)
+	case "level":
+		values = []string{"night", "session"}
+	case "shape":
+		values = []string{"wide", "long"}
+	case "by":
+		values = []string{"night", "week", "month"}
+	case "report":
+		values = []string{"clinical", "naps", "monthly"}
+	case "travel":
+		values = []string{"mark", "exclude"}
+	case "normalize":
+		values = []string{"zscore"}
+	case "week-start":
+		values = []string{"monday", "sunday"}
+	}
+	slices.Sort(values)
+	return values
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image/color"
+	"math"
+	"time"
+
+	"gonum.org/v1/plot"
+	"gonum.org/v1/plot/vg"
+	"gonum.org/v1/plot/vg/draw"
+
+	"sleep-stats/sleep"
+)
+
+// the stages of the composition chart, in bed overlaps them
+var compositionStages = []sleep.Stage{sleep.Deep, sleep.Core, sleep.REM, sleep.Asleep, sleep.Awake}
+
+// the segments the arcs of the donut are drawn with per full turn
+const donutSegments = 180
+
+// donut draws the shares as the sectors of a ring clockwise from the top
+type donut struct {
+	shares []float64
+	colors []color.Color
+}
+
+func (d donut) Plot(c draw.Canvas, plt *plot.Plot) {
+	center := vg.Point{X: (c.Min.X + c.Max.X) / 2, Y: (c.Min.Y + c.Max.Y) / 2}
+	outer := min(c.Max.X-c.Min.X, c.Max.Y-c.Min.Y) / 2
+	inner := outer * 0.55
+	at := func(r vg.Length, angle float64) vg.Point {
+		return vg.Point{X: center.X + r*vg.Length(math.Cos(angle)), Y: center.Y + r*vg.Length(math.Sin(angle))}
+	}
+	angle := math.Pi / 2
+	for i, share := range d.shares {
+		sweep := 2 * math.Pi * share
+		steps := max(1, int(math.Ceil(share*donutSegments)))
+		pts := make([]vg.Point, 0, 2*(steps+1))
+		for j := 0; j <= steps; j++ {
+			pts = append(pts, at(outer, angle-sweep*float64(j)/float64(steps)))
+		}
+		for j := steps; j >= 0; j-- {
+			pts = append(pts, at(inner, angle-sweep*float64(j)/float64(steps)))
+		}
+		c.FillPolygon(d.colors[i], pts)
+		angle -= sweep
+	}
+}
+
+// swatch is the legend entry of a sector, a square of its color
+type swatch struct{ color color.Color }
+
+func (s swatch) Thumbnail(c *draw.Canvas) {
+	c.FillPolygon(s.color, []vg.Point{c.Min, {X: c.Max.X, Y: c.Min.Y}, c.Max, {X: c.Min.X, Y: c.Max.Y}})
+}
+
+// compositionPlot is a donut of the share of the time in each stage over all the nights, with the
+// shares and total times in the legend
+func compositionPlot(nights []*sleep.Night) (*plot.Plot, error) {
+	times := make([]time.Duration, len(compositionStages))
+	var total time.Duration
+	for _, night := range nights {
+		for i, stage := range compositionStages {
+			times[i] += night.Time(stage)
+			total += night.Time(stage)
+		}
+	}
+	if total == 0 {
+		return nil, errors.New("no sleep stages to chart the composition of")
+	}
+
+	p := plot.New()
+	p.Title.Text = fmt.Sprintf("Sleep Composition, %s to %s", nights[0].Key(), nights[len(nights)-1].Key())
+	p.HideAxes()
+	p.Legend.Left = false
+	var d donut
+	for i, stage := range compositionStages {
+		if times[i] == 0 {
+			continue
+		}
+		share := float64(times[i]) / float64(total)
+		d.shares = append(d.shares, share)
+		d.colors = append(d.colors, stageColors[stage])
+		p.Legend.Add(fmt.Sprintf("%s %.0f%% (%s per night)", stage, 100*share, formatDuration(times[i]/time.Duration(len(nights)))), swatch{stageColors[stage]})
+	}
+	p.Add(d)
+	return p, nil
+}
+
+// compositionChart draws the share of the time in each stage over the nights as a donut
+func compositionChart(ctx context.Context, data *nightData, opts chartOptions) error {
+	p, err := compositionPlot(data.nights)
+	if err != nil {
+		return err
+	}
+	return writeChart(ctx, p, 8*vg.Inch, 6*vg.Inch, opts.file)
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"sleep-stats/source"
+)
+
+// Config holds the settings read from the JSON config file
+type Config struct {
+	// derived metrics computed for every night, in order so later ones can use earlier ones
+	Metrics []MetricConfig `json:"metrics"`
+	// the first day of the week for weekly grouping and calendars, monday (the ISO-8601 default) or
+	// sunday
+	WeekStart string `json:"weekStart"`
+	// the holidays marked on the plot and compared with the working days like -holidays, a CSV
+	// file or a country code like US
+	Holidays string `json:"holidays"`
+	// what the daemon command does on every run
+	Daemon DaemonConfig `json:"daemon"`
+	// what a good night is for the sleep score
+	Score ScoreConfig `json:"score"`
+	// YYYY-MM-DD, compares the averages with the recommendations for the age like -age
+	Birthdate string `json:"birthdate"`
+	// the time zone the nights are in like -tz, e.g. Europe/Berlin
+	Timezone string `json:"timezone"`
+	// the font of the plots and charts
+	Font FontConfig `json:"font"`
+	// the ranges of the metrics shaded behind their series on the plot, durations for the
+	// durations, e.g. {"deep": ["45m", "1h30m"], "deepShare": ["13", "23"]}
+	Normal map[string][2]string `json:"normal"`
+	// the shares of the time asleep in percent of the core, deep and REM sleep, the weeks averaging
+	// outside them are warned about, e.g. {"deep": [13, 23], "rem": [20, 25]}
+	StageTargets map[string][2]float64 `json:"stageTargets"`
+}
+
+// FontConfig sets the font of the plots, e.g. {"file": "NotoSansJP-Regular.ttf", "title": 16}
+type FontConfig struct {
+	// a TrueType or OpenType font file, the built-in Liberation Serif by default
+	File string `json:"file"`
+	// the sizes in points, the ones left out keep the defaults of 12 for the title, the axis
+	// labels and the legend and 10 for the ticks
+	Title  float64 `json:"title"`
+	Axis   float64 `json:"axis"`
+	Tick   float64 `json:"tick"`
+	Legend float64 `json:"legend"`
+}
+
+// ScoreConfig tunes the sleep score, the values left out keep their defaults, e.g.
+// {"weights": {"duration": 50, "fragmentation": 0}, "duration": "7h30m"}
+type ScoreConfig struct {
+	// how much each part counts, 0 leaves a part out
+	Weights struct {
+		Duration      *float64 `json:"duration"`
+		Efficiency    *float64 `json:"efficiency"`
+		Stages        *float64 `json:"stages"`
+		Fragmentation *float64 `json:"fragmentation"`
+	} `json:"weights"`
+	// the time asleep scoring fully, 8h by default
+	Duration string `json:"duration"`
+	// the sleep efficiency in percent scoring fully, 90 by default
+	Efficiency float64 `json:"efficiency"`
+	// the share of deep and REM sleep in percent scoring fully, 40 by default
+	Stages float64 `json:"stages"`
+	// the awakenings per hour asleep where fragmentation scores nothing, 2 by default
+	Awakenings float64 `json:"awakenings"`
+}
+
+// DaemonConfig lists the inputs the daemon imports into the store and what it checks afterwards
+type DaemonConfig struct {
+	// the exports read again on every run, e.g. {"format": "apple", "file": "/sync/export.csv"}
+	Imports []ImportConfig `json:"imports"`
+	// the services fetched for the last days before importing them, e.g. ["oura"]
+	Fetch []string `json:"fetch"`
+	// conditions like -assert, a notification is sent for each one that fails
+	Assert []string `json:"assert"`
+	// URL the notifications are POSTed to as plain text, e.g. an ntfy.sh topic
+	Notify string `json:"notify"`
+}
+
+// ImportConfig is an input of the daemon like the -format, -file, -delimiter and field flags
+type ImportConfig struct {
+	Format    string        `json:"format"`
+	File      string        `json:"file"`
+	Delimiter string        `json:"delimiter"`
+	Fields    source.Fields `json:"fields"`
+}
+
+// MetricConfig defines a derived metric as an expression over the night's values, e.g.
+// {"name": "quality", "expr": "deep*2 + rem - awake*0.5"}
+type MetricConfig struct {
+	Name string `json:"name"`
+	Expr string `json:"expr"`
+}
+
+// the config used when no -config is given, it is fine for it not to exist
+func defaultConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "sleep-stats", "config.json")
+}
+
+// loadConfig reads the config file, an empty path reads the default config if there is one
+func loadConfig(path string) (*Config, error) {
+	explicit := path != ""
+	if !explicit {
+		path = defaultConfigPath()
+	}
+	config := &Config{}
+	if path == "" {
+		return config, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) && !explicit {
+		return config, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("invalid config %s: %w", path, err)
+	}
+	return config, nil
+}
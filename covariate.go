@@ -0,0 +1,212 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"image/color"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gonum.org/v1/gonum/stat"
+	"gonum.org/v1/plot"
+	"gonum.org/v1/plot/plotter"
+	"gonum.org/v1/plot/vg"
+	"gonum.org/v1/plot/vg/draw"
+
+	"sleep-stats/sleep"
+)
+
+// covariates are variables measured once a day by their name and date
+type covariates struct {
+	names  []string                      // in the order of the columns
+	values map[string]map[string]float64 // by date, then name
+}
+
+// readCovariates reads a CSV with a row per day, the date in the column named on and a covariate in
+// every other column with numbers. Cells that aren't numbers are left out.
+func readCovariates(path, on string) (covariates, error) {
+	c := covariates{values: make(map[string]map[string]float64)}
+	file, err := os.Open(path)
+	if err != nil {
+		return c, err
+	}
+	defer file.Close()
+	r := csv.NewReader(file)
+	r.FieldsPerRecord = -1
+	header, err := r.Read()
+	if err != nil {
+		return c, fmt.Errorf("reading the header of %s: %w", path, err)
+	}
+	dateColumn := slices.IndexFunc(header, func(name string) bool { return strings.EqualFold(strings.TrimSpace(name), on) })
+	if dateColumn < 0 {
+		return c, fmt.Errorf("%s has no column %q, found %s", path, on, strings.Join(header, ", "))
+	}
+
+	found := make([]bool, len(header))
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return c, err
+		}
+		line, _ := r.FieldPos(0)
+		date, err := parseCovariateDate(record[dateColumn])
+		if err != nil {
+			return c, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		for i, cell := range record {
+			v, err := strconv.ParseFloat(strings.TrimSpace(cell), 64)
+			if i == dateColumn || i >= len(header) || err != nil {
+				continue
+			}
+			if c.values[date] == nil {
+				c.values[date] = make(map[string]float64)
+			}
+			c.values[date][strings.TrimSpace(header[i])] = v
+			found[i] = true
+		}
+	}
+	for i, name := range header {
+		if found[i] {
+			c.names = append(c.names, strings.TrimSpace(name))
+		}
+	}
+	if len(c.names) == 0 {
+		return c, fmt.Errorf("%s has no columns with numbers", path)
+	}
+	return c, nil
+}
+
+// the date of a covariate as YYYY-MM-DD, the time of timestamps is left out
+func parseCovariateDate(value string) (string, error) {
+	value = strings.TrimSpace(value)
+	if len(value) >= len(sleep.DateLayout) {
+		if _, err := time.Parse(sleep.DateLayout, value[:len(sleep.DateLayout)]); err == nil {
+			return value[:len(sleep.DateLayout)], nil
+		}
+	}
+	return "", fmt.Errorf("invalid date %q, expected the format YYYY-MM-DD", value)
+}
+
+// the metrics the covariates are compared with, weekday is no measurement and asleep is total
+func comparedMetrics(data *nightData) []string {
+	return slices.DeleteFunc(append(slices.Clone(baseMetricNames), data.derived.names...), func(name string) bool {
+		return name == "weekday" || name == "asleep"
+	})
+}
+
+// covariateFit is the linear regression of a metric on a covariate over the nights having both
+type covariateFit struct {
+	covariate, metric string
+	xs, ys            []float64
+	r                 float64 // the Pearson correlation
+	slope, p          float64
+}
+
+func fitCovariate(covariate, metric string, xs, ys []float64) covariateFit {
+	fit := covariateFit{covariate: covariate, metric: metric, xs: xs, ys: ys}
+	fit.slope, fit.p = regressionSlope(xs, ys)
+	if _, sx := stat.MeanStdDev(xs, nil); sx > 0 {
+		if _, sy := stat.MeanStdDev(ys, nil); sy > 0 {
+			fit.r = stat.Correlation(xs, ys, nil)
+		}
+	}
+	return fit
+}
+
+// analyzeCovariates correlates each covariate of -covariate with each metric of the nights on the
+// same date, prints the fits from the most to the least significant and charts the strongest
+func analyzeCovariates(ctx context.Context, w io.Writer, data *nightData, opts analyzeOptions) error {
+	if opts.covariate == "" {
+		return errors.New("give the CSV of the daily variables with -covariate")
+	}
+	c, err := readCovariates(opts.covariate, opts.on)
+	if err != nil {
+		return err
+	}
+
+	vars := make([]map[string]float64, len(data.nights))
+	for i, night := range data.nights {
+		vars[i] = allNightVars(night, data.derived)
+	}
+	var fits []covariateFit
+	for _, covariate := range c.names {
+		for _, metric := range comparedMetrics(data) {
+			var xs, ys []float64
+			for i, night := range data.nights {
+				x, ok := c.values[night.Day()][covariate]
+				if !ok {
+					continue
+				}
+				xs = append(xs, x)
+				ys = append(ys, vars[i][metric])
+			}
+			if len(xs) >= 3 {
+				fits = append(fits, fitCovariate(covariate, metric, xs, ys))
+			}
+		}
+	}
+	if len(fits) == 0 {
+		return fmt.Errorf("fewer than 3 nights have a date of %s", opts.covariate)
+	}
+	slices.SortStableFunc(fits, func(a, b covariateFit) int { return cmp.Compare(a.p, b.p) })
+
+	fmt.Fprintf(w, "%-16s %-12s %6s %7s %12s %7s\n", "Covariate", "Metric", "Nights", "r", "slope", "p")
+	for _, fit := range fits {
+		mark := ""
+		if fit.p < trendSignificance {
+			mark = " *"
+		}
+		fmt.Fprintf(w, "%-16s %-12s %6d %+7.2f %+12.4g %7.3f%s\n", fit.covariate, fit.metric, len(fit.xs), fit.r, fit.slope, fit.p, mark)
+	}
+	fmt.Fprintf(w, "\nThe slope is the change of the metric per unit of the covariate, * marks p < %.2f.\n", trendSignificance)
+
+	if opts.chart == "" {
+		return nil
+	}
+	p, err := scatterFit(fits[0].xs, fits[0].ys)
+	if err != nil {
+		return err
+	}
+	p.Title.Text = fmt.Sprintf("%s by %s (r = %.2f)", fits[0].metric, fits[0].covariate, fits[0].r)
+	p.X.Label.Text = fits[0].covariate
+	p.Y.Label.Text = fits[0].metric
+	return writeChart(ctx, p, 8*vg.Inch, 6*vg.Inch, opts.chart)
+}
+
+// scatterFit plots the points with the least squares line through them
+func scatterFit(xs, ys []float64) (*plot.Plot, error) {
+	points := make(plotter.XYs, len(xs))
+	for i := range xs {
+		points[i] = plotter.XY{X: xs[i], Y: ys[i]}
+	}
+	scatter, err := plotter.NewScatter(points)
+	if err != nil {
+		return nil, err
+	}
+	scatter.GlyphStyle.Color = color.RGBA{R: 0, G: 90, B: 200, A: 255}
+	scatter.GlyphStyle.Radius = vg.Points(2.5)
+	scatter.GlyphStyle.Shape = draw.CircleGlyph{}
+
+	alpha, beta := stat.LinearRegression(xs, ys, nil, false)
+	lo, hi := slices.Min(xs), slices.Max(xs)
+	line, err := plotter.NewLine(plotter.XYs{{X: lo, Y: alpha + beta*lo}, {X: hi, Y: alpha + beta*hi}})
+	if err != nil {
+		return nil, err
+	}
+	line.LineStyle.Color = color.RGBA{R: 200, G: 30, B: 30, A: 255}
+	line.LineStyle.Width = vg.Points(1.5)
+
+	p := plot.New()
+	p.Add(scatter, line)
+	return p, nil
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"sleep-stats/sleep"
+	"sleep-stats/source"
+	"sleep-stats/store"
+)
+
+// how many days back the daemon fetches from the services on every run
+const daemonFetchDays = 7
+
+// the file in -outdir the daemon writes the monthly digest to
+const digestFileName = "digest.html"
+
+// daemonCommand imports the inputs of the config into the store on a schedule, writes the plot,
+// stats and monthly digest of the store and sends a notification for each failed check
+func daemonCommand(fs *flag.FlagSet) func(ctx context.Context) error {
+	input := addInputFlags(fs)
+	// the daemon analyzes the store it imports into unless told otherwise
+	fs.Lookup("format").DefValue = "store"
+	*input.format = "store"
+	every := fs.Duration("every", 6*time.Hour, "time between the runs")
+	outdir := fs.String("outdir", ".", "directory the plot, stats.csv and "+digestFileName+" are written to after each run")
+	full := fs.Bool("full", false, "import the whole exports on the first run instead of only the sessions newer than the last import")
+	return func(ctx context.Context) error {
+		d := &daemon{input: input, outdir: *outdir, full: *full}
+		ticker := time.NewTicker(*every)
+		defer ticker.Stop()
+		for {
+			d.run(ctx)
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-ticker.C:
+			}
+		}
+	}
+}
+
+type daemon struct {
+	input  inputFlags
+	outdir string
+	full   bool // import everything on the next run
+}
+
+// run does one import and report, errors are logged and notified so the next run tries again
+func (d *daemon) run(ctx context.Context) {
+	config, err := d.input.loadConfig()
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	if err := d.update(ctx, config.Daemon); err != nil {
+		log.Println(err)
+		d.notify(ctx, config.Daemon.Notify, "sleep-stats daemon: "+err.Error())
+		return
+	}
+	d.full = false
+}
+
+func (d *daemon) update(ctx context.Context, config DaemonConfig) error {
+	storePath := store.DefaultPath()
+	if *d.input.format == "store" {
+		storePath = d.input.path()
+	}
+
+	for _, imp := range config.Imports {
+		result, skipped, err := importInput(ctx, storePath, imp, d.full)
+		if err != nil {
+			return err
+		}
+		log.Printf("Imported %d new sessions from %s, rejected %d duplicates, skipped %d rows", result.Added, imp.File, result.Duplicates, skipped)
+	}
+
+	// the fetched data only covers the last days, it is imported whole as late syncs can add
+	// sessions before the latest one
+	if len(config.Fetch) > 0 {
+		opts := fetchOptions{
+			clientID:     os.Getenv("FITBIT_CLIENT_ID"),
+			clientSecret: os.Getenv("FITBIT_CLIENT_SECRET"),
+			redirect:     defaultRedirect,
+			token:        os.Getenv("OURA_TOKEN"),
+		}
+		var err error
+		opts.start, opts.end, err = fetchRange(time.Now().AddDate(0, 0, -daemonFetchDays).Format(sleep.DateLayout), "")
+		if err != nil {
+			return err
+		}
+		for _, service := range config.Fetch {
+			fetch, ok := fetchers[service]
+			if !ok {
+				return fmt.Errorf("unknown service %q to fetch, known services are %v", service, sortedServices())
+			}
+			opts.dir = fetchDir(service)
+			if _, err := fetch(ctx, opts); err != nil {
+				return fmt.Errorf("fetching %s: %w", service, err)
+			}
+			sessions, _, err := parseSource(ctx, service, opts.dir, source.Options{}, nil, nil, false)
+			if err != nil {
+				return fmt.Errorf("importing %s: %w", opts.dir, err)
+			}
+			result, err := store.Import(storePath, sessions)
+			if err != nil {
+				return err
+			}
+			log.Printf("Imported %d new sessions from %s, rejected %d duplicates", result.Added, service, result.Duplicates)
+		}
+	}
+
+	data, err := d.input.analyze(ctx)
+	if err != nil {
+		return err
+	}
+	if len(data.nights) > 0 {
+		if err := createPlot(ctx, data.nights, data.derived, plotOptions{lines: true}, filepath.Join(d.outdir, plotName+".svg")); err != nil {
+			return err
+		}
+		// the digest is ready for a mailer to send as the body of a message
+		err = writeFile(ctx, filepath.Join(d.outdir, digestFileName), func(w io.Writer) error {
+			return writeDigest(w, data)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	err = writeFile(ctx, filepath.Join(d.outdir, "stats.csv"), func(w io.Writer) error {
+		return writeCSV(w, data, outputOptions{level: "night", shape: "wide"})
+	})
+	if err != nil {
+		return err
+	}
+
+	failures, err := checkAssertions(config.Assert, data)
+	if err != nil {
+		return err
+	}
+	for _, failure := range failures {
+		log.Println(failure)
+		d.notify(ctx, config.Notify, failure)
+	}
+	return nil
+}
+
+// notify POSTs the message as plain text to the URL, without one the log is all there is
+func (d *daemon) notify(ctx context.Context, url, message string) {
+	if url == "" {
+		return
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, strings.NewReader(message))
+	if err != nil {
+		log.Println("notifying:", err)
+		return
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		log.Println("notifying:", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		log.Println("notifying:", resp.Status)
+	}
+}
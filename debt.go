@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image/color"
+	"time"
+
+	"gonum.org/v1/plot"
+	"gonum.org/v1/plot/plotter"
+	"gonum.org/v1/plot/vg"
+
+	"sleep-stats/sleep"
+)
+
+// the color of the sleep debt
+var debtColor = color.RGBA{R: 200, G: 30, B: 30, A: 255}
+
+// sleepDebt returns every day from the first to the last night with the sleep debt on it, the
+// time asleep short of the goal over the nights of the trailing days up to it in hours. Longer
+// nights pay the debt back but don't build up a surplus, and days without a night count nothing.
+func sleepDebt(nights []*sleep.Night, goal time.Duration, days int) ([]time.Time, []float64) {
+	if len(nights) == 0 {
+		return nil, nil
+	}
+	short := make(map[string]float64, len(nights))
+	for _, night := range nights {
+		short[night.Key()] += (goal - night.TotalAsleep()).Hours()
+	}
+	var dates []time.Time
+	var debt []float64
+	last := nights[len(nights)-1].Date
+	for day := nights[0].Date; !day.After(last); day = day.AddDate(0, 0, 1) {
+		var sum float64
+		for back := range days {
+			sum += short[day.AddDate(0, 0, -back).Format(sleep.DateLayout)]
+		}
+		dates = append(dates, day)
+		debt = append(debt, max(0, sum))
+	}
+	return dates, debt
+}
+
+// debtChart plots the sleep debt against the duration goal of the score over the trailing days of
+// -window as an area
+func debtChart(ctx context.Context, data *nightData, opts chartOptions) error {
+	if opts.window <= 0 {
+		return errors.New("the window of the sleep debt needs at least a day")
+	}
+	dates, debt := sleepDebt(data.nights, data.score.duration, opts.window)
+	if len(dates) == 0 {
+		return errors.New("no nights to chart the sleep debt of")
+	}
+
+	p := plot.New()
+	p.Title.Text = fmt.Sprintf("Sleep Debt over the last %d days, against %s a night", opts.window, formatDuration(data.score.duration))
+	p.Y.Label.Text = "Hours behind"
+	p.Legend.Top = true
+
+	line := make(plotter.XYs, len(dates))
+	area := make(plotter.XYs, 0, len(dates)+2)
+	for i, day := range dates {
+		line[i] = plotter.XY{X: float64(day.Unix()), Y: debt[i]}
+	}
+	area = append(area, plotter.XY{X: line[0].X, Y: 0})
+	area = append(area, line...)
+	area = append(area, plotter.XY{X: line[len(line)-1].X, Y: 0})
+	polygon, err := plotter.NewPolygon(area)
+	if err != nil {
+		return err
+	}
+	polygon.Color = color.NRGBA{R: debtColor.R, G: debtColor.G, B: debtColor.B, A: 80}
+	polygon.LineStyle.Width = 0
+	p.Add(polygon)
+
+	l, err := plotter.NewLine(line)
+	if err != nil {
+		return err
+	}
+	l.LineStyle.Color = debtColor
+	l.LineStyle.Width = vg.Points(2)
+	p.Add(l)
+	p.Legend.Add(fmt.Sprintf("Debt, %s now", formatDuration(time.Duration(debt[len(debt)-1]*float64(time.Hour)))), l)
+
+	p.X.Tick.Marker = plot.TimeTicks{Format: "2006-01"}
+	p.Y.Min = 0
+	p.Y.Max = max(p.Y.Max, 1)
+	return writeChart(ctx, p, 12*vg.Inch, 5*vg.Inch, opts.file)
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"image/color"
+	"time"
+
+	"gonum.org/v1/plot"
+	"gonum.org/v1/plot/plotter"
+	"gonum.org/v1/plot/vg"
+	"gonum.org/v1/plot/vg/draw"
+
+	"sleep-stats/sleep"
+)
+
+const (
+	// the nights averaged into the trend, about a month so the weeks even out
+	trendWindow = 29
+	// how often the trend and the weekly component are refined in turn
+	decomposeRounds = 3
+)
+
+// decomposition splits the total sleep of every day from the first to the last night into a
+// trend, a weekly component and what is left, in hours
+type decomposition struct {
+	dates                               []time.Time
+	observed, trend, seasonal, residual []float64
+}
+
+// decompose separates the total sleep in the manner of STL, alternating between the weekday means
+// of the series without the trend and the moving average of the series without the weekday means.
+// Days without a night are interpolated from the nights around them.
+func decompose(nights []*sleep.Night) (decomposition, error) {
+	var d decomposition
+	if len(nights) < 14 {
+		return d, errors.New("need at least 14 nights to decompose")
+	}
+	d.dates, d.observed = dailySeries(nights, func(night *sleep.Night) float64 { return night.TotalAsleep().Hours() })
+
+	n := len(d.observed)
+	d.trend = make([]float64, n)
+	d.seasonal = make([]float64, n)
+	deseasoned := make([]float64, n)
+	for range decomposeRounds {
+		var sums, counts [7]float64
+		for i, v := range d.observed {
+			sums[i%7] += v - d.trend[i]
+			counts[i%7]++
+		}
+		var means [7]float64
+		var overall float64
+		for i := range means {
+			means[i] = sums[i] / counts[i]
+			overall += means[i] / 7
+		}
+		for i, v := range d.observed {
+			d.seasonal[i] = means[i%7] - overall
+			deseasoned[i] = v - d.seasonal[i]
+		}
+		d.trend = movingAverage(deseasoned, trendWindow)
+	}
+	d.residual = make([]float64, n)
+	for i, v := range d.observed {
+		d.residual[i] = v - d.trend[i] - d.seasonal[i]
+	}
+	return d, nil
+}
+
+// dailySeries returns every day from the first to the last night with the value of its night,
+// the days without one linearly interpolated from the days around them
+func dailySeries(nights []*sleep.Night, value func(night *sleep.Night) float64) ([]time.Time, []float64) {
+	if len(nights) == 0 {
+		return nil, nil
+	}
+	values := make(map[string]float64, len(nights))
+	for _, night := range nights {
+		values[night.Key()] = value(night)
+	}
+	var days []time.Time
+	last := nights[len(nights)-1].Date
+	for day := nights[0].Date; !day.After(last); day = day.AddDate(0, 0, 1) {
+		days = append(days, day)
+	}
+
+	series := make([]float64, len(days))
+	prev := -1
+	for i, day := range days {
+		v, ok := values[day.Format(sleep.DateLayout)]
+		if !ok {
+			continue
+		}
+		series[i] = v
+		if prev >= 0 {
+			for j := prev + 1; j < i; j++ {
+				series[j] = series[prev] + (v-series[prev])*float64(j-prev)/float64(i-prev)
+			}
+		}
+		prev = i
+	}
+	return days, series
+}
+
+// the centered moving average, narrowing towards the ends
+func movingAverage(values []float64, window int) []float64 {
+	avg := make([]float64, len(values))
+	half := window / 2
+	for i := range values {
+		begin, end := max(0, i-half), min(len(values), i+half+1)
+		sum := 0.0
+		for _, v := range values[begin:end] {
+			sum += v
+		}
+		avg[i] = sum / float64(end-begin)
+	}
+	return avg
+}
+
+// writeDecomposition writes the trend over the observed nights, the weekly component and the
+// residual of total sleep as three stacked plots
+func writeDecomposition(ctx context.Context, nights []*sleep.Night, filename string) error {
+	panels, err := decompositionPlots(nights)
+	if err != nil {
+		return err
+	}
+	return writePanels(ctx, panels, 15*vg.Inch, 12*vg.Inch, filename)
+}
+
+// decompositionPlots returns the plots of the trend, the weekly component and the residual of
+// total sleep, a row each
+func decompositionPlots(nights []*sleep.Night) ([][]*plot.Plot, error) {
+	d, err := decompose(nights)
+	if err != nil {
+		return nil, err
+	}
+	points := func(values []float64) plotter.XYs {
+		xys := make(plotter.XYs, len(values))
+		for i, v := range values {
+			xys[i] = plotter.XY{X: float64(d.dates[i].Unix()), Y: v}
+		}
+		return xys
+	}
+	panel := func(title string) *plot.Plot {
+		p := plot.New()
+		p.Title.Text = title
+		p.Y.Label.Text = "Hours"
+		p.X.Tick.Marker = plot.TimeTicks{Format: "2006-01"}
+		return p
+	}
+	line := func(values []float64, c color.Color) *plotter.Line {
+		l, err := plotter.NewLine(points(values))
+		if err != nil {
+			panic(err)
+		}
+		l.LineStyle.Color = c
+		l.LineStyle.Width = vg.Points(1.5)
+		return l
+	}
+
+	trend := panel("Total sleep and trend")
+	observed, err := plotter.NewScatter(points(d.observed))
+	if err != nil {
+		panic(err)
+	}
+	observed.GlyphStyle.Color = color.RGBA{R: 160, G: 160, B: 160, A: 255}
+	observed.GlyphStyle.Radius = vg.Points(1.5)
+	observed.GlyphStyle.Shape = draw.CircleGlyph{}
+	trend.Add(observed, line(d.trend, color.RGBA{R: 0, G: 90, B: 200, A: 255}))
+
+	weekly := panel("Weekly")
+	weekly.Add(line(d.seasonal, color.RGBA{R: 230, G: 140, B: 0, A: 255}))
+
+	residual := panel("Residual")
+	residual.X.Label.Text = "Date"
+	residual.Add(line(d.residual, color.RGBA{R: 128, G: 128, B: 128, A: 255}))
+
+	return [][]*plot.Plot{{trend}, {weekly}, {residual}}, nil
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"image/color"
+	"slices"
+
+	"gonum.org/v1/plot/plotter"
+	"gonum.org/v1/plot/vg"
+	"gonum.org/v1/plot/vg/draw"
+)
+
+// the color of the rings around the flagged nights, apart from the colors of the stages
+var flagColor = color.RGBA{R: 220, G: 20, B: 60, A: 255}
+
+// flagNights returns the keys of the nights matching the condition of -flag
+func flagNights(condition string, data *nightData) (map[string]bool, error) {
+	names := slices.Concat(baseMetricNames, data.derived.names)
+	e, err := parseExpr(condition, func(name string) bool { return slices.Contains(names, name) })
+	if err != nil {
+		return nil, fmt.Errorf("invalid -flag: %w", err)
+	}
+	flagged := make(map[string]bool)
+	for _, night := range data.nights {
+		if e.eval(allNightVars(night, data.derived)) != 0 {
+			flagged[night.Key()] = true
+		}
+	}
+	return flagged, nil
+}
+
+// flagMarkers rings the points of the flagged nights of a series
+func flagMarkers(points plotter.XYs) *plotter.Scatter {
+	scatter, err := plotter.NewScatter(points)
+	if err != nil {
+		panic(err)
+	}
+	scatter.GlyphStyle = draw.GlyphStyle{Color: flagColor, Radius: vg.Points(5), Shape: draw.RingGlyph{}}
+	return scatter
+}
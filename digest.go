@@ -0,0 +1,129 @@
+package main
+
+import (
+	"cmp"
+	_ "embed"
+	"fmt"
+	"html/template"
+	"io"
+	"math"
+	"slices"
+	"time"
+
+	"gonum.org/v1/gonum/stat"
+	"gonum.org/v1/plot/vg"
+
+	"sleep-stats/sleep"
+)
+
+//go:embed digest.html
+var digestHTML string
+
+var digestTemplate = template.Must(template.New("digest").Parse(digestHTML))
+
+// the nights listed as the best and the worst of the month
+const digestNights = 3
+
+// monthlyDigest is what the digest template shows of the last month of the nights
+type monthlyDigest struct {
+	Month    string
+	Previous string // the month compared with, empty without nights in it
+	Nights   int
+
+	Stats       []digestStat
+	Best, Worst []digestNight
+
+	Plot        template.URL
+	Composition template.URL // empty without sleep stages
+}
+
+// digestStat is the average of a metric over the month and its change since the month before
+type digestStat struct {
+	Metric, Value, Change string
+	Color                 string // green for better, red for worse, inherit without a direction
+}
+
+type digestNight struct{ Date, Total, Score string }
+
+// writeDigest writes the digest of the month of the last night as a page that can be sent by
+// mail: the averages with their change from the month before, the best and worst nights by score
+// and the charts of the month embedded as SVG
+func writeDigest(w io.Writer, data *nightData) error {
+	if len(data.nights) == 0 {
+		return fmt.Errorf("no nights to report")
+	}
+	last := data.nights[len(data.nights)-1].Date
+	month := time.Date(last.Year(), last.Month(), 1, 0, 0, 0, 0, last.Location())
+	previous := month.AddDate(0, -1, 0)
+	nights := nightsBetween(data.nights, month, month.AddDate(0, 1, 0))
+	before := nightsBetween(data.nights, previous, month)
+
+	digest := monthlyDigest{Month: month.Format("January 2006"), Nights: len(nights)}
+	if len(before) > 0 {
+		digest.Previous = previous.Format("January 2006")
+	}
+
+	averages := func(nights []*sleep.Night, name string) float64 {
+		values := make([]float64, len(nights))
+		for i, night := range nights {
+			values[i] = allNightVars(night, data.derived)[name]
+		}
+		return stat.Mean(values, nil)
+	}
+	for _, name := range comparedMetrics(data) {
+		s := digestStat{Metric: name, Value: formatMetric(name, averages(nights, name)), Color: "inherit"}
+		if len(before) > 0 {
+			change := averages(nights, name) - averages(before, name)
+			s.Change = fmt.Sprintf("%+.2f", change)
+			if isDuration(name) {
+				change = math.Round(change*60) + 0
+				s.Change = fmt.Sprintf("%+.0f min", change)
+			}
+			switch direction := metricDirections[name]; {
+			case change*direction > 0:
+				s.Color = "#2a7a2a"
+			case change*direction < 0:
+				s.Color = "#c33"
+			}
+		}
+		digest.Stats = append(digest.Stats, s)
+	}
+
+	ranked := slices.Clone(nights)
+	slices.SortStableFunc(ranked, func(a, b *sleep.Night) int {
+		return cmp.Compare(data.derived.scores[b.Key()], data.derived.scores[a.Key()])
+	})
+	show := func(night *sleep.Night) digestNight {
+		return digestNight{night.Key(), formatDuration(night.TotalAsleep()), fmt.Sprintf("%.0f", data.derived.scores[night.Key()])}
+	}
+	for i := range min(digestNights, len(ranked)) {
+		digest.Best = append(digest.Best, show(ranked[i]))
+		digest.Worst = append(digest.Worst, show(ranked[len(ranked)-1-i]))
+	}
+
+	err := renderAll(
+		func() (err error) {
+			digest.Plot, err = svgURL(buildPlot(nights, data.derived, plotOptions{lines: true}), 15*vg.Inch, 8*vg.Inch)
+			return err
+		},
+		func() error {
+			p, err := compositionPlot(nights)
+			if err != nil {
+				return nil
+			}
+			digest.Composition, err = svgURL(p, 8*vg.Inch, 6*vg.Inch)
+			return err
+		},
+	)
+	if err != nil {
+		return err
+	}
+	return digestTemplate.Execute(w, digest)
+}
+
+// nightsBetween returns the nights from the start up to the end, the nights are in date order
+func nightsBetween(nights []*sleep.Night, start, end time.Time) []*sleep.Night {
+	from, _ := slices.BinarySearchFunc(nights, start, func(night *sleep.Night, t time.Time) int { return night.Date.Compare(t) })
+	to, _ := slices.BinarySearchFunc(nights, end, func(night *sleep.Night, t time.Time) int { return night.Date.Compare(t) })
+	return nights[from:to]
+}
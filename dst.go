@@ -0,0 +1,72 @@
+package main
+
+import (
+	"image/color"
+	"time"
+
+	"gonum.org/v1/plot"
+	"gonum.org/v1/plot/font"
+	"gonum.org/v1/plot/text"
+	"gonum.org/v1/plot/vg"
+	"gonum.org/v1/plot/vg/draw"
+
+	"sleep-stats/sleep"
+)
+
+// dstChanges returns the days from the first to the last night on which the clocks of their time
+// zone changed, none for UTC
+func dstChanges(nights []*sleep.Night) []time.Time {
+	if len(nights) == 0 {
+		return nil
+	}
+	var days []time.Time
+	last := nights[len(nights)-1].Date
+	for day := nights[0].Date; !day.After(last); day = day.AddDate(0, 0, 1) {
+		_, before := day.Zone()
+		_, after := day.AddDate(0, 0, 1).Zone()
+		if before != after {
+			days = append(days, day)
+		}
+	}
+	return days
+}
+
+// dstMarkers draws a subtle dotted line labeled DST at the days the clocks changed, vertical at
+// the x values of the dates of a time series or horizontal at the y values of the rows of the days
+type dstMarkers struct {
+	at         []float64
+	horizontal bool
+}
+
+func (m dstMarkers) Plot(c draw.Canvas, plt *plot.Plot) {
+	trX, trY := plt.Transforms(&c)
+	line := draw.LineStyle{Color: color.RGBA{R: 120, G: 120, B: 120, A: 120}, Width: vg.Points(0.5), Dashes: []vg.Length{vg.Points(1), vg.Points(2)}}
+	style := text.Style{
+		Color:   color.RGBA{R: 120, G: 120, B: 120, A: 255},
+		Font:    font.From(plot.DefaultFont, vg.Points(7)),
+		XAlign:  text.XRight,
+		YAlign:  text.YBottom,
+		Handler: plt.TextHandler,
+	}
+	for _, v := range m.at {
+		if m.horizontal {
+			y := trY(v)
+			c.StrokeLine2(line, c.Min.X, y, c.Max.X, y)
+			c.FillText(style, vg.Point{X: c.Max.X, Y: y}, "DST")
+			continue
+		}
+		x := trX(v)
+		c.StrokeLine2(line, x, c.Min.Y, x, c.Max.Y)
+		c.FillText(style, vg.Point{X: x - vg.Points(1), Y: c.Min.Y}, "DST")
+	}
+}
+
+// dstRows marks the rows of the days the clocks changed on a chart with a row per day counted
+// from first, the line is at the top of the row of the day moved down by shift rows
+func dstRows(nights []*sleep.Night, first time.Time, shift int) dstMarkers {
+	m := dstMarkers{horizontal: true}
+	for _, day := range dstChanges(nights) {
+		m.at = append(m.at, float64(int(day.Sub(first).Hours()/24+0.5)+shift))
+	}
+	return m
+}
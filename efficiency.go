@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"image/color"
+
+	"gonum.org/v1/plot"
+	"gonum.org/v1/plot/plotter"
+	"gonum.org/v1/plot/vg"
+	"gonum.org/v1/plot/vg/draw"
+
+	"sleep-stats/sleep"
+)
+
+const (
+	// the efficiency of good sleep, lower is time lying awake and higher can be too little time in bed
+	efficiencyLow, efficiencyHigh = 85, 95
+	// the nights of the rolling average of the efficiency chart
+	efficiencyWindow = 7
+)
+
+// efficiencyChart plots the share of the time in bed asleep of each night in percent with its
+// rolling average over a week and the target band. Nights without time in bed have no efficiency
+// and are left out.
+func efficiencyChart(ctx context.Context, data *nightData, opts chartOptions) error {
+	var points plotter.XYs
+	for _, night := range data.nights {
+		if efficiency := night.Efficiency(); efficiency > 0 {
+			points = append(points, plotter.XY{X: float64(night.Date.Unix()), Y: 100 * efficiency})
+		}
+	}
+	if len(points) == 0 {
+		return errors.New("no nights with time in bed to chart the efficiency of")
+	}
+
+	p := plot.New()
+	p.Title.Text = "Sleep Efficiency"
+	p.Y.Label.Text = "Asleep of the time in bed (%)"
+	p.Legend.Top = true
+	p.Add(bands{{x0: points[0].X, x1: points[len(points)-1].X, y0: efficiencyLow, y1: efficiencyHigh,
+		color: color.RGBA{R: 0, G: 160, B: 0, A: 40}}})
+
+	scatter, err := plotter.NewScatter(points)
+	if err != nil {
+		return err
+	}
+	scatter.GlyphStyle.Color = stageColors[sleep.Asleep]
+	scatter.GlyphStyle.Radius = vg.Points(2)
+	scatter.GlyphStyle.Shape = draw.CircleGlyph{}
+	p.Add(scatter)
+	p.Legend.Add("Night", scatter)
+
+	ys := make([]float64, len(points))
+	for i := range points {
+		ys[i] = points[i].Y
+	}
+	avg := make(plotter.XYs, len(points))
+	for i, y := range movingAverage(ys, efficiencyWindow) {
+		avg[i] = plotter.XY{X: points[i].X, Y: y}
+	}
+	line, err := plotter.NewLine(avg)
+	if err != nil {
+		return err
+	}
+	line.LineStyle.Color = derivedColors[0]
+	line.LineStyle.Width = vg.Points(2)
+	p.Add(line)
+	p.Legend.Add("7-night average", line)
+
+	p.X.Tick.Marker = plot.TimeTicks{Format: "2006-01"}
+	p.Y.Max = max(p.Y.Max, 100)
+	return writeChart(ctx, p, 12*vg.Inch, 5*vg.Inch, opts.file)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image/color"
+	"io"
+	"time"
+
+	"gonum.org/v1/plot"
+	"gonum.org/v1/plot/plotter"
+	"gonum.org/v1/plot/vg"
+
+	"sleep-stats/sleep"
+)
+
+// the histogram of the episode lengths counts the longer ones in its last bin
+const episodeHistogramHours = 12
+
+// analyzeEpisodes clusters the sleep of the nights into episodes separated by at least
+// -episode-gap awake or without data, prints each episode with its stats and charts a histogram
+// of their lengths. Several episodes in a night show fragmented sleep, short ones by day naps.
+func analyzeEpisodes(ctx context.Context, w io.Writer, data *nightData, opts analyzeOptions) error {
+	var asleep []sleep.Session
+	for _, night := range data.nights {
+		for _, session := range night.Sessions {
+			if session.Stage.IsAsleep() {
+				asleep = append(asleep, session)
+			}
+		}
+	}
+	if len(asleep) == 0 {
+		return errors.New("no sleep to cluster into episodes")
+	}
+	episodes := sleep.Episodes(asleep, opts.episodeGap)
+
+	fmt.Fprintf(w, "Episodes of sleep separated by at least %s:\n", formatDuration(opts.episodeGap))
+	fmt.Fprintf(w, "%-16s  %-16s  %8s  %8s  %10s  %8s\n", "Start", "End", "length", "asleep", "efficiency", "sessions")
+	const layout = "2006-01-02 15:04"
+	lengths := make([]float64, len(episodes))
+	var total time.Duration
+	perDate := make(map[string]int)
+	for i, e := range episodes {
+		asleep := e.TotalAsleep()
+		fmt.Fprintf(w, "%-16s  %-16s  %8s  %8s  %9.0f%%  %8d\n", e.Start.Format(layout), e.End.Format(layout),
+			formatDuration(e.Length()), formatDuration(asleep), 100*asleep.Seconds()/e.Length().Seconds(), len(e.Sessions))
+		lengths[i] = min(e.Length().Hours(), episodeHistogramHours)
+		total += e.Length()
+		perDate[e.Key()]++
+	}
+	fragmented := 0
+	for _, n := range perDate {
+		if n > 1 {
+			fragmented++
+		}
+	}
+	fmt.Fprintf(w, "\n%d episodes on %d days, %.1f per day, lasting %s on average.\n", len(episodes), len(perDate),
+		float64(len(episodes))/float64(len(perDate)), formatDuration(total/time.Duration(len(episodes))))
+	fmt.Fprintf(w, "%d days had more than one episode.\n", fragmented)
+
+	if opts.chart == "" {
+		return nil
+	}
+	p := plot.New()
+	p.Title.Text = "Sleep Episodes by Length"
+	p.X.Label.Text = fmt.Sprintf("Hours (%d+ in the last bin)", episodeHistogramHours)
+	p.Y.Label.Text = "Episodes"
+	hist, err := plotter.NewHist(plotter.Values(lengths), episodeHistogramHours*4)
+	if err != nil {
+		return err
+	}
+	hist.FillColor = color.RGBA{R: 0, G: 90, B: 200, A: 255}
+	hist.LineStyle.Width = 0
+	p.Add(hist)
+	return writeChart(ctx, p, 10*vg.Inch, 5*vg.Inch, opts.chart)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"sleep-stats/source"
+)
+
+// the exit codes scripts can tell the conditions of a run apart by, 1 is any other error
+const (
+	// invalid flags or arguments
+	exitUsage = 2
+	// an -assert condition doesn't hold
+	exitAssertFailed = 3
+	// no nights are left after -start, -end, -where or -source-name, or the file has none
+	exitNoNights = 4
+	// the input can't be read, like a missing file or an unknown header
+	exitUnreadable = 5
+	// rows couldn't be parsed, the run skipped them or with -strict stopped at the first
+	exitSkippedRows = 6
+	// the run was interrupted, 128 and the number of SIGINT like shells exit with
+	exitInterrupted = 130
+)
+
+// exitError is an error with its own exit code, without an err when the run reported the condition
+// itself
+type exitError struct {
+	err  error
+	code int
+}
+
+func (e *exitError) Error() string {
+	if e.err == nil {
+		return fmt.Sprintf("exit status %d", e.code)
+	}
+	return e.err.Error()
+}
+
+func (e *exitError) Unwrap() error { return e.err }
+
+// exitCode is the exit code of the error, 1 without an exitError in its chain
+func exitCode(err error) int {
+	var e *exitError
+	switch {
+	case errors.Is(err, context.Canceled):
+		return exitInterrupted
+	case errors.As(err, &e):
+		return e.code
+	}
+	return 1
+}
+
+// exit prints the error of a run unless the run reported it and exits with its code
+func exit(err error) {
+	var e *exitError
+	if !errors.As(err, &e) || e.err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	os.Exit(exitCode(err))
+}
+
+// skippedRows is the error a run that read the rows finishes with, nil when none were skipped. The
+// rows are reported with the output, so the error only sets the exit code.
+func skippedRows(skipped []*source.RowError) error {
+	if len(skipped) == 0 {
+		return nil
+	}
+	return &exitError{code: exitSkippedRows}
+}
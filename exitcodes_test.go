@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"sleep-stats/sleep/sleeptest"
+	"sleep-stats/source/apple"
+)
+
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{errors.New("failed"), 1},
+		{&exitError{errors.New("unknown report"), exitUsage}, exitUsage},
+		{fmt.Errorf("reading: %w", &exitError{code: exitSkippedRows}), exitSkippedRows},
+		{fmt.Errorf("rendering: %w", context.Canceled), exitInterrupted},
+		{&exitError{fmt.Errorf("error reading sleep data: %w", context.Canceled), exitUnreadable}, exitInterrupted},
+	}
+	for _, test := range tests {
+		if got := exitCode(test.err); got != test.want {
+			t.Errorf("exitCode(%v) = %d, want %d", test.err, got, test.want)
+		}
+	}
+}
+
+func TestPlotExitCodes(t *testing.T) {
+	dir := t.TempDir()
+	var export bytes.Buffer
+	if err := apple.Write(&export, sleeptest.Generate(30, 1)); err != nil {
+		t.Fatal(err)
+	}
+	name := filepath.Join(dir, "export.csv")
+	if err := os.WriteFile(name, export.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	skipping := filepath.Join(dir, "skipping.csv")
+	row := "HKCategoryTypeIdentifierSleepAnalysis,Apple Watch,,\"Watch6,1\",,yesterday,2024-12-01 06:00:00 +0000,asleepCore\n"
+	if err := os.WriteFile(skipping, append(export.Bytes(), row...), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	config := filepath.Join(dir, "config.json")
+	if err := os.WriteFile(config, []byte("{}"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	tests := []struct {
+		name string
+		ctx  context.Context
+		args []string
+		want int
+	}{
+		{"nights", context.Background(), []string{"-file", name}, 0},
+		{"unknown report", context.Background(), []string{"-file", name, "-report", "weekly"}, exitUsage},
+		{"unknown travel", context.Background(), []string{"-file", name, "-travel", "hide"}, exitUsage},
+		{"failed assertion", context.Background(), []string{"-file", name, "-assert", "avg(total, 7d) > 12h"}, exitAssertFailed},
+		{"holding assertion", context.Background(), []string{"-file", name, "-assert", "avg(total, 7d) > 1h"}, 0},
+		{"no nights", context.Background(), []string{"-file", name, "-where", "total > 12h"}, exitNoNights},
+		{"missing file", context.Background(), []string{"-file", filepath.Join(dir, "missing.csv")}, exitUnreadable},
+		{"skipped rows", context.Background(), []string{"-file", skipping}, exitSkippedRows},
+		{"strict", context.Background(), []string{"-file", skipping, "-strict"}, exitSkippedRows},
+		{"interrupted", canceled, []string{"-file", name}, exitInterrupted},
+	}
+
+	// the output of the runs isn't looked at
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer devNull.Close()
+	stdout, stderr := os.Stdout, os.Stderr
+	os.Stdout, os.Stderr = devNull, devNull
+	defer func() { os.Stdout, os.Stderr = stdout, stderr }()
+
+	for _, test := range tests {
+		fs := flag.NewFlagSet("plot", flag.ContinueOnError)
+		run := plotCommand(fs)
+		if err := fs.Parse(append([]string{"-config", config, "-plot", "none"}, test.args...)); err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		err := run(test.ctx)
+		if got := exitCode(err); (err == nil && test.want != 0) || (err != nil && got != test.want) {
+			t.Errorf("%s: exited with %d (%v), want %d", test.name, got, err, test.want)
+		}
+	}
+}
@@ -0,0 +1,359 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// expr is a parsed expression over named values, e.g. deep*2 + rem - awake*0.5 or
+// total < 6h && weekday in (Sat, Sun). Conditions evaluate to 1 for true and 0 for false.
+type expr interface {
+	eval(vars map[string]float64) float64
+}
+
+type numberExpr float64
+
+type varExpr string
+
+type unaryExpr struct {
+	op rune
+	x  expr
+}
+
+type binaryExpr struct {
+	op   rune
+	x, y expr
+}
+
+type callExpr struct {
+	fn   string
+	args []expr
+}
+
+type inExpr struct {
+	x    expr
+	list []expr
+}
+
+func (e numberExpr) eval(map[string]float64) float64 { return float64(e) }
+
+func (e varExpr) eval(vars map[string]float64) float64 { return vars[string(e)] }
+
+func (e unaryExpr) eval(vars map[string]float64) float64 {
+	if e.op == '!' {
+		return boolValue(e.x.eval(vars) == 0)
+	}
+	return -e.x.eval(vars)
+}
+
+func (e binaryExpr) eval(vars map[string]float64) float64 {
+	x := e.x.eval(vars)
+	// short circuit the logical operators
+	switch e.op {
+	case '&':
+		return boolValue(x != 0 && e.y.eval(vars) != 0)
+	case '|':
+		return boolValue(x != 0 || e.y.eval(vars) != 0)
+	}
+
+	y := e.y.eval(vars)
+	switch e.op {
+	case '+':
+		return x + y
+	case '-':
+		return x - y
+	case '*':
+		return x * y
+	case '/':
+		return x / y
+	case '<':
+		return boolValue(x < y)
+	case '>':
+		return boolValue(x > y)
+	case '≤':
+		return boolValue(x <= y)
+	case '≥':
+		return boolValue(x >= y)
+	case '=':
+		return boolValue(x == y)
+	default:
+		return boolValue(x != y)
+	}
+}
+
+func (e inExpr) eval(vars map[string]float64) float64 {
+	x := e.x.eval(vars)
+	for _, item := range e.list {
+		if item.eval(vars) == x {
+			return 1
+		}
+	}
+	return 0
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func (e callExpr) eval(vars map[string]float64) float64 {
+	x := e.args[0].eval(vars)
+	switch e.fn {
+	case "abs":
+		return math.Abs(x)
+	case "min":
+		return math.Min(x, e.args[1].eval(vars))
+	default:
+		return math.Max(x, e.args[1].eval(vars))
+	}
+}
+
+// the number of arguments of the functions that can be called
+var exprFuncs = map[string]int{"abs": 1, "min": 2, "max": 2}
+
+// named constants, the weekdays match time.Weekday
+var exprConstants = map[string]float64{
+	"Sun": 0, "Mon": 1, "Tue": 2, "Wed": 3, "Thu": 4, "Fri": 5, "Sat": 6,
+}
+
+var twoCharOperators = []string{"&&", "||", "<=", ">=", "==", "!="}
+
+// the binary operators by precedence level, lowest first, with the rune used in binaryExpr
+var exprOperators = []map[string]rune{
+	{"||": '|'},
+	{"&&": '&'},
+	{"<": '<', ">": '>', "<=": '≤', ">=": '≥', "==": '=', "!=": '≠'},
+	{"+": '+', "-": '-'},
+	{"*": '*', "/": '/'},
+}
+
+// the level of exprOperators with the comparisons, where 'in' also belongs
+const comparisonLevel = 2
+
+// parseExpr parses the expression, every variable has to be one of the known names
+func parseExpr(s string, known func(name string) bool) (expr, error) {
+	return parseExprWith(s, known, nil)
+}
+
+// aggregateFunc creates the expression for an aggregate call like avg(total, 7d) from its text,
+// the per night expression x and the window in days, 0 when no window was given
+type aggregateFunc func(text string, x expr, days int) expr
+
+// parseExprWith parses the expression also allowing the aggregate functions, which can't be nested
+func parseExprWith(s string, known func(name string) bool, aggregates map[string]aggregateFunc) (expr, error) {
+	p := &exprParser{input: s, known: known, aggregates: aggregates}
+	p.next()
+	e, err := p.parseBinary(0)
+	if err != nil {
+		return nil, err
+	}
+	if p.tok != "" {
+		return nil, fmt.Errorf("unexpected %q at position %d", p.tok, p.pos)
+	}
+	return e, nil
+}
+
+// a recursive descent parser, each level of exprOperators is binary := next-level (op next-level)*
+// with the comparison level also allowing binary 'in' '(' list ')', and
+// unary := ('-'|'!') unary | number | duration | name | name '(' args ')' | '(' binary ')'
+// where durations like 6h30m are in hours.
+type exprParser struct {
+	input      string
+	known      func(name string) bool
+	aggregates map[string]aggregateFunc
+	tok        string // the current token, empty at the end
+	pos        int    // position of the current token
+	end        int    // position after the current token
+}
+
+func (p *exprParser) next() {
+	for p.end < len(p.input) && p.input[p.end] == ' ' {
+		p.end++
+	}
+	p.pos = p.end
+	if p.end == len(p.input) {
+		p.tok = ""
+		return
+	}
+	r := rune(p.input[p.end])
+	switch {
+	case unicode.IsDigit(r) || r == '.':
+		// numbers or durations
+		for p.end < len(p.input) && strings.IndexByte("0123456789.dhms", p.input[p.end]) >= 0 {
+			p.end++
+		}
+	case unicode.IsLetter(r) || r == '_':
+		for p.end < len(p.input) && (unicode.IsLetter(rune(p.input[p.end])) || unicode.IsDigit(rune(p.input[p.end])) || p.input[p.end] == '_') {
+			p.end++
+		}
+	default:
+		p.end++
+		// the two character operators
+		if p.end < len(p.input) && slices.Contains(twoCharOperators, p.input[p.pos:p.end+1]) {
+			p.end++
+		}
+	}
+	p.tok = p.input[p.pos:p.end]
+}
+
+func (p *exprParser) parseBinary(level int) (expr, error) {
+	if level == len(exprOperators) {
+		return p.parseUnary()
+	}
+	x, err := p.parseBinary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if p.tok == "in" && level == comparisonLevel {
+			p.next()
+			list, err := p.parseList()
+			if err != nil {
+				return nil, err
+			}
+			x = inExpr{x, list}
+			continue
+		}
+		op, ok := exprOperators[level][p.tok]
+		if !ok {
+			return x, nil
+		}
+		p.next()
+		y, err := p.parseBinary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		x = binaryExpr{op, x, y}
+	}
+}
+
+// parse a parenthesized, comma separated list of expressions, the current token is the (
+func (p *exprParser) parseList() ([]expr, error) {
+	if p.tok != "(" {
+		return nil, fmt.Errorf("expected ( at position %d", p.pos)
+	}
+	var list []expr
+	for p.tok != ")" {
+		p.next() // skip the ( or ,
+		if p.tok == ")" && len(list) == 0 {
+			break
+		}
+		item, err := p.parseBinary(0)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, item)
+		if p.tok != "," && p.tok != ")" {
+			return nil, fmt.Errorf("expected , or ) at position %d", p.pos)
+		}
+	}
+	p.next()
+	return list, nil
+}
+
+func (p *exprParser) parseUnary() (expr, error) {
+	tok, pos := p.tok, p.pos
+	switch {
+	case tok == "":
+		return nil, fmt.Errorf("unexpected end of expression")
+	case tok == "-" || tok == "!":
+		p.next()
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return unaryExpr{rune(tok[0]), x}, nil
+	case tok == "(":
+		p.next()
+		x, err := p.parseBinary(0)
+		if err != nil {
+			return nil, err
+		}
+		if p.tok != ")" {
+			return nil, fmt.Errorf("missing ) at position %d", p.pos)
+		}
+		p.next()
+		return x, nil
+	case unicode.IsDigit(rune(tok[0])) || tok[0] == '.':
+		p.next()
+		if strings.ContainsAny(tok, "dhms") {
+			d, err := time.ParseDuration(tok)
+			if err != nil {
+				return nil, fmt.Errorf("invalid duration %q at position %d", tok, pos)
+			}
+			return numberExpr(d.Hours()), nil
+		}
+		n, err := strconv.ParseFloat(tok, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q at position %d", tok, pos)
+		}
+		return numberExpr(n), nil
+	case unicode.IsLetter(rune(tok[0])) || tok[0] == '_':
+		p.next()
+		if p.tok == "(" {
+			return p.parseCall(tok, pos)
+		}
+		if value, ok := exprConstants[tok]; ok {
+			return numberExpr(value), nil
+		}
+		if !p.known(tok) {
+			return nil, fmt.Errorf("unknown name %q at position %d", tok, pos)
+		}
+		return varExpr(tok), nil
+	}
+	return nil, fmt.Errorf("unexpected %q at position %d", tok, pos)
+}
+
+func (p *exprParser) parseCall(fn string, pos int) (expr, error) {
+	if aggregate, ok := p.aggregates[fn]; ok {
+		return p.parseAggregate(fn, pos, aggregate)
+	}
+	arity, ok := exprFuncs[fn]
+	if !ok {
+		return nil, fmt.Errorf("unknown function %q at position %d", fn, pos)
+	}
+	args, err := p.parseList()
+	if err != nil {
+		return nil, err
+	}
+	if len(args) != arity {
+		return nil, fmt.Errorf("%s takes %d arguments, got %d", fn, arity, len(args))
+	}
+	return callExpr{fn, args}, nil
+}
+
+// parse the arguments of an aggregate, the per night expression and an optional window in days
+func (p *exprParser) parseAggregate(fn string, pos int, aggregate aggregateFunc) (expr, error) {
+	aggregates := p.aggregates
+	p.aggregates = nil
+	defer func() { p.aggregates = aggregates }()
+
+	p.next() // skip the (
+	x, err := p.parseBinary(0)
+	if err != nil {
+		return nil, err
+	}
+	days := 0
+	if p.tok == "," {
+		p.next()
+		n, err := strconv.Atoi(strings.TrimSuffix(p.tok, "d"))
+		if err != nil || !strings.HasSuffix(p.tok, "d") || n <= 0 {
+			return nil, fmt.Errorf("%s window %q at position %d should be a number of days like 7d", fn, p.tok, p.pos)
+		}
+		days = n
+		p.next()
+	}
+	if p.tok != ")" {
+		return nil, fmt.Errorf("missing ) at position %d", p.pos)
+	}
+	text := p.input[pos:p.end]
+	p.next()
+	return aggregate(text, x, days), nil
+}
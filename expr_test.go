@@ -0,0 +1,74 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseExpr(t *testing.T) {
+	vars := map[string]float64{"total": 7.5, "deep": 1, "rem": 2, "awake": 0.5, "weekday": 6}
+	known := func(name string) bool { _, ok := vars[name]; return ok }
+	tests := []struct {
+		input string
+		want  float64
+	}{
+		{"deep*2 + rem - awake*0.5", 3.75},
+		{"1 + 2 * 3", 7},
+		{"(1 + 2) * 3", 9},
+		{"8 - 2 - 1", 5},
+		{"8 / 2 / 2", 2},
+		{"-deep + 3", 2},
+		{"total < 6h30m", 0},
+		{"total >= 7h30m", 1},
+		{"1 + 1 == 2", 1},
+		{"1 < 2 == 1", 1},
+		{"0 || 1 && 0", 0},
+		{"1 || 0 && 0", 1},
+		{"!(deep > rem) && total > 7h", 1},
+		{"weekday in (Sat, Sun)", 1},
+		{"weekday in (Mon, Tue) || rem == 2", 1},
+		{"deep + 1 in (2, 3)", 1},
+		{"abs(awake - deep)", 0.5},
+		{"max(deep, min(rem, 1.5))", 1.5},
+	}
+	for _, test := range tests {
+		e, err := parseExpr(test.input, known)
+		if err != nil {
+			t.Errorf("%s: %v", test.input, err)
+			continue
+		}
+		if got := e.eval(vars); got != test.want {
+			t.Errorf("%s = %v, want %v", test.input, got, test.want)
+		}
+	}
+}
+
+func TestParseExprErrors(t *testing.T) {
+	known := func(name string) bool { return name == "total" }
+	tests := []struct {
+		input string
+		err   string
+	}{
+		{"", "unexpected end of expression"},
+		{"total <", "unexpected end of expression"},
+		{"total > 6h)", `unexpected ")" at position 10`},
+		{"(total > 6h", "missing ) at position 11"},
+		{"deep > 1", `unknown name "deep" at position 0`},
+		{"total > 6x", `unexpected "x" at position 9`},
+		{"total > 6hh", `invalid duration "6hh" at position 8`},
+		{"total > 1..2", `invalid number "1..2" at position 8`},
+		{"sqrt(total)", `unknown function "sqrt" at position 0`},
+		{"min(total)", "min takes 2 arguments, got 1"},
+		{"total in 1, 2", "expected ( at position 9"},
+		{"total in (1 2)", "expected , or ) at position 12"},
+		{"total * * 2", `unexpected "*" at position 8`},
+	}
+	for _, test := range tests {
+		_, err := parseExpr(test.input, known)
+		if err == nil {
+			t.Errorf("%q parsed, want the error %q", test.input, test.err)
+		} else if !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%q: got the error %q, want %q", test.input, err, test.err)
+		}
+	}
+}
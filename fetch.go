@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"golang.org/x/exp/maps"
+	"golang.org/x/oauth2"
+
+	"sleep-stats/sleep"
+	"sleep-stats/source/fitbit"
+	"sleep-stats/source/oura"
+)
+
+type fetchOptions struct {
+	start, end   time.Time
+	dir          string
+	clientID     string
+	clientSecret string
+	redirect     string
+	token        string
+}
+
+// the redirect URL of the local server receiving the OAuth2 authorization
+const defaultRedirect = "http://localhost:8189/callback"
+
+// the services that can be fetched from, each stores its data in a directory read by the source
+// of the same name and returns the number of records stored
+var fetchers = map[string]func(ctx context.Context, opts fetchOptions) (int, error){
+	"fitbit": fetchFitbit,
+	"oura":   fetchOura,
+}
+
+// fetchCommand downloads the sleep data of a service, e.g. sleep-stats fetch fitbit -start 2024-01-01
+func fetchCommand(fs *flag.FlagSet) func(ctx context.Context) error {
+	start := fs.String("start", "", "first date to fetch in YYYY-MM-DD format, defaults to 30 days before -end")
+	end := fs.String("end", "", "last date to fetch in YYYY-MM-DD format, defaults to today")
+	dir := fs.String("dir", "", "directory to store the data in, defaults to "+fetchDir("<service>"))
+	clientID := fs.String("client-id", os.Getenv("FITBIT_CLIENT_ID"), "OAuth2 client ID of your Fitbit app, defaults to $FITBIT_CLIENT_ID")
+	clientSecret := fs.String("client-secret", os.Getenv("FITBIT_CLIENT_SECRET"), "OAuth2 client secret of your Fitbit app, defaults to $FITBIT_CLIENT_SECRET")
+	redirect := fs.String("redirect", defaultRedirect, "OAuth2 redirect URL registered for your Fitbit app")
+	token := fs.String("token", os.Getenv("OURA_TOKEN"), "Oura personal access token, defaults to $OURA_TOKEN")
+	return func(ctx context.Context) error {
+		if fs.NArg() == 0 {
+			return &exitError{fmt.Errorf("Usage: %s fetch <service> [flags], the services are %v", os.Args[0], sortedServices()), exitUsage}
+		}
+		service := fs.Arg(0)
+		fetch, ok := fetchers[service]
+		if !ok {
+			return &exitError{fmt.Errorf("unknown service %q, known services are %v", service, sortedServices()), exitUsage}
+		}
+		// the flags can also follow the service
+		fs.Parse(fs.Args()[1:])
+
+		opts := fetchOptions{dir: *dir, clientID: *clientID, clientSecret: *clientSecret, redirect: *redirect, token: *token}
+		if opts.dir == "" {
+			opts.dir = fetchDir(service)
+		}
+		var err error
+		if opts.start, opts.end, err = fetchRange(*start, *end); err != nil {
+			return err
+		}
+		count, err := fetch(ctx, opts)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Fetched %d records into %s, analyze them with: -format %s -file %s\n", count, opts.dir, service, opts.dir)
+		return nil
+	}
+}
+
+func sortedServices() []string {
+	names := maps.Keys(fetchers)
+	slices.Sort(names)
+	return names
+}
+
+// where the fetched data of the service is kept by default
+func fetchDir(service string) string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = "."
+	}
+	return filepath.Join(dir, "sleep-stats", service)
+}
+
+// the dates to fetch, the last 30 days by default
+func fetchRange(start, end string) (time.Time, time.Time, error) {
+	to, err := time.Parse(sleep.DateLayout, time.Now().Format(sleep.DateLayout))
+	if end != "" {
+		if to, err = time.Parse(sleep.DateLayout, end); err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid end date format: %w", err)
+		}
+	}
+	from := to.AddDate(0, 0, -30)
+	if start != "" {
+		if from, err = time.Parse(sleep.DateLayout, start); err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid start date format: %w", err)
+		}
+	}
+	if from.After(to) {
+		return time.Time{}, time.Time{}, fmt.Errorf("the start date %s is after the end date %s", start, end)
+	}
+	return from, to, err
+}
+
+func fetchFitbit(ctx context.Context, opts fetchOptions) (int, error) {
+	if opts.clientID == "" {
+		return 0, errors.New("please provide the client ID of your Fitbit app with -client-id, register one at https://dev.fitbit.com/apps")
+	}
+	conf := &oauth2.Config{
+		ClientID:     opts.clientID,
+		ClientSecret: opts.clientSecret,
+		Endpoint:     fitbit.Endpoint,
+		RedirectURL:  opts.redirect,
+		Scopes:       []string{fitbit.Scope},
+	}
+	tokenFile := tokenPath("fitbit")
+	token, err := readToken(tokenFile)
+	if errors.Is(err, os.ErrNotExist) {
+		token, err = authorize(ctx, conf)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	tokens := conf.TokenSource(ctx, token)
+	count, err := fitbit.Fetch(ctx, oauth2.NewClient(ctx, tokens), opts.start, opts.end, opts.dir)
+	// Fitbit refresh tokens can only be used once, so the latest is saved even when fetching failed
+	if token, tokenErr := tokens.Token(); tokenErr == nil {
+		if saveErr := saveToken(tokenFile, token); saveErr != nil && err == nil {
+			err = saveErr
+		}
+	}
+	return count, err
+}
+
+func fetchOura(ctx context.Context, opts fetchOptions) (int, error) {
+	if opts.token == "" {
+		return 0, errors.New("please provide your Oura personal access token with -token, create one at https://cloud.ouraring.com/personal-access-tokens")
+	}
+	client := oauth2.NewClient(ctx, oauth2.StaticTokenSource(&oauth2.Token{AccessToken: opts.token}))
+	return oura.Fetch(ctx, client, opts.start, opts.end, opts.dir)
+}
+
+// the OAuth2 tokens of a service are kept next to the config
+func tokenPath(service string) string {
+	return filepath.Join(filepath.Dir(defaultConfigPath()), service+"-token.json")
+}
+
+func readToken(path string) (*oauth2.Token, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	token := &oauth2.Token{}
+	if err := json.Unmarshal(data, token); err != nil {
+		return nil, fmt.Errorf("invalid token %s, delete it to authorize again: %w", path, err)
+	}
+	return token, nil
+}
+
+func saveToken(path string, token *oauth2.Token) error {
+	data, err := json.Marshal(token)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o600)
+}
+
+// authorize runs the OAuth2 authorization code flow with PKCE: the user opens the printed URL and
+// the browser is redirected back to a server listening on the redirect URL with the code
+func authorize(ctx context.Context, conf *oauth2.Config) (*oauth2.Token, error) {
+	redirect, err := url.Parse(conf.RedirectURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid redirect URL: %w", err)
+	}
+	listener, err := net.Listen("tcp", redirect.Host)
+	if err != nil {
+		return nil, err
+	}
+
+	verifier := oauth2.GenerateVerifier()
+	state := oauth2.GenerateVerifier()
+	codes := make(chan string, 1)
+	errs := make(chan error, 1)
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if r.URL.Path != redirect.Path || query.Get("state") != state {
+			http.NotFound(w, r)
+			return
+		}
+		if reason := query.Get("error"); reason != "" {
+			fmt.Fprintln(w, "Authorization failed, see the terminal.")
+			select {
+			case errs <- fmt.Errorf("authorization failed: %s %s", reason, query.Get("error_description")):
+			default:
+			}
+			return
+		}
+		fmt.Fprintln(w, "Authorized, you can close this tab.")
+		select {
+		case codes <- query.Get("code"):
+		default:
+		}
+	})}
+	go server.Serve(listener)
+	defer server.Close()
+
+	fmt.Fprintf(os.Stderr, "Open this URL to allow reading your sleep data:\n\n  %s\n\n",
+		conf.AuthCodeURL(state, oauth2.S256ChallengeOption(verifier)))
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case err := <-errs:
+		return nil, err
+	case code := <-codes:
+		return conf.Exchange(ctx, code, oauth2.VerifierOption(verifier))
+	}
+}
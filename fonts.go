@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"golang.org/x/image/font/opentype"
+	"gonum.org/v1/plot"
+	"gonum.org/v1/plot/font"
+	"gonum.org/v1/plot/plotter"
+	"gonum.org/v1/plot/vg"
+)
+
+// the typeface the font file of the config is registered as
+const configTypeface = "config"
+
+// the font sizes of the config, applied to each plot when it is drawn
+var fontSizes FontConfig
+
+// useFont makes the plots created from now on use the font file of the config and keeps its sizes
+// for applyFontSizes
+func useFont(c FontConfig) error {
+	fontSizes = c
+	if c.File == "" {
+		return nil
+	}
+	data, err := os.ReadFile(c.File)
+	if err != nil {
+		return err
+	}
+	face, err := opentype.Parse(data)
+	if err != nil {
+		return fmt.Errorf("invalid font %s: %w", c.File, err)
+	}
+	custom := font.Font{Typeface: configTypeface}
+	font.DefaultCache.Add(font.Collection{{Font: custom, Face: face}})
+	plot.DefaultFont = custom
+	plotter.DefaultFont = custom
+	return nil
+}
+
+// applyFontSizes sets the sizes of the title, axes and legend of the plot that the config has
+func applyFontSizes(p *plot.Plot) {
+	set := func(f *font.Font, size float64) {
+		if size > 0 {
+			f.Size = vg.Points(size)
+		}
+	}
+	set(&p.Title.TextStyle.Font, fontSizes.Title)
+	for _, axis := range []*plot.Axis{&p.X, &p.Y} {
+		set(&axis.Label.TextStyle.Font, fontSizes.Axis)
+		set(&axis.Tick.Label.Font, fontSizes.Tick)
+	}
+	set(&p.Legend.TextStyle.Font, fontSizes.Legend)
+}
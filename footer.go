@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"gonum.org/v1/gonum/floats"
+	"gonum.org/v1/gonum/stat"
+
+	"sleep-stats/sleep"
+)
+
+// the aggregates of the rows under the stats tables
+var footerAggregates = []struct {
+	name string
+	fn   func(values []float64) float64
+}{
+	{"mean", func(values []float64) float64 { return stat.Mean(values, nil) }},
+	{"median", median},
+	{"total", floats.Sum},
+}
+
+// footerValue aggregates the values of a metric over the nights, false for the metrics it makes no
+// sense for like the total of the scores or any aggregate of the weekdays
+func footerValue(aggregate, metric string, values []float64) (float64, bool) {
+	if metric == "weekday" || len(values) == 0 {
+		return 0, false
+	}
+	if aggregate == "total" && (!isDuration(metric) || metric == "longest") && metric != "awakeCount" {
+		return 0, false
+	}
+	for _, a := range footerAggregates {
+		if a.name == aggregate {
+			return a.fn(values), true
+		}
+	}
+	return 0, false
+}
+
+// writeStatsFooter prints the mean, median and total of the nights under the stats by date
+func writeStatsFooter(w io.Writer, nights []*sleep.Night, derived derivedStats) {
+	if len(nights) == 0 {
+		return
+	}
+	vars := make(map[string][]float64)
+	for _, night := range nights {
+		for name, value := range allNightVars(night, derived) {
+			vars[name] = append(vars[name], value)
+		}
+	}
+	for _, a := range footerAggregates {
+		cell := func(metric string) string {
+			v, ok := footerValue(a.name, metric, vars[metric])
+			switch {
+			case !ok:
+				return "-"
+			case isDuration(metric):
+				return time.Duration(v * float64(time.Hour)).Round(time.Second).String()
+			case metric == "awakeCount" && a.name != "total":
+				return strconv.FormatFloat(v, 'f', 1, 64)
+			case metric == "awakeCount" || metric == "score":
+				return strconv.FormatFloat(v, 'f', 0, 64)
+			}
+			return strconv.FormatFloat(v, 'f', 2, 64)
+		}
+		fmt.Fprintf(w, "%-10s\tBed: %s\tCore: %s\tREM: %s\tDeep: %s\tAwake: %s\tLongest: %s\tAwake Count: %s\tScore: %s",
+			a.name, cell("inBed"), cell("core"), cell("rem"), cell("deep"), cell("awake"), cell("longest"), cell("awakeCount"), cell("score"))
+		for _, name := range derived.names {
+			fmt.Fprintf(w, "\t%s: %s", name, cell(name))
+		}
+		fmt.Fprintln(w)
+	}
+}
+
+// footerRecords are the rows of the aggregates under the wide night table as CSV records, named
+// in the date column
+func footerRecords(table []tableColumn) [][]string {
+	records := make([][]string, len(footerAggregates))
+	for i, a := range footerAggregates {
+		records[i] = make([]string, len(table))
+		records[i][0] = a.name
+		for j, c := range table[1:] {
+			var values []float64
+			switch column := c.values.(type) {
+			case []float64:
+				values = column
+			case []int32:
+				for _, v := range column {
+					values = append(values, float64(v))
+				}
+			}
+			if v, ok := footerValue(a.name, c.name, values); ok {
+				records[i][j+1] = strconv.FormatFloat(v, 'f', -1, 64)
+			}
+		}
+	}
+	return records
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"io"
+	"os"
+
+	"sleep-stats/sleep/sleeptest"
+	"sleep-stats/source/apple"
+)
+
+// generateCommand writes an Apple Health export CSV of made-up nights, the fixtures the benchmarks
+// read, so a run on a large export can be profiled without one, e.g.
+// sleep-stats generate -nights 40000 -o large.csv && sleep-stats -cpuprofile cpu.prof -file large.csv
+func generateCommand(fs *flag.FlagSet) func(ctx context.Context) error {
+	nights := fs.Int("nights", 3650, "number of nights to write, each is about 25 rows")
+	seed := fs.Int64("seed", 1, "seed of the random nights, the same seed writes the same export")
+	out := fs.String("o", "", "file the export is written to, defaults to stdout")
+	return func(ctx context.Context) error {
+		if *nights < 1 {
+			return &exitError{errors.New("-nights has to be at least 1"), exitUsage}
+		}
+		write := func(w io.Writer) error { return apple.Write(w, sleeptest.Generate(*nights, *seed)) }
+		if *out == "" {
+			return write(os.Stdout)
+		}
+		return writeFile(ctx, *out, write)
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"sleep-stats/rpc"
+	"sleep-stats/sleep"
+	"sleep-stats/source"
+)
+
+// grpcCommand serves the analysis as the SleepStats gRPC service of rpc/sleepstats.proto
+func grpcCommand(fs *flag.FlagSet) func(ctx context.Context) error {
+	addr := fs.String("addr", "localhost:50051", "address to listen on")
+	config := fs.String("config", "", "JSON config file with the derived metrics, defaults to "+defaultConfigPath())
+	return func(ctx context.Context) error {
+		return runGRPC(ctx, *addr, *config)
+	}
+}
+
+func runGRPC(ctx context.Context, addr, configPath string) error {
+	// every call reads the config like a run of analyze, a broken one fails here instead
+	config, err := loadConfig(configPath)
+	if err != nil {
+		return err
+	}
+	if _, err := compileMetrics(config.Metrics); err != nil {
+		return err
+	}
+	if _, err := newScoreModel(config.Score); err != nil {
+		return err
+	}
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	server := grpc.NewServer()
+	rpc.RegisterSleepStatsServer(server, &grpcServer{config: configPath})
+
+	// finish the calls in progress on interrupt
+	go func() {
+		<-ctx.Done()
+		server.GracefulStop()
+	}()
+	fmt.Fprintf(os.Stderr, "Serving gRPC on %s\n", listener.Addr())
+	return server.Serve(listener)
+}
+
+type grpcServer struct {
+	rpc.UnimplementedSleepStatsServer
+	config string // the path of the config file, empty for the default one
+}
+
+// ParseAndAnalyze parses the chunks as they arrive and sends the nights once the client is done,
+// analyzed like the analyze command does a file with the config of the server. The number of
+// skipped rows is sent in the skipped-rows trailer.
+func (s *grpcServer) ParseAndAnalyze(stream rpc.SleepStats_ParseAndAnalyzeServer) error {
+	first, err := stream.Recv()
+	if err == io.EOF {
+		return status.Error(codes.InvalidArgument, "no sleep data was sent")
+	}
+	if err != nil {
+		return err
+	}
+
+	format := first.Format
+	if format == "" {
+		format = "apple"
+	}
+	src, err := source.New(format, source.Options{})
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	if _, ok := src.(source.ReaderSource); !ok {
+		return status.Errorf(codes.Unimplemented, "the %s format can only be read from a file", format)
+	}
+	fs := flag.NewFlagSet("grpc", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	input := addInputFlags(fs)
+	args := []string{"-config", s.config, "-format", format, "-delimiter", first.Delimiter, "-q"}
+	if first.Strict {
+		args = append(args, "-strict")
+	}
+	if err := fs.Parse(args); err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	// the chunks are piped into the source, closing the reader stops the copy when parsing fails
+	reader, writer := io.Pipe()
+	defer reader.Close()
+	go func() {
+		chunk := first.Chunk
+		for {
+			if _, err := writer.Write(chunk); err != nil {
+				return
+			}
+			req, err := stream.Recv()
+			if err != nil {
+				if err == io.EOF {
+					err = nil
+				}
+				writer.CloseWithError(err)
+				return
+			}
+			chunk = req.Chunk
+		}
+	}()
+
+	input.reader = reader
+	data, err := input.analyze(stream.Context())
+	if err != nil {
+		return grpcError(err)
+	}
+	stream.SetTrailer(metadata.Pairs("skipped-rows", strconv.Itoa(len(data.skipped))))
+	for _, night := range data.nights {
+		stats := &rpc.NightStats{
+			Date:        night.Key(),
+			InBedHours:  night.Time(sleep.InBed).Hours(),
+			AsleepHours: night.TotalAsleep().Hours(),
+			CoreHours:   night.Time(sleep.Core).Hours(),
+			DeepHours:   night.Time(sleep.Deep).Hours(),
+			RemHours:    night.Time(sleep.REM).Hours(),
+			AwakeHours:  night.Time(sleep.Awake).Hours(),
+			AwakeCount:  int32(night.InBedCount()),
+			Derived:     data.derived.values[night.Key()],
+		}
+		if err := stream.Send(stats); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// errors of the client's stream keep their status, anything else is a problem with the data
+func grpcError(err error) error {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return status.FromContextError(err).Err()
+	}
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	return status.Error(codes.InvalidArgument, err.Error())
+}
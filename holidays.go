@@ -0,0 +1,262 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"image/color"
+	"io"
+	"io/fs"
+	"math"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"golang.org/x/exp/maps"
+
+	"sleep-stats/sleep"
+)
+
+// holiday is a span of free days like a public holiday or a vacation, from the first to the last
+// day
+type holiday struct {
+	name       string
+	start, end time.Time
+}
+
+// contains tells whether the day is one of the holiday
+func (h holiday) contains(day time.Time) bool {
+	day = time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, time.UTC)
+	return !day.Before(h.start) && !day.After(h.end)
+}
+
+// holidayRule gives the date of a public holiday in a year
+type holidayRule struct {
+	name string
+	date func(year int) time.Time
+}
+
+// fixed is a holiday on the same day every year
+func fixed(name string, month time.Month, day int) holidayRule {
+	return holidayRule{name, func(year int) time.Time { return time.Date(year, month, day, 0, 0, 0, 0, time.UTC) }}
+}
+
+// nthWeekday is a holiday on the nth weekday of the month, the last one for -1
+func nthWeekday(name string, n int, weekday time.Weekday, month time.Month) holidayRule {
+	return holidayRule{name, func(year int) time.Time {
+		if n < 0 {
+			last := time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC)
+			return last.AddDate(0, 0, -(int(last.Weekday()-weekday)+7)%7)
+		}
+		first := time.Date(year, month, 1, 0, 0, 0, 0, time.UTC)
+		return first.AddDate(0, 0, (int(weekday-first.Weekday())+7)%7+7*(n-1))
+	}}
+}
+
+// easter is a holiday the days after Easter Sunday, before it for negative days
+func easter(name string, days int) holidayRule {
+	return holidayRule{name, func(year int) time.Time { return easterSunday(year).AddDate(0, 0, days) }}
+}
+
+// easterSunday is the date of Easter in the Gregorian calendar by the anonymous algorithm
+func easterSunday(year int) time.Time {
+	a, b, c := year%19, year/100, year%100
+	d, e := b/4, b%4
+	f := (b + 8) / 25
+	g := (b - f + 1) / 3
+	h := (19*a + b - d - g + 15) % 30
+	i, k := c/4, c%4
+	l := (32 + 2*e + 2*i - h - k) % 7
+	m := (a + 11*h + 22*l) / 451
+	month := (h + l - 7*m + 114) / 31
+	day := (h+l-7*m+114)%31 + 1
+	return time.Date(year, time.Month(month), day, 0, 0, 0, 0, time.UTC)
+}
+
+// the built-in calendars of the national public holidays, regional ones need a holidays file
+var holidayCalendars = map[string][]holidayRule{
+	"US": {
+		fixed("New Year's Day", time.January, 1),
+		nthWeekday("Martin Luther King Jr. Day", 3, time.Monday, time.January),
+		nthWeekday("Presidents' Day", 3, time.Monday, time.February),
+		nthWeekday("Memorial Day", -1, time.Monday, time.May),
+		fixed("Juneteenth", time.June, 19),
+		fixed("Independence Day", time.July, 4),
+		nthWeekday("Labor Day", 1, time.Monday, time.September),
+		nthWeekday("Columbus Day", 2, time.Monday, time.October),
+		fixed("Veterans Day", time.November, 11),
+		nthWeekday("Thanksgiving", 4, time.Thursday, time.November),
+		fixed("Christmas Day", time.December, 25),
+	},
+	"GB": {
+		fixed("New Year's Day", time.January, 1),
+		easter("Good Friday", -2),
+		easter("Easter Monday", 1),
+		nthWeekday("Early May Bank Holiday", 1, time.Monday, time.May),
+		nthWeekday("Spring Bank Holiday", -1, time.Monday, time.May),
+		nthWeekday("Summer Bank Holiday", -1, time.Monday, time.August),
+		fixed("Christmas Day", time.December, 25),
+		fixed("Boxing Day", time.December, 26),
+	},
+	"DE": {
+		fixed("Neujahr", time.January, 1),
+		easter("Karfreitag", -2),
+		easter("Ostermontag", 1),
+		fixed("Tag der Arbeit", time.May, 1),
+		easter("Christi Himmelfahrt", 39),
+		easter("Pfingstmontag", 50),
+		fixed("Tag der Deutschen Einheit", time.October, 3),
+		fixed("1. Weihnachtstag", time.December, 25),
+		fixed("2. Weihnachtstag", time.December, 26),
+	},
+	"FR": {
+		fixed("Jour de l'an", time.January, 1),
+		easter("Lundi de Pâques", 1),
+		fixed("Fête du Travail", time.May, 1),
+		fixed("Victoire 1945", time.May, 8),
+		easter("Ascension", 39),
+		easter("Lundi de Pentecôte", 50),
+		fixed("Fête nationale", time.July, 14),
+		fixed("Assomption", time.August, 15),
+		fixed("Toussaint", time.November, 1),
+		fixed("Armistice", time.November, 11),
+		fixed("Noël", time.December, 25),
+	},
+}
+
+// loadHolidays reads the holidays file, or gives the public holidays of the years of the nights
+// for a country code of the built-in calendars like US
+func loadHolidays(name string, nights []*sleep.Night) ([]holiday, error) {
+	rules, ok := holidayCalendars[strings.ToUpper(name)]
+	if !ok {
+		holidays, err := readHolidays(name)
+		if errors.Is(err, fs.ErrNotExist) && len(name) == 2 {
+			return nil, fmt.Errorf("no holidays file %s and no built-in calendar for the country, they are %v", name, sortedCountries())
+		}
+		return holidays, err
+	}
+	if len(nights) == 0 {
+		return nil, nil
+	}
+	var holidays []holiday
+	// the morning after the last night can be in the next year
+	for year := nights[0].Date.Year(); year <= nights[len(nights)-1].Date.AddDate(0, 0, 1).Year(); year++ {
+		for _, rule := range rules {
+			day := rule.date(year)
+			holidays = append(holidays, holiday{rule.name, day, day})
+		}
+	}
+	slices.SortStableFunc(holidays, func(a, b holiday) int { return a.start.Compare(b.start) })
+	return holidays, nil
+}
+
+func sortedCountries() []string {
+	countries := maps.Keys(holidayCalendars)
+	slices.Sort(countries)
+	return countries
+}
+
+// readHolidays reads a CSV with the columns start, end and name of the holidays and vacations, the
+// end can be left empty for a single day
+func readHolidays(path string) ([]holiday, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	r := csv.NewReader(file)
+	r.FieldsPerRecord = -1
+	header, err := r.Read()
+	if err != nil {
+		return nil, fmt.Errorf("reading the header of %s: %w", path, err)
+	}
+	columns := make(map[string]int, 3)
+	for _, name := range []string{"start", "end", "name"} {
+		i := slices.IndexFunc(header, func(h string) bool { return strings.EqualFold(strings.TrimSpace(h), name) })
+		if i < 0 && name != "end" {
+			return nil, fmt.Errorf("%s has no column %q, the holidays file needs the columns start, end and name", path, name)
+		}
+		columns[name] = i
+	}
+	field := func(record []string, name string) string {
+		if i := columns[name]; i >= 0 && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	var holidays []holiday
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		line, _ := r.FieldPos(0)
+		start, err := time.Parse(sleep.DateLayout, field(record, "start"))
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid start %q, expected the format YYYY-MM-DD", path, line, field(record, "start"))
+		}
+		end := start
+		if s := field(record, "end"); s != "" {
+			if end, err = time.Parse(sleep.DateLayout, s); err != nil || end.Before(start) {
+				return nil, fmt.Errorf("%s:%d: invalid end %q, expected a date like YYYY-MM-DD from the start on", path, line, s)
+			}
+		}
+		holidays = append(holidays, holiday{field(record, "name"), start, end})
+	}
+	return holidays, nil
+}
+
+// isHoliday tells whether the night is followed by a free day, the morning after it is in one of
+// the holidays
+func isHoliday(night *sleep.Night, holidays []holiday) bool {
+	morning := night.Date.AddDate(0, 0, 1)
+	return slices.ContainsFunc(holidays, func(h holiday) bool { return h.contains(morning) })
+}
+
+// splitHolidays splits the nights into those before a holiday and those before a working day, the
+// nights before weekends are in neither
+func splitHolidays(nights []*sleep.Night, holidays []holiday) (free, work []*sleep.Night) {
+	for _, night := range nights {
+		switch weekday := night.Date.AddDate(0, 0, 1).Weekday(); {
+		case isHoliday(night, holidays):
+			free = append(free, night)
+		case weekday != time.Saturday && weekday != time.Sunday:
+			work = append(work, night)
+		}
+	}
+	return free, work
+}
+
+// writeHolidays prints the means of the metrics of the nights before holidays next to those before
+// working days
+func writeHolidays(w io.Writer, data *nightData, holidays []holiday) {
+	free, work := splitHolidays(data.nights, holidays)
+	if len(free) == 0 || len(work) == 0 {
+		fmt.Fprintln(w, "\nComparing the holidays with the working days needs nights before both.")
+		return
+	}
+	fmt.Fprintf(w, "\nThe nights before holidays (%d) vs before working days (%d):\n", len(free), len(work))
+	for _, c := range compareBaseline(data, free, work) {
+		diff := fmt.Sprintf("%+.2f", c.recent-c.baseline)
+		if isDuration(c.metric) {
+			diff = fmt.Sprintf("%+.0f min", math.Round((c.recent-c.baseline)*60)+0)
+		}
+		fmt.Fprintf(w, "  %-12s %8s  vs %8s  %10s  (p=%.3f)\n", c.metric, formatMetric(c.metric, c.recent),
+			formatMetric(c.metric, c.baseline), diff, c.p)
+	}
+}
+
+// holidayMarkers shade the nights before the holidays on a plot of the nights and name them
+func holidayMarkers(holidays []holiday) spanMarkers {
+	m := spanMarkers{fill: color.RGBA{R: 255, G: 200, B: 60, A: 60}, label: color.RGBA{R: 150, G: 100, B: 0, A: 255}}
+	for _, h := range holidays {
+		// the nights are dated by the evening before the free day
+		m.spans = append(m.spans, nightSpan{h.name, h.start.AddDate(0, 0, -1), h.end.AddDate(0, 0, -1)})
+	}
+	return m
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"io"
+	"math"
+	"strconv"
+	"time"
+
+	"gonum.org/v1/gonum/stat"
+	"gonum.org/v1/plot"
+	"gonum.org/v1/plot/plotter"
+	"gonum.org/v1/plot/vg"
+
+	"sleep-stats/sleep"
+)
+
+//go:embed report.html
+var reportHTML string
+
+var reportTemplate = template.Must(template.New("report").Parse(reportHTML))
+
+// the metrics with a histogram on the distributions tab
+var distributionMetrics = []string{"total", "core", "deep", "rem", "awake", "longest"}
+
+// the total sleep in hours the colors of the calendar range over, shorter and longer nights get
+// the color of the ends
+const (
+	calendarShortest = 5.0
+	calendarLongest  = 9.0
+)
+
+// htmlReport is what the report template shows on its tabs
+type htmlReport struct {
+	First, Last string
+	Nights      int
+	Score       string
+
+	Averages    []htmlAverage
+	Plot        template.URL
+	Composition template.URL // empty without sleep stages
+	Warnings    []string     // the weeks missing the stage targets of the config
+
+	Trends        []htmlTrend
+	Decomposition template.URL // empty with too few nights
+
+	Distributions template.URL
+
+	Legend   []htmlDay
+	Weekdays []string // the heads of the columns of the calendar, from the first day of the week
+	Months   []htmlMonth
+
+	Header []string
+	Rows   [][]htmlCell
+}
+
+type htmlAverage struct{ Metric, Value string }
+
+type htmlTrend struct{ Metric, Class, Change, P string }
+
+// htmlMonth is a month of the calendar, weeks from the first day of the week with zero days outside
+// it
+type htmlMonth struct {
+	Name  string
+	Weeks [][]htmlDay
+}
+
+type htmlDay struct {
+	Day   int
+	Text  string
+	Title string // the date and total sleep of the night, empty without one
+	Style template.CSS
+}
+
+// htmlCell is a cell of the raw data, the value is what it sorts by
+type htmlCell struct{ Text, Value string }
+
+// writeHTML writes a single-file dashboard with tabs for the overview, the trends, the
+// distributions of the metrics, a calendar of the nights and the raw data. The charts are
+// embedded as SVG so the file can be opened anywhere.
+func writeHTML(w io.Writer, data *nightData, opts outputOptions) error {
+	if len(data.nights) == 0 {
+		return fmt.Errorf("no nights to report")
+	}
+	first, last := data.nights[0].Date, data.nights[len(data.nights)-1].Date
+	report := htmlReport{
+		First:    first.Format(sleep.DateLayout),
+		Last:     last.Format(sleep.DateLayout),
+		Nights:   len(data.nights),
+		Score:    data.score.String(),
+		Warnings: stageWarnings(data.nights, data.targets, opts.weekStart),
+	}
+
+	vars := make([]map[string]float64, len(data.nights))
+	for i, night := range data.nights {
+		vars[i] = allNightVars(night, data.derived)
+	}
+	metric := func(name string) []float64 {
+		values := make([]float64, len(vars))
+		for i := range vars {
+			values[i] = vars[i][name]
+		}
+		return values
+	}
+	for _, name := range comparedMetrics(data) {
+		report.Averages = append(report.Averages, htmlAverage{name, formatMetric(name, stat.Mean(metric(name), nil))})
+	}
+
+	for _, t := range calculateTrends(data) {
+		change := fmt.Sprintf("%+.2f/week", t.slope)
+		if isDuration(t.metric) {
+			change = fmt.Sprintf("%+.1f min/week", t.slope)
+		}
+		report.Trends = append(report.Trends, htmlTrend{t.metric, t.class(), change, fmt.Sprintf("%.3f", t.p)})
+	}
+
+	var histograms [][]*plot.Plot
+	for i, name := range distributionMetrics {
+		if i%3 == 0 {
+			histograms = append(histograms, nil)
+		}
+		p, err := histogram(name, metric(name))
+		if err != nil {
+			return err
+		}
+		histograms[len(histograms)-1] = append(histograms[len(histograms)-1], p)
+	}
+	err := renderAll(
+		func() (err error) {
+			report.Plot, err = svgURL(buildPlot(data.nights, data.derived, plotOptions{lines: true}), 15*vg.Inch, 8*vg.Inch)
+			return err
+		},
+		func() error {
+			p, err := compositionPlot(data.nights)
+			if err != nil {
+				// nothing to break down, the overview leaves it out
+				return nil
+			}
+			report.Composition, err = svgURL(p, 8*vg.Inch, 6*vg.Inch)
+			return err
+		},
+		func() error {
+			panels, err := decompositionPlots(data.nights)
+			if err != nil {
+				// too few nights to decompose, the tab says so
+				return nil
+			}
+			report.Decomposition, err = panelsURL(panels, 15*vg.Inch, 12*vg.Inch)
+			return err
+		},
+		func() (err error) {
+			report.Distributions, err = panelsURL(histograms, 15*vg.Inch, 8*vg.Inch)
+			return err
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	for hours := calendarShortest; hours <= calendarLongest; hours++ {
+		report.Legend = append(report.Legend, htmlDay{Text: strconv.Itoa(int(hours)) + "h", Style: calendarStyle(hours)})
+	}
+	for i := range 7 {
+		report.Weekdays = append(report.Weekdays, (opts.weekStart + time.Weekday(i)).String()[:2])
+	}
+	report.Months = calendarMonths(data.nights, opts.weekStart)
+
+	table := nightTable(data)
+	for _, c := range table {
+		report.Header = append(report.Header, c.name)
+	}
+	for row := range tableRows(table) {
+		cells := make([]htmlCell, len(table))
+		for i, c := range table {
+			value := formatCell(c.values, row)
+			cells[i] = htmlCell{value, value}
+			if values, ok := c.values.([]float64); ok {
+				cells[i].Text = strconv.FormatFloat(values[row], 'f', 2, 64)
+			}
+		}
+		report.Rows = append(report.Rows, cells)
+	}
+
+	return reportTemplate.Execute(w, report)
+}
+
+// formatMetric shows the durations like 7h05m and the other metrics with two decimals
+func formatMetric(name string, value float64) string {
+	if isDuration(name) {
+		return formatDuration(time.Duration(value * float64(time.Hour)))
+	}
+	return strconv.FormatFloat(value, 'f', 2, 64)
+}
+
+// histogram plots the distribution of the values of a metric over the nights
+func histogram(name string, values []float64) (*plot.Plot, error) {
+	hist, err := plotter.NewHist(plotter.Values(values), 20)
+	if err != nil {
+		return nil, err
+	}
+	hist.FillColor = stageColors[sleep.Core]
+	p := plot.New()
+	p.Title.Text = name
+	p.X.Label.Text = "Hours"
+	p.Y.Label.Text = "Nights"
+	p.Add(hist)
+	return p, nil
+}
+
+// calendarMonths lays out the months from the first to the last night as weeks starting on the
+// given day, the days with a night colored by its total sleep
+func calendarMonths(nights []*sleep.Night, weekStart time.Weekday) []htmlMonth {
+	byDate := make(map[string]*sleep.Night, len(nights))
+	for _, night := range nights {
+		byDate[night.Key()] = night
+	}
+	first, last := nights[0].Date, nights[len(nights)-1].Date
+	var months []htmlMonth
+	for month := time.Date(first.Year(), first.Month(), 1, 0, 0, 0, 0, first.Location()); !month.After(last); month = month.AddDate(0, 1, 0) {
+		m := htmlMonth{Name: month.Format("January 2006")}
+		week := make([]htmlDay, (int(month.Weekday()-weekStart)+7)%7, 7)
+		for day := month; day.Month() == month.Month(); day = day.AddDate(0, 0, 1) {
+			d := htmlDay{Day: day.Day()}
+			if night, ok := byDate[day.Format(sleep.DateLayout)]; ok {
+				total := night.TotalAsleep()
+				d.Title = night.Key() + " " + formatDuration(total)
+				d.Style = calendarStyle(total.Hours())
+			}
+			week = append(week, d)
+			if len(week) == 7 {
+				m.Weeks = append(m.Weeks, week)
+				week = make([]htmlDay, 0, 7)
+			}
+		}
+		if len(week) > 0 {
+			m.Weeks = append(m.Weeks, append(week, make([]htmlDay, 7-len(week))...))
+		}
+		months = append(months, m)
+	}
+	return months
+}
+
+// calendarStyle colors the hours of total sleep from red for short nights to blue for long ones
+func calendarStyle(hours float64) template.CSS {
+	share := math.Max(0, math.Min(1, (hours-calendarShortest)/(calendarLongest-calendarShortest)))
+	return template.CSS(fmt.Sprintf("background: hsl(%.0f, 60%%, 45%%)", share*220))
+}
+
+// svgURL renders the plot as an SVG data URL for embedding it in the page
+func svgURL(p *plot.Plot, width, height vg.Length) (template.URL, error) {
+	return panelsURL([][]*plot.Plot{{p}}, width, height)
+}
+
+// panelsURL renders the plots aligned in a grid as an SVG data URL
+func panelsURL(plots [][]*plot.Plot, width, height vg.Length) (template.URL, error) {
+	img, err := drawPanels(plots, width, height, "svg")
+	if err != nil {
+		return "", err
+	}
+	var svg bytes.Buffer
+	if _, err := img.WriteTo(&svg); err != nil {
+		return "", err
+	}
+	return template.URL("data:image/svg+xml;base64," + base64.StdEncoding.EncodeToString(svg.Bytes())), nil
+}
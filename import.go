@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"sleep-stats/source"
+	"sleep-stats/store"
+)
+
+// importCommand writes the sessions of an export into the store the analysis reads with
+// -format store
+func importCommand(fs *flag.FlagSet) func(ctx context.Context) error {
+	format := fs.String("format", "", fmt.Sprintf("format of the file, one of %v, detected from the file by default", source.Names()))
+	file := fs.String("file", "", "file to import, defaults to where fetch stores the data for those formats")
+	delimiter := fs.String("delimiter", "", `field delimiter like ";" or "\t", detected by default`)
+	storePath := fs.String("store", store.DefaultPath(), "the store to import into")
+	fields := addFieldFlags(fs)
+	full := fs.Bool("full", false, "import the whole file instead of only the sessions newer than the last import of it")
+	return func(ctx context.Context) error {
+		imp := ImportConfig{Format: *format, File: *file, Delimiter: *delimiter, Fields: fields.mapping()}
+		if imp.File == "" {
+			if _, fetched := fetchers[imp.Format]; fetched {
+				imp.File = fetchDir(imp.Format)
+			}
+		}
+		if imp.File == "" {
+			return errors.New("please provide the file to import with -file")
+		}
+		result, skipped, err := importInput(ctx, *storePath, imp, *full)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Imported %d new sessions into %s, rejected %d duplicates and skipped %d rows that could not be parsed\n",
+			result.Added, *storePath, result.Duplicates, skipped)
+		if skipped > 0 {
+			return &exitError{code: exitSkippedRows}
+		}
+		return nil
+	}
+}
+
+// importInput imports the sessions of an export into the store. Unless full only the sessions
+// starting at or after the latest one imported from the same file before are read, as a newer
+// export contains everything the older one did. It returns what the store did with the sessions
+// and the number of skipped rows.
+func importInput(ctx context.Context, storePath string, imp ImportConfig, full bool) (store.Result, int, error) {
+	var result store.Result
+	file, err := filepath.Abs(imp.File)
+	if err != nil {
+		return result, 0, err
+	}
+	if imp.Format == "" {
+		if imp.Format, err = source.Detect(imp.File); err != nil {
+			return result, 0, err
+		}
+	}
+	input := imp.Format + ":" + file
+	var since time.Time
+	if !full {
+		if since, err = store.LastImported(storePath, input); err != nil {
+			return result, 0, err
+		}
+	}
+
+	delimiter, err := parseDelimiter(imp.Delimiter)
+	if err != nil {
+		return result, 0, err
+	}
+	opts := source.Options{Delimiter: delimiter, Since: since, Fields: imp.Fields}
+	var startFilter *time.Time
+	if !since.IsZero() {
+		startFilter = &since
+	}
+	sessions, skipped, err := parseSource(ctx, imp.Format, imp.File, opts, startFilter, nil, false)
+	if err != nil {
+		return result, 0, fmt.Errorf("importing %s: %w", imp.File, err)
+	}
+	if result, err = store.Import(storePath, sessions); err != nil {
+		return result, 0, err
+	}
+
+	latest := since
+	for _, s := range sessions {
+		if s.Start.After(latest) {
+			latest = s.Start
+		}
+	}
+	return result, len(skipped), store.SetLastImported(storePath, input, latest)
+}
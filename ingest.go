@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"time"
+
+	"sleep-stats/sleep"
+	"sleep-stats/store"
+)
+
+// the largest payload accepted, a year of samples is well below
+const maxIngestSize = 32 << 20
+
+// a sleep sample as sent by Health Auto Export with the sleep data not aggregated, or by a
+// Shortcut passing on the Health samples
+type ingestSample struct {
+	StartDate string `json:"startDate"`
+	EndDate   string `json:"endDate"`
+	Value     string `json:"value"`
+	Source    string `json:"source"`
+	// Shortcuts name the source like the CSV export
+	SourceName string `json:"sourceName"`
+}
+
+// the layouts of Health Auto Export and of ISO 8601 dates from Shortcuts
+var ingestLayouts = []string{"2006-01-02 15:04:05 -0700", time.RFC3339}
+
+// serveIngest appends the sleep samples of a Health Auto Export or Shortcuts payload to the store
+// the dashboard reads and answers with the updated nights the samples belong to
+func (d *dashboard) serveIngest(w http.ResponseWriter, r *http.Request) {
+	if *d.input.format != "store" {
+		http.Error(w, "ingesting needs the dashboard to read the store, serve with -format store", http.StatusConflict)
+		return
+	}
+	if d.token != "" {
+		auth := r.Header.Get("Authorization")
+		if subtle.ConstantTimeCompare([]byte(auth), []byte("Bearer "+d.token)) != 1 {
+			http.Error(w, "missing or wrong token", http.StatusUnauthorized)
+			return
+		}
+	}
+
+	sessions, err := decodeIngest(http.MaxBytesReader(w, r.Body, maxIngestSize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	d.ingestMu.Lock()
+	result, err := store.Import(d.input.path(), sessions)
+	d.ingestMu.Unlock()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := d.reload(r.Context()); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	data, _, _ := d.current()
+	var nights []map[string]any
+	for _, night := range data.nights {
+		if slices.ContainsFunc(night.Sessions, func(s sleep.Session) bool {
+			return slices.ContainsFunc(sessions, func(i sleep.Session) bool { return i.Start.Equal(s.Start) })
+		}) {
+			nights = append(nights, nightJSON(night, data.derived))
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"ingested": result.Added, "duplicates": result.Duplicates, "nights": nights})
+}
+
+// decodeIngest reads the sleep samples of a Health Auto Export payload, where they are the data of
+// the sleep_analysis metric, or a plain array of samples
+func decodeIngest(r io.Reader) ([]sleep.Session, error) {
+	body, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	var samples []ingestSample
+	if body = bytes.TrimSpace(body); len(body) > 0 && body[0] == '[' {
+		if err := json.Unmarshal(body, &samples); err != nil {
+			return nil, fmt.Errorf("invalid samples: %w", err)
+		}
+	} else {
+		var export struct {
+			Data struct {
+				Metrics []struct {
+					Name string         `json:"name"`
+					Data []ingestSample `json:"data"`
+				} `json:"metrics"`
+			} `json:"data"`
+		}
+		if err := json.Unmarshal(body, &export); err != nil {
+			return nil, fmt.Errorf("invalid Health Auto Export payload: %w", err)
+		}
+		for _, metric := range export.Data.Metrics {
+			if metric.Name == "sleep_analysis" {
+				samples = append(samples, metric.Data...)
+			}
+		}
+	}
+	if len(samples) == 0 {
+		return nil, errors.New("no sleep samples found, export sleep_analysis without aggregating the sleep data")
+	}
+
+	sessions := make([]sleep.Session, len(samples))
+	for i, sample := range samples {
+		start, err := parseIngestTime(sample.StartDate)
+		if err != nil {
+			return nil, fmt.Errorf("sample %d: startDate: %w", i+1, err)
+		}
+		end, err := parseIngestTime(sample.EndDate)
+		if err != nil {
+			return nil, fmt.Errorf("sample %d: endDate: %w", i+1, err)
+		}
+		stage, err := sleep.ParseStage(sample.Value)
+		if err != nil {
+			return nil, fmt.Errorf("sample %d: %w", i+1, err)
+		}
+		sourceName := sample.SourceName
+		if sourceName == "" {
+			sourceName = sample.Source
+		}
+		_, offset := start.Zone()
+		sessions[i] = sleep.Session{Start: start, End: end, Stage: stage, SourceName: sourceName, Offset: offset}
+	}
+	return sessions, nil
+}
+
+// in the UTC offset of the phone, which travel detection needs
+func parseIngestTime(value string) (time.Time, error) {
+	for _, layout := range ingestLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid date %q", value)
+}
@@ -0,0 +1,390 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"sleep-stats/sleep"
+	"sleep-stats/source"
+	"sleep-stats/store"
+)
+
+// flags common to all commands for selecting the input data
+type inputFlags struct {
+	filename  *string
+	config    *string
+	format    *string
+	delimiter *string
+	start     *string
+	end       *string
+	where     *string
+	tz        *string
+	source    *string
+	fields    fieldFlags
+	strict    *bool
+	resume    *bool
+	interval  *time.Duration
+	mmap      *bool
+	keep      *bool
+	split     *bool
+	gap       *time.Duration
+	naps      *bool
+	verbose   *bool
+	quiet     *bool
+	// reader is read instead of the file when set, like the chunks of a gRPC call, in the format
+	// given as it can't be detected
+	reader io.Reader
+}
+
+func addInputFlags(fs *flag.FlagSet) inputFlags {
+	return inputFlags{
+		filename:  fs.String("file", "", "CSV file containing sleep data, defaults to where fetch and the store keep their data for those formats"),
+		config:    fs.String("config", "", "JSON config file, defaults to "+defaultConfigPath()),
+		format:    fs.String("format", "", fmt.Sprintf("format of the file, one of %v, detected from the file by default", source.Names())),
+		delimiter: fs.String("delimiter", "", `field delimiter like ";" or "\t", defaults to the sep= line of the file, tab for .tsv files or a comma`),
+		start:     fs.String("start", "", "Start date (inclusive) in YYYY-MM-DD format"),
+		end:       fs.String("end", "", "End date (inclusive) in YYYY-MM-DD format"),
+		where:     fs.String("where", "", `only include nights matching the condition, e.g. "total < 6h && weekday in (Sat, Sun)"`),
+		tz:        fs.String("tz", "", "time zone of the nights like Europe/Berlin or Local, overrides timezone in the config, defaults to UTC"),
+		fields:    addFieldFlags(fs),
+		source:    fs.String("source-name", "", `only read the sessions recorded by this device or app, e.g. "Niklas's Apple Watch", the sources command lists them`),
+		strict:    fs.Bool("strict", false, "stop at the first row that can't be parsed instead of skipping it"),
+		interval:  fs.Duration("checkpoint", 0, "write how far the file was read to <file>.checkpoint this often and when interrupted, so -resume continues a large apple or ndjson file, e.g. 1m"),
+		resume:    fs.Bool("resume", false, "continue reading the file where the checkpoint of an interrupted run with -checkpoint left off"),
+		mmap:      fs.Bool("mmap", false, "memory-map an apple export instead of reading it, which can be faster for a large file on a local disk"),
+		split:     fs.Bool("split-midnight", false, "split the sessions at midnight, so the nights are calendar days with the time slept on each"),
+		gap:       fs.Duration("gap", 0, "group the sessions into nights by the sleep periods separated by gaps of at least this long instead of by date, e.g. 4h for shift work"),
+		naps:      fs.Bool("exclude-naps", true, "take the short daytime episodes of sleep out of the nights and summarize them separately"),
+		keep:      fs.Bool("keep-implausible", false, "keep the sessions ending before they start, lasting over a day or ending in the future in the stats"),
+		verbose:   fs.Bool("v", false, "print how the input was read to stderr"),
+		quiet:     fs.Bool("q", false, "print nothing but the output and errors, not even the skipped rows"),
+	}
+}
+
+// flags naming the columns or keys the fields of the sessions are read from
+type fieldFlags struct {
+	start, end, stage, sourceName *string
+}
+
+func addFieldFlags(fs *flag.FlagSet) fieldFlags {
+	return fieldFlags{
+		start:      fs.String("start-field", "", "column or key the start of the sessions is read from, like startDate, start or startTime by default"),
+		end:        fs.String("end-field", "", "column or key the end of the sessions is read from, like endDate, end or endTime by default"),
+		stage:      fs.String("stage-field", "", "column or key the sleep stage is read from, like value, stage or level by default"),
+		sourceName: fs.String("source-field", "", "column or key the device or app recording the sessions is read from, like sourceName by default"),
+	}
+}
+
+func (f fieldFlags) mapping() source.Fields {
+	return source.Fields{Start: *f.start, End: *f.end, Stage: *f.stage, SourceName: *f.sourceName}
+}
+
+// nightData is the sleep data grouped into nights with the derived metrics of each night
+type nightData struct {
+	nights  []*sleep.Night
+	derived derivedStats
+	config  *Config
+	score   scoreModel
+	skipped []*source.RowError // the rows that couldn't be parsed
+	// the sessions left out as they can't be right, unless -keep-implausible
+	implausible []implausibleSession
+	// the naps taken out of the nights, unless -exclude-naps=false
+	naps []*sleep.Episode
+	// the nights left out of the trend fits by their key, like the travel of -travel exclude
+	untrended map[string]bool
+	// the shares of the stages of the config the weeks are checked against
+	targets []stageTarget
+	// the nights were passed to the sink of analyzeStreaming as they were read
+	streamed bool
+}
+
+// diagnostics are written to stderr so stdout only carries the output, with -q they are dropped
+func (f inputFlags) diagnostics() io.Writer {
+	if *f.quiet {
+		return io.Discard
+	}
+	return os.Stderr
+}
+
+// the writer of the -v diagnostics, nil without -v
+func (f inputFlags) verboseWriter() io.Writer {
+	if !*f.verbose || *f.quiet {
+		return nil
+	}
+	return os.Stderr
+}
+
+// read the config file given by -config or the default one
+func (f inputFlags) loadConfig() (*Config, error) {
+	return loadConfig(*f.config)
+}
+
+// parse the date filters and read the sleep data from the file
+func (f inputFlags) load(ctx context.Context) ([]sleep.Session, []*source.RowError, error) {
+	var sessions []sleep.Session
+	skipped, err := f.read(ctx, func(s sleep.Session) error {
+		sessions = append(sessions, s)
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return sessions, skipped, nil
+}
+
+// nightCutoff is the time of day before which a session belongs to the night before. In a time
+// zone the nights are from noon to noon so one crossing midnight there stays one night, in UTC and
+// with -split they are the calendar dates.
+func (f inputFlags) nightCutoff() time.Duration {
+	if *f.tz == "" || *f.split {
+		return 0
+	}
+	return 12 * time.Hour
+}
+
+// read is load passing the sessions to each as they are read, in the location of the nights. An
+// error of each stops reading and is returned as it is.
+func (f inputFlags) read(ctx context.Context, each func(sleep.Session) error) ([]*source.RowError, error) {
+	filename := f.path()
+	if filename == "" && f.reader == nil {
+		return nil, errors.New("please provide the CSV file as an argument")
+	}
+
+	loc, err := time.LoadLocation(*f.tz)
+	if err != nil {
+		return nil, fmt.Errorf("invalid time zone: %w", err)
+	}
+	var startDate, endDate *time.Time
+	if *f.start != "" {
+		parsedStart, err := time.ParseInLocation("2006-01-02", *f.start, loc)
+		if err != nil {
+			return nil, fmt.Errorf("invalid start date format: %w", err)
+		}
+		startDate = &parsedStart
+	}
+	if *f.end != "" {
+		parsedEnd, err := time.ParseInLocation("2006-01-02", *f.end, loc)
+		if err != nil {
+			return nil, fmt.Errorf("invalid end date format: %w", err)
+		}
+		endDate = &parsedEnd
+	}
+	if err := f.detectFormat(); err != nil {
+		return nil, &exitError{err, exitUnreadable}
+	}
+	delimiter, err := parseDelimiter(*f.delimiter)
+	if err != nil {
+		return nil, err
+	}
+	opts := source.Options{Delimiter: delimiter, Verbose: f.verboseWriter(), Fields: f.fields.mapping(), MemoryMap: *f.mmap}
+	count := 0
+	var eachErr error
+	read := func(s sleep.Session) error {
+		if *f.source != "" && !isSourceName(s, *f.source) {
+			return nil
+		}
+		count++
+		// the nights are grouped by the dates of the sessions in their location
+		s.Start, s.End = s.Start.In(loc), s.End.In(loc)
+		eachErr = each(s)
+		return eachErr
+	}
+	var skipped []*source.RowError
+	switch {
+	case f.reader != nil:
+		skipped, err = eachReaderSource(ctx, *f.format, f.reader, opts, startDate, endDate, *f.strict, read)
+	case *f.interval > 0 || *f.resume:
+		skipped, err = readCheckpointed(ctx, *f.format, filename, opts, startDate, endDate, *f.strict, *f.interval, *f.resume, read)
+	default:
+		skipped, err = eachSource(ctx, *f.format, filename, opts, startDate, endDate, *f.strict, read)
+	}
+	if eachErr != nil {
+		return nil, eachErr
+	}
+	if err != nil {
+		// with -strict a row that can't be parsed stops the run, which is no reason to think the
+		// file unreadable
+		code := exitUnreadable
+		var rowErr *source.RowError
+		if errors.As(err, &rowErr) {
+			code = exitSkippedRows
+		}
+		return nil, &exitError{fmt.Errorf("error reading sleep data: %w", err), code}
+	}
+	if *f.source != "" && count == 0 {
+		return nil, &exitError{fmt.Errorf("no sessions recorded by %q, the sources command lists the names in the file", *f.source), exitNoNights}
+	}
+	if w := f.verboseWriter(); w != nil {
+		fmt.Fprintf(w, "Read %d sessions from %s as %s, skipped %d rows\n", count, filename, *f.format, len(skipped))
+	}
+	return skipped, nil
+}
+
+// the apostrophes of the device names of iOS are typographic
+var sourceNameFold = strings.NewReplacer("’", "'", "‘", "'")
+
+// isSourceName tells whether the session was recorded by the named source, ignoring case and
+// whether the apostrophes are typographic
+func isSourceName(session sleep.Session, name string) bool {
+	return strings.EqualFold(sourceNameFold.Replace(session.SourceName), sourceNameFold.Replace(name))
+}
+
+// the file given by -file, the fetched data and the store have a default location
+func (f inputFlags) path() string {
+	if *f.filename != "" {
+		return *f.filename
+	}
+	if _, fetched := fetchers[*f.format]; fetched {
+		return fetchDir(*f.format)
+	}
+	if *f.format == "store" {
+		return store.DefaultPath()
+	}
+	return ""
+}
+
+// detectFormat sets -format from the file when it wasn't given
+func (f inputFlags) detectFormat() error {
+	if *f.format != "" {
+		return nil
+	}
+	format, err := source.Detect(f.path())
+	if err != nil {
+		return err
+	}
+	*f.format = format
+	if w := f.verboseWriter(); w != nil {
+		fmt.Fprintf(w, "Detected the format %s\n", format)
+	}
+	return nil
+}
+
+// a single character, with \t or tab for a tab
+func parseDelimiter(s string) (rune, error) {
+	switch s {
+	case "":
+		return 0, nil
+	case `\t`, "tab":
+		return '\t', nil
+	}
+	r := []rune(s)
+	if len(r) != 1 || r[0] == '"' || r[0] == '\n' || r[0] == '\r' {
+		return 0, fmt.Errorf("invalid delimiter %q, it should be a single character", s)
+	}
+	return r[0], nil
+}
+
+// analyze loads the sleep data, groups it into nights, calculates the derived metrics from the
+// config and drops the nights not matching -where
+func (f inputFlags) analyze(ctx context.Context) (*nightData, error) {
+	return f.analyzeStreaming(ctx, nil)
+}
+
+// analyzeStreaming is analyze also passing each night matching -where to the sink as soon as the
+// sessions read show it is complete, before the rest of the file is read. The nights aren't
+// streamed with -gap, which groups them by the whole sleep periods.
+func (f inputFlags) analyzeStreaming(ctx context.Context, sink nightSink) (*nightData, error) {
+	config, err := f.loadConfig()
+	if err != nil {
+		return nil, err
+	}
+	metrics, err := compileMetrics(config.Metrics)
+	if err != nil {
+		return nil, err
+	}
+	score, err := newScoreModel(config.Score)
+	if err != nil {
+		return nil, err
+	}
+	if err := useFont(config.Font); err != nil {
+		return nil, err
+	}
+	targets, err := parseStageTargets(config.StageTargets)
+	if err != nil {
+		return nil, err
+	}
+	var filter expr
+	if *f.where != "" {
+		if filter, err = compileFilter(*f.where, metrics); err != nil {
+			return nil, err
+		}
+	}
+
+	if *f.tz == "" {
+		*f.tz = config.Timezone
+	}
+	var stream *nightStream
+	if sink != nil && *f.gap == 0 {
+		stream = &nightStream{cutoff: f.nightCutoff(), emit: func(night *sleep.Night) error {
+			nights := []*sleep.Night{night}
+			if *f.naps {
+				nights, _ = splitNaps(nights)
+			}
+			derived := calculateDerivedMetrics(metrics, score, nights)
+			for _, night := range nights {
+				if filter == nil || filter.eval(allNightVars(night, derived)) != 0 {
+					if err := sink(night, derived); err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		}}
+	}
+	now := time.Now()
+	var sessions []sleep.Session
+	var implausible []implausibleSession
+	skipped, err := f.read(ctx, func(s sleep.Session) error {
+		if !*f.keep {
+			if reason := implausibleReason(s, now); reason != "" {
+				implausible = append(implausible, implausibleSession{s, reason})
+				return nil
+			}
+		}
+		sessions = append(sessions, s)
+		if stream == nil {
+			return nil
+		}
+		if *f.split {
+			return stream.add(sleep.SplitAtMidnight([]sleep.Session{s})...)
+		}
+		return stream.add(s)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if stream != nil {
+		if err := stream.close(); err != nil {
+			return nil, err
+		}
+	}
+	if *f.split {
+		sessions = sleep.SplitAtMidnight(sessions)
+	}
+	var nights []*sleep.Night
+	if *f.gap > 0 {
+		nights = sleep.GroupByGaps(sessions, *f.gap)
+	} else {
+		nights = sleep.GroupByNight(sessions, f.nightCutoff())
+	}
+	var naps []*sleep.Episode
+	if *f.naps {
+		nights, naps = splitNaps(nights)
+	}
+	data := &nightData{nights: nights, naps: naps, config: config, score: score, skipped: skipped, implausible: implausible, targets: targets, streamed: stream != nil}
+	data.derived = calculateDerivedMetrics(metrics, score, data.nights)
+	grouped := len(data.nights)
+	if filter != nil {
+		filterNights(filter, data)
+	}
+	if w := f.verboseWriter(); w != nil {
+		fmt.Fprintf(w, "Grouped the sessions into %d nights, %d match -where\n", grouped, len(data.nights))
+	}
+	return data, nil
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/exp/maps"
+
+	"sleep-stats/sleep"
+	"sleep-stats/source/apple"
+)
+
+// inspectCommand describes the input without analyzing it, a quick check that it is read as
+// expected, e.g. sleep-stats inspect -file export.csv
+func inspectCommand(fs *flag.FlagSet) func(ctx context.Context) error {
+	input := addInputFlags(fs)
+	return func(ctx context.Context) error {
+		filename := input.path()
+		if filename == "" {
+			return &exitError{errors.New("please provide the file to inspect with -file"), exitUsage}
+		}
+		if err := input.detectFormat(); err != nil {
+			return err
+		}
+		if *input.format == "apple" {
+			delimiter, err := parseDelimiter(*input.delimiter)
+			if err != nil {
+				return &exitError{err, exitUsage}
+			}
+			in, err := apple.Inspect(filename, delimiter)
+			if err != nil {
+				return &exitError{err, exitUnreadable}
+			}
+			writeAppleInspection(os.Stdout, filename, in)
+			if in.Invalid > 0 {
+				return &exitError{code: exitSkippedRows}
+			}
+			return nil
+		}
+
+		// the other formats are described by the sessions read from them
+		sessions, skipped, err := input.load(ctx)
+		if err != nil {
+			return err
+		}
+		writeSessionInspection(os.Stdout, filename, *input.format, sessions, len(skipped))
+		return skippedRows(skipped)
+	}
+}
+
+func writeAppleInspection(w io.Writer, filename string, in *apple.Inspection) {
+	fmt.Fprintf(w, "%-12s %s\n", "File", filename)
+	fmt.Fprintf(w, "%-12s %s\n", "Format", "apple")
+	fmt.Fprintf(w, "%-12s %s\n", "Schema", in.Schema)
+	fmt.Fprintf(w, "%-12s %s from %s\n", "Delimiter", strconv.QuoteRune(in.Delimiter), in.DelimiterFrom)
+	var columns []string
+	for _, name := range []string{"startDate", "endDate", "value", "productType", "device", "sourceName", "type"} {
+		if column, ok := in.Columns[name]; ok && column != name {
+			columns = append(columns, fmt.Sprintf("%s from %q", name, column))
+		} else if ok {
+			columns = append(columns, name)
+		}
+	}
+	fmt.Fprintf(w, "%-12s %s\n", "Columns", strings.Join(columns, ", "))
+	fmt.Fprintf(w, "%-12s %d\n", "Rows", in.Rows)
+	fmt.Fprintf(w, "%-12s %d from watches, %d can't be parsed\n", "Sleep rows", in.SleepRows, in.Invalid)
+	writeDateRange(w, in.First, in.Last, in.SleepRows-in.Invalid)
+	writeCounts(w, "Stages", in.Stages)
+	writeCounts(w, "UTC offsets", in.Offsets)
+}
+
+func writeSessionInspection(w io.Writer, filename, format string, sessions []sleep.Session, skipped int) {
+	fmt.Fprintf(w, "%-12s %s\n", "File", filename)
+	fmt.Fprintf(w, "%-12s %s\n", "Format", format)
+	fmt.Fprintf(w, "%-12s %d, %d rows can't be parsed\n", "Sessions", len(sessions), skipped)
+	if len(sessions) == 0 {
+		return
+	}
+	stages := make(map[string]int)
+	offsets := make(map[string]int)
+	first, last := sessions[0].Start, sessions[0].Start
+	for _, s := range sessions {
+		stages[s.Stage.String()]++
+		offsets[s.Start.Format("-0700")]++
+		if s.Start.Before(first) {
+			first = s.Start
+		}
+		if s.Start.After(last) {
+			last = s.Start
+		}
+	}
+	writeDateRange(w, first, last, len(sessions))
+	writeCounts(w, "Stages", stages)
+	writeCounts(w, "UTC offsets", offsets)
+}
+
+func writeDateRange(w io.Writer, first, last time.Time, n int) {
+	if n == 0 {
+		return
+	}
+	fmt.Fprintf(w, "%-12s %s to %s\n", "Dates", first.Format(sleep.DateLayout), last.Format(sleep.DateLayout))
+}
+
+// the values with how often they occur, one per line under the label
+func writeCounts(w io.Writer, label string, counts map[string]int) {
+	values := maps.Keys(counts)
+	slices.Sort(values)
+	for i, value := range values {
+		if i > 0 {
+			label = ""
+		}
+		n := counts[value]
+		if value == "" {
+			value = "(empty)"
+		}
+		fmt.Fprintf(w, "%-12s %-45s %8d\n", label, value, n)
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gonum.org/v1/gonum/stat"
+	"gonum.org/v1/gonum/stat/distuv"
+	"gonum.org/v1/plot"
+	"gonum.org/v1/plot/vg"
+
+	"sleep-stats/sleep"
+)
+
+// intake before this hour is taken after midnight and counts for the night before
+const intakeNightEnd = 5
+
+// the columns of an intake log
+var intakeColumns = []string{"date", "substance", "amount", "time"}
+
+// the metrics charted against the amount taken, alcohol cuts REM and caffeine deep sleep
+var doseMetrics = []string{"total", "deep", "rem"}
+
+// intakeLog holds the amounts of each substance taken by the night they count for
+type intakeLog struct {
+	substances []string                      // in the order they first appear
+	amounts    map[string]map[string]float64 // by substance, then night
+}
+
+// readIntake reads a CSV with the columns date, substance, amount and time of a caffeine or alcohol
+// log, the amounts of a substance on the same night add up
+func readIntake(path string) (intakeLog, error) {
+	log := intakeLog{amounts: make(map[string]map[string]float64)}
+	file, err := os.Open(path)
+	if err != nil {
+		return log, err
+	}
+	defer file.Close()
+	r := csv.NewReader(file)
+	header, err := r.Read()
+	if err != nil {
+		return log, fmt.Errorf("reading the header of %s: %w", path, err)
+	}
+	columns := make(map[string]int, len(intakeColumns))
+	for _, name := range intakeColumns {
+		i := slices.IndexFunc(header, func(h string) bool { return strings.EqualFold(strings.TrimSpace(h), name) })
+		if i < 0 {
+			return log, fmt.Errorf("%s has no column %q, the intake log needs the columns %s", path, name, strings.Join(intakeColumns, ", "))
+		}
+		columns[name] = i
+	}
+
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return log, err
+		}
+		line, _ := r.FieldPos(0)
+		day, err := time.Parse(sleep.DateLayout, strings.TrimSpace(record[columns["date"]]))
+		if err != nil {
+			return log, fmt.Errorf("%s:%d: invalid date %q, expected the format YYYY-MM-DD", path, line, record[columns["date"]])
+		}
+		at, err := time.Parse("15:04", strings.TrimSpace(record[columns["time"]]))
+		if err != nil {
+			return log, fmt.Errorf("%s:%d: invalid time %q, expected the format HH:MM", path, line, record[columns["time"]])
+		}
+		amount, err := strconv.ParseFloat(strings.TrimSpace(record[columns["amount"]]), 64)
+		if err != nil || amount < 0 {
+			return log, fmt.Errorf("%s:%d: invalid amount %q", path, line, record[columns["amount"]])
+		}
+		substance := strings.ToLower(strings.TrimSpace(record[columns["substance"]]))
+		if substance == "" {
+			return log, fmt.Errorf("%s:%d: no substance", path, line)
+		}
+		if at.Hour() < intakeNightEnd {
+			day = day.AddDate(0, 0, -1)
+		}
+		if log.amounts[substance] == nil {
+			log.substances = append(log.substances, substance)
+			log.amounts[substance] = make(map[string]float64)
+		}
+		log.amounts[substance][day.Format(sleep.DateLayout)] += amount
+	}
+	if len(log.substances) == 0 {
+		return log, fmt.Errorf("%s has no intake", path)
+	}
+	return log, nil
+}
+
+// welchTest returns the difference of the means of b and a with the two-sided p-value of Welch's
+// t-test, which doesn't assume the groups vary the same
+func welchTest(a, b []float64) (float64, float64) {
+	meanA, varA := stat.MeanVariance(a, nil)
+	meanB, varB := stat.MeanVariance(b, nil)
+	diff := meanB - meanA
+	sa, sb := varA/float64(len(a)), varB/float64(len(b))
+	if len(a) < 2 || len(b) < 2 || sa+sb == 0 {
+		return diff, 1
+	}
+	nu := (sa + sb) * (sa + sb) / (sa*sa/float64(len(a)-1) + sb*sb/float64(len(b)-1))
+	t := distuv.StudentsT{Mu: 0, Sigma: 1, Nu: nu}
+	return diff, 2 * t.CDF(-math.Abs(diff/math.Sqrt(sa+sb)))
+}
+
+// analyzeIntake compares the metrics of the nights with and without each substance of the -intake
+// log and charts the metrics of doseMetrics against the amount taken, a row per substance
+func analyzeIntake(ctx context.Context, w io.Writer, data *nightData, opts analyzeOptions) error {
+	if opts.intake == "" {
+		return errors.New("give the intake log with -intake")
+	}
+	log, err := readIntake(opts.intake)
+	if err != nil {
+		return err
+	}
+	vars := make([]map[string]float64, len(data.nights))
+	for i, night := range data.nights {
+		vars[i] = allNightVars(night, data.derived)
+	}
+
+	var panels [][]*plot.Plot
+	for _, substance := range log.substances {
+		amounts := log.amounts[substance]
+		with := 0
+		for _, night := range data.nights {
+			if amounts[night.Day()] > 0 {
+				with++
+			}
+		}
+		fmt.Fprintf(w, "%s on %d of %d nights:\n", strings.ToUpper(substance[:1])+substance[1:], with, len(data.nights))
+		switch with {
+		case 0:
+			fmt.Fprintf(w, "  none taken on the nights analyzed\n\n")
+			continue
+		case len(data.nights):
+			fmt.Fprintf(w, "  taken every night, only the amounts are compared in the chart\n\n")
+		default:
+			writeIntakeComparison(w, data, vars, amounts)
+		}
+
+		var row []*plot.Plot
+		for _, metric := range doseMetrics {
+			xs, ys := make([]float64, len(data.nights)), make([]float64, len(data.nights))
+			for i, night := range data.nights {
+				xs[i], ys[i] = amounts[night.Day()], vars[i][metric]
+			}
+			fit := fitCovariate(substance, metric, xs, ys)
+			p, err := scatterFit(xs, ys)
+			if err != nil {
+				return err
+			}
+			p.Title.Text = fmt.Sprintf("%s by %s (r = %.2f)", metric, substance, fit.r)
+			p.X.Label.Text = substance + " amount"
+			p.Y.Label.Text = metric + " (hours)"
+			row = append(row, p)
+		}
+		panels = append(panels, row)
+	}
+	fmt.Fprintf(w, "Durations are in minutes, * marks p < %.2f by Welch's t-test.\n", trendSignificance)
+
+	if opts.chart == "" || len(panels) == 0 {
+		return nil
+	}
+	return writePanels(ctx, panels, 15*vg.Inch, vg.Length(len(panels))*4*vg.Inch, opts.chart)
+}
+
+// writeIntakeComparison prints the means of the metrics on the nights without and with the
+// substance with the p-value of the change
+func writeIntakeComparison(w io.Writer, data *nightData, vars []map[string]float64, amounts map[string]float64) {
+	fmt.Fprintf(w, "  %-12s %10s %10s %10s %7s\n", "Metric", "Without", "With", "Change", "p")
+	for _, metric := range comparedMetrics(data) {
+		var without, taken []float64
+		for i, night := range data.nights {
+			if amounts[night.Day()] > 0 {
+				taken = append(taken, vars[i][metric])
+			} else {
+				without = append(without, vars[i][metric])
+			}
+		}
+		diff, p := welchTest(without, taken)
+		mark := ""
+		if p < trendSignificance {
+			mark = " *"
+		}
+		fmt.Fprintf(w, "  %-12s %10s %10s %10s %7.3f%s\n", metric, formatIntakeMetric(metric, stat.Mean(without, nil), false),
+			formatIntakeMetric(metric, stat.Mean(taken, nil), false), formatIntakeMetric(metric, diff, true), p, mark)
+	}
+	fmt.Fprintln(w)
+}
+
+// formatIntakeMetric shows the durations in minutes and the other metrics as they are
+func formatIntakeMetric(metric string, value float64, signed bool) string {
+	if isDuration(metric) {
+		value *= 60
+	}
+	if signed {
+		return fmt.Sprintf("%+.1f", value)
+	}
+	return fmt.Sprintf("%.1f", value)
+}
@@ -1,145 +1,194 @@
 package main
 
 import (
-	"bufio"
-	"encoding/csv"
+	"cmp"
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"image/color"
 	"io"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"slices"
-	"sort"
-	"strings"
 	"time"
 
-	"golang.org/x/exp/maps"
 	"gonum.org/v1/gonum/stat"
 	"gonum.org/v1/plot"
 	"gonum.org/v1/plot/plotter"
 	"gonum.org/v1/plot/vg"
 	"gonum.org/v1/plot/vg/draw"
+
+	"sleep-stats/sleep"
+	"sleep-stats/source"
+	_ "sleep-stats/source/apple"
+	_ "sleep-stats/source/ndjson"
 )
 
-type SleepData struct {
-	StartDate time.Time
-	EndDate   time.Time
-	Value     string
+// read the sessions from the source and keep those within the date filters, stopping early when
+// the context is cancelled. Unless strict the rows that can't be parsed are skipped and returned.
+func parseSource(ctx context.Context, format, filename string, opts source.Options, startFilter, endFilter *time.Time, strict bool) ([]sleep.Session, []*source.RowError, error) {
+	var sessions []sleep.Session
+	skipped, err := eachSource(ctx, format, filename, opts, startFilter, endFilter, strict, func(s sleep.Session) error {
+		sessions = append(sessions, s)
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return sessions, skipped, nil
 }
 
-func parseCSV(filename string, startFilter, endFilter *time.Time) ([]SleepData, error) {
-	file, err := os.Open(filename)
+// eachSource is parseSource passing the sessions to each as they are read instead of collecting
+// them, an error of each stops reading and is returned
+func eachSource(ctx context.Context, format, filename string, opts source.Options, startFilter, endFilter *time.Time, strict bool, each func(sleep.Session) error) ([]*source.RowError, error) {
+	src, err := source.New(format, opts)
 	if err != nil {
 		return nil, err
 	}
-	defer file.Close()
-
-	reader := bufio.NewReader(file)
+	if err := src.Open(filename); err != nil {
+		return nil, err
+	}
+	defer src.Close()
+	return eachSession(ctx, src, startFilter, endFilter, strict, each)
+}
 
-	// check for the "sep=" starting line and if it exists read past it before parsing CSV
-	// TODO go ahead and read the separator character and use it for the CSV delim
-	head, err := reader.Peek(4)
+// eachReaderSource is eachSource reading the stream instead of a file, for the formats that can be
+// read from one
+func eachReaderSource(ctx context.Context, format string, r io.Reader, opts source.Options, startFilter, endFilter *time.Time, strict bool, each func(sleep.Session) error) ([]*source.RowError, error) {
+	src, err := source.New(format, opts)
 	if err != nil {
 		return nil, err
-
 	}
-	if string(head) == "sep=" {
-		_, err := reader.ReadString('\n')
-		if err != nil {
-			return nil, err
-		}
+	streamSrc, ok := src.(source.ReaderSource)
+	if !ok {
+		return nil, fmt.Errorf("the %s format can only be read from a file", format)
 	}
-
-	csvReader := csv.NewReader(reader)
-
-	// read and parse the first row
-	header, err := csvReader.Read()
-	if err != nil {
+	if err := streamSrc.OpenReader(r); err != nil {
 		return nil, err
 	}
-	headerMap := parseHeader(header)
+	defer src.Close()
+	return eachSession(ctx, src, startFilter, endFilter, strict, each)
+}
 
-	var sleepData []SleepData
+// pass the sessions of an opened source to each, see eachSource
+func eachSession(ctx context.Context, src source.Source, startFilter, endFilter *time.Time, strict bool, each func(sleep.Session) error) ([]*source.RowError, error) {
+	var skipped []*source.RowError
 	for {
-		record, err := csvReader.Read()
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		session, err := src.Next()
 		if err == io.EOF {
 			break
 		}
-		if err != nil {
-			return nil, err
-		}
-
-		// Skip non-watch entries
-		isWatch := strings.HasPrefix(record[headerMap["productType"]], "Watch")
-		if !isWatch {
+		var rowErr *source.RowError
+		if !strict && errors.As(err, &rowErr) {
+			skipped = append(skipped, rowErr)
 			continue
 		}
-
-		startDate, err := time.Parse("2006-01-02 15:04:05 +0000", record[headerMap["startDate"]])
 		if err != nil {
 			return nil, err
 		}
-		endDate, err := time.Parse("2006-01-02 15:04:05 +0000", record[headerMap["endDate"]])
-		if err != nil {
-			return nil, err
-		}
-		if (startFilter == nil || startDate.After(*startFilter) || startDate.Equal(*startFilter)) &&
-			(endFilter == nil || endDate.Before(*endFilter) || endDate.Equal(*endFilter)) {
-			sleepData = append(sleepData, SleepData{
-				StartDate: startDate,
-				EndDate:   endDate,
-				Value:     record[headerMap["value"]],
-			})
+
+		if inDateFilters(session, startFilter, endFilter) {
+			if err := each(session); err != nil {
+				return nil, err
+			}
 		}
 	}
-	return sleepData, nil
+	return skipped, nil
 }
 
-// parse the header names and return a map of the names to the index
-func parseHeader(header []string) map[string]int {
-	headerMap := make(map[string]int, (len(header)))
-	for i, name := range header {
-		headerMap[name] = i
+// inDateFilters tells whether the session starts at or after the start filter and ends at or
+// before the end filter, nil filters don't apply
+func inDateFilters(session sleep.Session, startFilter, endFilter *time.Time) bool {
+	return (startFilter == nil || !session.Start.Before(*startFilter)) &&
+		(endFilter == nil || !session.End.After(*endFilter))
+}
+
+// the number of skipped rows shown in the summary
+const skippedExamples = 3
+
+// printSkipped summarizes the rows that were skipped with a few examples
+func printSkipped(w io.Writer, skipped []*source.RowError) {
+	if len(skipped) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "Skipped %d rows that could not be parsed, use -strict to stop at the first one:\n", len(skipped))
+	for _, rowErr := range skipped[:min(len(skipped), skippedExamples)] {
+		fmt.Fprintf(w, "  %v\n    %s\n", rowErr, rowErr.Text)
 	}
-	fmt.Println(headerMap)
-	return headerMap
 }
 
-func groupByDate(data []SleepData) map[string][]SleepData {
-	groupedData := make(map[string][]SleepData)
-	for _, entry := range data {
-		dateKey := entry.StartDate
-		// don't need to account for date spanning since the data is in UTC
-		// if entry.StartDate.Hour() < 12 {
-		// 	// Group with the previous day if the start time is before noon
-		// 	dateKey = dateKey.AddDate(0, 0, -1)
-		// }
-		dateKeyStr := dateKey.Format("2006-01-02")
-		groupedData[dateKeyStr] = append(groupedData[dateKeyStr], entry)
-	}
-	return groupedData
+// the file the plot is written to without the extension of its format
+const plotName = "sleep_statistics"
+
+// the color of the score on the plot
+var scoreColor = color.RGBA{R: 30, G: 30, B: 30, A: 255}
+
+// plotOptions selects how the nights are plotted
+type plotOptions struct {
+	lines bool // lines instead of points
+	score bool // also plot the sleep score
+	// plot the metrics as z-scores, to see which deviated most on a night
+	zscore bool
+	// the changes marked on the plot
+	changes changeMarkers
+	// the holidays and the travel shaded on the plot
+	holidays, travel spanMarkers
+	// the nights left out of the regression lines, by their key
+	untrended map[string]bool
+	// the nights marked as deficient on every series, by their key
+	flagged map[string]bool
+	// the normal ranges shaded behind the series
+	normal normalRanges
 }
 
-func calculateNightlyStatistics(data map[string][]SleepData) (map[string]map[string]time.Duration, map[string]int) {
-	nightlyStats := make(map[string]map[string]time.Duration)
-	awakeCount := make(map[string]int)
-	for date, entries := range data {
-		stats := make(map[string]time.Duration)
-		var count int = 0
-		for _, entry := range entries {
-			duration := entry.EndDate.Sub(entry.StartDate)
-			stats[entry.Value] += duration
-			if entry.Value == "inBed" {
-				count++
-			}
-		}
-		nightlyStats[date] = stats
-		awakeCount[date] = count
+func createPlot(ctx context.Context, nights []*sleep.Night, derived derivedStats, opts plotOptions, filename string) error {
+	return writeChart(ctx, buildPlot(nights, derived, opts), 15*vg.Inch, 8*vg.Inch, filename)
+}
+
+// the file name that writes to stdout instead, e.g. -out - | convert - out.png
+const stdoutFile = "-"
+
+// writeFile writes to a temporary file next to filename and only renames it into place once
+// complete, so cancelling never leaves a partly written file behind. The stdoutFile is written to
+// stdout.
+func writeFile(ctx context.Context, filename string, write func(w io.Writer) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if filename == stdoutFile {
+		return write(os.Stdout)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(filename), "."+filepath.Base(filename)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	// temporary files are only readable by the owner
+	if err := tmp.Chmod(0o644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := write(tmp); err != nil {
+		tmp.Close()
+		return err
 	}
-	return nightlyStats, awakeCount
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filename)
 }
 
-func createPlot(nightlyStats map[string]map[string]time.Duration, awakeCount map[string]int, useLines bool) {
+// build the time series plot of the nights without saving it
+func buildPlot(nights []*sleep.Night, derived derivedStats, opts plotOptions) *plot.Plot {
 	p := plot.New()
 
 	p.Title.Text = "Sleep Statistics Over Time"
@@ -147,10 +196,13 @@ func createPlot(nightlyStats map[string]map[string]time.Duration, awakeCount map
 	p.Y.Label.Text = "Duration (hours)"
 	p.Y.Scale = plot.LogScale{}
 	p.Legend.Top = true
+	if opts.zscore {
+		p.Y.Label.Text = "Standard deviations from the mean"
+		p.Y.Scale = plot.LinearScale{}
+	}
 
 	// Prepare data for plotting
-	numTicks := len(nightlyStats)
-	dates := make([]time.Time, 0, numTicks)
+	numTicks := len(nights)
 	inBedDurations := make([]float64, 0, numTicks)
 	asleepCoreDurations := make([]float64, 0, numTicks)
 	asleepREMDurations := make([]float64, 0, numTicks)
@@ -159,45 +211,45 @@ func createPlot(nightlyStats map[string]map[string]time.Duration, awakeCount map
 	awakeCountPlot := make([]float64, 0, numTicks)
 	datePoints := make(plotter.XYs, numTicks)
 
-	layout := "2006-01-02"
-	for date := range nightlyStats {
-		dateParsed, _ := time.Parse(layout, date)
-		dates = append(dates, dateParsed)
-	}
-
-	// Sort dates for plotting
-	sort.Slice(dates, func(i, j int) bool { return dates[i].Before(dates[j]) })
-
-	for i, date := range dates {
-		// fmt.Printf("%d, %s", i, date)
-		datePoints[i].X = float64(date.Unix())
+	for i, night := range nights {
+		datePoints[i].X = float64(night.Date.Unix())
 	}
 
-	for _, date := range dates {
-		dateKey := date.Format(layout)
-		stats := nightlyStats[dateKey]
-		inBedDurations = append(inBedDurations, stats["inBed"].Hours())
-		asleepCoreDurations = append(asleepCoreDurations, stats["asleepCore"].Hours())
-		asleepREMDurations = append(asleepREMDurations, stats["asleepREM"].Hours())
-		asleepDeepDurations = append(asleepDeepDurations, stats["asleepDeep"].Hours())
-		awakeDurations = append(awakeDurations, stats["awake"].Hours())
-		awakeCountPlot = append(awakeCountPlot, float64(awakeCount[dateKey]))
+	for _, night := range nights {
+		inBedDurations = append(inBedDurations, night.Time(sleep.InBed).Hours())
+		asleepCoreDurations = append(asleepCoreDurations, night.Time(sleep.Core).Hours())
+		asleepREMDurations = append(asleepREMDurations, night.Time(sleep.REM).Hours())
+		asleepDeepDurations = append(asleepDeepDurations, night.Time(sleep.Deep).Hours())
+		awakeDurations = append(awakeDurations, night.Time(sleep.Awake).Hours())
+		awakeCountPlot = append(awakeCountPlot, float64(night.InBedCount()))
 	}
 
-	createItem := func(durations []float64, label string, color color.RGBA) []plot.Plotter {
-		points := make(plotter.XYs, len(dates))
+	// hours are shown as durations in the tooltips, the other values with two decimals
+	createItem := func(durations []float64, label string, color color.RGBA, hours bool) []plot.Plotter {
+		points := make(plotter.XYs, len(nights))
+		tips := make(tooltips, len(nights))
+		if opts.zscore {
+			durations, hours = standardize(durations), false
+		}
 		for i, duration := range durations {
 			points[i].X = datePoints[i].X
-			if duration == 0 {
+			// the log scale can't show zero or negative values
+			if duration <= 0 && !opts.zscore {
 				points[i].Y = 0.01
 			} else {
 				points[i].Y = duration
 			}
+			value := fmt.Sprintf("%.2f", duration)
+			if hours {
+				value = formatDuration(time.Duration(duration * float64(time.Hour)))
+			}
+			date := nights[i].Key()
+			tips[i] = tooltip{points[i].X, points[i].Y, date, date + " " + label + " " + value}
 		}
 		var item plot.Plotter
 		var thumb plot.Thumbnailer
 
-		if useLines {
+		if opts.lines {
 			line, err := plotter.NewLine(points)
 			if err != nil {
 				panic(err)
@@ -218,21 +270,87 @@ func createPlot(nightlyStats map[string]map[string]time.Duration, awakeCount map
 		}
 		p.Legend.Add(label, thumb)
 
-		return []plot.Plotter{item, linearRegression(points, color)}
+		fitted := points
+		if len(opts.untrended) > 0 {
+			fitted = slices.DeleteFunc(slices.Clone(points), func(point plotter.XY) bool {
+				return opts.untrended[time.Unix(int64(point.X), 0).In(nights[0].Date.Location()).Format(sleep.DateLayout)]
+			})
+		}
+		plotters := []plot.Plotter{item, linearRegression(fitted, color), tips}
+		if len(opts.flagged) > 0 {
+			var marked plotter.XYs
+			for i, night := range nights {
+				if opts.flagged[night.Key()] {
+					marked = append(marked, points[i])
+				}
+			}
+			plotters = append(plotters, flagMarkers(marked))
+		}
+		return plotters
+	}
+
+	// the normal ranges are added first to be behind the series, z-scores have no units to
+	// compare them with
+	if len(opts.normal) > 0 && len(nights) > 0 && !opts.zscore {
+		colors := map[string]color.RGBA{
+			"core":  stageColors[sleep.Core],
+			"rem":   stageColors[sleep.REM],
+			"deep":  stageColors[sleep.Deep],
+			"awake": stageColors[sleep.Awake],
+		}
+		if opts.score {
+			colors["score"] = scoreColor
+		}
+		for i, name := range derived.names {
+			colors[name] = derivedColors[i%len(derivedColors)]
+		}
+		p.Add(normalBands(nights, opts.normal, colors))
 	}
 
 	// p.Add(createItem(inBedDurations, "In Bed", color.RGBA{R: 255, G: 0, B: 0, A: 255})...)
-	p.Add(createItem(asleepCoreDurations, "Core", color.RGBA{R: 0, G: 255, B: 0, A: 255})...)
-	p.Add(createItem(asleepREMDurations, "REM", color.RGBA{R: 255, G: 0, B: 255, A: 255})...)
-	p.Add(createItem(asleepDeepDurations, "Deep", color.RGBA{R: 0, G: 122, B: 122, A: 255})...)
-	p.Add(createItem(awakeDurations, "Awake", color.RGBA{R: 128, G: 128, B: 128, A: 255})...)
+	p.Add(createItem(asleepCoreDurations, "Core", stageColors[sleep.Core], true)...)
+	p.Add(createItem(asleepREMDurations, "REM", stageColors[sleep.REM], true)...)
+	p.Add(createItem(asleepDeepDurations, "Deep", stageColors[sleep.Deep], true)...)
+	p.Add(createItem(awakeDurations, "Awake", stageColors[sleep.Awake], true)...)
 	// p.Add(createItem(awakeCountPlot, "Awake Count", color.RGBA{R: 255, G: 155, B: 156, A: 255})...)
+	if opts.score {
+		scores := make([]float64, len(nights))
+		for i, night := range nights {
+			scores[i] = derived.scores[night.Key()]
+		}
+		p.Add(createItem(scores, "Score", scoreColor, false)...)
+	}
 
-	p.X.Tick.Marker = plot.TimeTicks{Format: "2006-01"}
+	for i, name := range derived.names {
+		values := make([]float64, len(nights))
+		for j, night := range nights {
+			values[j] = derived.values[night.Key()][name]
+		}
+		p.Add(createItem(values, name, derivedColors[i%len(derivedColors)], false)...)
+	}
 
-	if err := p.Save(15*vg.Inch, 8*vg.Inch, "sleep_statistics.svg"); err != nil {
-		panic(err)
+	for _, spans := range []spanMarkers{opts.holidays, opts.travel} {
+		if len(spans.spans) > 0 {
+			p.Add(spans)
+		}
 	}
+	if len(opts.changes) > 0 {
+		p.Add(opts.changes)
+	}
+	if len(opts.flagged) > 0 {
+		p.Legend.Add("Flagged", flagMarkers(nil))
+	}
+	if changes := dstChanges(nights); len(changes) > 0 {
+		markers := dstMarkers{}
+		for _, day := range changes {
+			markers.at = append(markers.at, float64(day.Unix()))
+		}
+		p.Add(markers)
+	}
+
+	p.X.Tick.Marker = plot.TimeTicks{Format: "2006-01"}
+
+	return p
 }
 
 func linearRegression(points plotter.XYs, color color.RGBA) plot.Plotter {
@@ -269,58 +387,431 @@ func linearRegression(points plotter.XYs, color color.RGBA) plot.Plotter {
 	return rline
 }
 
-func outputStats(nightlyStats map[string]map[string]time.Duration, awakeCount map[string]int) {
-	fmt.Println("Sleep Statistics by Date:")
-
-	dates := maps.Keys(nightlyStats)
-	slices.Sort(dates)
+func outputStats(w io.Writer, nights []*sleep.Night, derived derivedStats) {
+	fmt.Fprintln(w, "Sleep Statistics by Date:")
 
-	for _, date := range dates {
-		stats := nightlyStats[date]
-		fmt.Printf("%s\tBed: %v\tCore: %v\tREM: %v\tDeep: %v\tAwake: %v\tAwake Count: %v\n",
-			date, stats["inBed"], stats["asleepCore"], stats["asleepREM"], stats["asleepDeep"], stats["awake"], awakeCount[date])
+	for _, night := range nights {
+		date := night.Key()
+		fmt.Fprintf(w, "%s\tBed: %v\tCore: %v\tREM: %v\tDeep: %v\tAwake: %v\tLongest: %v\tAwake Count: %v\tScore: %.0f",
+			date, night.Time(sleep.InBed), night.Time(sleep.Core), night.Time(sleep.REM), night.Time(sleep.Deep), night.Time(sleep.Awake), night.LongestAsleep(),
+			night.InBedCount(), derived.scores[date])
+		for _, name := range derived.names {
+			fmt.Fprintf(w, "\t%s: %.2f", name, derived.values[date][name])
+		}
+		fmt.Fprintln(w)
 	}
 }
 
-func main() {
-	filename := flag.String("file", "", "CSV file containing sleep data")
-	start := flag.String("start", "", "Start date (inclusive) in YYYY-MM-DD format")
-	end := flag.String("end", "", "End date (inclusive) in YYYY-MM-DD format")
-	useLines := flag.Bool("lines", false, "whether to plot with lines, default to points")
-	flag.Parse()
+// a command defines its flags on the flag set and returns the function to run once they are parsed
+type command struct {
+	name  string
+	usage string
+	setup func(fs *flag.FlagSet) func(ctx context.Context) error
+}
 
-	if *filename == "" {
-		fmt.Println("Please provide the CSV file as an argument.")
-		os.Exit(1)
+// the subcommands, without one the stats are plotted and printed
+var commands []command
+
+func init() {
+	commands = []command{
+		{"spark", "print a sparkline of the last nights for status bars", sparkCommand},
+		{"summary", "print the last night, the averages of the last week and the trend, as compact JSON for widgets", summaryCommand},
+		{"tui", "explore the nights interactively", tuiCommand},
+		{"night", "print the sessions and measures of one night", nightCommand},
+		{"serve", "serve a dashboard that refreshes when the file changes", serveCommand},
+		{"analyze", "analyze the nights for patterns like a weekly rhythm", analyzeCommand},
+		{"chart", "draw a chart of the sessions like the time of day they were asleep", chartCommand},
+		{"fetch", "download the sleep data of Fitbit or Oura", fetchCommand},
+		{"import", "import an export into the store read with -format store", importCommand},
+		{"validate", "check that every row of the file can be read, listing the ones that can't and exiting with 1", validateCommand},
+		{"inspect", "print how the file is read, its delimiter, rows, dates, stage values and UTC offsets", inspectCommand},
+		{"sources", "list the devices and apps that recorded the sessions with their counts and dates", sourcesCommand},
+		{"merge", "combine overlapping exports into one export CSV with every session once", mergeCommand},
+		{"anonymize", "write the sessions as a CSV that can be shared, without device names and with rounded times", anonymizeCommand},
+		{"generate", "write an export of made-up nights of any size, to benchmark and profile a run with", generateCommand},
+		{"daemon", "import the configured inputs into the store on a schedule and notify failed checks", daemonCommand},
+		{"grpc", "serve the analysis as a gRPC service", grpcCommand},
+		{"completion", "print the shell completion script for bash, zsh or fish", completionCommand},
+		{"__complete", "", completeCommand},
 	}
+}
 
-	var startDate, endDate *time.Time
-	if *start != "" {
-		parsedStart, err := time.Parse("2006-01-02", *start)
-		if err != nil {
-			fmt.Printf("Invalid start date format: %v\n", err)
-			os.Exit(1)
+func lookupCommand(name string) (command, bool) {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd, true
 		}
-		startDate = &parsedStart
 	}
-	if *end != "" {
-		parsedEnd, err := time.Parse("2006-01-02", *end)
+	return command{}, false
+}
+
+func plotCommand(fs *flag.FlagSet) func(ctx context.Context) error {
+	input := addInputFlags(fs)
+	useLines := fs.Bool("lines", false, "whether to plot with lines, default to points")
+	score := fs.Bool("score", false, "also plot the sleep score of the nights, from 0 to 100")
+	animate := fs.String("animate", "", "also write an animated GIF of the plot to this file")
+	decomposition := fs.String("decompose", "", "also write the trend, weekly component and residual of total sleep as a three-panel SVG to this file")
+	window := fs.Int("window", 0, "number of nights in the sliding window of the animation, default reveals all nights")
+	by := fs.String("by", "night", "group the stats table by night, week or month, weeks use ISO-8601 numbering like 2024-W01 and months are like 2024-01")
+	weekStart := fs.String("week-start", "", "first day of the week for -by week and the calendar of -output html, monday or sunday, overrides weekStart in the config")
+	report := fs.String("report", "", "print a report instead of the stats table, clinical prints the measures sleep clinics use, naps the naps, monthly an HTML digest of the last month for sending by mail")
+	output := fs.String("output", "table", "format of the stats, table, json, csv, md for a Markdown table, jsonl for one JSON object per line, parquet, arrow for an Arrow IPC stream, apple for the sessions as an Apple Health export CSV or html for a dashboard with tabs in a single file")
+	plotFormat := addPlotFlag(fs)
+	paletteName := addPaletteFlag(fs)
+	resolution := addResolutionFlags(fs)
+	open := addOpenFlag(fs)
+	level := fs.String("level", "night", "what the rows of -output json, csv, md, jsonl, parquet or arrow are, night or session")
+	changes := fs.Bool("changes", false, "find the nights where total sleep or bedtime shifted, print them and mark them on the plot")
+	napsOnly := fs.Bool("naps-only", false, "print and plot only the naps, how often and how long they were and at what time of day, like -report naps")
+	normalize := fs.String("normalize", "", "zscore to show the metrics of the plot and the stats in standard deviations from their mean over the nights")
+	trends := fs.Bool("trends", false, "also print whether each metric improved, stayed stable or declined over the nights")
+	recent := fs.String("recent", "", "also compare the nights of the last days like 14d with the baseline before them")
+	flagged := fs.String("flag", "", `mark the nights matching the condition on the plot with red rings, e.g. "total < 6h || deep < 45m"`)
+	travel := fs.String("travel", "", "mark to shade and list the nights probably spent traveling, found by changes of the UTC offset of the export or shifts of the sleep midpoint, exclude to also leave them out of the trends")
+	holidays := fs.String("holidays", "", "CSV file with the columns start, end and name of holidays and vacations or a country code like US, shades them on the plot and compares the nights before them with those before working days, overrides holidays in the config")
+	baseline := fs.String("baseline", "", "the days before -recent compared with, like 90d, defaults to all nights before")
+	age := fs.Int("age", 0, "compare the averages with the recommended ranges for the age, overrides birthdate in the config")
+	footer := fs.Bool("footer", true, "append the mean, median and total of the nights to the stats table and -output csv")
+	shape := fs.String("shape", "wide", "shape of -output csv, wide with a column per metric or long with a row per date and metric")
+	out := fs.String("out", "", "file the plot is written to, defaults to "+plotName+".<plot>, - writes it to stdout instead of the stats")
+	outdir := fs.String("outdir", "", "write the plot, the stats and the other files into a new directory inside this one named by the time of the run, e.g. reports/2024-07/")
+	bundle := fs.String("bundle", "", "zip to pack the directory of -outdir into a single archive")
+	var assertions assertFlags
+	fs.Var(&assertions, "assert", `condition checked after the analysis, exits with code 3 if it fails, e.g. "avg(total, 7d) >= 6h30m", can be repeated`)
+
+	return func(ctx context.Context) error {
+		zscore := *normalize == "zscore"
+		if err := checkPlotFormat(*plotFormat); err != nil {
+			return &exitError{err, exitUsage}
+		}
+		if err := checkNormalize(*normalize); err != nil {
+			return &exitError{err, exitUsage}
+		}
+		if *napsOnly {
+			if !*input.naps {
+				return &exitError{errors.New("-naps-only needs the naps taken out of the nights by -exclude-naps"), exitUsage}
+			}
+			*report = "naps"
+		}
+		if zscore && *by != "night" {
+			return &exitError{errors.New("-normalize is only for -by night"), exitUsage}
+		}
+		if err := usePalette(*paletteName); err != nil {
+			return &exitError{err, exitUsage}
+		}
+		if err := resolution.apply(); err != nil {
+			return &exitError{err, exitUsage}
+		}
+		recentDays, err := parseDays(*recent)
 		if err != nil {
-			fmt.Printf("Invalid end date format: %v\n", err)
-			os.Exit(1)
+			return &exitError{err, exitUsage}
+		}
+		baselineDays, err := parseDays(*baseline)
+		if err != nil {
+			return &exitError{err, exitUsage}
+		}
+		if *bundle != "" && (*bundle != "zip" || *outdir == "") {
+			return &exitError{errors.New("-bundle only supports zip and needs -outdir"), exitUsage}
+		}
+		switch *travel {
+		case "", "mark", "exclude":
+		default:
+			return &exitError{fmt.Errorf("unknown -travel %q, use mark or exclude", *travel), exitUsage}
+		}
+		switch *by {
+		case "night", "week", "month":
+		default:
+			return &exitError{fmt.Errorf("unknown -by %q, use night, week or month", *by), exitUsage}
+		}
+		if _, ok := outputWriters[*output]; !ok && !isTable(*output) {
+			return &exitError{fmt.Errorf("unknown output %q, use table, json, csv, md, jsonl, parquet, arrow, apple or html", *output), exitUsage}
+		}
+		switch *report {
+		case "", "clinical", "naps", "monthly":
+		default:
+			return &exitError{fmt.Errorf("unknown report %q, use clinical, naps or monthly", *report), exitUsage}
+		}
+
+		// the line formats going to stdout are written night by night while the file is read
+		var sink nightSink
+		if stream, ok := outputStreams[*output]; ok && *report == "" && *outdir == "" && !(*out == stdoutFile && *plotFormat != "none") {
+			sink = stream(os.Stdout, outputOptions{level: *level, shape: *shape, zscore: zscore})
+		}
+		data, err := input.analyzeStreaming(ctx, sink)
+		if err != nil {
+			return err
+		}
+		if len(data.nights) == 0 {
+			return &exitError{errors.New("no nights match -start, -end and -where"), exitNoNights}
+		}
+		nights, derived := data.nights, data.derived
+		opts := plotOptions{lines: *useLines, score: *score, zscore: zscore}
+		if *changes {
+			opts.changes = detectChanges(nights)
+		}
+		start, err := parseWeekStart(*weekStart, data.config)
+		if err != nil {
+			return err
+		}
+		var freeDays []holiday
+		if name := cmp.Or(*holidays, data.config.Holidays); name != "" {
+			if freeDays, err = loadHolidays(name, nights); err != nil {
+				return err
+			}
+			opts.holidays = holidayMarkers(freeDays)
+		}
+		if opts.normal, err = parseNormalRanges(data.config.Normal, derived.names); err != nil {
+			return err
+		}
+		if *flagged != "" {
+			if opts.flagged, err = flagNights(*flagged, data); err != nil {
+				return err
+			}
+		}
+		var trips []travelSpan
+		if *travel != "" {
+			trips = detectTravel(nights)
+			opts.travel = travelMarkers(trips)
+			if *travel == "exclude" {
+				data.untrended = untrendedNights(nights, trips)
+				opts.untrended = data.untrended
+			}
+		}
+		// with -outdir the stats go to a file in the directory of the run instead of stdout
+		var dir string
+		var statsFile *os.File
+		stdout := io.Writer(os.Stdout)
+		if *outdir != "" {
+			if dir, err = newRunDir(*outdir, time.Now()); err != nil {
+				return err
+			}
+			if statsFile, err = os.Create(filepath.Join(dir, statsFileName(*output))); err != nil {
+				return err
+			}
+			stdout = statsFile
+		}
+
+		if *out == "" {
+			*out = plotName + "." + *plotFormat
+		}
+		stdoutFormat = *plotFormat
+		if *out == stdoutFile && *plotFormat != "none" && dir == "" {
+			// the plot is the output
+			stdout = io.Discard
+		}
+		// the files of the run are rendered at the same time
+		var renders []func() error
+		if *plotFormat != "none" {
+			renders = append(renders, func() error {
+				if *napsOnly {
+					if err := writeNapChart(ctx, data.naps, inRunDir(dir, *out)); err != nil {
+						return fmt.Errorf("creating nap chart: %w", err)
+					}
+					return nil
+				}
+				if err := createPlot(ctx, nights, derived, opts, inRunDir(dir, *out)); err != nil {
+					return fmt.Errorf("creating plot: %w", err)
+				}
+				return nil
+			})
+		}
+		if *animate != "" {
+			renders = append(renders, func() error {
+				if err := createAnimation(ctx, nights, derived, opts, *window, inRunDir(dir, *animate)); err != nil {
+					return fmt.Errorf("creating animation: %w", err)
+				}
+				return nil
+			})
+		}
+		if *decomposition != "" {
+			renders = append(renders, func() error {
+				if err := writeDecomposition(ctx, nights, inRunDir(dir, *decomposition)); err != nil {
+					return fmt.Errorf("decomposing: %w", err)
+				}
+				return nil
+			})
+		}
+		if err := renderAll(renders...); err != nil {
+			return fmt.Errorf("rendering: %w", err)
+		}
+
+		switch *report {
+		case "":
+			if write, ok := outputWriters[*output]; ok {
+				if data.streamed {
+					break
+				}
+				if err := write(stdout, data, outputOptions{level: *level, shape: *shape, zscore: opts.zscore, weekStart: start, footer: *footer}); err != nil {
+					return err
+				}
+				break
+			}
+			switch *by {
+			case "night":
+				if opts.zscore {
+					writeZScores(stdout, data)
+					break
+				}
+				outputStats(stdout, nights, derived)
+				if *footer {
+					writeStatsFooter(stdout, nights, derived)
+				}
+				fmt.Fprintf(stdout, "\nScore = %v\n", data.score)
+			case "week":
+				periods := aggregatePeriods(data, func(date time.Time) string { return weekKey(date, start) })
+				outputPeriodStats(stdout, "Week", periods, derived.names)
+				fmt.Fprintf(stdout, "\nScore = %v\n", data.score)
+			case "month":
+				periods := aggregatePeriods(data, func(date time.Time) string { return date.Format("2006-01") })
+				outputPeriodStats(stdout, "Month", periods, derived.names)
+				fmt.Fprintf(stdout, "\nScore = %v\n", data.score)
+			}
+		case "clinical":
+			writeClinicalReport(stdout, nights)
+		case "naps":
+			writeNapReport(stdout, data)
+		case "monthly":
+			if err := writeDigest(stdout, data); err != nil {
+				return err
+			}
+		}
+
+		if *age == 0 && data.config.Birthdate != "" {
+			if *age, err = ageOn(data.config.Birthdate, time.Now()); err != nil {
+				return err
+			}
+		}
+		if *changes && (*report != "" || isTable(*output)) {
+			writeChanges(stdout, opts.changes)
+		}
+		if *trends && (*report != "" || isTable(*output)) {
+			writeTrends(stdout, data)
+		}
+		if recentDays > 0 && (*report != "" || isTable(*output)) {
+			writeBaseline(stdout, data, recentDays, baselineDays)
+		}
+		if *travel != "" && (*report != "" || isTable(*output)) {
+			writeTravel(stdout, trips)
+		}
+		if freeDays != nil && (*report != "" || isTable(*output)) {
+			writeHolidays(stdout, data, freeDays)
+		}
+		if *report == "" && isTable(*output) {
+			writeNaps(stdout, data.naps)
+		}
+		if *age > 0 && (*report != "" || isTable(*output)) {
+			writeRecommendations(stdout, nights, *age)
+		}
+		if len(data.targets) > 0 && (*report != "" || isTable(*output)) {
+			writeStageWarnings(stdout, stageWarnings(nights, data.targets, start))
+		}
+
+		data.printExcluded(input.diagnostics())
+
+		// -open shows the plot, the HTML report of -outdir or the bundle
+		var opened string
+		if *plotFormat != "none" {
+			opened = inRunDir(dir, *out)
+		}
+		if dir != "" {
+			if err := statsFile.Close(); err != nil {
+				return err
+			}
+			if *output == "html" {
+				opened = filepath.Join(dir, statsFileName(*output))
+			}
+			written := dir
+			if *bundle == "zip" {
+				if written, err = bundleZip(dir); err != nil {
+					return err
+				}
+				opened = written
+			}
+			fmt.Fprintf(input.diagnostics(), "Wrote %s\n", written)
 		}
-		endDate = &parsedEnd
+
+		if len(assertions) > 0 {
+			failures, err := checkAssertions(assertions, data)
+			if err != nil {
+				return err
+			}
+			for _, failure := range failures {
+				fmt.Fprintln(os.Stderr, failure)
+			}
+			if len(failures) > 0 {
+				return &exitError{code: exitAssertFailed}
+			}
+		}
+		openAfterRun(*open, opened)
+		return skippedRows(data.skipped)
 	}
-	sleepData, err := parseCSV(*filename, startDate, endDate)
-	if err != nil {
-		fmt.Printf("Error reading CSV file: %v\n", err)
-		os.Exit(1)
+}
+
+func main() {
+	// the first interrupt cancels the work so files aren't left half written, a second one kills
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
+	if len(os.Args) > 1 {
+		if cmd, ok := lookupCommand(os.Args[1]); ok {
+			fs := flag.NewFlagSet(cmd.name, flag.ExitOnError)
+			profile := addProfileFlags(fs)
+			run := cmd.setup(fs)
+			fs.Parse(os.Args[2:])
+			runProfiled(ctx, run, profile)
+			return
+		}
 	}
 
-	groupedData := groupByDate(sleepData)
-	nightlyStats, awakeCount := calculateNightlyStatistics(groupedData)
+	flag.Usage = usage
+	profile := addProfileFlags(flag.CommandLine)
+	run := plotCommand(flag.CommandLine)
+	flag.Parse()
+	runProfiled(ctx, run, profile)
+}
 
-	createPlot(nightlyStats, awakeCount, *useLines)
+// runProfiled runs the command and exits with the code of its error once the profiles are written,
+// which they also are when the command panics
+func runProfiled(ctx context.Context, run func(ctx context.Context) error, profile profileFlags) {
+	stop, err := profile.start()
+	if err != nil {
+		exit(err)
+	}
+	err = func() error {
+		defer stop()
+		return run(ctx)
+	}()
+	if err != nil {
+		exit(err)
+	}
+}
 
-	outputStats(nightlyStats, awakeCount)
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: %s [command] [flags]\n\nCommands:\n", os.Args[0])
+	for _, cmd := range commands {
+		if cmd.usage != "" {
+			fmt.Fprintf(out, "  %-12s %s\n", cmd.name, cmd.usage)
+		}
+	}
+	fmt.Fprintf(out, "\nWithout a command the stats are plotted and printed:\n")
+	flag.PrintDefaults()
+	fmt.Fprintf(out, "\nExit codes:\n")
+	for _, code := range []struct {
+		code int
+		text string
+	}{
+		{1, "an error"},
+		{exitUsage, "invalid flags"},
+		{exitAssertFailed, "an -assert condition failed"},
+		{exitNoNights, "no nights matched the filters"},
+		{exitUnreadable, "the input couldn't be read"},
+		{exitSkippedRows, "rows couldn't be parsed, they were skipped or -strict stopped at one"},
+		{exitInterrupted, "the run was interrupted"},
+	} {
+		fmt.Fprintf(out, "  %-3d  %s\n", code.code, code.text)
+	}
 }
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"time"
+
+	"sleep-stats/sleep"
+	"sleep-stats/source"
+	"sleep-stats/source/apple"
+)
+
+// mergeCommand combines overlapping exports into one export CSV with every session once, in order,
+// e.g. sleep-stats merge 2023.csv 2024.csv -o combined.csv
+func mergeCommand(fs *flag.FlagSet) func(ctx context.Context) error {
+	input := addInputFlags(fs)
+	out := fs.String("o", "", "file the merged CSV is written to, defaults to stdout")
+	return func(ctx context.Context) error {
+		// the flags can also follow the files
+		var files []string
+		for fs.NArg() > 0 {
+			files = append(files, fs.Arg(0))
+			fs.Parse(fs.Args()[1:])
+		}
+		if *input.filename != "" {
+			files = append(files, *input.filename)
+		}
+		if len(files) == 0 {
+			return &exitError{fmt.Errorf("Usage: %s merge <file>... [-o combined.csv]", os.Args[0]), exitUsage}
+		}
+
+		// each file can have another format unless -format is given
+		format := *input.format
+		var lists [][]sleep.Session
+		var skipped []*source.RowError
+		for _, file := range files {
+			*input.filename, *input.format = file, format
+			sessions, fileSkipped, err := input.load(ctx)
+			if err != nil {
+				return fmt.Errorf("%s: %w", file, err)
+			}
+			lists = append(lists, sessions)
+			skipped = append(skipped, fileSkipped...)
+		}
+		merged, duplicates := mergeSessions(lists)
+
+		write := func(w io.Writer) error { return apple.Write(w, merged) }
+		var err error
+		if *out == "" {
+			err = write(os.Stdout)
+		} else {
+			err = writeFile(ctx, *out, write)
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(input.diagnostics(), "Merged %d sessions from %d files, dropped %d duplicates\n", len(merged), len(files), duplicates)
+		printSkipped(input.diagnostics(), skipped)
+		return skippedRows(skipped)
+	}
+}
+
+// mergeSessions sorts the sessions of all lists by their start and keeps each once, a session is
+// the same as another with the same times, stage and source. It returns the number dropped.
+func mergeSessions(lists [][]sleep.Session) ([]sleep.Session, int) {
+	all := slices.Concat(lists...)
+	slices.SortStableFunc(all, func(a, b sleep.Session) int {
+		return cmp.Or(a.Start.Compare(b.Start), a.End.Compare(b.End), cmp.Compare(a.Stage, b.Stage), cmp.Compare(a.SourceName, b.SourceName))
+	})
+	type key struct {
+		start, end time.Time
+		stage      sleep.Stage
+		sourceName string
+	}
+	seen := make(map[key]bool, len(all))
+	merged := all[:0]
+	for _, s := range all {
+		k := key{s.Start.UTC(), s.End.UTC(), s.Stage, s.SourceName}
+		if seen[k] {
+			continue
+		}
+		seen[k] = true
+		merged = append(merged, s)
+	}
+	return merged, len(all) - len(merged)
+}
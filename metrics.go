@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+
+	"sleep-stats/sleep"
+)
+
+// the names of the per night values that derived metric and filter expressions can use
+var baseMetricNames = []string{"inBed", "core", "rem", "deep", "awake", "asleep", "total", "longest", "awakeCount", "weekday", "score"}
+
+// the values of a night for evaluating expressions, durations are in hours, total is the same as
+// asleep, longest is the longest stretch asleep without waking up and the score is from 0 to 100
+func nightVars(night *sleep.Night, score float64) map[string]float64 {
+	return map[string]float64{
+		"inBed":      night.Time(sleep.InBed).Hours(),
+		"core":       night.Time(sleep.Core).Hours(),
+		"rem":        night.Time(sleep.REM).Hours(),
+		"deep":       night.Time(sleep.Deep).Hours(),
+		"awake":      night.Time(sleep.Awake).Hours(),
+		"asleep":     night.TotalAsleep().Hours(),
+		"total":      night.TotalAsleep().Hours(),
+		"longest":    night.LongestAsleep().Hours(),
+		"awakeCount": float64(night.InBedCount()),
+		"weekday":    float64(night.Date.Weekday()),
+		"score":      score,
+	}
+}
+
+// the values of the night and its derived metrics
+func allNightVars(night *sleep.Night, derived derivedStats) map[string]float64 {
+	vars := nightVars(night, derived.scores[night.Key()])
+	for name, value := range derived.values[night.Key()] {
+		vars[name] = value
+	}
+	return vars
+}
+
+type derivedMetric struct {
+	name string
+	expr expr
+}
+
+// derivedStats holds the values of the derived metrics by date, then name, and the score of each
+// date
+type derivedStats struct {
+	names  []string
+	values map[string]map[string]float64
+	scores map[string]float64
+}
+
+// compileMetrics parses the expressions of the configured metrics, each can use the base values
+// and the metrics defined before it
+func compileMetrics(configs []MetricConfig) ([]derivedMetric, error) {
+	known := slices.Clone(baseMetricNames)
+	metrics := make([]derivedMetric, 0, len(configs))
+	for _, config := range configs {
+		if config.Name == "" || slices.Contains(known, config.Name) {
+			return nil, fmt.Errorf("metric name %q is empty or already used", config.Name)
+		}
+		e, err := parseExpr(config.Expr, func(name string) bool { return slices.Contains(known, name) })
+		if err != nil {
+			return nil, fmt.Errorf("metric %s: %w", config.Name, err)
+		}
+		metrics = append(metrics, derivedMetric{config.Name, e})
+		known = append(known, config.Name)
+	}
+	return metrics, nil
+}
+
+func calculateDerivedMetrics(metrics []derivedMetric, model scoreModel, nights []*sleep.Night) derivedStats {
+	derived := derivedStats{
+		values: make(map[string]map[string]float64, len(nights)),
+		scores: make(map[string]float64, len(nights)),
+	}
+	for _, metric := range metrics {
+		derived.names = append(derived.names, metric.name)
+	}
+	for _, night := range nights {
+		derived.scores[night.Key()] = model.score(night)
+		vars := nightVars(night, derived.scores[night.Key()])
+		values := make(map[string]float64, len(metrics))
+		for _, metric := range metrics {
+			values[metric.name] = metric.expr.eval(vars)
+			vars[metric.name] = values[metric.name]
+		}
+		derived.values[night.Key()] = values
+	}
+	return derived
+}
+
+// parse a -where condition, it can use the base values and the derived metrics
+func compileFilter(where string, metrics []derivedMetric) (expr, error) {
+	known := slices.Clone(baseMetricNames)
+	for _, metric := range metrics {
+		known = append(known, metric.name)
+	}
+	filter, err := parseExpr(where, func(name string) bool { return slices.Contains(known, name) })
+	if err != nil {
+		return nil, fmt.Errorf("invalid -where: %w", err)
+	}
+	return filter, nil
+}
+
+// remove the nights not matching the filter
+func filterNights(filter expr, data *nightData) {
+	data.nights = slices.DeleteFunc(data.nights, func(night *sleep.Night) bool {
+		if filter.eval(allNightVars(night, data.derived)) != 0 {
+			return false
+		}
+		delete(data.derived.values, night.Key())
+		delete(data.derived.scores, night.Key())
+		return true
+	})
+}
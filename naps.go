@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"gonum.org/v1/plot"
+	"gonum.org/v1/plot/plotter"
+	"gonum.org/v1/plot/vg"
+
+	"sleep-stats/sleep"
+)
+
+const (
+	// the shortest gap between a nap and the other sleep of the day
+	napGap = 30 * time.Minute
+	// the longest a nap lasts, longer daytime sleep is a night shifted into the day
+	maxNapLength = 3 * time.Hour
+	// the hours a nap starts within
+	napDayStart, napDayEnd = 9, 19
+)
+
+// isNap tells whether the episode of a night is a nap, a short episode of sleep by day besides the
+// main sleep of the night
+func isNap(e *sleep.Episode, main *sleep.Episode) bool {
+	hour := e.Start.Hour()
+	return e != main && e.TotalAsleep() > 0 && e.Length() <= maxNapLength && hour >= napDayStart && hour < napDayEnd
+}
+
+// splitNaps takes the naps out of the nights so they don't add to the time of the night, the nights
+// left without sessions are dropped
+func splitNaps(nights []*sleep.Night) ([]*sleep.Night, []*sleep.Episode) {
+	var naps []*sleep.Episode
+	kept := make([]*sleep.Night, 0, len(nights))
+	for _, night := range nights {
+		episodes := sleep.Episodes(night.Sessions, napGap)
+		var main *sleep.Episode
+		for _, e := range episodes {
+			if main == nil || e.TotalAsleep() > main.TotalAsleep() {
+				main = e
+			}
+		}
+		var sessions []sleep.Session
+		for _, e := range episodes {
+			if isNap(e, main) {
+				naps = append(naps, e)
+				continue
+			}
+			sessions = append(sessions, e.Sessions...)
+		}
+		if len(sessions) == 0 {
+			continue
+		}
+		if len(sessions) < len(night.Sessions) {
+			night = &sleep.Night{Date: night.Date, Sessions: sessions}
+		}
+		kept = append(kept, night)
+	}
+	return kept, naps
+}
+
+// writeNaps summarizes the naps taken out of the nights
+func writeNaps(w io.Writer, naps []*sleep.Episode) {
+	if len(naps) == 0 {
+		return
+	}
+	days := make(map[string]bool)
+	var length, asleep time.Duration
+	for _, nap := range naps {
+		days[nap.Key()] = true
+		length += nap.Length()
+		asleep += nap.TotalAsleep()
+	}
+	n := time.Duration(len(naps))
+	fmt.Fprintf(w, "\nNaps: %d on %d days, %s long and %s asleep on average, not counted in the nights (-exclude-naps=false counts them)\n",
+		len(naps), len(days), formatDuration(length/n), formatDuration(asleep/n))
+}
+
+// writeNapReport prints each nap, how often they were taken over the days of the data and at what
+// time of day
+func writeNapReport(w io.Writer, data *nightData) {
+	naps := data.naps
+	if len(naps) == 0 {
+		fmt.Fprintln(w, "No naps found.")
+		return
+	}
+	const layout = "15:04"
+	fmt.Fprintf(w, "%-10s  %-5s  %-5s  %8s  %8s\n", "Date", "Start", "End", "length", "asleep")
+	days := make(map[string]bool)
+	var length, asleep time.Duration
+	for _, nap := range naps {
+		fmt.Fprintf(w, "%-10s  %-5s  %-5s  %8s  %8s\n", nap.Key(), nap.Start.Format(layout), nap.End.Format(layout),
+			formatDuration(nap.Length()), formatDuration(nap.TotalAsleep()))
+		days[nap.Key()] = true
+		length += nap.Length()
+		asleep += nap.TotalAsleep()
+	}
+
+	// the days from the first to the last night or nap
+	first, last := naps[0].Date, naps[len(naps)-1].Date
+	if len(data.nights) > 0 {
+		first, last = earliest(first, data.nights[0].Date), latest(last, data.nights[len(data.nights)-1].Date)
+	}
+	span := int(last.Sub(first).Hours()/24) + 1
+	n := time.Duration(len(naps))
+	fmt.Fprintf(w, "\n%d naps on %d of %d days, %.2f per week, %s long and %s asleep on average.\n",
+		len(naps), len(days), span, float64(len(naps))/float64(span)*7, formatDuration(length/n), formatDuration(asleep/n))
+
+	fmt.Fprintln(w, "\nNaps by the hour they started:")
+	counts := napHours(naps)
+	for hour := napDayStart; hour < napDayEnd; hour++ {
+		fmt.Fprintf(w, "  %02d:00  %-20s %d\n", hour, strings.Repeat("#", counts[hour-napDayStart]), counts[hour-napDayStart])
+	}
+}
+
+// the number of naps starting in each hour from napDayStart
+func napHours(naps []*sleep.Episode) []int {
+	counts := make([]int, napDayEnd-napDayStart)
+	for _, nap := range naps {
+		counts[nap.Start.Hour()-napDayStart]++
+	}
+	return counts
+}
+
+func earliest(a, b time.Time) time.Time {
+	if b.Before(a) {
+		return b
+	}
+	return a
+}
+
+func latest(a, b time.Time) time.Time {
+	if b.After(a) {
+		return b
+	}
+	return a
+}
+
+// writeNapChart charts the number of naps by the hour they started
+func writeNapChart(ctx context.Context, naps []*sleep.Episode, filename string) error {
+	counts := napHours(naps)
+	values := make(plotter.Values, len(counts))
+	labels := make([]string, len(counts))
+	for i, count := range counts {
+		values[i] = float64(count)
+		labels[i] = fmt.Sprintf("%02d:00", napDayStart+i)
+	}
+	p := plot.New()
+	p.Title.Text = "Naps by Time of Day"
+	p.Y.Label.Text = "Naps"
+	bars, err := plotter.NewBarChart(values, vg.Points(30))
+	if err != nil {
+		return err
+	}
+	bars.Color = stageColors[sleep.Asleep]
+	bars.LineStyle.Width = 0
+	p.Add(bars)
+	p.NominalX(labels...)
+	return writeChart(ctx, p, 10*vg.Inch, 5*vg.Inch, filename)
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"sleep-stats/sleep"
+)
+
+const (
+	// REM sessions closer than this belong to the same REM period and so the same cycle
+	cycleGap = 15 * time.Minute
+	// the columns of the hypnogram
+	hypnogramWidth = 72
+)
+
+// nightCommand prints the sessions and measures of one night, e.g. sleep-stats night 2024-06-12
+func nightCommand(fs *flag.FlagSet) func(ctx context.Context) error {
+	input := addInputFlags(fs)
+	hypnogram := fs.Bool("hypnogram", false, "also draw the stages over the night as text in the table")
+	output := fs.String("output", "table", "format of the night, table, json, csv of the measures or md")
+	return func(ctx context.Context) error {
+		if fs.NArg() == 0 {
+			return &exitError{fmt.Errorf("Usage: %s night <YYYY-MM-DD> [flags]", os.Args[0]), exitUsage}
+		}
+		date := fs.Arg(0)
+		// the flags can also follow the date
+		fs.Parse(fs.Args()[1:])
+
+		data, err := input.analyze(ctx)
+		if err != nil {
+			return err
+		}
+		i := slices.IndexFunc(data.nights, func(night *sleep.Night) bool { return night.Key() == date || night.Day() == date })
+		if i < 0 {
+			return &exitError{fmt.Errorf("no night on %s", date), exitNoNights}
+		}
+		if write, ok := nightWriters[*output]; ok {
+			if err := write(os.Stdout, data.nights[i], data.derived); err != nil {
+				return err
+			}
+			data.printExcluded(input.diagnostics())
+			return skippedRows(data.skipped)
+		}
+		if !isTable(*output) {
+			return &exitError{fmt.Errorf("unknown output %q, use table, json, csv or md", *output), exitUsage}
+		}
+		writeNight(os.Stdout, data.nights[i], data.derived)
+		if *hypnogram {
+			fmt.Println()
+			writeHypnogram(os.Stdout, data.nights[i])
+		}
+		data.printExcluded(input.diagnostics())
+		return skippedRows(data.skipped)
+	}
+}
+
+// writeNight prints the ordered sessions of the night followed by its clinical measures, sleep
+// cycles, score and derived metrics
+func writeNight(w io.Writer, night *sleep.Night, derived derivedStats) {
+	for _, line := range nightDetail(night) {
+		fmt.Fprintln(w, line)
+	}
+
+	clinical := calculateClinicalNight(night)
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "%-20s %s\n", "Sleep onset latency", formatDuration(clinical.SOL))
+	fmt.Fprintf(w, "%-20s %s\n", "Wake after onset", formatDuration(clinical.WASO))
+	fmt.Fprintf(w, "%-20s %d\n", "Awakenings", clinical.Awakenings)
+	fmt.Fprintf(w, "%-20s %s\n", "Longest asleep", formatDuration(night.LongestAsleep()))
+	fmt.Fprintf(w, "%-20s %.1f%%\n", "Sleep efficiency", clinical.SE)
+	fmt.Fprintf(w, "%-20s %d\n", "Sleep cycles", sleepCycles(night))
+	fmt.Fprintf(w, "%-20s %.0f\n", "Score", derived.scores[night.Key()])
+	for _, name := range derived.names {
+		fmt.Fprintf(w, "%-20s %.2f\n", name, derived.values[night.Key()][name])
+	}
+}
+
+// the -output formats of the night command besides the table
+var nightWriters = map[string]func(w io.Writer, night *sleep.Night, derived derivedStats) error{
+	"json": writeNightJSON,
+	"csv":  writeNightCSV,
+	"md":   writeNightMarkdown,
+}
+
+// a metric or measure of a night by its name in the outputs
+type measure struct {
+	name  string
+	value float64
+}
+
+// the metrics of the night followed by its clinical measures and sleep cycles, durations are in
+// hours and the efficiency in percent
+func nightMeasures(night *sleep.Night, derived derivedStats) []measure {
+	vars := allNightVars(night, derived)
+	var measures []measure
+	for _, name := range append(slices.Clone(baseMetricNames), derived.names...) {
+		measures = append(measures, measure{name, vars[name]})
+	}
+	clinical := calculateClinicalNight(night)
+	return append(measures,
+		measure{"sol", clinical.SOL.Hours()},
+		measure{"waso", clinical.WASO.Hours()},
+		measure{"awakenings", float64(clinical.Awakenings)},
+		measure{"efficiency", clinical.SE},
+		measure{"cycles", float64(sleepCycles(night))},
+	)
+}
+
+// writeNightJSON writes the night as an indented JSON object of its date, measures and sessions
+func writeNightJSON(w io.Writer, night *sleep.Night, derived derivedStats) error {
+	values := map[string]any{"date": night.Key()}
+	for _, m := range nightMeasures(night, derived) {
+		values[m.name] = m.value
+	}
+	sessions := make([]sessionJSON, len(night.Sessions))
+	for i, session := range night.Sessions {
+		sessions[i] = newSessionJSON(night, session)
+	}
+	values["sessions"] = sessions
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(values)
+}
+
+// writeNightCSV writes a row for each measure of the night with its value
+func writeNightCSV(w io.Writer, night *sleep.Night, derived derivedStats) error {
+	out := csv.NewWriter(w)
+	out.Write([]string{"measure", "value"})
+	for _, m := range nightMeasures(night, derived) {
+		out.Write([]string{m.name, strconv.FormatFloat(m.value, 'f', -1, 64)})
+	}
+	out.Flush()
+	return out.Error()
+}
+
+// writeNightMarkdown writes the sessions and the measures of the night as two Markdown tables
+func writeNightMarkdown(w io.Writer, night *sleep.Night, derived derivedStats) error {
+	var sessions [][]string
+	for _, session := range night.Sessions {
+		sessions = append(sessions, []string{session.Start.Format("15:04"), session.End.Format("15:04"),
+			session.Stage.String(), formatDuration(session.Duration())})
+	}
+	if _, err := fmt.Fprintf(w, "## %s\n\n", night.Key()); err != nil {
+		return err
+	}
+	if err := markdownTable(w, []string{"start", "end", "stage", "duration"}, sessions); err != nil {
+		return err
+	}
+	var measures [][]string
+	for _, m := range nightMeasures(night, derived) {
+		measures = append(measures, []string{m.name, strconv.FormatFloat(m.value, 'f', 2, 64)})
+	}
+	fmt.Fprintln(w)
+	return markdownTable(w, []string{"measure", "value"}, measures)
+}
+
+// sleepCycles counts the cycles of the night, each ends with a REM period. Nights recorded
+// without stages have none.
+func sleepCycles(night *sleep.Night) int {
+	cycles := 0
+	var lastREM time.Time
+	for _, session := range night.Sessions {
+		if session.Stage != sleep.REM {
+			continue
+		}
+		if lastREM.IsZero() || session.Start.Sub(lastREM) >= cycleGap {
+			cycles++
+		}
+		lastREM = session.End
+	}
+	return cycles
+}
+
+// writeHypnogram draws a line per stage from the first to the last session, marking the columns
+// where the night was mostly in that stage
+func writeHypnogram(w io.Writer, night *sleep.Night) {
+	if len(night.Sessions) == 0 {
+		return
+	}
+	start, end := night.Sessions[0].Start, night.Sessions[0].End
+	for _, session := range night.Sessions {
+		if session.End.After(end) {
+			end = session.End
+		}
+	}
+	column := end.Sub(start) / hypnogramWidth
+	if column <= 0 {
+		return
+	}
+
+	stages := []struct {
+		stage sleep.Stage
+		label string
+	}{{sleep.Awake, "Awake"}, {sleep.REM, "REM"}, {sleep.Core, "Core"}, {sleep.Deep, "Deep"}, {sleep.Asleep, "Asleep"}}
+	rows := make(map[sleep.Stage][]rune, len(stages))
+	for _, s := range stages {
+		rows[s.stage] = []rune(strings.Repeat(" ", hypnogramWidth))
+	}
+	for i := range hypnogramWidth {
+		from := start.Add(time.Duration(i) * column)
+		to := from.Add(column)
+		// the stage covering most of the column, being in bed without a stage is left blank
+		var most sleep.Stage
+		var longest time.Duration
+		for _, session := range night.Sessions {
+			if _, ok := rows[session.Stage]; !ok {
+				continue
+			}
+			overlapStart, overlapEnd := session.Start, session.End
+			if from.After(overlapStart) {
+				overlapStart = from
+			}
+			if to.Before(overlapEnd) {
+				overlapEnd = to
+			}
+			if overlap := overlapEnd.Sub(overlapStart); overlap > longest {
+				most, longest = session.Stage, overlap
+			}
+		}
+		if longest > 0 {
+			rows[most][i] = '█'
+		}
+	}
+
+	for _, s := range stages {
+		if s.stage == sleep.Asleep && night.Time(sleep.Asleep) == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "%-7s│%s│\n", s.label, string(rows[s.stage]))
+	}
+	fmt.Fprintf(w, "%-7s %-*s%s\n", "", hypnogramWidth-4, start.Format("15:04"), end.Format("15:04"))
+}
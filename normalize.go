@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"slices"
+
+	"gonum.org/v1/gonum/stat"
+)
+
+// checkNormalize checks the value of -normalize, empty for the values as they are
+func checkNormalize(normalize string) error {
+	if normalize != "" && normalize != "zscore" {
+		return fmt.Errorf("unknown normalization %q, use zscore", normalize)
+	}
+	return nil
+}
+
+// standardize returns the values as z-scores, how many standard deviations each is from their
+// mean, all 0 when they don't vary
+func standardize(values []float64) []float64 {
+	mean, sd := stat.MeanStdDev(values, nil)
+	z := make([]float64, len(values))
+	if sd == 0 || math.IsNaN(sd) {
+		return z
+	}
+	for i, v := range values {
+		z[i] = (v - mean) / sd
+	}
+	return z
+}
+
+// zscoreVars returns the metrics of each night as z-scores over the nights, the weekday is kept as
+// it is no measurement
+func zscoreVars(data *nightData) []map[string]float64 {
+	vars := make([]map[string]float64, len(data.nights))
+	for i, night := range data.nights {
+		vars[i] = allNightVars(night, data.derived)
+	}
+	for _, name := range append(slices.Clone(baseMetricNames), data.derived.names...) {
+		if name == "weekday" {
+			continue
+		}
+		values := make([]float64, len(vars))
+		for i := range vars {
+			values[i] = vars[i][name]
+		}
+		for i, z := range standardize(values) {
+			vars[i][name] = z
+		}
+	}
+	return vars
+}
+
+// writeZScores prints the metrics of each night as z-scores and the one that deviated most
+func writeZScores(w io.Writer, data *nightData) {
+	fmt.Fprintln(w, "Sleep Statistics by Date in standard deviations from the mean:")
+	names := comparedMetrics(data)
+	for i, vars := range zscoreVars(data) {
+		fmt.Fprint(w, data.nights[i].Key())
+		most := ""
+		for _, name := range names {
+			fmt.Fprintf(w, "\t%s: %+.2f", name, vars[name])
+			if most == "" || math.Abs(vars[name]) > math.Abs(vars[most]) {
+				most = name
+			}
+		}
+		fmt.Fprintf(w, "\tMost: %s %+.2f\n", most, vars[most])
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"image/color"
+	"slices"
+	"strconv"
+	"time"
+
+	"golang.org/x/exp/maps"
+
+	"sleep-stats/sleep"
+)
+
+// normalRanges are the lower and upper bounds of the metrics that are normal by metric, in hours
+// for the durations
+type normalRanges map[string][2]float64
+
+// parseNormalRanges reads the normal ranges of the config, durations like 45m for the duration
+// metrics and numbers for the others
+func parseNormalRanges(config map[string][2]string, derivedNames []string) (normalRanges, error) {
+	ranges := make(normalRanges, len(config))
+	// in order so the same config always fails on the same range
+	names := maps.Keys(config)
+	slices.Sort(names)
+	for _, name := range names {
+		bounds := config[name]
+		if !slices.Contains(baseMetricNames, name) && !slices.Contains(derivedNames, name) {
+			return nil, fmt.Errorf("invalid normal range of %q, it is no metric", name)
+		}
+		var r [2]float64
+		for i, bound := range bounds {
+			var err error
+			if isDuration(name) {
+				var d time.Duration
+				d, err = time.ParseDuration(bound)
+				r[i] = d.Hours()
+			} else {
+				r[i], err = strconv.ParseFloat(bound, 64)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("invalid normal range of %s %q, expected a duration like 45m or a number", name, bound)
+			}
+		}
+		if r[0] > r[1] {
+			return nil, fmt.Errorf("invalid normal range of %s, %s is above %s", name, bounds[0], bounds[1])
+		}
+		ranges[name] = r
+	}
+	return ranges, nil
+}
+
+// normalBands shades the normal ranges of the series of the plot of the nights in their colors,
+// the ranges of the metrics without a series are left out
+func normalBands(nights []*sleep.Night, ranges normalRanges, colors map[string]color.RGBA) bands {
+	const halfDay = 12 * 60 * 60
+	x0, x1 := float64(nights[0].Date.Unix())-halfDay, float64(nights[len(nights)-1].Date.Unix())+halfDay
+	names := maps.Keys(ranges)
+	slices.Sort(names)
+	var b bands
+	for _, name := range names {
+		c, ok := colors[name]
+		if !ok {
+			continue
+		}
+		r := ranges[name]
+		// the log scale can't show zero
+		b = append(b, band{x0: x0, x1: x1, y0: max(r[0], 0.01), y1: max(r[1], 0.01), color: color.NRGBA{R: c.R, G: c.G, B: c.B, A: 40}})
+	}
+	return b
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+)
+
+func addOpenFlag(fs *flag.FlagSet) *bool {
+	return fs.Bool("open", false, "open the plot or report in the default viewer after a successful run")
+}
+
+// openFile opens the file with the default application of the desktop without waiting for it
+func openFile(filename string) error {
+	path, err := filepath.Abs(filename)
+	if err != nil {
+		return err
+	}
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", path)
+	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", path)
+	default:
+		cmd = exec.Command("xdg-open", path)
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("opening %s: %w", filename, err)
+	}
+	// the viewer keeps running on its own
+	return cmd.Process.Release()
+}
+
+// openAfterRun opens the file when -open is given, failing to open it only warns as the run itself
+// succeeded
+func openAfterRun(open bool, filename string) {
+	if !open || filename == "" || filename == stdoutFile {
+		return
+	}
+	if err := openFile(filename); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"archive/zip"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// the extension of the stats file of -outdir by -output, the table and the reports are text
+var outputExtensions = map[string]string{
+	"json":    "json",
+	"md":      "md",
+	"jsonl":   "jsonl",
+	"parquet": "parquet",
+	"arrow":   "arrow",
+	"csv":     "csv",
+	"apple":   "csv",
+	"html":    "html",
+}
+
+// the name of the stats file in the directory of -outdir
+func statsFileName(output string) string {
+	if ext, ok := outputExtensions[output]; ok {
+		return "stats." + ext
+	}
+	return "stats.txt"
+}
+
+// newRunDir creates the directory of the files of a run inside parent, named by the time of the run
+// like 20240719-071500 and numbered like 20240719-071500-2 when another run started the same second
+func newRunDir(parent string, now time.Time) (string, error) {
+	if err := os.MkdirAll(parent, 0o755); err != nil {
+		return "", err
+	}
+	name := now.Format("20060102-150405")
+	for i := 1; ; i++ {
+		dir := filepath.Join(parent, name)
+		if i > 1 {
+			dir += "-" + strconv.Itoa(i)
+		}
+		err := os.Mkdir(dir, 0o755)
+		if !errors.Is(err, fs.ErrExist) {
+			return dir, err
+		}
+	}
+}
+
+// inRunDir returns where a file goes, in the directory of the run by its name unless there is none
+func inRunDir(dir, filename string) string {
+	if dir == "" || filename == stdoutFile {
+		return filename
+	}
+	return filepath.Join(dir, filepath.Base(filename))
+}
+
+// bundleZip packs the files of the directory into a zip archive next to it and removes the
+// directory, returning the path of the archive
+func bundleZip(dir string) (string, error) {
+	archive := dir + ".zip"
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", err
+	}
+	f, err := os.Create(archive)
+	if err != nil {
+		return "", err
+	}
+	zw := zip.NewWriter(f)
+	for _, entry := range entries {
+		if err := addToZip(zw, filepath.Join(dir, entry.Name()), filepath.Join(filepath.Base(dir), entry.Name())); err != nil {
+			f.Close()
+			os.Remove(archive)
+			return "", err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		f.Close()
+		os.Remove(archive)
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(archive)
+		return "", err
+	}
+	return archive, os.RemoveAll(dir)
+}
+
+func addToZip(zw *zip.Writer, path, name string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	header, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+	header.Name = filepath.ToSlash(name)
+	header.Method = zip.Deflate
+	w, err := zw.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, f)
+	return err
+}
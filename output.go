@@ -0,0 +1,522 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gonum.org/v1/plot/vg/vgimg"
+
+	"sleep-stats/arrow"
+	"sleep-stats/parquet"
+	"sleep-stats/sleep"
+	"sleep-stats/source/apple"
+)
+
+// outputOptions select what the -output formats write
+type outputOptions struct {
+	level string // night or session, what the rows are
+	shape string // wide or long, whether the metrics are columns or rows of the night table
+	// the metrics of the nights are z-scores instead of their values
+	zscore bool
+	// the first day of the weeks of the calendar
+	weekStart time.Weekday
+	// append the mean, median and total of the nights to the wide night table of csv
+	footer bool
+}
+
+// the -output formats besides the table, writing the nights or sessions depending on the level
+var outputWriters = map[string]func(w io.Writer, data *nightData, opts outputOptions) error{
+	"json":    writeJSON,
+	"md":      writeMarkdown,
+	"jsonl":   writeJSONLines,
+	"parquet": writeParquet,
+	"arrow":   writeArrow,
+	"csv":     writeCSV,
+	"apple":   writeApple,
+	"html":    writeHTML,
+}
+
+// outputStreams write the -output formats of a line per night or session night by night, so the
+// first nights come out while the file is still read. They return nil for the options that need
+// all nights first, like z-scores.
+var outputStreams = map[string]func(w io.Writer, opts outputOptions) nightSink{
+	"jsonl": streamJSONLines,
+	"csv":   streamLongCSV,
+}
+
+// sessionJSON is a session in the JSON output, the durations are in hours like the metrics
+type sessionJSON struct {
+	Night       string    `json:"night"`
+	Start       time.Time `json:"start"`
+	End         time.Time `json:"end"`
+	Stage       string    `json:"stage"`
+	Hours       float64   `json:"hours"`
+	SourceName  string    `json:"sourceName,omitempty"`
+	ProductType string    `json:"productType,omitempty"`
+}
+
+// writeJSONLines writes one JSON object per night with its metrics, or per session for the
+// session level, each line is written as soon as it is encoded so it can be piped into jq
+func writeJSONLines(w io.Writer, data *nightData, opts outputOptions) error {
+	level := opts.level
+	if level != "night" && level != "session" {
+		return fmt.Errorf("unknown level %q, use night or session", level)
+	}
+	enc := json.NewEncoder(w)
+	var zscores []map[string]float64
+	if opts.zscore {
+		zscores = zscoreVars(data)
+	}
+	for i, night := range data.nights {
+		switch level {
+		case "night":
+			if err := enc.Encode(normalizedJSON(nightJSON(night, data.derived), zscores, i)); err != nil {
+				return err
+			}
+		case "session":
+			for _, session := range night.Sessions {
+				if err := enc.Encode(newSessionJSON(night, session)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// streamJSONLines writes the lines of writeJSONLines a night at a time
+func streamJSONLines(w io.Writer, opts outputOptions) nightSink {
+	if opts.zscore || (opts.level != "night" && opts.level != "session") {
+		return nil
+	}
+	enc := json.NewEncoder(w)
+	return func(night *sleep.Night, derived derivedStats) error {
+		if opts.level == "night" {
+			return enc.Encode(nightJSON(night, derived))
+		}
+		for _, session := range night.Sessions {
+			if err := enc.Encode(newSessionJSON(night, session)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// writeJSON writes an indented JSON array of the nights with their metrics, or of the sessions for
+// the session level
+func writeJSON(w io.Writer, data *nightData, opts outputOptions) error {
+	rows := []any{}
+	var zscores []map[string]float64
+	if opts.zscore {
+		zscores = zscoreVars(data)
+	}
+	for i, night := range data.nights {
+		switch opts.level {
+		case "night":
+			rows = append(rows, normalizedJSON(nightJSON(night, data.derived), zscores, i))
+		case "session":
+			for _, session := range night.Sessions {
+				rows = append(rows, newSessionJSON(night, session))
+			}
+		default:
+			return fmt.Errorf("unknown level %q, use night or session", opts.level)
+		}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(rows)
+}
+
+// the night as a JSON object of its date and metrics
+func nightJSON(night *sleep.Night, derived derivedStats) map[string]any {
+	values := map[string]any{"date": night.Key()}
+	for name, value := range allNightVars(night, derived) {
+		values[name] = value
+	}
+	return values
+}
+
+func newSessionJSON(night *sleep.Night, session sleep.Session) sessionJSON {
+	return sessionJSON{
+		Night:       night.Key(),
+		Start:       session.Start,
+		End:         session.End,
+		Stage:       session.Stage.String(),
+		Hours:       session.Duration().Hours(),
+		SourceName:  session.SourceName,
+		ProductType: session.ProductType,
+	}
+}
+
+// normalizedJSON replaces the metrics of the i-th night with their z-scores, without them the
+// night is left as it is
+func normalizedJSON(night map[string]any, zscores []map[string]float64, i int) map[string]any {
+	if zscores == nil {
+		return night
+	}
+	for name, z := range zscores[i] {
+		night[name] = z
+	}
+	return night
+}
+
+// tableColumn is a typed column of the night or session table for the binary formats. The values
+// are []float64, []int32, []string, dates or []time.Time for timestamps.
+type tableColumn struct {
+	name   string
+	values any
+}
+
+// dates are times where only the calendar date matters
+type dates []time.Time
+
+// the rows of the table are the nights or the sessions depending on the level
+func levelTable(data *nightData, opts outputOptions) ([]tableColumn, error) {
+	switch opts.level {
+	case "night":
+		table := nightTable(data)
+		if opts.zscore {
+			standardizeTable(table)
+		}
+		return table, nil
+	case "session":
+		return sessionTable(data.nights), nil
+	}
+	return nil, fmt.Errorf("unknown level %q, use night or session", opts.level)
+}
+
+// standardizeTable replaces the metrics of the night table with their z-scores, the counts become
+// floats and the weekday is kept
+func standardizeTable(table []tableColumn) {
+	for i, c := range table {
+		switch values := c.values.(type) {
+		case []float64:
+			table[i].values = standardize(values)
+		case []int32:
+			if c.name == "weekday" {
+				continue
+			}
+			floats := make([]float64, len(values))
+			for j, v := range values {
+				floats[j] = float64(v)
+			}
+			table[i].values = standardize(floats)
+		}
+	}
+}
+
+// writeParquet writes a Parquet table of the nights with their metrics, or of the sessions for
+// the session level
+func writeParquet(w io.Writer, data *nightData, opts outputOptions) error {
+	table, err := levelTable(data, opts)
+	if err != nil {
+		return err
+	}
+	columns := make([]parquet.Column, len(table))
+	for i, c := range table {
+		switch values := c.values.(type) {
+		case []float64:
+			columns[i] = parquet.Double(c.name, values)
+		case []int32:
+			columns[i] = parquet.Int32(c.name, values)
+		case []string:
+			columns[i] = parquet.String(c.name, values)
+		case dates:
+			columns[i] = parquet.Date(c.name, values)
+		case []time.Time:
+			columns[i] = parquet.Timestamp(c.name, values)
+		}
+	}
+	return parquet.Write(w, columns...)
+}
+
+// writeArrow writes the same table as writeParquet as an Arrow IPC stream
+func writeArrow(w io.Writer, data *nightData, opts outputOptions) error {
+	table, err := levelTable(data, opts)
+	if err != nil {
+		return err
+	}
+	columns := make([]arrow.Column, len(table))
+	for i, c := range table {
+		switch values := c.values.(type) {
+		case []float64:
+			columns[i] = arrow.Double(c.name, values)
+		case []int32:
+			columns[i] = arrow.Int32(c.name, values)
+		case []string:
+			columns[i] = arrow.String(c.name, values)
+		case dates:
+			columns[i] = arrow.Date(c.name, values)
+		case []time.Time:
+			columns[i] = arrow.Timestamp(c.name, values)
+		}
+	}
+	return arrow.WriteStream(w, columns...)
+}
+
+// writeCSV writes the table as CSV. The long shape is the tidy form of the night table that ggplot2
+// and pandas pipelines want, a row for each date and metric with its value.
+func writeCSV(w io.Writer, data *nightData, opts outputOptions) error {
+	table, err := levelTable(data, opts)
+	if err != nil {
+		return err
+	}
+	out := csv.NewWriter(w)
+	switch opts.shape {
+	case "wide":
+		header := make([]string, len(table))
+		for i, c := range table {
+			header[i] = c.name
+		}
+		out.Write(header)
+		for row := 0; row < tableRows(table); row++ {
+			record := make([]string, len(table))
+			for i, c := range table {
+				record[i] = formatCell(c.values, row)
+			}
+			out.Write(record)
+		}
+		// z-scores are centered on zero, there is nothing to add up
+		if opts.footer && opts.level == "night" && !opts.zscore {
+			out.WriteAll(footerRecords(table))
+		}
+	case "long":
+		if opts.level != "night" {
+			return errors.New("-shape long is only for the night level")
+		}
+		out.Write(longHeader)
+		writeLongRows(out, table)
+	default:
+		return fmt.Errorf("unknown shape %q, use wide or long", opts.shape)
+	}
+	out.Flush()
+	return out.Error()
+}
+
+// the header of the long shape, the metrics of the night table become rows
+var longHeader = []string{"date", "metric", "value"}
+
+func writeLongRows(out *csv.Writer, table []tableColumn) {
+	for row := 0; row < tableRows(table); row++ {
+		for _, c := range table[1:] {
+			out.Write([]string{formatCell(table[0].values, row), c.name, formatCell(c.values, row)})
+		}
+	}
+}
+
+// streamLongCSV writes the rows of the long night table a night at a time, the wide table is
+// written once all nights are known
+func streamLongCSV(w io.Writer, opts outputOptions) nightSink {
+	if opts.shape != "long" || opts.level != "night" || opts.zscore {
+		return nil
+	}
+	out := csv.NewWriter(w)
+	out.Write(longHeader)
+	return func(night *sleep.Night, derived derivedStats) error {
+		writeLongRows(out, nightTable(&nightData{nights: []*sleep.Night{night}, derived: derived}))
+		out.Flush()
+		return out.Error()
+	}
+}
+
+// writeMarkdown writes the table as a Markdown table for pasting into notes or issues
+func writeMarkdown(w io.Writer, data *nightData, opts outputOptions) error {
+	table, err := levelTable(data, opts)
+	if err != nil {
+		return err
+	}
+	header := make([]string, len(table))
+	for i, c := range table {
+		header[i] = c.name
+	}
+	rows := make([][]string, tableRows(table))
+	for row := range rows {
+		rows[row] = make([]string, len(table))
+		for i, c := range table {
+			rows[row][i] = formatCell(c.values, row)
+		}
+	}
+	return markdownTable(w, header, rows)
+}
+
+// markdownTable writes the rows under the header, escaping the pipes in the cells
+func markdownTable(w io.Writer, header []string, rows [][]string) error {
+	line := func(cells []string) error {
+		escaped := make([]string, len(cells))
+		for i, cell := range cells {
+			escaped[i] = strings.ReplaceAll(cell, "|", `\|`)
+		}
+		_, err := fmt.Fprintf(w, "| %s |\n", strings.Join(escaped, " | "))
+		return err
+	}
+	if err := line(header); err != nil {
+		return err
+	}
+	separator := make([]string, len(header))
+	for i := range separator {
+		separator[i] = "---"
+	}
+	if err := line(separator); err != nil {
+		return err
+	}
+	for _, row := range rows {
+		if err := line(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func tableRows(table []tableColumn) int {
+	if len(table) == 0 {
+		return 0
+	}
+	switch values := table[0].values.(type) {
+	case []float64:
+		return len(values)
+	case []int32:
+		return len(values)
+	case []string:
+		return len(values)
+	case dates:
+		return len(values)
+	case []time.Time:
+		return len(values)
+	}
+	panic(fmt.Sprintf("unknown column type %T", table[0].values))
+}
+
+// the value in the row of the column as text
+func formatCell(values any, row int) string {
+	switch values := values.(type) {
+	case []float64:
+		return strconv.FormatFloat(values[row], 'f', -1, 64)
+	case []int32:
+		return strconv.Itoa(int(values[row]))
+	case []string:
+		return values[row]
+	case dates:
+		return values[row].Format(sleep.DateLayout)
+	case []time.Time:
+		return values[row].Format(time.RFC3339)
+	}
+	panic(fmt.Sprintf("unknown column type %T", values))
+}
+
+// a date column and a column for each metric, the counts are integers and the rest hours
+func nightTable(data *nightData) []tableColumn {
+	days := make(dates, len(data.nights))
+	vars := make([]map[string]float64, len(data.nights))
+	for i, night := range data.nights {
+		days[i] = night.Date
+		vars[i] = allNightVars(night, data.derived)
+	}
+
+	table := []tableColumn{{"date", days}}
+	for _, name := range append(slices.Clone(baseMetricNames), data.derived.names...) {
+		values := make([]float64, len(vars))
+		for i := range vars {
+			values[i] = vars[i][name]
+		}
+		if name == "awakeCount" || name == "weekday" {
+			counts := make([]int32, len(values))
+			for i, v := range values {
+				counts[i] = int32(v)
+			}
+			table = append(table, tableColumn{name, counts})
+			continue
+		}
+		table = append(table, tableColumn{name, values})
+	}
+	return table
+}
+
+func sessionTable(nights []*sleep.Night) []tableColumn {
+	var (
+		days                              dates
+		starts, ends                      []time.Time
+		stages, sourceNames, productTypes []string
+		hours                             []float64
+	)
+	for _, night := range nights {
+		for _, session := range night.Sessions {
+			days = append(days, night.Date)
+			starts = append(starts, session.Start)
+			ends = append(ends, session.End)
+			stages = append(stages, session.Stage.String())
+			hours = append(hours, session.Duration().Hours())
+			sourceNames = append(sourceNames, session.SourceName)
+			productTypes = append(productTypes, session.ProductType)
+		}
+	}
+	return []tableColumn{
+		{"night", days},
+		{"start", starts},
+		{"end", ends},
+		{"stage", stages},
+		{"hours", hours},
+		{"sourceName", sourceNames},
+		{"productType", productTypes},
+	}
+}
+
+// writeApple writes the sessions of the nights back as an Apple Health export CSV, cleaned of the
+// duplicates and with the stages normalized, whatever the format they were read from
+func writeApple(w io.Writer, data *nightData, opts outputOptions) error {
+	var sessions []sleep.Session
+	for _, night := range data.nights {
+		sessions = append(sessions, night.Sessions...)
+	}
+	return apple.Write(w, sessions)
+}
+
+// the table is the text the stats are printed as by default, text is its older name
+func isTable(output string) bool {
+	return output == "table" || output == "text"
+}
+
+// addPlotFlag adds -plot, the format of the plot or chart a command writes
+func addPlotFlag(fs *flag.FlagSet) *string {
+	return fs.String("plot", "svg", "format of the plot, svg, png, jpg or none to write no plot")
+}
+
+func checkPlotFormat(format string) error {
+	switch format {
+	case "svg", "png", "jpg", "none":
+		return nil
+	}
+	return fmt.Errorf("unknown plot format %q, use svg, png, jpg or none", format)
+}
+
+// resolutionFlags set the resolution of the PNG, JPEG and TIFF images, -scale multiplies -dpi so
+// -scale 2 gives images for high-DPI displays
+type resolutionFlags struct {
+	dpi   *int
+	scale *float64
+}
+
+func addResolutionFlags(fs *flag.FlagSet) resolutionFlags {
+	return resolutionFlags{
+		dpi:   fs.Int("dpi", vgimg.DefaultDPI, "dots per inch of the png and jpg images, e.g. 300 for print"),
+		scale: fs.Float64("scale", 1, "factor the resolution of the png and jpg images is multiplied by, e.g. 2 for retina displays"),
+	}
+}
+
+// apply sets rasterDPI
+func (f resolutionFlags) apply() error {
+	if *f.dpi <= 0 || *f.scale <= 0 {
+		return errors.New("-dpi and -scale have to be positive")
+	}
+	rasterDPI = int(math.Round(float64(*f.dpi) * *f.scale))
+	return nil
+}
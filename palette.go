@@ -0,0 +1,96 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"image/color"
+	"slices"
+
+	"golang.org/x/exp/maps"
+
+	"sleep-stats/sleep"
+)
+
+// stagePalette is the colors of the stages and of the series without a stage like the derived
+// metrics
+type stagePalette struct {
+	stages map[sleep.Stage]color.RGBA
+	series []color.RGBA
+}
+
+// the palettes of -palette, okabe-ito and viridis can be told apart with color vision deficiencies
+var palettes = map[string]stagePalette{
+	"default": {
+		stages: map[sleep.Stage]color.RGBA{
+			sleep.Awake:  {R: 128, G: 128, B: 128, A: 255},
+			sleep.Asleep: {R: 0, G: 90, B: 200, A: 255},
+			sleep.Core:   {R: 0, G: 255, B: 0, A: 255},
+			sleep.Deep:   {R: 0, G: 122, B: 122, A: 255},
+			sleep.REM:    {R: 255, G: 0, B: 255, A: 255},
+		},
+		series: []color.RGBA{
+			{R: 230, G: 140, B: 0, A: 255},
+			{R: 0, G: 90, B: 200, A: 255},
+			{R: 200, G: 30, B: 30, A: 255},
+			{R: 120, G: 80, B: 40, A: 255},
+		},
+	},
+	// Okabe and Ito, Color Universal Design (2008)
+	"okabe-ito": {
+		stages: map[sleep.Stage]color.RGBA{
+			sleep.Awake:  {R: 230, G: 159, B: 0, A: 255},
+			sleep.Asleep: {R: 0, G: 158, B: 115, A: 255},
+			sleep.Core:   {R: 86, G: 180, B: 233, A: 255},
+			sleep.Deep:   {R: 0, G: 114, B: 178, A: 255},
+			sleep.REM:    {R: 204, G: 121, B: 167, A: 255},
+		},
+		series: []color.RGBA{
+			{R: 213, G: 94, B: 0, A: 255},
+			{R: 240, G: 228, B: 66, A: 255},
+			{R: 0, G: 0, B: 0, A: 255},
+			{R: 0, G: 158, B: 115, A: 255},
+		},
+	},
+	// colors of the viridis map of matplotlib, from dark for deep sleep to light for awake
+	"viridis": {
+		stages: map[sleep.Stage]color.RGBA{
+			sleep.Awake:  {R: 253, G: 231, B: 37, A: 255},
+			sleep.Asleep: {R: 59, G: 82, B: 139, A: 255},
+			sleep.Core:   {R: 33, G: 145, B: 140, A: 255},
+			sleep.Deep:   {R: 68, G: 1, B: 84, A: 255},
+			sleep.REM:    {R: 94, G: 201, B: 98, A: 255},
+		},
+		series: []color.RGBA{
+			{R: 72, G: 40, B: 120, A: 255},
+			{R: 49, G: 104, B: 142, A: 255},
+			{R: 53, G: 183, B: 121, A: 255},
+			{R: 144, G: 215, B: 67, A: 255},
+		},
+	},
+}
+
+// the colors of the stages in the plots and charts, set by usePalette
+var stageColors = palettes["default"].stages
+
+// the colors of the derived metrics in the plot, set by usePalette
+var derivedColors = palettes["default"].series
+
+func addPaletteFlag(fs *flag.FlagSet) *string {
+	return fs.String("palette", "default", "colors of the stages and metrics, default, or okabe-ito and viridis which are colorblind-safe")
+}
+
+func sortedPalettes() []string {
+	names := maps.Keys(palettes)
+	slices.Sort(names)
+	return names
+}
+
+// usePalette switches the colors of the plots and charts to the palette
+func usePalette(name string) error {
+	p, ok := palettes[name]
+	if !ok {
+		return fmt.Errorf("unknown palette %q, use one of %v", name, sortedPalettes())
+	}
+	stageColors, derivedColors = p.stages, p.series
+	return nil
+}
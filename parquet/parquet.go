@@ -0,0 +1,199 @@
+// Package parquet writes flat tables of required columns as Apache Parquet files.
+//
+// Only what the exports need is supported: a single row group with one uncompressed, plain
+// encoded data page per column. That is enough for DuckDB, pandas and Spark to read the files
+// with the proper types.
+package parquet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+const magic = "PAR1"
+
+// the physical types
+const (
+	typeInt32     = 1
+	typeInt64     = 2
+	typeDouble    = 5
+	typeByteArray = 6
+)
+
+// the converted types, telling readers how to interpret the physical values
+const (
+	noConversion       = -1
+	convertedUTF8      = 0
+	convertedDate      = 6
+	convertedTimestamp = 9 // milliseconds since the epoch in UTC
+)
+
+const (
+	encodingPlain = 0
+	encodingRLE   = 3
+)
+
+// Column is a named column of values
+type Column struct {
+	name      string
+	typ       int32
+	converted int32
+	count     int
+	data      []byte // the plain encoded values
+}
+
+// String is a column of UTF-8 text
+func String(name string, values []string) Column {
+	c := Column{name: name, typ: typeByteArray, converted: convertedUTF8, count: len(values)}
+	for _, v := range values {
+		c.data = binary.LittleEndian.AppendUint32(c.data, uint32(len(v)))
+		c.data = append(c.data, v...)
+	}
+	return c
+}
+
+// Double is a column of 64-bit floats
+func Double(name string, values []float64) Column {
+	c := Column{name: name, typ: typeDouble, converted: noConversion, count: len(values)}
+	for _, v := range values {
+		c.data = binary.LittleEndian.AppendUint64(c.data, math.Float64bits(v))
+	}
+	return c
+}
+
+// Int32 is a column of 32-bit integers
+func Int32(name string, values []int32) Column {
+	c := Column{name: name, typ: typeInt32, converted: noConversion, count: len(values)}
+	for _, v := range values {
+		c.data = binary.LittleEndian.AppendUint32(c.data, uint32(v))
+	}
+	return c
+}
+
+// Date is a column of calendar dates, the time of day is ignored
+func Date(name string, values []time.Time) Column {
+	c := Column{name: name, typ: typeInt32, converted: convertedDate, count: len(values)}
+	for _, v := range values {
+		y, m, d := v.Date()
+		days := time.Date(y, m, d, 0, 0, 0, 0, time.UTC).Unix() / (24 * 60 * 60)
+		c.data = binary.LittleEndian.AppendUint32(c.data, uint32(days))
+	}
+	return c
+}
+
+// Timestamp is a column of instants with millisecond precision
+func Timestamp(name string, values []time.Time) Column {
+	c := Column{name: name, typ: typeInt64, converted: convertedTimestamp, count: len(values)}
+	for _, v := range values {
+		c.data = binary.LittleEndian.AppendUint64(c.data, uint64(v.UnixMilli()))
+	}
+	return c
+}
+
+// Write writes the columns as a Parquet file, every column has to have the same number of values
+func Write(w io.Writer, columns ...Column) error {
+	rows := 0
+	if len(columns) > 0 {
+		rows = columns[0].count
+	}
+	for _, c := range columns {
+		if c.count != rows {
+			return fmt.Errorf("parquet: column %s has %d values, expected %d", c.name, c.count, rows)
+		}
+	}
+
+	var file bytes.Buffer
+	file.WriteString(magic)
+
+	chunks := make([]columnChunk, len(columns))
+	var total int64
+	for i, c := range columns {
+		header := newCompactWriter()
+		header.i32(1, 0) // DATA_PAGE
+		header.i32(2, int32(len(c.data)))
+		header.i32(3, int32(len(c.data)))
+		header.beginStruct(5)
+		header.i32(1, int32(c.count))
+		header.i32(2, encodingPlain)
+		header.i32(3, encodingRLE)
+		header.i32(4, encodingRLE)
+		header.endStruct()
+		header.stop()
+
+		chunks[i] = columnChunk{column: c, offset: int64(file.Len()), size: int64(header.buf.Len() + len(c.data))}
+		total += chunks[i].size
+		file.Write(header.buf.Bytes())
+		file.Write(c.data)
+	}
+
+	footer := fileMetadata(chunks, rows, total)
+	file.Write(footer)
+	file.Write(binary.LittleEndian.AppendUint32(nil, uint32(len(footer))))
+	file.WriteString(magic)
+
+	_, err := file.WriteTo(w)
+	return err
+}
+
+type columnChunk struct {
+	column Column
+	offset int64 // of the data page in the file
+	size   int64 // of the page header and data
+}
+
+// the thrift encoded FileMetaData describing the schema and where the column chunks are
+func fileMetadata(chunks []columnChunk, rows int, total int64) []byte {
+	m := newCompactWriter()
+	m.i32(1, 1) // version
+
+	// the root of the schema is named schema with the columns as its children
+	m.beginList(2, len(chunks)+1)
+	m.beginElement()
+	m.binary(4, "schema")
+	m.i32(5, int32(len(chunks)))
+	m.endStruct()
+	for _, chunk := range chunks {
+		c := chunk.column
+		m.beginElement()
+		m.i32(1, c.typ)
+		m.i32(3, 0) // REQUIRED
+		m.binary(4, c.name)
+		if c.converted != noConversion {
+			m.i32(6, c.converted)
+		}
+		m.endStruct()
+	}
+
+	m.i64(3, int64(rows))
+
+	m.beginList(4, 1)
+	m.beginElement()
+	m.beginList(1, len(chunks))
+	for _, chunk := range chunks {
+		c := chunk.column
+		m.beginElement()
+		m.i64(2, chunk.offset)
+		m.beginStruct(3)
+		m.i32(1, c.typ)
+		m.i32List(2, encodingPlain, encodingRLE)
+		m.binaryList(3, c.name)
+		m.i32(4, 0) // UNCOMPRESSED
+		m.i64(5, int64(c.count))
+		m.i64(6, chunk.size)
+		m.i64(7, chunk.size)
+		m.i64(9, chunk.offset)
+		m.endStruct()
+		m.endStruct()
+	}
+	m.i64(2, total)
+	m.i64(3, int64(rows))
+	m.endStruct()
+
+	m.binary(6, "sleep-stats")
+	m.stop()
+	return m.buf.Bytes()
+}
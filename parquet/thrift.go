@@ -0,0 +1,114 @@
+package parquet
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// the thrift compact protocol types used in the metadata
+const (
+	compactI32    = 5
+	compactI64    = 6
+	compactBinary = 8
+	compactList   = 9
+	compactStruct = 12
+)
+
+// compactWriter encodes structs with the thrift compact protocol the Parquet metadata uses.
+// Fields have to be written in increasing id order within a struct.
+type compactWriter struct {
+	buf  bytes.Buffer
+	last []int // the id of the last field written in each open struct
+}
+
+func newCompactWriter() *compactWriter {
+	return &compactWriter{last: []int{0}}
+}
+
+// small increases of the field id are packed into the type byte
+func (w *compactWriter) field(id int, typ byte) {
+	top := len(w.last) - 1
+	if delta := id - w.last[top]; delta > 0 && delta <= 15 {
+		w.buf.WriteByte(byte(delta<<4) | typ)
+	} else {
+		w.buf.WriteByte(typ)
+		w.varint(int64(id))
+	}
+	w.last[top] = id
+}
+
+// zigzag encode so small negative numbers stay short
+func (w *compactWriter) varint(v int64) {
+	w.buf.Write(binary.AppendUvarint(nil, uint64((v<<1)^(v>>63))))
+}
+
+func (w *compactWriter) length(n int) {
+	w.buf.Write(binary.AppendUvarint(nil, uint64(n)))
+}
+
+func (w *compactWriter) i32(id int, v int32) {
+	w.field(id, compactI32)
+	w.varint(int64(v))
+}
+
+func (w *compactWriter) i64(id int, v int64) {
+	w.field(id, compactI64)
+	w.varint(v)
+}
+
+func (w *compactWriter) binary(id int, s string) {
+	w.field(id, compactBinary)
+	w.length(len(s))
+	w.buf.WriteString(s)
+}
+
+func (w *compactWriter) listHeader(n int, elem byte) {
+	if n < 15 {
+		w.buf.WriteByte(byte(n<<4) | elem)
+		return
+	}
+	w.buf.WriteByte(0xf0 | elem)
+	w.length(n)
+}
+
+func (w *compactWriter) i32List(id int, values ...int32) {
+	w.field(id, compactList)
+	w.listHeader(len(values), compactI32)
+	for _, v := range values {
+		w.varint(int64(v))
+	}
+}
+
+func (w *compactWriter) binaryList(id int, values ...string) {
+	w.field(id, compactList)
+	w.listHeader(len(values), compactBinary)
+	for _, v := range values {
+		w.length(len(v))
+		w.buf.WriteString(v)
+	}
+}
+
+// beginList starts a list of n structs, each written between beginElement and endStruct
+func (w *compactWriter) beginList(id int, n int) {
+	w.field(id, compactList)
+	w.listHeader(n, compactStruct)
+}
+
+func (w *compactWriter) beginElement() {
+	w.last = append(w.last, 0)
+}
+
+func (w *compactWriter) beginStruct(id int) {
+	w.field(id, compactStruct)
+	w.last = append(w.last, 0)
+}
+
+func (w *compactWriter) endStruct() {
+	w.buf.WriteByte(0)
+	w.last = w.last[:len(w.last)-1]
+}
+
+// stop ends the outermost struct
+func (w *compactWriter) stop() {
+	w.buf.WriteByte(0)
+}
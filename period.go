@@ -0,0 +1,116 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+
+	"golang.org/x/exp/maps"
+
+	"sleep-stats/sleep"
+)
+
+// periodStats holds the averages of the nights in a period like a week
+type periodStats struct {
+	key        string
+	nights     int
+	stats      map[sleep.Stage]time.Duration
+	longest    time.Duration
+	awakeCount float64
+	score      float64
+	derived    map[string]float64
+}
+
+// weekKey returns the ISO-8601 week of the date, e.g. 2024-W01. The ISO year can differ from the
+// calendar year around new year, 2024-12-30 is in 2025-W01. Weeks starting on Sunday use the
+// ISO week of the following Monday so that Sunday begins the week.
+func weekKey(date time.Time, weekStart time.Weekday) string {
+	if weekStart == time.Sunday {
+		date = date.AddDate(0, 0, 1)
+	}
+	year, week := date.ISOWeek()
+	return fmt.Sprintf("%d-W%02d", year, week)
+}
+
+// parseWeekStart returns the first day of the week given by -week-start, or else by weekStart in
+// the config, Monday without either
+func parseWeekStart(name string, config *Config) (time.Weekday, error) {
+	name = cmp.Or(name, config.WeekStart)
+	if name == "" {
+		return time.Monday, nil
+	}
+	start, err := parseWeekday(name)
+	if err != nil || (start != time.Monday && start != time.Sunday) {
+		return 0, fmt.Errorf("invalid week start %q, use monday or sunday", name)
+	}
+	return start, nil
+}
+
+// parseWeekday accepts the full or three letter English name of a day, in any case
+func parseWeekday(name string) (time.Weekday, error) {
+	for day := time.Sunday; day <= time.Saturday; day++ {
+		if strings.EqualFold(name, day.String()) || strings.EqualFold(name, day.String()[:3]) {
+			return day, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid weekday %q", name)
+}
+
+// aggregatePeriods averages the nights grouped by the key of their date, in key order
+func aggregatePeriods(data *nightData, key func(date time.Time) string) []periodStats {
+	periods := make(map[string]*periodStats)
+	for _, night := range data.nights {
+		k := key(night.Date)
+		period, ok := periods[k]
+		if !ok {
+			period = &periodStats{key: k, stats: make(map[sleep.Stage]time.Duration), derived: make(map[string]float64)}
+			periods[k] = period
+		}
+		period.nights++
+		for _, stage := range sleep.Stages {
+			period.stats[stage] += night.Time(stage)
+		}
+		period.longest += night.LongestAsleep()
+		period.awakeCount += float64(night.InBedCount())
+		period.score += data.derived.scores[night.Key()]
+		for name, value := range data.derived.values[night.Key()] {
+			period.derived[name] += value
+		}
+	}
+
+	keys := maps.Keys(periods)
+	slices.Sort(keys)
+	result := make([]periodStats, 0, len(keys))
+	for _, k := range keys {
+		period := periods[k]
+		n := period.nights
+		for stage, total := range period.stats {
+			period.stats[stage] = (total / time.Duration(n)).Round(time.Second)
+		}
+		period.longest = (period.longest / time.Duration(n)).Round(time.Second)
+		period.awakeCount /= float64(n)
+		period.score /= float64(n)
+		for name, total := range period.derived {
+			period.derived[name] = total / float64(n)
+		}
+		result = append(result, *period)
+	}
+	return result
+}
+
+func outputPeriodStats(w io.Writer, title string, periods []periodStats, derivedNames []string) {
+	fmt.Fprintf(w, "Average Sleep Statistics by %s:\n", title)
+	for _, period := range periods {
+		stats := period.stats
+		fmt.Fprintf(w, "%s\tNights: %d\tBed: %v\tCore: %v\tREM: %v\tDeep: %v\tAwake: %v\tLongest: %v\tAwake Count: %.1f\tScore: %.0f",
+			period.key, period.nights, stats[sleep.InBed], stats[sleep.Core], stats[sleep.REM], stats[sleep.Deep], stats[sleep.Awake], period.longest,
+			period.awakeCount, period.score)
+		for _, name := range derivedNames {
+			fmt.Fprintf(w, "\t%s: %.2f", name, period.derived[name])
+		}
+		fmt.Fprintln(w)
+	}
+}
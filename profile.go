@@ -0,0 +1,68 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+	"runtime/pprof"
+)
+
+// profileFlags write profiles of a run for finding what makes it slow on a large export, e.g.
+// sleep-stats -cpuprofile cpu.prof -file export.csv && go tool pprof cpu.prof. The generate command
+// writes the exports the benchmarks read to profile on.
+type profileFlags struct {
+	cpu *string
+	mem *string
+}
+
+// the profile flags are added to every command
+func addProfileFlags(fs *flag.FlagSet) profileFlags {
+	return profileFlags{
+		cpu: fs.String("cpuprofile", "", "write a CPU profile of the run to this file"),
+		mem: fs.String("memprofile", "", "write a heap profile to this file at the end of the run"),
+	}
+}
+
+// start starts the CPU profile and returns the function that stops it and writes the heap profile.
+// runProfiled calls it before exiting with the code of an error, as the runs that fail on a large
+// export are the ones to profile.
+func (p profileFlags) start() (stop func(), err error) {
+	var cpu *os.File
+	if *p.cpu != "" {
+		if cpu, err = os.Create(*p.cpu); err != nil {
+			return nil, err
+		}
+		if err := pprof.StartCPUProfile(cpu); err != nil {
+			cpu.Close()
+			return nil, err
+		}
+	}
+	return func() {
+		if cpu != nil {
+			pprof.StopCPUProfile()
+			if err := cpu.Close(); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+			}
+		}
+		if *p.mem != "" {
+			if err := writeHeapProfile(*p.mem); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+			}
+		}
+	}, nil
+}
+
+func writeHeapProfile(filename string) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	// the heap profile shows the allocations up to the last garbage collection
+	runtime.GC()
+	if err := pprof.WriteHeapProfile(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"sleep-stats/sleep"
+)
+
+// the longest a session can plausibly last
+const maxSessionLength = 24 * time.Hour
+
+// implausibleSession is a session left out of the stats as it can't be right
+type implausibleSession struct {
+	session sleep.Session
+	reason  string
+}
+
+// e.g. asleepCore 2024-03-02 23:10 to 2024-03-02 22:40: ends before it starts
+func (s implausibleSession) String() string {
+	const layout = "2006-01-02 15:04"
+	return fmt.Sprintf("%v %s to %s: %s", s.session.Stage, s.session.Start.Format(layout), s.session.End.Format(layout), s.reason)
+}
+
+// implausibleReason says why the session can't be right, empty when it can. A device clock that is
+// off produces sessions ending before they start, lasting for days or ending in the future.
+func implausibleReason(s sleep.Session, now time.Time) string {
+	switch {
+	case s.End.Before(s.Start):
+		return "ends before it starts"
+	case s.Duration() > maxSessionLength:
+		return fmt.Sprintf("lasts %s, longer than a day", formatDuration(s.Duration()))
+	case s.End.After(now):
+		return "ends in the future"
+	}
+	return ""
+}
+
+// excludeImplausible separates the implausible sessions from the others
+func excludeImplausible(sessions []sleep.Session, now time.Time) ([]sleep.Session, []implausibleSession) {
+	var implausible []implausibleSession
+	kept := sessions[:0:0]
+	for _, s := range sessions {
+		if reason := implausibleReason(s, now); reason != "" {
+			implausible = append(implausible, implausibleSession{s, reason})
+			continue
+		}
+		kept = append(kept, s)
+	}
+	return kept, implausible
+}
+
+// printImplausible summarizes the implausible sessions that were left out with a few examples
+func printImplausible(w io.Writer, implausible []implausibleSession) {
+	if len(implausible) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "Excluded %d implausible sessions, use -keep-implausible to include them:\n", len(implausible))
+	for _, s := range implausible[:min(len(implausible), skippedExamples)] {
+		fmt.Fprintf(w, "  %v\n", s)
+	}
+}
+
+// printExcluded summarizes what was left out of the nights, the rows that couldn't be parsed and
+// the implausible sessions
+func (data *nightData) printExcluded(w io.Writer) {
+	printSkipped(w, data.skipped)
+	printImplausible(w, data.implausible)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"time"
+
+	"sleep-stats/sleep"
+)
+
+// recommendation is the range recommended for an age group, the total sleep from the National
+// Sleep Foundation (Hirshkowitz et al. 2015) and the deep and REM shares of the time asleep from
+// the age norms of Ohayon et al. 2004
+type recommendation struct {
+	ages           string
+	minAge, maxAge int
+	total          [2]time.Duration
+	deep, rem      [2]float64 // in percent
+}
+
+var recommendations = []recommendation{
+	{"6-13", 6, 13, [2]time.Duration{9 * time.Hour, 11 * time.Hour}, [2]float64{20, 30}, [2]float64{20, 25}},
+	{"14-17", 14, 17, [2]time.Duration{8 * time.Hour, 10 * time.Hour}, [2]float64{18, 25}, [2]float64{20, 25}},
+	{"18-25", 18, 25, [2]time.Duration{7 * time.Hour, 9 * time.Hour}, [2]float64{15, 25}, [2]float64{20, 25}},
+	{"26-64", 26, 64, [2]time.Duration{7 * time.Hour, 9 * time.Hour}, [2]float64{13, 23}, [2]float64{20, 25}},
+	{"65+", 65, math.MaxInt, [2]time.Duration{7 * time.Hour, 8 * time.Hour}, [2]float64{5, 15}, [2]float64{17, 23}},
+}
+
+func recommendationFor(age int) (recommendation, bool) {
+	for _, r := range recommendations {
+		if age >= r.minAge && age <= r.maxAge {
+			return r, true
+		}
+	}
+	return recommendation{}, false
+}
+
+// ageOn returns the age in whole years on the day of a YYYY-MM-DD birthdate
+func ageOn(birthdate string, day time.Time) (int, error) {
+	born, err := time.Parse(sleep.DateLayout, birthdate)
+	if err != nil {
+		return 0, fmt.Errorf("invalid birthdate %q, use YYYY-MM-DD", birthdate)
+	}
+	age := day.Year() - born.Year()
+	if day.Month() < born.Month() || day.Month() == born.Month() && day.Day() < born.Day() {
+		age--
+	}
+	return age, nil
+}
+
+// writeRecommendations compares the averages of the nights with the ranges recommended for the
+// age. The shares only count the nights recorded with stages.
+func writeRecommendations(w io.Writer, nights []*sleep.Night, age int) {
+	r, ok := recommendationFor(age)
+	if !ok {
+		fmt.Fprintf(w, "\nNo recommendations for age %d, they cover ages 6 and up.\n", age)
+		return
+	}
+	if len(nights) == 0 {
+		return
+	}
+
+	var total, staged, deep, rem time.Duration
+	for _, night := range nights {
+		asleep := night.TotalAsleep()
+		total += asleep
+		if night.Time(sleep.Core)+night.Time(sleep.Deep)+night.Time(sleep.REM) > 0 {
+			staged += asleep
+			deep += night.Time(sleep.Deep)
+			rem += night.Time(sleep.REM)
+		}
+	}
+	average := total / time.Duration(len(nights))
+
+	fmt.Fprintf(w, "\nRecommended for ages %s:\n", r.ages)
+	fmt.Fprintf(w, "  %-12s %7s   %-13s %s\n", "Total sleep", formatDuration(average),
+		formatDuration(r.total[0])+"-"+formatDuration(r.total[1]), rangeFlag(average.Hours(), r.total[0].Hours(), r.total[1].Hours()))
+	if staged == 0 {
+		fmt.Fprintln(w, "  The nights have no stages to compare the deep and REM shares.")
+		return
+	}
+	for _, share := range []struct {
+		name   string
+		value  float64
+		bounds [2]float64
+	}{
+		{"Deep", 100 * deep.Hours() / staged.Hours(), r.deep},
+		{"REM", 100 * rem.Hours() / staged.Hours(), r.rem},
+	} {
+		fmt.Fprintf(w, "  %-12s %6.1f%%   %-13s %s\n", share.name, share.value,
+			fmt.Sprintf("%g-%g%%", share.bounds[0], share.bounds[1]), rangeFlag(share.value, share.bounds[0], share.bounds[1]))
+	}
+}
+
+func rangeFlag(value, low, high float64) string {
+	switch {
+	case value < low:
+		return "below range"
+	case value > high:
+		return "above range"
+	}
+	return "in range"
+}
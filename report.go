@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"gonum.org/v1/gonum/stat"
+
+	"sleep-stats/sleep"
+)
+
+// clinicalNight holds the standard sleep measures of a night as used by sleep clinics, all within
+// its in bed episodes so segments of the night before in the same date are not counted
+type clinicalNight struct {
+	TST         time.Duration // total sleep time
+	TIB         time.Duration // time in bed, the inBed segments or the episodes of the segments without them
+	SE          float64       // sleep efficiency, TST as a percentage of TIB
+	SOL         time.Duration // sleep onset latency, from getting in bed to falling asleep
+	WASO        time.Duration // wake after sleep onset, awake time between falling asleep and the final awakening
+	Awakenings  int           // awake segments between falling asleep and the final awakening
+	sleepOnset  time.Time
+	finalWaking time.Time
+}
+
+// span is a stretch of time from start to end
+type span struct{ start, end time.Time }
+
+// spans merges the times of the sessions into the stretches they cover, in order
+func spans(sessions []sleep.Session) []span {
+	var merged []span
+	for _, e := range sleep.Episodes(sessions, 0) {
+		merged = append(merged, span{e.Start, e.End})
+	}
+	return merged
+}
+
+// within returns the parts of the stretches inside the stretches of bed, in order
+func within(stretches, bed []span) []span {
+	var parts []span
+	for _, s := range stretches {
+		for _, b := range bed {
+			start, end := latest(s.start, b.start), earliest(s.end, b.end)
+			if start.Before(end) {
+				parts = append(parts, span{start, end})
+			}
+		}
+	}
+	return parts
+}
+
+func total(stretches []span) time.Duration {
+	var d time.Duration
+	for _, s := range stretches {
+		d += s.end.Sub(s.start)
+	}
+	return d
+}
+
+func calculateClinicalNight(n *sleep.Night) clinicalNight {
+	var night clinicalNight
+	var inBed, asleep, awake []sleep.Session
+	for _, session := range n.Sessions {
+		switch {
+		case session.Stage == sleep.InBed:
+			inBed = append(inBed, session)
+		case session.Stage.IsAsleep():
+			asleep = append(asleep, session)
+		case session.Stage == sleep.Awake:
+			awake = append(awake, session)
+		}
+	}
+	var bed []span
+	if len(inBed) > 0 {
+		bed = spans(inBed)
+	} else {
+		for _, e := range sleep.Episodes(n.Sessions, napGap) {
+			bed = append(bed, span{e.Start, e.End})
+		}
+	}
+	night.TIB = total(bed)
+
+	slept := within(spans(asleep), bed)
+	if len(slept) == 0 {
+		return night
+	}
+	night.TST = total(slept)
+	if night.TIB > 0 {
+		night.SE = 100 * night.TST.Hours() / night.TIB.Hours()
+	}
+	night.sleepOnset, night.finalWaking = slept[0].start, slept[len(slept)-1].end
+	for _, b := range bed {
+		if !night.sleepOnset.Before(b.start) && night.sleepOnset.Before(b.end) {
+			night.SOL = night.sleepOnset.Sub(b.start)
+		}
+	}
+
+	onset := []span{{night.sleepOnset, night.finalWaking}}
+	sort.SliceStable(awake, func(i, j int) bool { return awake[i].Start.Before(awake[j].Start) })
+	for _, session := range awake {
+		if parts := within(within([]span{{session.Start, session.End}}, onset), bed); len(parts) > 0 {
+			night.Awakenings++
+		}
+	}
+	night.WASO = total(within(within(spans(awake), onset), bed))
+	return night
+}
+
+// writeClinicalReport prints a report of the clinical measures for each night with the averages
+// and standard deviations over the period, laid out to fit a printed page
+func writeClinicalReport(w io.Writer, nights []*sleep.Night) {
+	fmt.Fprintln(w, "Sleep Report")
+	fmt.Fprintln(w, strings.Repeat("=", 72))
+	if len(nights) == 0 {
+		fmt.Fprintln(w, "No nights recorded in the period.")
+		return
+	}
+	fmt.Fprintf(w, "Period: %s to %s (%d nights)\n\n", nights[0].Key(), nights[len(nights)-1].Key(), len(nights))
+
+	fmt.Fprintf(w, "%-12s %8s %8s %7s %8s %8s %11s\n", "Date", "TST", "TIB", "SE%", "SOL", "WASO", "Awakenings")
+	fmt.Fprintln(w, strings.Repeat("-", 72))
+
+	var tst, tib, se, sol, waso, awakenings []float64
+	for _, n := range nights {
+		night := calculateClinicalNight(n)
+		fmt.Fprintf(w, "%-12s %8s %8s %7.1f %7.0fm %7.0fm %11d\n", n.Key(), formatDuration(night.TST), formatDuration(night.TIB),
+			night.SE, night.SOL.Minutes(), night.WASO.Minutes(), night.Awakenings)
+		tst = append(tst, night.TST.Minutes())
+		tib = append(tib, night.TIB.Minutes())
+		se = append(se, night.SE)
+		sol = append(sol, night.SOL.Minutes())
+		waso = append(waso, night.WASO.Minutes())
+		awakenings = append(awakenings, float64(night.Awakenings))
+	}
+
+	fmt.Fprintln(w, strings.Repeat("-", 72))
+	fmt.Fprintf(w, "\n%-28s %14s %14s\n", "Measure", "Mean", "SD")
+	summary := []struct {
+		name   string
+		values []float64
+		unit   string
+	}{
+		{"Total sleep time (TST)", tst, "min"},
+		{"Time in bed (TIB)", tib, "min"},
+		{"Sleep efficiency (SE)", se, "%"},
+		{"Sleep onset latency (SOL)", sol, "min"},
+		{"Wake after onset (WASO)", waso, "min"},
+		{"Awakenings", awakenings, ""},
+	}
+	for _, row := range summary {
+		mean, sd := stat.MeanStdDev(row.values, nil)
+		if len(row.values) < 2 {
+			sd = 0
+		}
+		fmt.Fprintf(w, "%-28s %10.1f %-3s %10.1f %-3s\n", row.name, mean, row.unit, sd, row.unit)
+	}
+	fmt.Fprintln(w, "\nTIB is the recorded time in bed, or the episodes of the segments of nights without it.")
+}
@@ -0,0 +1,4 @@
+// Package rpc is the gRPC API of the analysis, generated from sleepstats.proto.
+package rpc
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative sleepstats.proto
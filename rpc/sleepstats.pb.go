@@ -0,0 +1,339 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.34.1
+// 	protoc        v4.25.3
+// source: sleepstats.proto
+
+package rpc
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type ParseRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// the format of the export, one of the names the command line -format takes, apple by default.
+	// Only read from the first request.
+	Format string `protobuf:"bytes,1,opt,name=format,proto3" json:"format,omitempty"`
+	// the field delimiter like "," or "\t", detected from the export when empty. Only read from the
+	// first request.
+	Delimiter string `protobuf:"bytes,2,opt,name=delimiter,proto3" json:"delimiter,omitempty"`
+	// fail at the first row that can't be parsed instead of skipping it. Only read from the first
+	// request.
+	Strict bool `protobuf:"varint,3,opt,name=strict,proto3" json:"strict,omitempty"`
+	// the next part of the export
+	Chunk []byte `protobuf:"bytes,4,opt,name=chunk,proto3" json:"chunk,omitempty"`
+}
+
+func (x *ParseRequest) Reset() {
+	*x = ParseRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_sleepstats_proto_msgTypes[0]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *ParseRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ParseRequest) ProtoMessage() {}
+
+func (x *ParseRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_sleepstats_proto_msgTypes[0]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ParseRequest.ProtoReflect.Descriptor instead.
+func (*ParseRequest) Descriptor() ([]byte, []int) {
+	return file_sleepstats_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *ParseRequest) GetFormat() string {
+	if x != nil {
+		return x.Format
+	}
+	return ""
+}
+
+func (x *ParseRequest) GetDelimiter() string {
+	if x != nil {
+		return x.Delimiter
+	}
+	return ""
+}
+
+func (x *ParseRequest) GetStrict() bool {
+	if x != nil {
+		return x.Strict
+	}
+	return false
+}
+
+func (x *ParseRequest) GetChunk() []byte {
+	if x != nil {
+		return x.Chunk
+	}
+	return nil
+}
+
+type NightStats struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// YYYY-MM-DD
+	Date        string  `protobuf:"bytes,1,opt,name=date,proto3" json:"date,omitempty"`
+	InBedHours  float64 `protobuf:"fixed64,2,opt,name=in_bed_hours,json=inBedHours,proto3" json:"in_bed_hours,omitempty"`
+	AsleepHours float64 `protobuf:"fixed64,3,opt,name=asleep_hours,json=asleepHours,proto3" json:"asleep_hours,omitempty"`
+	CoreHours   float64 `protobuf:"fixed64,4,opt,name=core_hours,json=coreHours,proto3" json:"core_hours,omitempty"`
+	DeepHours   float64 `protobuf:"fixed64,5,opt,name=deep_hours,json=deepHours,proto3" json:"deep_hours,omitempty"`
+	RemHours    float64 `protobuf:"fixed64,6,opt,name=rem_hours,json=remHours,proto3" json:"rem_hours,omitempty"`
+	AwakeHours  float64 `protobuf:"fixed64,7,opt,name=awake_hours,json=awakeHours,proto3" json:"awake_hours,omitempty"`
+	// the number of times in bed
+	AwakeCount int32 `protobuf:"varint,8,opt,name=awake_count,json=awakeCount,proto3" json:"awake_count,omitempty"`
+	// the derived metrics of the server's config by name
+	Derived map[string]float64 `protobuf:"bytes,9,rep,name=derived,proto3" json:"derived,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"fixed64,2,opt,name=value,proto3"`
+}
+
+func (x *NightStats) Reset() {
+	*x = NightStats{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_sleepstats_proto_msgTypes[1]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *NightStats) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*NightStats) ProtoMessage() {}
+
+func (x *NightStats) ProtoReflect() protoreflect.Message {
+	mi := &file_sleepstats_proto_msgTypes[1]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use NightStats.ProtoReflect.Descriptor instead.
+func (*NightStats) Descriptor() ([]byte, []int) {
+	return file_sleepstats_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *NightStats) GetDate() string {
+	if x != nil {
+		return x.Date
+	}
+	return ""
+}
+
+func (x *NightStats) GetInBedHours() float64 {
+	if x != nil {
+		return x.InBedHours
+	}
+	return 0
+}
+
+func (x *NightStats) GetAsleepHours() float64 {
+	if x != nil {
+		return x.AsleepHours
+	}
+	return 0
+}
+
+func (x *NightStats) GetCoreHours() float64 {
+	if x != nil {
+		return x.CoreHours
+	}
+	return 0
+}
+
+func (x *NightStats) GetDeepHours() float64 {
+	if x != nil {
+		return x.DeepHours
+	}
+	return 0
+}
+
+func (x *NightStats) GetRemHours() float64 {
+	if x != nil {
+		return x.RemHours
+	}
+	return 0
+}
+
+func (x *NightStats) GetAwakeHours() float64 {
+	if x != nil {
+		return x.AwakeHours
+	}
+	return 0
+}
+
+func (x *NightStats) GetAwakeCount() int32 {
+	if x != nil {
+		return x.AwakeCount
+	}
+	return 0
+}
+
+func (x *NightStats) GetDerived() map[string]float64 {
+	if x != nil {
+		return x.Derived
+	}
+	return nil
+}
+
+var File_sleepstats_proto protoreflect.FileDescriptor
+
+var file_sleepstats_proto_rawDesc = []byte{
+	0x0a, 0x10, 0x73, 0x6c, 0x65, 0x65, 0x70, 0x73, 0x74, 0x61, 0x74, 0x73, 0x2e, 0x70, 0x72, 0x6f,
+	0x74, 0x6f, 0x12, 0x0d, 0x73, 0x6c, 0x65, 0x65, 0x70, 0x73, 0x74, 0x61, 0x74, 0x73, 0x2e, 0x76,
+	0x31, 0x22, 0x72, 0x0a, 0x0c, 0x50, 0x61, 0x72, 0x73, 0x65, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73,
+	0x74, 0x12, 0x16, 0x0a, 0x06, 0x66, 0x6f, 0x72, 0x6d, 0x61, 0x74, 0x18, 0x01, 0x20, 0x01, 0x28,
+	0x09, 0x52, 0x06, 0x66, 0x6f, 0x72, 0x6d, 0x61, 0x74, 0x12, 0x1c, 0x0a, 0x09, 0x64, 0x65, 0x6c,
+	0x69, 0x6d, 0x69, 0x74, 0x65, 0x72, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x09, 0x64, 0x65,
+	0x6c, 0x69, 0x6d, 0x69, 0x74, 0x65, 0x72, 0x12, 0x16, 0x0a, 0x06, 0x73, 0x74, 0x72, 0x69, 0x63,
+	0x74, 0x18, 0x03, 0x20, 0x01, 0x28, 0x08, 0x52, 0x06, 0x73, 0x74, 0x72, 0x69, 0x63, 0x74, 0x12,
+	0x14, 0x0a, 0x05, 0x63, 0x68, 0x75, 0x6e, 0x6b, 0x18, 0x04, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x05,
+	0x63, 0x68, 0x75, 0x6e, 0x6b, 0x22, 0x80, 0x03, 0x0a, 0x0a, 0x4e, 0x69, 0x67, 0x68, 0x74, 0x53,
+	0x74, 0x61, 0x74, 0x73, 0x12, 0x12, 0x0a, 0x04, 0x64, 0x61, 0x74, 0x65, 0x18, 0x01, 0x20, 0x01,
+	0x28, 0x09, 0x52, 0x04, 0x64, 0x61, 0x74, 0x65, 0x12, 0x20, 0x0a, 0x0c, 0x69, 0x6e, 0x5f, 0x62,
+	0x65, 0x64, 0x5f, 0x68, 0x6f, 0x75, 0x72, 0x73, 0x18, 0x02, 0x20, 0x01, 0x28, 0x01, 0x52, 0x0a,
+	0x69, 0x6e, 0x42, 0x65, 0x64, 0x48, 0x6f, 0x75, 0x72, 0x73, 0x12, 0x21, 0x0a, 0x0c, 0x61, 0x73,
+	0x6c, 0x65, 0x65, 0x70, 0x5f, 0x68, 0x6f, 0x75, 0x72, 0x73, 0x18, 0x03, 0x20, 0x01, 0x28, 0x01,
+	0x52, 0x0b, 0x61, 0x73, 0x6c, 0x65, 0x65, 0x70, 0x48, 0x6f, 0x75, 0x72, 0x73, 0x12, 0x1d, 0x0a,
+	0x0a, 0x63, 0x6f, 0x72, 0x65, 0x5f, 0x68, 0x6f, 0x75, 0x72, 0x73, 0x18, 0x04, 0x20, 0x01, 0x28,
+	0x01, 0x52, 0x09, 0x63, 0x6f, 0x72, 0x65, 0x48, 0x6f, 0x75, 0x72, 0x73, 0x12, 0x1d, 0x0a, 0x0a,
+	0x64, 0x65, 0x65, 0x70, 0x5f, 0x68, 0x6f, 0x75, 0x72, 0x73, 0x18, 0x05, 0x20, 0x01, 0x28, 0x01,
+	0x52, 0x09, 0x64, 0x65, 0x65, 0x70, 0x48, 0x6f, 0x75, 0x72, 0x73, 0x12, 0x1b, 0x0a, 0x09, 0x72,
+	0x65, 0x6d, 0x5f, 0x68, 0x6f, 0x75, 0x72, 0x73, 0x18, 0x06, 0x20, 0x01, 0x28, 0x01, 0x52, 0x08,
+	0x72, 0x65, 0x6d, 0x48, 0x6f, 0x75, 0x72, 0x73, 0x12, 0x1f, 0x0a, 0x0b, 0x61, 0x77, 0x61, 0x6b,
+	0x65, 0x5f, 0x68, 0x6f, 0x75, 0x72, 0x73, 0x18, 0x07, 0x20, 0x01, 0x28, 0x01, 0x52, 0x0a, 0x61,
+	0x77, 0x61, 0x6b, 0x65, 0x48, 0x6f, 0x75, 0x72, 0x73, 0x12, 0x1f, 0x0a, 0x0b, 0x61, 0x77, 0x61,
+	0x6b, 0x65, 0x5f, 0x63, 0x6f, 0x75, 0x6e, 0x74, 0x18, 0x08, 0x20, 0x01, 0x28, 0x05, 0x52, 0x0a,
+	0x61, 0x77, 0x61, 0x6b, 0x65, 0x43, 0x6f, 0x75, 0x6e, 0x74, 0x12, 0x40, 0x0a, 0x07, 0x64, 0x65,
+	0x72, 0x69, 0x76, 0x65, 0x64, 0x18, 0x09, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x26, 0x2e, 0x73, 0x6c,
+	0x65, 0x65, 0x70, 0x73, 0x74, 0x61, 0x74, 0x73, 0x2e, 0x76, 0x31, 0x2e, 0x4e, 0x69, 0x67, 0x68,
+	0x74, 0x53, 0x74, 0x61, 0x74, 0x73, 0x2e, 0x44, 0x65, 0x72, 0x69, 0x76, 0x65, 0x64, 0x45, 0x6e,
+	0x74, 0x72, 0x79, 0x52, 0x07, 0x64, 0x65, 0x72, 0x69, 0x76, 0x65, 0x64, 0x1a, 0x3a, 0x0a, 0x0c,
+	0x44, 0x65, 0x72, 0x69, 0x76, 0x65, 0x64, 0x45, 0x6e, 0x74, 0x72, 0x79, 0x12, 0x10, 0x0a, 0x03,
+	0x6b, 0x65, 0x79, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x03, 0x6b, 0x65, 0x79, 0x12, 0x14,
+	0x0a, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x01, 0x52, 0x05, 0x76,
+	0x61, 0x6c, 0x75, 0x65, 0x3a, 0x02, 0x38, 0x01, 0x32, 0x5b, 0x0a, 0x0a, 0x53, 0x6c, 0x65, 0x65,
+	0x70, 0x53, 0x74, 0x61, 0x74, 0x73, 0x12, 0x4d, 0x0a, 0x0f, 0x50, 0x61, 0x72, 0x73, 0x65, 0x41,
+	0x6e, 0x64, 0x41, 0x6e, 0x61, 0x6c, 0x79, 0x7a, 0x65, 0x12, 0x1b, 0x2e, 0x73, 0x6c, 0x65, 0x65,
+	0x70, 0x73, 0x74, 0x61, 0x74, 0x73, 0x2e, 0x76, 0x31, 0x2e, 0x50, 0x61, 0x72, 0x73, 0x65, 0x52,
+	0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x19, 0x2e, 0x73, 0x6c, 0x65, 0x65, 0x70, 0x73, 0x74,
+	0x61, 0x74, 0x73, 0x2e, 0x76, 0x31, 0x2e, 0x4e, 0x69, 0x67, 0x68, 0x74, 0x53, 0x74, 0x61, 0x74,
+	0x73, 0x28, 0x01, 0x30, 0x01, 0x42, 0x11, 0x5a, 0x0f, 0x73, 0x6c, 0x65, 0x65, 0x70, 0x2d, 0x73,
+	0x74, 0x61, 0x74, 0x73, 0x2f, 0x72, 0x70, 0x63, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+}
+
+var (
+	file_sleepstats_proto_rawDescOnce sync.Once
+	file_sleepstats_proto_rawDescData = file_sleepstats_proto_rawDesc
+)
+
+func file_sleepstats_proto_rawDescGZIP() []byte {
+	file_sleepstats_proto_rawDescOnce.Do(func() {
+		file_sleepstats_proto_rawDescData = protoimpl.X.CompressGZIP(file_sleepstats_proto_rawDescData)
+	})
+	return file_sleepstats_proto_rawDescData
+}
+
+var file_sleepstats_proto_msgTypes = make([]protoimpl.MessageInfo, 3)
+var file_sleepstats_proto_goTypes = []interface{}{
+	(*ParseRequest)(nil), // 0: sleepstats.v1.ParseRequest
+	(*NightStats)(nil),   // 1: sleepstats.v1.NightStats
+	nil,                  // 2: sleepstats.v1.NightStats.DerivedEntry
+}
+var file_sleepstats_proto_depIdxs = []int32{
+	2, // 0: sleepstats.v1.NightStats.derived:type_name -> sleepstats.v1.NightStats.DerivedEntry
+	0, // 1: sleepstats.v1.SleepStats.ParseAndAnalyze:input_type -> sleepstats.v1.ParseRequest
+	1, // 2: sleepstats.v1.SleepStats.ParseAndAnalyze:output_type -> sleepstats.v1.NightStats
+	2, // [2:3] is the sub-list for method output_type
+	1, // [1:2] is the sub-list for method input_type
+	1, // [1:1] is the sub-list for extension type_name
+	1, // [1:1] is the sub-list for extension extendee
+	0, // [0:1] is the sub-list for field type_name
+}
+
+func init() { file_sleepstats_proto_init() }
+func file_sleepstats_proto_init() {
+	if File_sleepstats_proto != nil {
+		return
+	}
+	if !protoimpl.UnsafeEnabled {
+		file_sleepstats_proto_msgTypes[0].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*ParseRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_sleepstats_proto_msgTypes[1].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*NightStats); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: file_sleepstats_proto_rawDesc,
+			NumEnums:      0,
+			NumMessages:   3,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_sleepstats_proto_goTypes,
+		DependencyIndexes: file_sleepstats_proto_depIdxs,
+		MessageInfos:      file_sleepstats_proto_msgTypes,
+	}.Build()
+	File_sleepstats_proto = out.File
+	file_sleepstats_proto_rawDesc = nil
+	file_sleepstats_proto_goTypes = nil
+	file_sleepstats_proto_depIdxs = nil
+}
@@ -0,0 +1,145 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             v4.25.3
+// source: sleepstats.proto
+
+package rpc
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	SleepStats_ParseAndAnalyze_FullMethodName = "/sleepstats.v1.SleepStats/ParseAndAnalyze"
+)
+
+// SleepStatsClient is the client API for SleepStats service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type SleepStatsClient interface {
+	// ParseAndAnalyze reads an export sent as a stream of chunks and answers with the stats of each
+	// night once the client has sent everything
+	ParseAndAnalyze(ctx context.Context, opts ...grpc.CallOption) (SleepStats_ParseAndAnalyzeClient, error)
+}
+
+type sleepStatsClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewSleepStatsClient(cc grpc.ClientConnInterface) SleepStatsClient {
+	return &sleepStatsClient{cc}
+}
+
+func (c *sleepStatsClient) ParseAndAnalyze(ctx context.Context, opts ...grpc.CallOption) (SleepStats_ParseAndAnalyzeClient, error) {
+	stream, err := c.cc.NewStream(ctx, &SleepStats_ServiceDesc.Streams[0], SleepStats_ParseAndAnalyze_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &sleepStatsParseAndAnalyzeClient{stream}
+	return x, nil
+}
+
+type SleepStats_ParseAndAnalyzeClient interface {
+	Send(*ParseRequest) error
+	Recv() (*NightStats, error)
+	grpc.ClientStream
+}
+
+type sleepStatsParseAndAnalyzeClient struct {
+	grpc.ClientStream
+}
+
+func (x *sleepStatsParseAndAnalyzeClient) Send(m *ParseRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *sleepStatsParseAndAnalyzeClient) Recv() (*NightStats, error) {
+	m := new(NightStats)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// SleepStatsServer is the server API for SleepStats service.
+// All implementations must embed UnimplementedSleepStatsServer
+// for forward compatibility
+type SleepStatsServer interface {
+	// ParseAndAnalyze reads an export sent as a stream of chunks and answers with the stats of each
+	// night once the client has sent everything
+	ParseAndAnalyze(SleepStats_ParseAndAnalyzeServer) error
+	mustEmbedUnimplementedSleepStatsServer()
+}
+
+// UnimplementedSleepStatsServer must be embedded to have forward compatible implementations.
+type UnimplementedSleepStatsServer struct {
+}
+
+func (UnimplementedSleepStatsServer) ParseAndAnalyze(SleepStats_ParseAndAnalyzeServer) error {
+	return status.Errorf(codes.Unimplemented, "method ParseAndAnalyze not implemented")
+}
+func (UnimplementedSleepStatsServer) mustEmbedUnimplementedSleepStatsServer() {}
+
+// UnsafeSleepStatsServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to SleepStatsServer will
+// result in compilation errors.
+type UnsafeSleepStatsServer interface {
+	mustEmbedUnimplementedSleepStatsServer()
+}
+
+func RegisterSleepStatsServer(s grpc.ServiceRegistrar, srv SleepStatsServer) {
+	s.RegisterService(&SleepStats_ServiceDesc, srv)
+}
+
+func _SleepStats_ParseAndAnalyze_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(SleepStatsServer).ParseAndAnalyze(&sleepStatsParseAndAnalyzeServer{stream})
+}
+
+type SleepStats_ParseAndAnalyzeServer interface {
+	Send(*NightStats) error
+	Recv() (*ParseRequest, error)
+	grpc.ServerStream
+}
+
+type sleepStatsParseAndAnalyzeServer struct {
+	grpc.ServerStream
+}
+
+func (x *sleepStatsParseAndAnalyzeServer) Send(m *NightStats) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *sleepStatsParseAndAnalyzeServer) Recv() (*ParseRequest, error) {
+	m := new(ParseRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// SleepStats_ServiceDesc is the grpc.ServiceDesc for SleepStats service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var SleepStats_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "sleepstats.v1.SleepStats",
+	HandlerType: (*SleepStatsServer)(nil),
+	Methods:     []grpc.MethodDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "ParseAndAnalyze",
+			Handler:       _SleepStats_ParseAndAnalyze_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "sleepstats.proto",
+}
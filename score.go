@@ -0,0 +1,125 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"sleep-stats/sleep"
+)
+
+// scoreModel rates how close a night comes to the targets of duration, efficiency, deep and REM
+// share and fragmentation. Each part is scored from 0 to 1 and the score is their weighted sum
+// scaled to 0-100.
+type scoreModel struct {
+	durationWeight      float64
+	efficiencyWeight    float64
+	stagesWeight        float64
+	fragmentationWeight float64
+
+	duration   time.Duration // time asleep scoring fully
+	efficiency float64       // sleep efficiency in percent scoring fully
+	stages     float64       // deep and REM share of the time asleep in percent scoring fully
+	awakenings float64       // awakenings per hour asleep scoring nothing
+}
+
+var defaultScoreModel = scoreModel{
+	durationWeight:      40,
+	efficiencyWeight:    25,
+	stagesWeight:        20,
+	fragmentationWeight: 15,
+
+	duration:   8 * time.Hour,
+	efficiency: 90,
+	stages:     40,
+	awakenings: 2,
+}
+
+// newScoreModel applies the config to the default model
+func newScoreModel(config ScoreConfig) (scoreModel, error) {
+	m := defaultScoreModel
+	weights := []struct {
+		value  *float64
+		weight *float64
+	}{
+		{config.Weights.Duration, &m.durationWeight},
+		{config.Weights.Efficiency, &m.efficiencyWeight},
+		{config.Weights.Stages, &m.stagesWeight},
+		{config.Weights.Fragmentation, &m.fragmentationWeight},
+	}
+	total := 0.0
+	for _, w := range weights {
+		if w.value != nil {
+			if *w.value < 0 {
+				return m, fmt.Errorf("invalid score weight %g, weights can't be negative", *w.value)
+			}
+			*w.weight = *w.value
+		}
+		total += *w.weight
+	}
+	if total == 0 {
+		return m, errors.New("invalid score weights, at least one has to be above 0")
+	}
+
+	if config.Duration != "" {
+		d, err := time.ParseDuration(config.Duration)
+		if err != nil || d <= 0 {
+			return m, fmt.Errorf("invalid score duration %q, use a duration like 7h30m", config.Duration)
+		}
+		m.duration = d
+	}
+	targets := []struct {
+		name   string
+		value  float64
+		target *float64
+	}{
+		{"efficiency", config.Efficiency, &m.efficiency},
+		{"stages", config.Stages, &m.stages},
+		{"awakenings", config.Awakenings, &m.awakenings},
+	}
+	for _, t := range targets {
+		if t.value < 0 {
+			return m, fmt.Errorf("invalid score %s %g, it can't be negative", t.name, t.value)
+		}
+		if t.value > 0 {
+			*t.target = t.value
+		}
+	}
+	return m, nil
+}
+
+// score returns the score of the night, 0 without sleep. Nights recorded without stages are
+// scored on the other parts only.
+func (m scoreModel) score(n *sleep.Night) float64 {
+	night := calculateClinicalNight(n)
+	asleep := night.TST.Hours()
+	if asleep == 0 {
+		return 0
+	}
+
+	var total, weights float64
+	add := func(weight, part float64) {
+		total += weight * min(1, max(0, part))
+		weights += weight
+	}
+	add(m.durationWeight, asleep/m.duration.Hours())
+	add(m.efficiencyWeight, night.SE/m.efficiency)
+	if n.Time(sleep.Core)+n.Time(sleep.Deep)+n.Time(sleep.REM) > 0 {
+		add(m.stagesWeight, 100*(n.Time(sleep.Deep)+n.Time(sleep.REM)).Hours()/asleep/m.stages)
+	}
+	add(m.fragmentationWeight, 1-float64(night.Awakenings)/asleep/m.awakenings)
+	if weights == 0 {
+		return 0
+	}
+	return 100 * total / weights
+}
+
+// String returns the formula of the score with the weights and targets
+func (m scoreModel) String() string {
+	return fmt.Sprintf("100 × (%g × min(1, asleep / %s) + %g × min(1, efficiency / %g%%) + "+
+		"%g × min(1, deep+REM share / %g%%) + %g × max(0, 1 - awakenings per hour / %g)) / %g, "+
+		"nights without stages leave out their weight",
+		m.durationWeight, formatDuration(m.duration), m.efficiencyWeight, m.efficiency,
+		m.stagesWeight, m.stages, m.fragmentationWeight, m.awakenings,
+		m.durationWeight+m.efficiencyWeight+m.stagesWeight+m.fragmentationWeight)
+}
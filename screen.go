@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gonum.org/v1/plot"
+	"gonum.org/v1/plot/vg"
+
+	"sleep-stats/sleep"
+)
+
+// the columns of a screen time export with the start of the time on screen, RescueTime calls it
+// Date and has a row per hour and activity
+var screenTimeColumns = []string{"date", "time", "timestamp", "start", "startDate"}
+
+// the columns with the time on screen and the duration each unit of it is
+var screenDurationColumns = []struct {
+	name string
+	unit time.Duration
+}{
+	{"Time Spent (seconds)", time.Second},
+	{"seconds", time.Second},
+	{"Screen Time (minutes)", time.Minute},
+	{"minutes", time.Minute},
+}
+
+// the layouts of the times of screen time exports, RescueTime writes the first
+var screenTimeLayouts = []string{"2006-01-02T15:04:05", "2006-01-02 15:04:05", "2006-01-02 15:04", time.RFC3339}
+
+// readScreenTime reads an iOS Screen Time or RescueTime export and sums the minutes on screen
+// starting from the hour evening until midnight by date. The times are read in the location.
+func readScreenTime(path string, evening int, loc *time.Location) (map[string]float64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	r := csv.NewReader(file)
+	r.FieldsPerRecord = -1
+	header, err := r.Read()
+	if err != nil {
+		return nil, fmt.Errorf("reading the header of %s: %w", path, err)
+	}
+	column := func(name string) int {
+		return slices.IndexFunc(header, func(h string) bool { return strings.EqualFold(strings.TrimSpace(h), name) })
+	}
+	timeColumn, durationColumn := -1, -1
+	var unit time.Duration
+	for _, name := range screenTimeColumns {
+		if timeColumn = column(name); timeColumn >= 0 {
+			break
+		}
+	}
+	for _, c := range screenDurationColumns {
+		if durationColumn = column(c.name); durationColumn >= 0 {
+			unit = c.unit
+			break
+		}
+	}
+	if timeColumn < 0 || durationColumn < 0 {
+		return nil, fmt.Errorf("%s needs a column with the time like Date and one with the time on screen like Time Spent (seconds), found %s",
+			path, strings.Join(header, ", "))
+	}
+
+	minutes := make(map[string]float64)
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if timeColumn >= len(record) || durationColumn >= len(record) {
+			continue
+		}
+		line, _ := r.FieldPos(0)
+		start, err := parseScreenTime(record[timeColumn], loc)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		amount, err := strconv.ParseFloat(strings.TrimSpace(record[durationColumn]), 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid time on screen %q", path, line, record[durationColumn])
+		}
+		if start.Hour() >= evening {
+			minutes[start.Format(sleep.DateLayout)] += amount * unit.Minutes()
+		}
+	}
+	return minutes, nil
+}
+
+func parseScreenTime(value string, loc *time.Location) (time.Time, error) {
+	for _, layout := range screenTimeLayouts {
+		if t, err := time.ParseInLocation(layout, strings.TrimSpace(value), loc); err == nil {
+			return t.In(loc), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q, screen time needs the time of day like 2024-01-31T21:00:00", value)
+}
+
+// analyzeScreen correlates the minutes on screen in the evening before each night with the sleep
+// onset latency and the total sleep of the night and charts both with their fits
+func analyzeScreen(ctx context.Context, w io.Writer, data *nightData, opts analyzeOptions) error {
+	if opts.screen == "" {
+		return errors.New("give the Screen Time or RescueTime export with -screen")
+	}
+	if opts.evening < 0 || opts.evening > 23 {
+		return errors.New("-evening has to be an hour from 0 to 23")
+	}
+	loc := time.UTC
+	if len(data.nights) > 0 {
+		loc = data.nights[0].Date.Location()
+	}
+	screen, err := readScreenTime(opts.screen, opts.evening, loc)
+	if err != nil {
+		return err
+	}
+
+	var minutes, latencies, totals []float64
+	for _, night := range data.nights {
+		m, ok := screen[night.Day()]
+		if !ok {
+			continue
+		}
+		minutes = append(minutes, m)
+		latencies = append(latencies, calculateClinicalNight(night).SOL.Minutes())
+		totals = append(totals, night.TotalAsleep().Minutes())
+	}
+	if len(minutes) < 3 {
+		return fmt.Errorf("fewer than 3 nights have screen time in %s", opts.screen)
+	}
+
+	fmt.Fprintf(w, "Minutes on screen from %02d:00 against the night after, over %d nights:\n", opts.evening, len(minutes))
+	var panels []*plot.Plot
+	for _, metric := range []struct {
+		name   string
+		values []float64
+	}{{"Sleep onset latency", latencies}, {"Total sleep", totals}} {
+		fit := fitCovariate("screen", metric.name, minutes, metric.values)
+		mark := ""
+		if fit.p < trendSignificance {
+			mark = " *"
+		}
+		fmt.Fprintf(w, "  %-20s r %+.2f  %+.3f min per minute on screen  (p=%.3f)%s\n", metric.name, fit.r, fit.slope, fit.p, mark)
+
+		p, err := scatterFit(minutes, metric.values)
+		if err != nil {
+			return err
+		}
+		p.Title.Text = fmt.Sprintf("%s (r = %.2f)", metric.name, fit.r)
+		p.X.Label.Text = fmt.Sprintf("Minutes on screen after %02d:00", opts.evening)
+		p.Y.Label.Text = "Minutes"
+		panels = append(panels, p)
+	}
+	fmt.Fprintf(w, "\n* marks p < %.2f.\n", trendSignificance)
+
+	if opts.chart == "" {
+		return nil
+	}
+	return writePanels(ctx, [][]*plot.Plot{panels}, 14*vg.Inch, 6*vg.Inch, opts.chart)
+}
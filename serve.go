@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"gonum.org/v1/plot/vg"
+	"gonum.org/v1/plot/vg/draw"
+)
+
+//go:embed dashboard.html
+var dashboardHTML []byte
+
+// serveCommand serves a dashboard of the stats that refreshes itself when the file changes. With
+// -format store, sleep samples can be POSTed to /ingest to add them to the store.
+func serveCommand(fs *flag.FlagSet) func(ctx context.Context) error {
+	input := addInputFlags(fs)
+	addr := fs.String("addr", "localhost:8080", "address to listen on")
+	poll := fs.Duration("poll", 5*time.Second, "how often to check the file for changes")
+	token := fs.String("token", os.Getenv("SLEEP_STATS_TOKEN"), "bearer token required to POST /ingest, defaults to $SLEEP_STATS_TOKEN")
+	profiling := fs.Bool("pprof", false, "also serve the profiles of the server under /debug/pprof/ for go tool pprof")
+	paletteName := addPaletteFlag(fs)
+	return func(ctx context.Context) error {
+		if err := usePalette(*paletteName); err != nil {
+			return &exitError{err, exitUsage}
+		}
+		return runServe(ctx, input, *addr, *poll, *token, *profiling)
+	}
+}
+
+func runServe(ctx context.Context, input inputFlags, addr string, poll time.Duration, token string, profiling bool) error {
+	d := &dashboard{input: input, token: token, changed: make(chan struct{})}
+	if err := d.reload(ctx); err != nil {
+		return err
+	}
+	go d.watch(ctx, poll)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(dashboardHTML)
+	})
+	mux.HandleFunc("GET /stats", d.serveStats)
+	mux.HandleFunc("GET /events", d.serveEvents)
+	mux.HandleFunc("GET /plot.svg", d.servePlot)
+	mux.HandleFunc("POST /ingest", d.serveIngest)
+	if profiling {
+		mux.HandleFunc("GET /debug/pprof/", pprof.Index)
+		mux.HandleFunc("GET /debug/pprof/cmdline", pprof.Cmdline)
+		mux.HandleFunc("GET /debug/pprof/profile", pprof.Profile)
+		mux.HandleFunc("GET /debug/pprof/symbol", pprof.Symbol)
+		mux.HandleFunc("GET /debug/pprof/trace", pprof.Trace)
+	}
+
+	server := &http.Server{Addr: addr, Handler: mux, BaseContext: func(net.Listener) context.Context { return ctx }}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+	fmt.Fprintf(os.Stderr, "Serving the dashboard on http://%s\n", addr)
+	if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// dashboard holds the latest analysis of the file, changed is closed and replaced whenever the
+// data is reloaded so any number of event streams can wait for the next update
+type dashboard struct {
+	input inputFlags
+	token string // required for ingesting when set
+
+	ingestMu  sync.Mutex // serializes the appends to the store
+	reloading sync.Mutex // so an older analysis never replaces a newer one
+
+	mu      sync.Mutex
+	data    *nightData
+	updated time.Time
+	changed chan struct{}
+}
+
+func (d *dashboard) reload(ctx context.Context) error {
+	d.reloading.Lock()
+	defer d.reloading.Unlock()
+	data, err := d.input.analyze(ctx)
+	if err != nil {
+		return err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.data = data
+	d.updated = time.Now()
+	close(d.changed)
+	d.changed = make(chan struct{})
+	return nil
+}
+
+// the current data and the channel closed on the next change
+func (d *dashboard) current() (*nightData, time.Time, <-chan struct{}) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.data, d.updated, d.changed
+}
+
+// watch reloads the data when the modification time or size of the file changes. Exports are
+// usually replaced as a whole, which polling notices as well as writes in place.
+func (d *dashboard) watch(ctx context.Context, poll time.Duration) {
+	var last os.FileInfo
+	if info, err := os.Stat(d.input.path()); err == nil {
+		last = info
+	}
+	ticker := time.NewTicker(poll)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		info, err := os.Stat(d.input.path())
+		if err != nil || last != nil && info.ModTime().Equal(last.ModTime()) && info.Size() == last.Size() {
+			continue
+		}
+		last = info
+		// a half written export fails to parse, the next poll retries it
+		if err := d.reload(ctx); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			last = nil
+		}
+	}
+}
+
+// the stats sent to the page, the nights in date order with their metrics
+func statsJSON(data *nightData, updated time.Time) ([]byte, error) {
+	nights := make([]map[string]any, len(data.nights))
+	for i, night := range data.nights {
+		nights[i] = nightJSON(night, data.derived)
+	}
+	return json.Marshal(map[string]any{
+		"updated": updated,
+		"metrics": slices.Concat(baseMetricNames, data.derived.names),
+		"nights":  nights,
+		"skipped": len(data.skipped),
+		// the sessions left out as implausible
+		"implausible": len(data.implausible),
+	})
+}
+
+func (d *dashboard) serveStats(w http.ResponseWriter, r *http.Request) {
+	data, updated, _ := d.current()
+	body, err := statsJSON(data, updated)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
+
+// serveEvents is a server-sent event stream with the stats, sent on connecting and after every
+// change of the file
+func (d *dashboard) serveEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	for {
+		data, updated, changed := d.current()
+		body, err := statsJSON(data, updated)
+		if err != nil {
+			return
+		}
+		if _, err := fmt.Fprintf(w, "event: stats\ndata: %s\n\n", body); err != nil {
+			return
+		}
+		flusher.Flush()
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-changed:
+		}
+	}
+}
+
+func (d *dashboard) servePlot(w http.ResponseWriter, r *http.Request) {
+	data, _, _ := d.current()
+	if len(data.nights) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+	svg := newSVG(15*vg.Inch, 8*vg.Inch)
+	p := buildPlot(data.nights, data.derived, plotOptions{lines: true})
+	applyFontSizes(p)
+	p.Draw(draw.New(svg))
+	w.Header().Set("Content-Type", "image/svg+xml")
+	w.Header().Set("Cache-Control", "no-cache")
+	svg.WriteTo(w)
+}
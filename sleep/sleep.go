@@ -0,0 +1,283 @@
+// Package sleep holds the domain model of the recorded sleep: sessions in a stage and the nights
+// they are grouped into.
+package sleep
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Stage is what a session was recording, being in bed, awake or in a stage of sleep
+type Stage int
+
+const (
+	InBed Stage = iota
+	Awake
+	// Asleep is sleep without a stage, from older devices and exports
+	Asleep
+	Core
+	Deep
+	REM
+)
+
+// Stages lists every stage in order
+var Stages = []Stage{InBed, Awake, Asleep, Core, Deep, REM}
+
+// the names used in the Apple Health export
+var stageNames = []string{"inBed", "awake", "asleep", "asleepCore", "asleepDeep", "asleepREM"}
+
+func (s Stage) String() string {
+	if s < 0 || int(s) >= len(stageNames) {
+		return fmt.Sprintf("Stage(%d)", int(s))
+	}
+	return stageNames[s]
+}
+
+// IsAsleep reports whether the stage is sleep
+func (s Stage) IsAsleep() bool {
+	return s == Asleep || s == Core || s == Deep || s == REM
+}
+
+// the spaces and brackets of the names apps write for people, like In Bed or Asleep (Core)
+var stageSpelling = strings.NewReplacer(" ", "", "_", "", "(", "", ")", "")
+
+// ParseStage parses the Apple Health name of a stage, either the short form like asleepCore or
+// the full HKCategoryValueSleepAnalysisAsleepCore, ignoring case. The names of apps and exporters
+// like In Bed, Core or Asleep (Core) are read too, and asleepUnspecified is the same as asleep.
+func ParseStage(name string) (Stage, error) {
+	short := stageSpelling.Replace(strings.TrimPrefix(name, "HKCategoryValueSleepAnalysis"))
+	if strings.EqualFold(short, "asleepUnspecified") || strings.EqualFold(short, "unspecified") {
+		return Asleep, nil
+	}
+	for i, stageName := range stageNames {
+		if strings.EqualFold(short, stageName) || strings.EqualFold("asleep"+short, stageName) {
+			return Stage(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown sleep stage %q", name)
+}
+
+// Session is a period spent in one stage as recorded by a device
+type Session struct {
+	Start       time.Time
+	End         time.Time
+	Stage       Stage
+	SourceName  string
+	ProductType string
+	// Offset is the UTC offset in seconds of the clock the device recorded the start with, 0 when
+	// the source doesn't tell
+	Offset int
+}
+
+func (s Session) Duration() time.Duration {
+	return s.End.Sub(s.Start)
+}
+
+// Night is the sessions grouped under one date
+type Night struct {
+	// Date is midnight of the date, or the start of the episode for nights grouped by gaps
+	Date time.Time
+	// the sessions ordered by their start
+	Sessions []Session
+}
+
+// DateLayout is the format of the date keys of nights
+const DateLayout = "2006-01-02"
+
+// EpisodeLayout is the format of the keys of nights grouped by gaps that don't start at midnight,
+// as several of them can start on one date
+const EpisodeLayout = "2006-01-02 15:04"
+
+// Key returns the date of the night formatted as YYYY-MM-DD, with the time of the start as
+// YYYY-MM-DD HH:MM for a night grouped by gaps
+func (n *Night) Key() string {
+	if h, m, s := n.Date.Clock(); h != 0 || m != 0 || s != 0 || n.Date.Nanosecond() != 0 {
+		return n.Date.Format(EpisodeLayout)
+	}
+	return n.Date.Format(DateLayout)
+}
+
+// Day returns the date the night starts on formatted as YYYY-MM-DD, the key of the data of other
+// sources by date like covariates for any grouping
+func (n *Night) Day() string {
+	return n.Date.Format(DateLayout)
+}
+
+// Time returns the total time of the sessions in the stage
+func (n *Night) Time(stage Stage) time.Duration {
+	var total time.Duration
+	for _, s := range n.Sessions {
+		if s.Stage == stage {
+			total += s.Duration()
+		}
+	}
+	return total
+}
+
+// TotalAsleep returns the time in all of the sleep stages
+func (n *Night) TotalAsleep() time.Duration {
+	var total time.Duration
+	for _, s := range n.Sessions {
+		if s.Stage.IsAsleep() {
+			total += s.Duration()
+		}
+	}
+	return total
+}
+
+// LongestAsleep returns the longest stretch of sleep stages following each other without waking
+// up or a gap, a simple measure of how fragmented the night was
+func (n *Night) LongestAsleep() time.Duration {
+	var longest time.Duration
+	var start, end time.Time
+	for _, s := range n.Sessions {
+		switch {
+		case s.Stage == Awake:
+			start, end = time.Time{}, time.Time{}
+		case !s.Stage.IsAsleep():
+		case start.IsZero() || s.Start.After(end):
+			start, end = s.Start, s.End
+		case s.End.After(end):
+			end = s.End
+		}
+		if !start.IsZero() {
+			longest = max(longest, end.Sub(start))
+		}
+	}
+	return longest
+}
+
+// InBedCount returns the number of in bed sessions, each time the device saw getting into bed.
+// The stats report this as the awake count.
+func (n *Night) InBedCount() int {
+	count := 0
+	for _, s := range n.Sessions {
+		if s.Stage == InBed {
+			count++
+		}
+	}
+	return count
+}
+
+// Efficiency returns the time asleep as a fraction of the time in bed, 0 without time in bed
+func (n *Night) Efficiency() float64 {
+	inBed := n.Time(InBed)
+	if inBed == 0 {
+		return 0
+	}
+	return float64(n.TotalAsleep()) / float64(inBed)
+}
+
+// SplitAtMidnight splits the sessions spanning midnight in the location of their start into a
+// session per day, so grouping them counts the time on the day it was spent
+func SplitAtMidnight(sessions []Session) []Session {
+	split := make([]Session, 0, len(sessions))
+	for _, s := range sessions {
+		for {
+			midnight := time.Date(s.Start.Year(), s.Start.Month(), s.Start.Day()+1, 0, 0, 0, 0, s.Start.Location())
+			if !s.End.After(midnight) {
+				break
+			}
+			before := s
+			before.End = midnight
+			split = append(split, before)
+			s.Start = midnight
+		}
+		split = append(split, s)
+	}
+	return split
+}
+
+// GroupByDate groups the sessions into nights by the date they start on in the location of their
+// start, the nights and their sessions are in order. The date of a night is its midnight in that
+// location.
+func GroupByDate(sessions []Session) []*Night {
+	return GroupByNight(sessions, 0)
+}
+
+// NightDate returns the date of the night a session starting at start belongs to, as midnight in
+// the location of the start. That is the date it starts on, or the date before when it starts
+// before the cutoff time of day, so the sessions after midnight belong to the night before.
+func NightDate(start time.Time, cutoff time.Duration) time.Time {
+	date := time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, start.Location())
+	clock := time.Duration(start.Hour())*time.Hour + time.Duration(start.Minute())*time.Minute + time.Duration(start.Second())*time.Second
+	if clock < cutoff {
+		return date.AddDate(0, 0, -1)
+	}
+	return date
+}
+
+// GroupByNight groups the sessions into nights like GroupByDate, the sessions starting before the
+// cutoff time of day belong to the night of the date before. With a cutoff like noon a night
+// crossing midnight in its location is one night.
+func GroupByNight(sessions []Session, cutoff time.Duration) []*Night {
+	byDate := make(map[string]*Night)
+	for _, s := range sessions {
+		date := NightDate(s.Start, cutoff)
+		key := date.Format(DateLayout)
+		night, ok := byDate[key]
+		if !ok {
+			night = &Night{Date: date}
+			byDate[key] = night
+		}
+		night.Sessions = append(night.Sessions, s)
+	}
+
+	nights := make([]*Night, 0, len(byDate))
+	for _, night := range byDate {
+		sort.SliceStable(night.Sessions, func(i, j int) bool { return night.Sessions[i].Start.Before(night.Sessions[j].Start) })
+		nights = append(nights, night)
+	}
+	slices.SortFunc(nights, func(a, b *Night) int { return a.Date.Compare(b.Date) })
+	return nights
+}
+
+// Episode is a stretch of sessions following each other with gaps shorter than the threshold of
+// Episodes, like a night's sleep or a nap. Its stats are those of a night of its sessions dated by
+// the start of the episode.
+type Episode struct {
+	Night
+	Start, End time.Time
+}
+
+// Length returns the time from the start of the first session to the end of the last
+func (e *Episode) Length() time.Duration {
+	return e.End.Sub(e.Start)
+}
+
+// Episodes clusters the sessions into episodes, a session starting less than gap after the end of
+// the episode so far belongs to it. The episodes and their sessions are in order.
+func Episodes(sessions []Session, gap time.Duration) []*Episode {
+	sorted := slices.Clone(sessions)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Start.Before(sorted[j].Start) })
+
+	var episodes []*Episode
+	var e *Episode
+	for _, s := range sorted {
+		if e == nil || s.Start.Sub(e.End) >= gap {
+			date := time.Date(s.Start.Year(), s.Start.Month(), s.Start.Day(), 0, 0, 0, 0, s.Start.Location())
+			e = &Episode{Night: Night{Date: date}, Start: s.Start, End: s.End}
+			episodes = append(episodes, e)
+		}
+		e.Sessions = append(e.Sessions, s)
+		if s.End.After(e.End) {
+			e.End = s.End
+		}
+	}
+	return episodes
+}
+
+// GroupByGaps groups the sessions into nights by the episodes they belong to instead of by their
+// dates, for sleep at any time of the day like with rotating shifts. An episode ends at a gap
+// without sessions of at least gap and each is a night of its own dated by its start, so the day
+// sleep after a night shift and the sleep of the evening after are two nights.
+func GroupByGaps(sessions []Session, gap time.Duration) []*Night {
+	var nights []*Night
+	for _, e := range Episodes(sessions, gap) {
+		nights = append(nights, &Night{Date: e.Start, Sessions: e.Sessions})
+	}
+	return nights
+}
@@ -0,0 +1,60 @@
+// Package sleeptest generates the sessions of made-up nights, for the benchmarks and the fixtures
+// of large exports to profile with.
+package sleeptest
+
+import (
+	"math/rand/v2"
+	"time"
+
+	"sleep-stats/sleep"
+)
+
+// the last generated night, in the past so the sessions aren't taken for ones that end in the future
+var lastNight = time.Date(2024, 12, 31, 0, 0, 0, 0, time.UTC)
+
+// Generate returns the sessions of the nights up to the last one of 2024, the same for the same
+// seed. Each night is recorded by a watch as the time in bed and cycles of core, deep and REM sleep
+// with short awakenings, and by a phone as the time in bed, like the sources of an Apple Health
+// export.
+func Generate(nights int, seed int64) []sleep.Session {
+	rnd := rand.New(rand.NewPCG(uint64(seed), 0))
+	// a random duration around mean, up to spread earlier or later
+	around := func(mean, spread time.Duration) time.Duration {
+		return mean + time.Duration(rnd.Int64N(int64(2*spread+1))) - spread
+	}
+	sessions := make([]sleep.Session, 0, nights*24)
+	for n := 0; n < nights; n++ {
+		bedtime := lastNight.AddDate(0, 0, n-nights+1).Add(around(22*time.Hour+30*time.Minute, time.Hour)).Truncate(time.Second)
+		wake := bedtime.Add(around(7*time.Hour+30*time.Minute, 45*time.Minute))
+		watch := func(start, end time.Time, stage sleep.Stage) {
+			sessions = append(sessions, sleep.Session{Start: start, End: end, Stage: stage, SourceName: "Apple Watch", ProductType: "Watch6,1"})
+		}
+		watch(bedtime, wake, sleep.InBed)
+		sessions = append(sessions, sleep.Session{Start: bedtime, End: wake, Stage: sleep.InBed, SourceName: "iPhone", ProductType: "iPhone14,2"})
+
+		// the cycles have more deep sleep early in the night and more REM sleep late
+		t := bedtime.Add(around(15*time.Minute, 10*time.Minute))
+		for cycle := 0; t.Before(wake); cycle++ {
+			for _, part := range []struct {
+				stage    sleep.Stage
+				duration time.Duration
+			}{
+				{sleep.Core, around(35*time.Minute, 10*time.Minute)},
+				{sleep.Deep, around(time.Duration(30-5*min(cycle, 5))*time.Minute, 5*time.Minute)},
+				{sleep.Core, around(15*time.Minute, 5*time.Minute)},
+				{sleep.REM, around(time.Duration(10+5*min(cycle, 4))*time.Minute, 5*time.Minute)},
+				{sleep.Awake, around(3*time.Minute, 2*time.Minute)},
+			} {
+				end := t.Add(part.duration)
+				if end.After(wake) {
+					end = wake
+				}
+				if end.After(t) {
+					watch(t, end, part.stage)
+				}
+				t = end
+			}
+		}
+	}
+	return sessions
+}
@@ -0,0 +1,593 @@
+// Package apple reads the sleep analysis CSV exported from Apple Health.
+package apple
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/exp/mmap"
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
+
+	"sleep-stats/sleep"
+	"sleep-stats/source"
+)
+
+const timeLayout = "2006-01-02 15:04:05 +0000"
+
+// exports recorded away from UTC have the offset of the clock, like +0200
+const offsetLayout = "2006-01-02 15:04:05 -0700"
+
+// the record type of the sleep rows in a full Health export with all the record types
+const sleepType = "HKCategoryTypeIdentifierSleepAnalysis"
+
+// the size of the read buffer, large exports are read faster in bigger chunks
+const bufferSize = 1 << 20
+
+func init() {
+	source.Register("apple", func(opts source.Options) source.Source {
+		s := &csvSource{delimiter: opts.Delimiter, verbose: opts.Verbose, fields: opts.Fields, mapped: opts.MemoryMap}
+		if !opts.Since.IsZero() {
+			s.since = opts.Since.UTC().Format(timeLayout)
+		}
+		return s
+	})
+	// an export has a header with the columns, separated by one of the delimiters it can have
+	source.RegisterDetector("apple", func(head []byte, dir bool) bool {
+		if dir {
+			return false
+		}
+		for _, delimiter := range []rune{0, ';', '\t'} {
+			s := &csvSource{delimiter: delimiter}
+			if s.OpenReader(bytes.NewReader(head)) == nil {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+type csvSource struct {
+	delimiter rune              // 0 to use the sep= line, the file extension or a comma
+	file      io.ReadSeekCloser // nil when reading from a stream
+	mapped    bool              // the file is memory-mapped instead of read
+	csvReader *csv.Reader
+	rows      *rowFilter // the lines the CSV reader reads, with those that can't be sessions marked
+	header    []string
+	headerMap map[string]int
+	schema    *schema
+	skipped   int                    // lines read before the CSV, so line numbers match the file
+	prefix    int64                  // bytes read before the CSV, so offsets match the file
+	decoded   bool                   // the file is UTF-16, so the offsets of the CSV don't match it
+	line      int                    // the line of the last record read
+	stages    map[string]sleep.Stage // the stage values parsed so far, an export only has a few
+	since     string                 // rows starting before this UTC time are skipped
+	verbose   io.Writer
+	fields    source.Fields // the columns named by the options instead of looking for them
+}
+
+func (s *csvSource) Open(name string) error {
+	file, err := s.openFile(name)
+	if err != nil {
+		return err
+	}
+	if err := s.readHeader(file, name); err != nil {
+		file.Close()
+		return fmt.Errorf("reading the header of %s: %w", name, err)
+	}
+	s.file = file
+	return nil
+}
+
+// openFile opens the file to read, memory-mapped with the option
+func (s *csvSource) openFile(name string) (io.ReadSeekCloser, error) {
+	if !s.mapped {
+		return os.Open(name)
+	}
+	mapped, err := mmap.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return mappedFile{io.NewSectionReader(mapped, 0, int64(mapped.Len())), mapped}, nil
+}
+
+// mappedFile reads a memory-mapped file like an *os.File, without a system call for every read of
+// a large export
+type mappedFile struct {
+	*io.SectionReader
+	mapped *mmap.ReaderAt
+}
+
+func (f mappedFile) Close() error {
+	return f.mapped.Close()
+}
+
+func (s *csvSource) OpenReader(r io.Reader) error {
+	if err := s.readHeader(r, ""); err != nil {
+		return fmt.Errorf("reading the header: %w", err)
+	}
+	return nil
+}
+
+// name is used to detect the delimiter by the extension, it can be empty
+func (s *csvSource) readHeader(r io.Reader, name string) error {
+	// files saved by Excel or Windows tools can start with a UTF-8 byte order mark or be UTF-16,
+	// the mark is dropped and UTF-16 is decoded to UTF-8. Other files are read as they are, as
+	// decoding is a third of the time spent reading an export.
+	reader := bufio.NewReaderSize(r, bufferSize)
+	mark, _ := reader.Peek(3)
+	switch {
+	case bytes.HasPrefix(mark, []byte{0xFF, 0xFE}), bytes.HasPrefix(mark, []byte{0xFE, 0xFF}):
+		decoded := transform.NewReader(reader, unicode.BOMOverride(unicode.UTF8.NewDecoder()))
+		reader = bufio.NewReaderSize(decoded, bufferSize)
+		s.decoded = true
+	case bytes.HasPrefix(mark, []byte("\xEF\xBB\xBF")):
+		reader.Discard(3)
+		s.prefix += 3
+	}
+
+	// check for the "sep=" starting line and if it exists read past it before parsing CSV, the
+	// separator it names is used unless one was given
+	head, err := reader.Peek(4)
+	if err != nil {
+		return err
+
+	}
+	if string(head) == "sep=" {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		s.skipped++
+		s.prefix += int64(len(line))
+		if sep := []rune(strings.TrimRight(line[len("sep="):], "\r\n")); len(sep) == 1 && s.delimiter == 0 {
+			s.delimiter = sep[0]
+		}
+	}
+
+	if s.delimiter == 0 {
+		s.delimiter = extensionDelimiter(name)
+	}
+	s.newCSVReader(reader)
+
+	// read and parse the first row
+	header, err := s.csvReader.Read()
+	if err != nil {
+		return err
+	}
+	if s.headerMap, err = parseHeader(header, s.fields); err != nil {
+		return err
+	}
+	if s.schema, err = detectSchema(header, s.headerMap, s.fields); err != nil {
+		return err
+	}
+	s.header = header
+	line, _ := s.csvReader.FieldPos(0)
+	s.line = s.skipped + line
+	if s.verbose != nil {
+		fmt.Fprintf(s.verbose, "Reading the export as %s\n", s.schema.name)
+		for _, column := range columns {
+			if i, ok := s.headerMap[column.name]; ok {
+				fmt.Fprintf(s.verbose, "Reading %s from the column %q\n", column.name, header[i])
+			}
+		}
+	}
+	return nil
+}
+
+func (s *csvSource) newCSVReader(r *bufio.Reader) {
+	s.rows = &rowFilter{r: r}
+	s.csvReader = csv.NewReader(s.rows)
+	// the fields are copied into the sessions, so the slice of a record can be reused for the next
+	s.csvReader.ReuseRecord = true
+	// the records of a full export don't all have the same fields, a short sleep row fails on the
+	// empty values instead
+	s.csvReader.FieldsPerRecord = -1
+	if s.delimiter != 0 {
+		s.csvReader.Comma = s.delimiter
+	}
+	// the lines the filter marks are skipped as comments
+	if s.delimiter != filteredMark {
+		s.csvReader.Comment = filteredMark
+		s.rows.keep = s.mayRead
+	}
+}
+
+// Offset is the offset after the last record read. A record of quoted fields with line breaks
+// counts as one line, which only shifts the line numbers of the errors after resuming.
+func (s *csvSource) Offset() (int64, int, bool) {
+	return s.prefix + s.csvReader.InputOffset(), s.line, !s.decoded && s.file != nil
+}
+
+// Resume reads the header of the file and continues with the records at the offset
+func (s *csvSource) Resume(name string, offset int64, line int) error {
+	if err := s.Open(name); err != nil {
+		return err
+	}
+	if s.decoded {
+		s.Close()
+		return fmt.Errorf("can't resume reading %s, a UTF-16 file is decoded as it is read", name)
+	}
+	if _, err := s.file.Seek(offset, io.SeekStart); err != nil {
+		s.Close()
+		return err
+	}
+	s.newCSVReader(bufio.NewReaderSize(s.file, bufferSize))
+	s.prefix, s.skipped, s.line = offset, line, line
+	return nil
+}
+
+func (s *csvSource) Next() (sleep.Session, error) {
+	for {
+		record, err := s.csvReader.Read()
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			s.line = s.skipped + parseErr.Line
+			return sleep.Session{}, &source.RowError{Line: s.skipped + parseErr.StartLine, Text: formatRecord(record), Err: parseErr.Err}
+		}
+		if err != nil {
+			return sleep.Session{}, err
+		}
+		line, _ := s.csvReader.FieldPos(0)
+		s.line = s.skipped + line
+
+		if !s.isSleep(record) {
+			continue
+		}
+		// Skip non-watch entries
+		productType, isWatch := s.device(record)
+		if !isWatch {
+			continue
+		}
+
+		// the times of the export are in UTC, so they compare as text without parsing them
+		start := s.field(record, "startDate")
+		if s.since != "" && strings.HasSuffix(start, "+0000") && start < s.since {
+			continue
+		}
+
+		startDate, err := parseTime(start)
+		if err != nil {
+			return sleep.Session{}, s.rowError(record, "startDate", err)
+		}
+		endDate, err := parseTime(s.field(record, "endDate"))
+		if err != nil {
+			return sleep.Session{}, s.rowError(record, "endDate", err)
+		}
+		stage, err := s.parseStage(s.field(record, "value"))
+		if err != nil {
+			return sleep.Session{}, s.rowError(record, "value", err)
+		}
+		_, offset := startDate.Zone()
+		return sleep.Session{
+			Start:       startDate,
+			End:         endDate,
+			Stage:       stage,
+			SourceName:  s.field(record, "sourceName"),
+			ProductType: productType,
+			Offset:      offset,
+		}, nil
+	}
+}
+
+// isSleep tells the sleep rows from the other records of a full export by the type column, files
+// without one or reading the stages from it only have sleep rows
+func (s *csvSource) isSleep(record []string) bool {
+	i, ok := s.headerMap["type"]
+	if !ok || i == s.headerMap["value"] {
+		return true
+	}
+	if i >= len(record) {
+		return false
+	}
+	// other exporters shorten the type like SleepAnalysis or Sleep Analysis
+	name := strings.ReplaceAll(strings.TrimPrefix(record[i], "HKCategoryTypeIdentifier"), " ", "")
+	return strings.EqualFold(name, "SleepAnalysis")
+}
+
+// tab separated files are detected by their extension
+func extensionDelimiter(name string) rune {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".tsv", ".tab":
+		return '\t'
+	}
+	return 0
+}
+
+// the value of the column in the record, empty for optional columns missing from the file
+func (s *csvSource) field(record []string, column string) string {
+	i, ok := s.headerMap[column]
+	if !ok || i >= len(record) {
+		return ""
+	}
+	return record[i]
+}
+
+// wrap the error with the line of the record that was just read and the column's value
+func (s *csvSource) rowError(record []string, column string, err error) error {
+	i := min(s.headerMap[column], len(record)-1)
+	line, _ := s.csvReader.FieldPos(i)
+	return &source.RowError{Line: s.skipped + line, Text: formatRecord(record), Column: column, Value: s.field(record, column), Err: err}
+}
+
+// device reads the product type of the device of a row and whether it is a watch, the rows of other
+// devices are skipped. A file without a device column is read whole.
+func (s *csvSource) device(record []string) (string, bool) {
+	if s.schema.productType == nil {
+		return "", true
+	}
+	productType := s.schema.productType(s, record)
+	return productType, strings.HasPrefix(productType, "Watch")
+}
+
+// parse the stage of a row, remembering the values already seen
+func (s *csvSource) parseStage(value string) (sleep.Stage, error) {
+	if stage, ok := s.stages[value]; ok {
+		return stage, nil
+	}
+	stage, ok := s.schema.values[value]
+	if !ok {
+		var err error
+		if stage, err = sleep.ParseStage(value); err != nil {
+			return stage, fmt.Errorf("%w in an export of %s", err, s.schema.name)
+		}
+	}
+	if s.stages == nil {
+		s.stages = make(map[string]sleep.Stage)
+	}
+	s.stages[value] = stage
+	return stage, nil
+}
+
+// parse an export timestamp, the error only says what is wrong as the value is reported with it
+func parseTime(value string) (time.Time, error) {
+	if t, ok := parseUTC(value); ok {
+		return t, nil
+	}
+	t, err := time.Parse(offsetLayout, value)
+	var parseErr *time.ParseError
+	if errors.As(err, &parseErr) {
+		if parseErr.Message != "" {
+			return t, errors.New(strings.TrimPrefix(parseErr.Message, ": "))
+		}
+		return t, fmt.Errorf("expected the format %s", timeLayout)
+	}
+	return t, err
+}
+
+// parseUTC parses a valid timestamp of the layout without time.Parse, which is most of the time
+// spent reading a large export. Anything else is left to time.Parse to describe the error.
+func parseUTC(value string) (time.Time, bool) {
+	if len(value) != len(timeLayout) || value[4] != '-' || value[7] != '-' || value[10] != ' ' ||
+		value[13] != ':' || value[16] != ':' || value[19:] != timeLayout[19:] {
+		return time.Time{}, false
+	}
+	ok := true
+	digits := func(s string) int {
+		n := 0
+		for _, c := range []byte(s) {
+			if c < '0' || c > '9' {
+				ok = false
+			}
+			n = n*10 + int(c-'0')
+		}
+		return n
+	}
+	year, month, day := digits(value[0:4]), digits(value[5:7]), digits(value[8:10])
+	hour, minute, second := digits(value[11:13]), digits(value[14:16]), digits(value[17:19])
+	if !ok || month < 1 || month > 12 || day < 1 || hour > 23 || minute > 59 || second > 59 {
+		return time.Time{}, false
+	}
+	t := time.Date(year, time.Month(month), day, hour, minute, second, 0, time.UTC)
+	// days past the end of the month roll over into the next one
+	return t, t.Day() == day
+}
+
+// the record as a CSV line, quoted where needed
+func formatRecord(record []string) string {
+	var sb strings.Builder
+	w := csv.NewWriter(&sb)
+	w.Write(record)
+	w.Flush()
+	return strings.TrimSuffix(sb.String(), "\n")
+}
+
+func (s *csvSource) Close() error {
+	if s.file == nil {
+		return nil
+	}
+	return s.file.Close()
+}
+
+// the columns that are read with the names other export variants use for them
+var columns = []struct {
+	name     string
+	aliases  []string
+	required bool
+}{
+	{"startDate", []string{"start", "startTime", "start_date"}, true},
+	{"endDate", []string{"end", "endTime", "end_date"}, true},
+	{"value", []string{"stage", "type"}, true},
+	// the device of the rows, productType from iOS 16 on and the description in device before, the
+	// schema tells which is read
+	{"productType", []string{"product", "product_type"}, false},
+	{"device", nil, false},
+	{"sourceName", []string{"source", "source_name"}, false},
+	// the record type, only in full exports that have more than the sleep rows
+	{"type", []string{"record_type"}, false},
+}
+
+// parse the header names and return a map of the column names to the index. The names match
+// ignoring case and the column's own name is preferred over its aliases, so a file with both a
+// type and a value column reads the value. A column named by the fields is only looked for by that
+// name.
+func parseHeader(header []string, fields source.Fields) (map[string]int, error) {
+	named := map[string]string{"startDate": fields.Start, "endDate": fields.End, "value": fields.Stage, "sourceName": fields.SourceName}
+	index := func(name string) (int, bool) {
+		for i, h := range header {
+			if strings.EqualFold(strings.TrimSpace(h), name) {
+				return i, true
+			}
+		}
+		return 0, false
+	}
+
+	headerMap := make(map[string]int, len(columns))
+	var missing, expected []string
+	for _, column := range columns {
+		names := append([]string{column.name}, column.aliases...)
+		if named[column.name] != "" {
+			names = []string{named[column.name]}
+		}
+		for _, name := range names {
+			if i, ok := index(name); ok {
+				headerMap[column.name] = i
+				break
+			}
+		}
+		if !column.required {
+			continue
+		}
+		expected = append(expected, column.name)
+		if _, ok := headerMap[column.name]; !ok && named[column.name] != "" {
+			missing = append(missing, fmt.Sprintf("%s (named %s)", column.name, named[column.name]))
+		} else if !ok {
+			missing = append(missing, fmt.Sprintf("%s (or %s)", column.name, strings.Join(column.aliases, ", ")))
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("missing the columns %s, expected %s but found %s",
+			strings.Join(missing, ", "), strings.Join(expected, ", "), strings.Join(header, ", "))
+	}
+	return headerMap, nil
+}
+
+// the columns Write writes, those of the export
+var exportHeader = []string{"type", "sourceName", "sourceVersion", "productType", "device", "startDate", "endDate", "value"}
+
+// Write writes the sessions as an export CSV that the apple source and other tools reading the
+// export can read, each session once. The stages are written by their short names and the source
+// version and device, which aren't kept, are left empty.
+func Write(w io.Writer, sessions []sleep.Session) error {
+	out := csv.NewWriter(w)
+	if _, err := io.WriteString(w, "sep=,\n"); err != nil {
+		return err
+	}
+	out.Write(exportHeader)
+	type key struct {
+		start, end time.Time
+		stage      sleep.Stage
+		sourceName string
+	}
+	seen := make(map[key]bool, len(sessions))
+	for _, s := range sessions {
+		k := key{s.Start, s.End, s.Stage, s.SourceName}
+		if seen[k] {
+			continue
+		}
+		seen[k] = true
+		out.Write([]string{
+			sleepType,
+			s.SourceName,
+			"",
+			s.ProductType,
+			"",
+			s.Start.UTC().Format(timeLayout),
+			s.End.UTC().Format(timeLayout),
+			s.Stage.String(),
+		})
+	}
+	out.Flush()
+	return out.Error()
+}
+
+// Inspection describes an export for checking it before analyzing it
+type Inspection struct {
+	Delimiter     rune
+	DelimiterFrom string            // the sep= line, the extension, the option or the default
+	Schema        string            // the iOS versions the columns of the export are of
+	Columns       map[string]string // the column of the file each value is read from
+	Rows          int               // the rows after the header
+	SleepRows     int               // the sleep rows of watches, which are read as sessions
+	Invalid       int               // the sleep rows that can't be parsed
+	Stages        map[string]int    // the values of the stage column of the sleep rows
+	Offsets       map[string]int    // the UTC offsets of the start times of the sleep rows like +0000
+	First, Last   time.Time         // of the sleep rows that could be parsed
+}
+
+// Inspect reads the whole export and describes it, the delimiter is detected when 0
+func Inspect(name string, delimiter rune) (*Inspection, error) {
+	s := &csvSource{delimiter: delimiter}
+	if err := s.Open(name); err != nil {
+		return nil, err
+	}
+	defer s.Close()
+
+	// every row is counted, not only those that may be read
+	s.rows.keep = nil
+	in := &Inspection{Schema: s.schema.name, Delimiter: s.delimiter, Columns: make(map[string]string), Stages: make(map[string]int), Offsets: make(map[string]int)}
+	switch {
+	case delimiter != 0:
+		in.DelimiterFrom = "the option"
+	case s.skipped > 0:
+		in.DelimiterFrom = "the sep= line"
+	case extensionDelimiter(name) != 0:
+		in.DelimiterFrom = "the extension"
+	default:
+		in.Delimiter, in.DelimiterFrom = ',', "the default"
+	}
+	for _, column := range columns {
+		if i, ok := s.headerMap[column.name]; ok {
+			in.Columns[column.name] = strings.TrimSpace(s.header[i])
+		}
+	}
+
+	for {
+		record, err := s.csvReader.Read()
+		if err == io.EOF {
+			break
+		}
+		in.Rows++
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			in.Invalid++
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if _, isWatch := s.device(record); !s.isSleep(record) || !isWatch {
+			continue
+		}
+		in.SleepRows++
+		in.Stages[s.field(record, "value")]++
+		start := s.field(record, "startDate")
+		if i := strings.LastIndexByte(start, ' '); i >= 0 {
+			in.Offsets[start[i+1:]]++
+		}
+		startDate, err := parseTime(start)
+		if err != nil {
+			in.Invalid++
+			continue
+		}
+		if _, err := parseTime(s.field(record, "endDate")); err != nil {
+			in.Invalid++
+			continue
+		}
+		if in.First.IsZero() || startDate.Before(in.First) {
+			in.First = startDate
+		}
+		if startDate.After(in.Last) {
+			in.Last = startDate
+		}
+	}
+	return in, nil
+}
@@ -0,0 +1,167 @@
+package apple
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"sleep-stats/sleep"
+	"sleep-stats/source"
+)
+
+func TestAliasedHeaderWithoutDevice(t *testing.T) {
+	input := "start,end,stage\n" +
+		"2024-01-01 22:04:00 +0000,2024-01-02 01:00:00 +0000,Core\n" +
+		"2024-01-02 01:00:00 +0000,2024-01-02 02:00:00 +0000,Asleep (Deep)\n"
+	s := &csvSource{}
+	if err := s.OpenReader(strings.NewReader(input)); err != nil {
+		t.Fatal(err)
+	}
+	if s.schema.productType != nil {
+		t.Errorf("read the file as %s, want the schema without a device", s.schema.name)
+	}
+	var stages []sleep.Stage
+	for {
+		session, err := s.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		stages = append(stages, session.Stage)
+	}
+	if len(stages) != 2 || stages[0] != sleep.Core || stages[1] != sleep.Deep {
+		t.Errorf("read the stages %v, want core and deep", stages)
+	}
+}
+
+func TestDetectAliasedHeader(t *testing.T) {
+	src, err := source.New("apple", source.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := src.(source.ReaderSource).OpenReader(strings.NewReader("start,end,stage\n")); err != nil {
+		t.Errorf("can't read a header without a device column: %v", err)
+	}
+}
+
+func TestDetectSchema(t *testing.T) {
+	tests := []struct {
+		header string
+		fields source.Fields
+		schema string // the name of the schema, empty for an error
+	}{
+		{"type,sourceName,sourceVersion,productType,device,startDate,endDate,value", source.Fields{}, "iOS 16 and later"},
+		{"type,sourceName,sourceVersion,device,startDate,endDate,value", source.Fields{}, "iOS 14 and 15"},
+		{"type,sourceName,sourceVersion,startDate,endDate,value", source.Fields{}, "a file without a device column"},
+		{"start,end,stage", source.Fields{}, "a file without a device column"},
+		{"start,end,stage,watch", source.Fields{}, ""},
+		{"from,to,level,watch", source.Fields{Start: "from", End: "to", Stage: "level"}, "a file without a device column"},
+	}
+	for _, test := range tests {
+		s := &csvSource{fields: test.fields}
+		err := s.OpenReader(strings.NewReader(test.header + "\n"))
+		switch {
+		case test.schema == "" && err == nil:
+			t.Errorf("%s: read as %s, want an unrecognized schema", test.header, s.schema.name)
+		case test.schema == "" && !strings.Contains(err.Error(), "watch"):
+			t.Errorf("%s: the error %q doesn't name the unknown column", test.header, err)
+		case test.schema != "" && err != nil:
+			t.Errorf("%s: %v", test.header, err)
+		case test.schema != "" && s.schema.name != test.schema:
+			t.Errorf("%s: read as %s, want %s", test.header, s.schema.name, test.schema)
+		}
+	}
+}
+
+func TestRowFilter(t *testing.T) {
+	input := "type,sourceName,sourceVersion,productType,device,startDate,endDate,value\n" +
+		"HKCategoryTypeIdentifierSleepAnalysis,Watch,10.0,\"Watch6,1\",,2024-01-01 22:04:00 +0000,2024-01-02 01:00:00 +0000,asleepCore\n" +
+		"HKQuantityTypeIdentifierHeartRate,Watch,10.0,\"Watch6,1\",,2024-01-01 23:00:00 +0000,2024-01-01 23:00:00 +0000,62\n" +
+		"HKCategoryTypeIdentifierSleepAnalysis,iPhone,17.0,\"iPhone14,2\",,2024-01-01 22:00:00 +0000,2024-01-02 06:00:00 +0000,inBed\n" +
+		// a quoted field with a line break, its second line isn't a row of its own
+		"HKQuantityTypeIdentifierHeartRate,\"Watch\nSleepAnalysis,Watch\",10.0,\"Watch6,1\",,2024-01-01 23:00:00 +0000,2024-01-01 23:00:00 +0000,62\n" +
+		"SLEEP ANALYSIS,Watch,10.0,\"Watch6,1\",,2024-01-02 01:00:00 +0000,2024-01-02 02:00:00 +0000,asleepDeep\n" +
+		"HKCategoryTypeIdentifierSleepAnalysis,Watch,10.0,\"Watch6,1\",,2024-01-02 02:00:00 +0000,2024-01-02 03:00:00 +0000,nap\n"
+	read := func(filter bool) ([]sleep.Stage, []int) {
+		s := &csvSource{}
+		if err := s.OpenReader(strings.NewReader(input)); err != nil {
+			t.Fatal(err)
+		}
+		if !filter {
+			s.rows.keep = nil
+		}
+		var stages []sleep.Stage
+		var lines []int
+		for {
+			session, err := s.Next()
+			if err == io.EOF {
+				break
+			}
+			var rowErr *source.RowError
+			if errors.As(err, &rowErr) {
+				lines = append(lines, rowErr.Line)
+				continue
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			stages = append(stages, session.Stage)
+		}
+		return stages, lines
+	}
+	stages, lines := read(true)
+	if len(stages) != 2 || stages[0] != sleep.Core || stages[1] != sleep.Deep {
+		t.Errorf("read the stages %v, want core and deep", stages)
+	}
+	if len(lines) != 1 || lines[0] != 8 {
+		t.Errorf("reported the rows of the lines %v, want 8", lines)
+	}
+	unfilteredStages, unfilteredLines := read(false)
+	if !slices.Equal(stages, unfilteredStages) || !slices.Equal(lines, unfilteredLines) {
+		t.Errorf("read %v and %v with the filter, want %v and %v like without", stages, lines, unfilteredStages, unfilteredLines)
+	}
+}
+
+func TestResume(t *testing.T) {
+	input := "\xEF\xBB\xBFsep=,\n" +
+		"type,sourceName,sourceVersion,productType,device,startDate,endDate,value\n" +
+		"HKCategoryTypeIdentifierSleepAnalysis,Watch,10.0,\"Watch6,1\",,2024-01-01 22:04:00 +0000,2024-01-02 01:00:00 +0000,asleepCore\n" +
+		"HKCategoryTypeIdentifierSleepAnalysis,Watch,10.0,\"Watch6,1\",,2024-01-02 01:00:00 +0000,2024-01-02 02:00:00 +0000,asleepDeep\n" +
+		"HKCategoryTypeIdentifierSleepAnalysis,Watch,10.0,\"Watch6,1\",,2024-01-02 02:00:00 +0000,2024-01-02 03:00:00 +0000,nap\n"
+	name := filepath.Join(t.TempDir(), "export.csv")
+	if err := os.WriteFile(name, []byte(input), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	s := &csvSource{}
+	if err := s.Open(name); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Next(); err != nil {
+		t.Fatal(err)
+	}
+	offset, line, ok := s.Offset()
+	s.Close()
+	if !ok || line != 3 {
+		t.Fatalf("stopped at line %d, resumable %t, want line 3", line, ok)
+	}
+
+	s = &csvSource{}
+	if err := s.Resume(name, offset, line); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	session, err := s.Next()
+	if err != nil || session.Stage != sleep.Deep {
+		t.Fatalf("continued with %+v, %v, want the deep session", session, err)
+	}
+	var rowErr *source.RowError
+	if _, err := s.Next(); !errors.As(err, &rowErr) || rowErr.Line != 5 {
+		t.Errorf("the unknown stage failed with %v, want an error on line 5", err)
+	}
+}
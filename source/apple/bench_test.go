@@ -0,0 +1,227 @@
+package apple
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
+
+	"sleep-stats/sleep"
+	"sleep-stats/sleep/sleeptest"
+	"sleep-stats/source"
+)
+
+// the export of a million rows the benchmarks read, written once for all of them
+var fixture struct {
+	once sync.Once
+	dir  string
+	name string
+	err  error
+}
+
+// the full export with the heart rate rows of the watch between the sleep rows
+var fullFixture struct {
+	once sync.Once
+	name string
+	err  error
+}
+
+// the heart rate rows after each sleep row of the full export, a full export has far more of the
+// other records than sleep rows
+const heartRates = 4
+
+func TestMain(m *testing.M) {
+	code := m.Run()
+	if fixture.dir != "" {
+		os.RemoveAll(fixture.dir)
+	}
+	os.Exit(code)
+}
+
+// millionRows returns the name of an export of about a million rows of generated nights
+func millionRows(b *testing.B) string {
+	fixture.once.Do(func() {
+		if fixture.dir, fixture.err = os.MkdirTemp("", "sleep-stats-bench"); fixture.err != nil {
+			return
+		}
+		fixture.name = filepath.Join(fixture.dir, "export.csv")
+		var f *os.File
+		if f, fixture.err = os.Create(fixture.name); fixture.err != nil {
+			return
+		}
+		defer f.Close()
+		w := bufio.NewWriter(f)
+		if fixture.err = Write(w, sleeptest.Generate(40000, 1)); fixture.err == nil {
+			fixture.err = w.Flush()
+		}
+	})
+	if fixture.err != nil {
+		b.Fatal(fixture.err)
+	}
+	return fixture.name
+}
+
+// fullExport returns the name of the export of millionRows with heartRates heart rate rows after
+// every sleep row, about five million rows
+func fullExport(b *testing.B) string {
+	name := millionRows(b)
+	fullFixture.once.Do(func() {
+		var in, out *os.File
+		if in, fullFixture.err = os.Open(name); fullFixture.err != nil {
+			return
+		}
+		defer in.Close()
+		fullFixture.name = filepath.Join(fixture.dir, "full.csv")
+		if out, fullFixture.err = os.Create(fullFixture.name); fullFixture.err != nil {
+			return
+		}
+		defer out.Close()
+		r, w := bufio.NewScanner(in), bufio.NewWriter(out)
+		for i := 0; r.Scan(); i++ {
+			w.Write(r.Bytes())
+			w.WriteByte('\n')
+			// past the sep= line and the header
+			for j := 0; i >= 2 && j < heartRates; j++ {
+				w.WriteString(`HKQuantityTypeIdentifierHeartRate,Apple Watch,10.0,"Watch6,1",,2024-01-01 23:00:00 +0000,2024-01-01 23:00:00 +0000,62` + "\n")
+			}
+		}
+		if fullFixture.err = r.Err(); fullFixture.err == nil {
+			fullFixture.err = w.Flush()
+		}
+	})
+	if fullFixture.err != nil {
+		b.Fatal(fullFixture.err)
+	}
+	return fullFixture.name
+}
+
+// readAll reads the sessions of the export with the options and returns how many there are
+func readAll(b *testing.B, name string, opts source.Options) int {
+	src, err := source.New("apple", opts)
+	if err != nil {
+		b.Fatal(err)
+	}
+	if err := src.Open(name); err != nil {
+		b.Fatal(err)
+	}
+	defer src.Close()
+	n := 0
+	for {
+		_, err := src.Next()
+		if err == io.EOF {
+			return n
+		}
+		if err != nil {
+			b.Fatal(err)
+		}
+		n++
+	}
+}
+
+// readBaseline reads the export like the source did before the fast path: decoding every file,
+// a new slice for every record and time.Parse for every timestamp
+func readBaseline(b *testing.B, name string) int {
+	f, err := os.Open(name)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer f.Close()
+	reader := bufio.NewReader(transform.NewReader(f, unicode.BOMOverride(unicode.UTF8.NewDecoder())))
+	if _, err := reader.ReadString('\n'); err != nil {
+		b.Fatal(err)
+	}
+	r := csv.NewReader(reader)
+	header, err := r.Read()
+	if err != nil {
+		b.Fatal(err)
+	}
+	headerMap, err := parseHeader(header, source.Fields{})
+	if err != nil {
+		b.Fatal(err)
+	}
+	n := 0
+	for {
+		record, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			return n
+		}
+		if err != nil {
+			b.Fatal(err)
+		}
+		if len(record[headerMap["productType"]]) < 5 || record[headerMap["productType"]][:5] != "Watch" {
+			continue
+		}
+		if _, err := time.Parse(timeLayout, record[headerMap["startDate"]]); err != nil {
+			b.Fatal(err)
+		}
+		if _, err := time.Parse(timeLayout, record[headerMap["endDate"]]); err != nil {
+			b.Fatal(err)
+		}
+		if _, err := sleep.ParseStage(record[headerMap["value"]]); err != nil {
+			b.Fatal(err)
+		}
+		n++
+	}
+}
+
+func BenchmarkRead(b *testing.B) {
+	name := millionRows(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		readAll(b, name, source.Options{})
+	}
+}
+
+func BenchmarkReadMemoryMapped(b *testing.B) {
+	name := millionRows(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		readAll(b, name, source.Options{MemoryMap: true})
+	}
+}
+
+func BenchmarkReadFullExport(b *testing.B) {
+	name := fullExport(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		readAll(b, name, source.Options{})
+	}
+}
+
+// BenchmarkReadFullExportUnfiltered reads the full export parsing every row, like without the
+// filter of the lines that can't be sessions
+func BenchmarkReadFullExportUnfiltered(b *testing.B) {
+	name := fullExport(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s := &csvSource{}
+		if err := s.Open(name); err != nil {
+			b.Fatal(err)
+		}
+		s.rows.keep = nil
+		for {
+			if _, err := s.Next(); err == io.EOF {
+				break
+			} else if err != nil {
+				b.Fatal(err)
+			}
+		}
+		s.Close()
+	}
+}
+
+func BenchmarkReadBaseline(b *testing.B) {
+	name := millionRows(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		readBaseline(b, name)
+	}
+}
@@ -0,0 +1,93 @@
+package apple
+
+import (
+	"bufio"
+	"bytes"
+)
+
+// the first byte of the lines the filter passes over is replaced with this comment character, so
+// encoding/csv skips them without splitting their fields. No export has it in its text.
+const filteredMark = '\x1f'
+
+// rowFilter passes the lines of an export on to the CSV reader, marking those that can't be read as
+// sessions by their raw bytes before they are parsed, like the heart rate rows of a full export
+// that are most of it. The lines keep their lengths so the offsets and line numbers are those of
+// the file.
+type rowFilter struct {
+	r *bufio.Reader
+	// keep tells whether the line may be a row that is read, nil to pass every line like the
+	// header
+	keep    func(line []byte) bool
+	pending []byte // the rest of the line the CSV reader didn't take yet
+	quoted  bool   // a quoted field with a line break continues on the next line
+	partial bool   // the line is longer than the buffer and continues in the next read
+	err     error
+}
+
+func (f *rowFilter) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		if len(f.pending) == 0 {
+			if f.err != nil {
+				break
+			}
+			f.next()
+		}
+		copied := copy(p[n:], f.pending)
+		f.pending = f.pending[copied:]
+		n += copied
+	}
+	if len(f.pending) == 0 && f.err != nil {
+		return n, f.err
+	}
+	return n, nil
+}
+
+// next reads the next line into pending, marking it unless it may be read
+func (f *rowFilter) next() {
+	line, err := f.r.ReadSlice('\n')
+	start := !f.quoted && !f.partial
+	f.partial = err == bufio.ErrBufferFull
+	if bytes.Count(line, []byte{'"'})%2 == 1 {
+		f.quoted = !f.quoted
+	}
+	// only whole lines outside of quoted fields are rows of their own
+	if start && !f.quoted && !f.partial && len(line) > 0 && f.keep != nil && !f.keep(line) {
+		line[0] = filteredMark
+	}
+	if err != nil && err != bufio.ErrBufferFull {
+		f.err = err
+	}
+	f.pending = line
+}
+
+// mayRead tells whether the line can be a sleep row of a watch without parsing it, the checks of
+// Next only keep rows that pass it
+func (s *csvSource) mayRead(line []byte) bool {
+	// the header is read before the schema is known
+	if s.schema == nil {
+		return true
+	}
+	if s.schema.productType != nil && !bytes.Contains(line, []byte("Watch")) {
+		return false
+	}
+	// isSleep matches the type ignoring case, and only the ASCII letters of leep fold to themselves
+	if i, ok := s.headerMap["type"]; ok && i != s.headerMap["value"] && !containsFold(line, "leep") {
+		return false
+	}
+	return true
+}
+
+// containsFold tells whether the line has the lower case ASCII word in any case
+func containsFold(line []byte, word string) bool {
+	for i := 0; i+len(word) <= len(line); i++ {
+		j := 0
+		for j < len(word) && line[i+j]|0x20 == word[j] {
+			j++
+		}
+		if j == len(word) {
+			return true
+		}
+	}
+	return false
+}
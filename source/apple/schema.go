@@ -0,0 +1,111 @@
+package apple
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"sleep-stats/sleep"
+	"sleep-stats/source"
+)
+
+// schema is the layout of the exports of a range of iOS versions. The columns telling the device
+// and the spellings of the stages changed between them, the times and the stage column didn't.
+type schema struct {
+	name string
+	// the columns the exports of the schema have besides the times and the stage
+	columns []string
+	// the only columns besides the ones read that a file of a schema without columns may have,
+	// as any other column may be the device under a name the schema doesn't know
+	others []string
+	// the spellings of the stages that sleep.ParseStage doesn't know
+	values map[string]sleep.Stage
+	// productType reads the product type of the device of a row, like Watch6,1, nil when the
+	// schema has no device
+	productType func(s *csvSource, record []string) string
+}
+
+// the stages by their HealthKit raw values, which converters of the export.xml write instead of
+// the names. 3 to 5 are the stages of iOS 16 on, earlier ones only had in bed, asleep and awake.
+var rawValues = map[string]sleep.Stage{
+	"0": sleep.InBed, "1": sleep.Asleep, "2": sleep.Awake,
+	"3": sleep.Core, "4": sleep.Deep, "5": sleep.REM,
+}
+
+// the hardware of the device description of iOS 14 and 15, like hardware:Watch5,4
+var deviceHardware = regexp.MustCompile(`hardware:([^,>]+(?:,\d+)?)`)
+
+// the schemas in the order they are tried, the newest first as later iOS versions kept the columns
+// of the earlier ones
+var schemas = []schema{
+	{
+		name:    "iOS 16 and later",
+		columns: []string{"productType"},
+		values:  rawValues,
+		productType: func(s *csvSource, record []string) string {
+			return s.field(record, "productType")
+		},
+	},
+	{
+		// before the productType column the device was only described in the device column, the
+		// stages were in bed, asleep and awake
+		name:    "iOS 14 and 15",
+		columns: []string{"device"},
+		values: map[string]sleep.Stage{
+			"0": sleep.InBed, "1": sleep.Asleep, "2": sleep.Awake,
+			"HKCategoryValueSleepAnalysisAsleep": sleep.Asleep,
+		},
+		productType: func(s *csvSource, record []string) string {
+			if m := deviceHardware.FindStringSubmatch(s.field(record, "device")); m != nil {
+				return m[1]
+			}
+			return ""
+		},
+	},
+	{
+		// files of other apps and converters with only the times and the stages, like
+		// start,end,stage, have no device to tell the watch by so all of their rows are read
+		name:   "a file without a device column",
+		others: []string{"sourceVersion", "unit", "creationDate"},
+		values: rawValues,
+	},
+}
+
+// detectSchema finds the schema of the export by the columns of the header. A header with columns
+// no schema knows is an error unless the fields name the columns to read, then it is read like a
+// file without a device column.
+func detectSchema(header []string, headerMap map[string]int, fields source.Fields) (*schema, error) {
+	for i := range schemas {
+		present := len(schemas[i].columns) > 0
+		for _, column := range schemas[i].columns {
+			if _, ok := headerMap[column]; !ok {
+				present = false
+			}
+		}
+		if present {
+			return &schemas[i], nil
+		}
+	}
+
+	last := &schemas[len(schemas)-1]
+	if fields != (source.Fields{}) {
+		return last, nil
+	}
+	var unknown []string
+	for i, name := range header {
+		name = strings.TrimSpace(name)
+		known := name == "" || slices.ContainsFunc(last.others, func(other string) bool { return strings.EqualFold(other, name) })
+		for _, index := range headerMap {
+			known = known || index == i
+		}
+		if !known {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) > 0 {
+		return nil, fmt.Errorf("unrecognized export schema, found the columns %s but no schema has %s, name the fields to read it anyway",
+			strings.Join(header, ", "), strings.Join(unknown, ", "))
+	}
+	return last, nil
+}
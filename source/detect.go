@@ -0,0 +1,71 @@
+package source
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// the bytes of the input detectors look at
+const headSize = 4096
+
+// Detector tells whether its source reads an input from the start of it. For a directory, head is
+// the start of its first JSON file by name.
+type Detector func(head []byte, dir bool) bool
+
+var detectors = make(map[string]Detector)
+
+// RegisterDetector makes Detect consider the source registered under the name
+func RegisterDetector(name string, detector Detector) {
+	mu.Lock()
+	defer mu.Unlock()
+	if _, dup := detectors[name]; dup {
+		panic("source: RegisterDetector called twice for " + name)
+	}
+	detectors[name] = detector
+}
+
+// Detect returns the name of the source that reads the named input, for reading it without being
+// told its format
+func Detect(name string) (string, error) {
+	head, dir, err := readHead(name)
+	if err != nil {
+		return "", err
+	}
+	mu.RLock()
+	defer mu.RUnlock()
+	for _, format := range names() {
+		if detector, ok := detectors[format]; ok && detector(head, dir) {
+			return format, nil
+		}
+	}
+	return "", fmt.Errorf("can't detect the format of %s, give it with -format, one of %v", name, names())
+}
+
+func readHead(name string) ([]byte, bool, error) {
+	info, err := os.Stat(name)
+	if err != nil {
+		return nil, false, err
+	}
+	file := name
+	if info.IsDir() {
+		files, err := filepath.Glob(filepath.Join(name, "*.json"))
+		if err != nil || len(files) == 0 {
+			return nil, true, err
+		}
+		file = slices.Min(files)
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, info.IsDir(), err
+	}
+	defer f.Close()
+	head := make([]byte, headSize)
+	n, err := io.ReadFull(f, head)
+	if err == io.ErrUnexpectedEOF || err == io.EOF {
+		err = nil
+	}
+	return head[:n], info.IsDir(), err
+}
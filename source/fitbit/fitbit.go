@@ -0,0 +1,250 @@
+// Package fitbit fetches the sleep logs of the Fitbit Web API into a directory with one JSON file
+// per date of sleep, and reads that directory as a source.
+package fitbit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+
+	"sleep-stats/sleep"
+	"sleep-stats/source"
+)
+
+// Endpoint is the OAuth2 endpoint of the Fitbit Web API
+var Endpoint = oauth2.Endpoint{
+	AuthURL:   "https://www.fitbit.com/oauth2/authorize",
+	TokenURL:  "https://api.fitbit.com/oauth2/token",
+	AuthStyle: oauth2.AuthStyleInHeader,
+}
+
+// Scope is the OAuth2 scope needed to read the sleep logs
+const Scope = "sleep"
+
+const (
+	logsURL    = "https://api.fitbit.com/1.2/user/-/sleep/date/%s/%s.json"
+	maxDays    = 100 // the longest range the API returns at once
+	timeLayout = "2006-01-02T15:04:05.000"
+)
+
+func init() {
+	source.Register("fitbit", func(source.Options) source.Source { return &dirSource{} })
+	source.RegisterDetector("fitbit", func(head []byte, dir bool) bool {
+		return dir && bytes.Contains(head, []byte(`"dateOfSleep"`))
+	})
+}
+
+// the response of the sleep log endpoint, which is also the format of the files
+type logs struct {
+	Sleep []json.RawMessage `json:"sleep"`
+}
+
+type sleepLog struct {
+	DateOfSleep string `json:"dateOfSleep"`
+	StartTime   string `json:"startTime"`
+	EndTime     string `json:"endTime"`
+	Levels      struct {
+		// the short wakes of stage logs are in shortData overlapping the data, they are left out
+		// like the totals of the Fitbit app do
+		Data []level `json:"data"`
+	} `json:"levels"`
+}
+
+type level struct {
+	DateTime string `json:"dateTime"`
+	Level    string `json:"level"`
+	Seconds  int    `json:"seconds"`
+}
+
+// the levels of stage logs and of classic logs from older devices. Restless in a classic log is
+// sleep with movement, which Fitbit counts as asleep. A classic log doesn't tell the stages, so it
+// is asleep without a stage rather than an awakening or core sleep.
+var stages = map[string]sleep.Stage{
+	"wake":     sleep.Awake,
+	"light":    sleep.Core,
+	"deep":     sleep.Deep,
+	"rem":      sleep.REM,
+	"awake":    sleep.Awake,
+	"restless": sleep.Asleep,
+	"asleep":   sleep.Asleep,
+}
+
+// Fetch downloads the sleep logs from start to end inclusive with a client authorized for Scope
+// and writes them to dir, replacing the files of the dates in the range. It returns the number of
+// logs written.
+func Fetch(ctx context.Context, client *http.Client, start, end time.Time, dir string) (int, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return 0, err
+	}
+	count := 0
+	for from := start; !from.After(end); from = from.AddDate(0, 0, maxDays) {
+		to := from.AddDate(0, 0, maxDays-1)
+		if to.After(end) {
+			to = end
+		}
+		byDate, err := fetchRange(ctx, client, from, to)
+		if err != nil {
+			return count, err
+		}
+		for day := from; !day.After(to); day = day.AddDate(0, 0, 1) {
+			name := filepath.Join(dir, day.Format(sleep.DateLayout)+".json")
+			dayLogs, ok := byDate[day.Format(sleep.DateLayout)]
+			if !ok {
+				// a log deleted in the app since the last fetch
+				if err := os.Remove(name); err != nil && !errors.Is(err, os.ErrNotExist) {
+					return count, err
+				}
+				continue
+			}
+			data, err := json.MarshalIndent(logs{Sleep: dayLogs}, "", "  ")
+			if err != nil {
+				return count, err
+			}
+			if err := os.WriteFile(name, data, 0o644); err != nil {
+				return count, err
+			}
+			count += len(dayLogs)
+		}
+	}
+	return count, nil
+}
+
+// the logs of the range by their date of sleep
+func fetchRange(ctx context.Context, client *http.Client, from, to time.Time) (map[string][]json.RawMessage, error) {
+	url := fmt.Sprintf(logsURL, from.Format(sleep.DateLayout), to.Format(sleep.DateLayout))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("fitbit: fetching the sleep logs: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	var page logs
+	if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
+		return nil, fmt.Errorf("fitbit: reading the sleep logs: %w", err)
+	}
+	byDate := make(map[string][]json.RawMessage)
+	for _, raw := range page.Sleep {
+		var log sleepLog
+		if err := json.Unmarshal(raw, &log); err != nil {
+			return nil, fmt.Errorf("fitbit: reading the sleep logs: %w", err)
+		}
+		byDate[log.DateOfSleep] = append(byDate[log.DateOfSleep], raw)
+	}
+	return byDate, nil
+}
+
+// dirSource reads the files written by Fetch, each log is an in bed session covering it followed
+// by its levels
+type dirSource struct {
+	sessions []sleep.Session
+	errs     []error // the error of each session, for the levels that can't be read
+}
+
+func (s *dirSource) Open(name string) error {
+	files, err := filepath.Glob(filepath.Join(name, "*.json"))
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("no Fitbit sleep logs in %s, fetch them with: sleep-stats fetch fitbit", name)
+	}
+	slices.Sort(files)
+	for _, file := range files {
+		if err := s.readFile(file); err != nil {
+			return fmt.Errorf("reading %s: %w", file, err)
+		}
+	}
+	return nil
+}
+
+func (s *dirSource) readFile(file string) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	var day logs
+	if err := json.Unmarshal(data, &day); err != nil {
+		return err
+	}
+	for _, raw := range day.Sleep {
+		var log sleepLog
+		if err := json.Unmarshal(raw, &log); err != nil {
+			return err
+		}
+		start, err := parseTime(log.StartTime)
+		if err != nil {
+			return err
+		}
+		end, err := parseTime(log.EndTime)
+		if err != nil {
+			return err
+		}
+		s.add(sleep.Session{Start: start, End: end, Stage: sleep.InBed}, nil)
+
+		for i, l := range log.Levels.Data {
+			stage, ok := stages[l.Level]
+			t, err := parseTime(l.DateTime)
+			switch {
+			case err != nil:
+				s.add(sleep.Session{}, levelError(file, i, l, "dateTime", l.DateTime, err))
+			case !ok:
+				s.add(sleep.Session{}, levelError(file, i, l, "level", l.Level, errors.New("unknown sleep level")))
+			default:
+				s.add(sleep.Session{Start: t, End: t.Add(time.Duration(l.Seconds) * time.Second), Stage: stage}, nil)
+			}
+		}
+	}
+	return nil
+}
+
+// the logs are in the local time of the user without an offset, like the other sources the
+// sessions are in UTC, assuming this computer is in the same time zone as the tracker
+func parseTime(value string) (time.Time, error) {
+	t, err := time.ParseInLocation(timeLayout, value, time.Local)
+	return t.UTC(), err
+}
+
+func (s *dirSource) add(session sleep.Session, err error) {
+	session.SourceName = "Fitbit"
+	session.ProductType = "Fitbit"
+	s.sessions = append(s.sessions, session)
+	s.errs = append(s.errs, err)
+}
+
+// the line is the position of the level in the log
+func levelError(file string, i int, l level, column, value string, err error) error {
+	text, _ := json.Marshal(l)
+	return &source.RowError{Line: i + 1, Text: filepath.Base(file) + ": " + string(text), Column: column, Value: value, Err: err}
+}
+
+func (s *dirSource) Next() (sleep.Session, error) {
+	if len(s.sessions) == 0 {
+		return sleep.Session{}, io.EOF
+	}
+	session, err := s.sessions[0], s.errs[0]
+	s.sessions, s.errs = s.sessions[1:], s.errs[1:]
+	return session, err
+}
+
+func (s *dirSource) Close() error {
+	return nil
+}
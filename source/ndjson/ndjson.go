@@ -0,0 +1,280 @@
+// Package ndjson reads sleep sessions from newline-delimited JSON, an object per session like
+// export pipelines write them, e.g.
+//
+//	{"startDate": "2024-01-01T22:04:00Z", "endDate": "2024-01-02T05:46:27Z", "value": "asleepCore"}
+//
+// The keys are looked for by their usual names unless the options name them. The times are RFC
+// 3339, like in the Apple export, or Unix seconds or milliseconds, and the stages are names read
+// by sleep.ParseStage or raw HealthKit values.
+// Unlike from the Apple export the sessions of every device are read.
+package ndjson
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+	"time"
+
+	"sleep-stats/sleep"
+	"sleep-stats/source"
+)
+
+// the longest line read, a session is far shorter
+const maxLine = 1 << 20
+
+// the layouts of the times besides Unix seconds and milliseconds
+var timeLayouts = []string{time.RFC3339Nano, "2006-01-02 15:04:05 -0700"}
+
+// field is a value of the sessions with the keys it is looked for by, the first one in a record is
+// read
+type field struct {
+	name     string
+	keys     []string
+	required bool
+}
+
+var (
+	startField   = field{"start", []string{"startDate", "start", "startTime", "start_date"}, true}
+	endField     = field{"end", []string{"endDate", "end", "endTime", "end_date"}, true}
+	stageField   = field{"stage", []string{"value", "stage", "level"}, true}
+	sourceField  = field{"source name", []string{"sourceName", "source", "source_name"}, false}
+	productField = field{"product type", []string{"productType", "product", "product_type"}, false}
+)
+
+func init() {
+	source.Register("ndjson", func(opts source.Options) source.Source {
+		// a field named by the options is only looked for by that key
+		named := func(f field, key string) field {
+			if key != "" {
+				f.keys = []string{key}
+			}
+			return f
+		}
+		return &jsonSource{
+			fields: []field{
+				named(startField, opts.Fields.Start),
+				named(endField, opts.Fields.End),
+				named(stageField, opts.Fields.Stage),
+				named(sourceField, opts.Fields.SourceName),
+				productField,
+			},
+			verbose: opts.Verbose,
+		}
+	})
+	// the first line is a JSON object, unless the head cut it off
+	source.RegisterDetector("ndjson", func(head []byte, dir bool) bool {
+		line, _, complete := bytes.Cut(bytes.TrimLeft(head, " \t\r\n"), []byte("\n"))
+		if dir || !bytes.HasPrefix(line, []byte("{")) {
+			return false
+		}
+		return !complete || json.Valid(line)
+	})
+}
+
+type jsonSource struct {
+	file    *os.File // nil when reading from a stream
+	scanner *bufio.Scanner
+	line    int
+	offset  int64 // the bytes of the lines scanned so far
+	// start, end, stage, source name and product type
+	fields  []field
+	verbose io.Writer // told the keys the fields are read from with the first record
+}
+
+func (s *jsonSource) Open(name string) error {
+	file, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	s.file = file
+	return s.OpenReader(file)
+}
+
+func (s *jsonSource) OpenReader(r io.Reader) error {
+	s.scanner = bufio.NewScanner(r)
+	s.scanner.Buffer(nil, maxLine)
+	s.scanner.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+		advance, token, err := bufio.ScanLines(data, atEOF)
+		s.offset += int64(advance)
+		return advance, token, err
+	})
+	return nil
+}
+
+func (s *jsonSource) Offset() (int64, int, bool) {
+	return s.offset, s.line, s.file != nil
+}
+
+// Resume continues reading the file with the line at the offset
+func (s *jsonSource) Resume(name string, offset int64, line int) error {
+	if err := s.Open(name); err != nil {
+		return err
+	}
+	if _, err := s.file.Seek(offset, io.SeekStart); err != nil {
+		s.Close()
+		return err
+	}
+	s.offset, s.line = offset, line
+	return nil
+}
+
+func (s *jsonSource) Next() (sleep.Session, error) {
+	for s.scanner.Scan() {
+		s.line++
+		text := bytes.TrimSpace(s.scanner.Bytes())
+		if len(text) == 0 {
+			continue
+		}
+		session, err := s.parse(text)
+		var rowErr *source.RowError
+		if errors.As(err, &rowErr) {
+			rowErr.Line, rowErr.Text = s.line, string(text)
+		}
+		return session, err
+	}
+	if err := s.scanner.Err(); err != nil {
+		return sleep.Session{}, fmt.Errorf("line %d: %w", s.line+1, err)
+	}
+	return sleep.Session{}, io.EOF
+}
+
+// parse reads the session of a line, the errors are row errors without the line
+func (s *jsonSource) parse(text []byte) (sleep.Session, error) {
+	var object map[string]any
+	decoder := json.NewDecoder(bytes.NewReader(text))
+	decoder.UseNumber()
+	if err := decoder.Decode(&object); err != nil {
+		return sleep.Session{}, &source.RowError{Err: err}
+	}
+	keys := make([]string, len(s.fields))
+	values := make([]any, len(s.fields))
+	for i, f := range s.fields {
+		var ok bool
+		if keys[i], values[i], ok = lookup(object, f.keys); !ok && f.required {
+			return sleep.Session{}, &source.RowError{Err: fmt.Errorf("no %s, expected one of the keys %s", f.name, strings.Join(f.keys, ", "))}
+		}
+	}
+	if s.verbose != nil {
+		for i, f := range s.fields {
+			if keys[i] != "" {
+				fmt.Fprintf(s.verbose, "Reading the %s from the key %q\n", f.name, keys[i])
+			}
+		}
+		s.verbose = nil
+	}
+
+	invalid := func(i int, err error) error {
+		return &source.RowError{Column: keys[i], Value: fmt.Sprint(values[i]), Err: err}
+	}
+	start, err := parseTime(values[0])
+	if err != nil {
+		return sleep.Session{}, invalid(0, err)
+	}
+	end, err := parseTime(values[1])
+	if err != nil {
+		return sleep.Session{}, invalid(1, err)
+	}
+	stage, err := parseStage(values[2])
+	if err != nil {
+		return sleep.Session{}, invalid(2, err)
+	}
+	_, offset := start.Zone()
+	return sleep.Session{
+		Start:       start,
+		End:         end,
+		Stage:       stage,
+		SourceName:  stringValue(values[3]),
+		ProductType: stringValue(values[4]),
+		Offset:      offset,
+	}, nil
+}
+
+// the stages by the raw HealthKit values, which pipelines copying the samples keep as numbers
+var rawValues = map[string]sleep.Stage{
+	"0": sleep.InBed, "1": sleep.Asleep, "2": sleep.Awake,
+	"3": sleep.Core, "4": sleep.Deep, "5": sleep.REM,
+}
+
+// parseStage reads a stage name or raw HealthKit value
+func parseStage(value any) (sleep.Stage, error) {
+	if number, ok := value.(json.Number); ok {
+		if stage, ok := rawValues[number.String()]; ok {
+			return stage, nil
+		}
+		return 0, fmt.Errorf("unknown HealthKit sleep value")
+	}
+	name, _ := value.(string)
+	return sleep.ParseStage(name)
+}
+
+// lookup finds the first of the keys in the object, matching them ignoring case when no key matches
+// exactly
+func lookup(object map[string]any, keys []string) (string, any, bool) {
+	for _, key := range keys {
+		if value, ok := object[key]; ok {
+			return key, value, true
+		}
+	}
+	for _, key := range keys {
+		for k, value := range object {
+			if strings.EqualFold(k, key) {
+				return k, value, true
+			}
+		}
+	}
+	return "", nil, false
+}
+
+// the Unix times from this on are in milliseconds, as seconds they would be after the year 5000
+const millisecondTimes = 1e11
+
+// parseTime reads a time of the layouts or Unix seconds or milliseconds, which exporters writing
+// JavaScript times use
+func parseTime(value any) (time.Time, error) {
+	switch v := value.(type) {
+	case json.Number:
+		seconds, err := v.Float64()
+		if err != nil {
+			return time.Time{}, err
+		}
+		if math.Abs(seconds) >= millisecondTimes {
+			seconds /= 1000
+		}
+		// beyond milliseconds, like microseconds, the nanoseconds overflow
+		if math.Abs(seconds) >= millisecondTimes {
+			return time.Time{}, fmt.Errorf("%s is out of the range of Unix seconds and milliseconds", v)
+		}
+		return time.Unix(0, int64(seconds*float64(time.Second))).UTC(), nil
+	case string:
+		for _, layout := range timeLayouts {
+			if t, err := time.Parse(layout, v); err == nil {
+				return t, nil
+			}
+		}
+	}
+	return time.Time{}, fmt.Errorf("expected a time like 2024-01-01T22:04:00Z or Unix seconds or milliseconds")
+}
+
+// stringValue is a string or number value as text, empty for anything else
+func stringValue(value any) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	}
+	return ""
+}
+
+func (s *jsonSource) Close() error {
+	if s.file == nil {
+		return nil
+	}
+	return s.file.Close()
+}
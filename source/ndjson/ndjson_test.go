@@ -0,0 +1,143 @@
+package ndjson
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"sleep-stats/sleep"
+	"sleep-stats/source"
+)
+
+// read returns the sessions and row errors of the input
+func read(t *testing.T, input string, opts source.Options) ([]sleep.Session, []*source.RowError) {
+	t.Helper()
+	src, err := source.New("ndjson", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := src.(source.ReaderSource).OpenReader(strings.NewReader(input)); err != nil {
+		t.Fatal(err)
+	}
+	var sessions []sleep.Session
+	var rowErrs []*source.RowError
+	for {
+		session, err := src.Next()
+		var rowErr *source.RowError
+		switch {
+		case err == io.EOF:
+			return sessions, rowErrs
+		case errors.As(err, &rowErr):
+			rowErrs = append(rowErrs, rowErr)
+		case err != nil:
+			t.Fatal(err)
+		default:
+			sessions = append(sessions, session)
+		}
+	}
+}
+
+func TestMinimalRecord(t *testing.T) {
+	sessions, rowErrs := read(t, `{"startDate": "2024-01-01T22:04:00+01:00", "endDate": "2024-01-02T05:46:27+01:00", "value": "asleepCore"}`+"\n", source.Options{})
+	if len(rowErrs) > 0 {
+		t.Fatalf("skipped %v", rowErrs)
+	}
+	if len(sessions) != 1 {
+		t.Fatalf("read %d sessions, want 1", len(sessions))
+	}
+	s := sessions[0]
+	if s.Stage != sleep.Core || s.ProductType != "" || s.Offset != 3600 {
+		t.Errorf("read %+v, want a core session without product type at +01:00", s)
+	}
+	if want := time.Date(2024, 1, 1, 21, 4, 0, 0, time.UTC); !s.Start.Equal(want) {
+		t.Errorf("start %v, want %v", s.Start, want)
+	}
+}
+
+func TestFieldMapping(t *testing.T) {
+	input := `{"from": 1704146640, "to": 1704174387, "kind": "deep", "startDate": "ignored"}` + "\n"
+	opts := source.Options{Fields: source.Fields{Start: "from", End: "to", Stage: "kind"}}
+	sessions, rowErrs := read(t, input, opts)
+	if len(rowErrs) > 0 || len(sessions) != 1 {
+		t.Fatalf("read %v, skipped %v", sessions, rowErrs)
+	}
+	if s := sessions[0]; s.Stage != sleep.Deep || s.Duration() != 7*time.Hour+42*time.Minute+27*time.Second {
+		t.Errorf("read %+v, want 7h42m27s of deep sleep", s)
+	}
+}
+
+func TestUnixTimes(t *testing.T) {
+	start := time.Date(2024, 1, 1, 22, 4, 0, 0, time.UTC)
+	tests := []struct {
+		start string
+		want  time.Time // zero for an error
+	}{
+		{"1704146640", start},
+		{"1704146640.5", start.Add(500 * time.Millisecond)},
+		{"1704146640000", start},
+		{"1704146640250", start.Add(250 * time.Millisecond)},
+		{"1704146640000000", time.Time{}},
+	}
+	for _, test := range tests {
+		input := `{"startDate": ` + test.start + `, "endDate": "2024-01-02T05:46:27Z", "value": "core"}` + "\n"
+		sessions, rowErrs := read(t, input, source.Options{})
+		switch {
+		case test.want.IsZero() && len(rowErrs) != 1:
+			t.Errorf("%s: read %v, want it skipped", test.start, sessions)
+		case test.want.IsZero():
+			if rowErrs[0].Column != "startDate" {
+				t.Errorf("%s: the error names the column %q, want startDate", test.start, rowErrs[0].Column)
+			}
+		case len(sessions) != 1:
+			t.Errorf("%s: skipped %v", test.start, rowErrs)
+		case sessions[0].Start.Sub(test.want).Abs() > time.Microsecond:
+			t.Errorf("%s: start %v, want %v", test.start, sessions[0].Start, test.want)
+		}
+	}
+}
+
+func TestRowErrors(t *testing.T) {
+	input := strings.Join([]string{
+		`{"startDate": "2024-01-02T02:00:00Z", "endDate": "2024-01-02T03:00:00Z", "value": "nap"}`,
+		``,
+		`{"startDate": "2024-01-02T03:00:00Z", "value": "rem"}`,
+		`not json`,
+		`{"startDate": "2024-01-02T03:00:00Z", "endDate": "2024-01-02T05:00:00Z", "value": 5}`,
+	}, "\n")
+	sessions, rowErrs := read(t, input, source.Options{})
+	if len(sessions) != 1 || sessions[0].Stage != sleep.REM {
+		t.Errorf("read %v, want the REM session", sessions)
+	}
+	var lines []int
+	for _, e := range rowErrs {
+		lines = append(lines, e.Line)
+	}
+	if len(lines) != 3 || lines[0] != 1 || lines[1] != 3 || lines[2] != 4 {
+		t.Errorf("skipped the lines %v, want 1, 3 and 4", lines)
+	}
+	if rowErrs[0].Column != "value" {
+		t.Errorf("the stage error names the column %q, want value", rowErrs[0].Column)
+	}
+}
+
+func TestDetect(t *testing.T) {
+	for head, want := range map[string]bool{
+		"{\"startDate\": \"2024-01-01T22:04:00Z\"}\n{": true,
+		"\n  {\"startDate\": \"2024-01-01T22:04:0":     true,
+		"{\"broken\n":               false,
+		"[{\"startDate\": 1}]\n":    false,
+		"startDate,endDate,value\n": false,
+	} {
+		name := filepath.Join(t.TempDir(), "sessions")
+		if err := os.WriteFile(name, []byte(head), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if format, err := source.Detect(name); (err == nil && format == "ndjson") != want {
+			t.Errorf("detected %q as %q, %v", head, format, err)
+		}
+	}
+}
@@ -0,0 +1,214 @@
+// Package oura fetches the sleep documents of the Oura API v2 into a directory with one JSON file
+// per day, and reads that directory as a source.
+package oura
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"sleep-stats/sleep"
+	"sleep-stats/source"
+)
+
+const (
+	sleepURL    = "https://api.ouraring.com/v2/usercollection/sleep"
+	phaseLength = 5 * time.Minute
+)
+
+func init() {
+	source.Register("oura", func(source.Options) source.Source { return &dirSource{} })
+	source.RegisterDetector("oura", func(head []byte, dir bool) bool {
+		return dir && bytes.Contains(head, []byte(`"bedtime_start"`))
+	})
+}
+
+// a page of the sleep endpoint, the files have the same format without the next token
+type page struct {
+	Data      []json.RawMessage `json:"data"`
+	NextToken string            `json:"next_token,omitempty"`
+}
+
+// a sleep period, a night can have several like naps or a bedtime interrupted by getting up
+type document struct {
+	Day          string    `json:"day"`
+	BedtimeStart time.Time `json:"bedtime_start"`
+	BedtimeEnd   time.Time `json:"bedtime_end"`
+	// one digit per 5 minutes since the bedtime start
+	Phases string `json:"sleep_phase_5_min"`
+}
+
+var phases = map[rune]sleep.Stage{
+	'1': sleep.Deep,
+	'2': sleep.Core,
+	'3': sleep.REM,
+	'4': sleep.Awake,
+}
+
+// Fetch downloads the sleep documents of the days from start to end inclusive with a client that
+// authorizes the requests, and writes them to dir, replacing the files of the days in the range.
+// It returns the number of documents written.
+func Fetch(ctx context.Context, client *http.Client, start, end time.Time, dir string) (int, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return 0, err
+	}
+
+	byDay := make(map[string][]json.RawMessage)
+	query := url.Values{
+		"start_date": {start.Format(sleep.DateLayout)},
+		// the end date is exclusive
+		"end_date": {end.AddDate(0, 0, 1).Format(sleep.DateLayout)},
+	}
+	for {
+		p, err := fetchPage(ctx, client, sleepURL+"?"+query.Encode())
+		if err != nil {
+			return 0, err
+		}
+		for _, raw := range p.Data {
+			var doc document
+			if err := json.Unmarshal(raw, &doc); err != nil {
+				return 0, fmt.Errorf("oura: reading the sleep documents: %w", err)
+			}
+			byDay[doc.Day] = append(byDay[doc.Day], raw)
+		}
+		if p.NextToken == "" {
+			break
+		}
+		query.Set("next_token", p.NextToken)
+	}
+
+	count := 0
+	for day := start; !day.After(end); day = day.AddDate(0, 0, 1) {
+		name := filepath.Join(dir, day.Format(sleep.DateLayout)+".json")
+		docs, ok := byDay[day.Format(sleep.DateLayout)]
+		if !ok {
+			if err := os.Remove(name); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return count, err
+			}
+			continue
+		}
+		data, err := json.MarshalIndent(page{Data: docs}, "", "  ")
+		if err != nil {
+			return count, err
+		}
+		if err := os.WriteFile(name, data, 0o644); err != nil {
+			return count, err
+		}
+		count += len(docs)
+	}
+	return count, nil
+}
+
+func fetchPage(ctx context.Context, client *http.Client, url string) (*page, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("oura: fetching the sleep documents: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	p := &page{}
+	if err := json.NewDecoder(resp.Body).Decode(p); err != nil {
+		return nil, fmt.Errorf("oura: reading the sleep documents: %w", err)
+	}
+	return p, nil
+}
+
+// dirSource reads the files written by Fetch, each document is an in bed session covering it
+// followed by a session for each run of the same phase
+type dirSource struct {
+	sessions []sleep.Session
+	errs     []error // the error of each session, for the phases that can't be read
+}
+
+func (s *dirSource) Open(name string) error {
+	files, err := filepath.Glob(filepath.Join(name, "*.json"))
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("no Oura sleep documents in %s, fetch them with: sleep-stats fetch oura", name)
+	}
+	slices.Sort(files)
+	for _, file := range files {
+		if err := s.readFile(file); err != nil {
+			return fmt.Errorf("reading %s: %w", file, err)
+		}
+	}
+	return nil
+}
+
+func (s *dirSource) readFile(file string) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	var day page
+	if err := json.Unmarshal(data, &day); err != nil {
+		return err
+	}
+	for _, raw := range day.Data {
+		var doc document
+		if err := json.Unmarshal(raw, &doc); err != nil {
+			return err
+		}
+		_, offset := doc.BedtimeStart.Zone()
+		s.add(sleep.Session{Start: doc.BedtimeStart.UTC(), End: doc.BedtimeEnd.UTC(), Stage: sleep.InBed, Offset: offset}, nil)
+
+		phase := []rune(doc.Phases)
+		for i := 0; i < len(phase); {
+			j := i + 1
+			for j < len(phase) && phase[j] == phase[i] {
+				j++
+			}
+			start := doc.BedtimeStart.Add(time.Duration(i) * phaseLength).UTC()
+			if stage, ok := phases[phase[i]]; ok {
+				s.add(sleep.Session{Start: start, End: start.Add(time.Duration(j-i) * phaseLength), Stage: stage, Offset: offset}, nil)
+			} else {
+				// the line is the position of the phase
+				s.add(sleep.Session{}, &source.RowError{
+					Line: i + 1, Text: filepath.Base(file) + ": " + doc.Phases, Column: "sleep_phase_5_min", Value: string(phase[i]),
+					Err: errors.New("unknown sleep phase"),
+				})
+			}
+			i = j
+		}
+	}
+	return nil
+}
+
+func (s *dirSource) add(session sleep.Session, err error) {
+	session.SourceName = "Oura"
+	session.ProductType = "Oura Ring"
+	s.sessions = append(s.sessions, session)
+	s.errs = append(s.errs, err)
+}
+
+func (s *dirSource) Next() (sleep.Session, error) {
+	if len(s.sessions) == 0 {
+		return sleep.Session{}, io.EOF
+	}
+	session, err := s.sessions[0], s.errs[0]
+	s.sessions, s.errs = s.sessions[1:], s.errs[1:]
+	return session, err
+}
+
+func (s *dirSource) Close() error {
+	return nil
+}
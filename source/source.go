@@ -0,0 +1,139 @@
+// Package source defines the interface device importers implement to feed sleep data into the
+// stats, and a registry of the importers by name.
+//
+// An importer registers itself from an init function, so adding one is a matter of importing its
+// package for the side effect:
+//
+//	import _ "sleep-stats/source/apple"
+package source
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+	"time"
+
+	"golang.org/x/exp/maps"
+
+	"sleep-stats/sleep"
+)
+
+// Source reads sleep sessions from an input
+type Source interface {
+	// Open prepares reading the sessions from the named input, usually a file
+	Open(name string) error
+	// Next returns the next session, or io.EOF once they are exhausted. Rows that can't be parsed
+	// are returned as a *RowError.
+	Next() (sleep.Session, error)
+	Close() error
+}
+
+// ReaderSource is implemented by the sources that can also read from a stream instead of a named
+// input, like an upload
+type ReaderSource interface {
+	Source
+	// OpenReader prepares reading the sessions from r, closing the source doesn't close r
+	OpenReader(r io.Reader) error
+}
+
+// Resumer is implemented by the sources that can continue reading a file where an earlier read
+// stopped, so an interrupted read of a large file doesn't start over
+type Resumer interface {
+	Source
+	// Offset returns the byte offset in the file after the last row Next read and the line of that
+	// row, false when the input can't be resumed like a file decoded while it is read
+	Offset() (offset int64, line int, ok bool)
+	// Resume opens the named file like Open to continue reading at an offset Offset returned
+	Resume(name string, offset int64, line int) error
+}
+
+// RowError is returned by Next for a row that can't be parsed, the source can still be read past it
+type RowError struct {
+	Line   int    // line number in the input, starting at 1
+	Text   string // the row as it was read
+	Column string // name of the column with the invalid value, empty when the whole row is invalid
+	Value  string // the invalid value
+	Err    error
+}
+
+// e.g. row 10423: startDate '2023-13-01 23:10:00 +0000' invalid: month out of range
+func (e *RowError) Error() string {
+	if e.Column == "" {
+		return fmt.Sprintf("row %d: %v", e.Line, e.Err)
+	}
+	return fmt.Sprintf("row %d: %s '%s' invalid: %v", e.Line, e.Column, e.Value, e.Err)
+}
+
+func (e *RowError) Unwrap() error {
+	return e.Err
+}
+
+// Options configure how a source reads its input, sources ignore the options that don't apply
+type Options struct {
+	// Delimiter separates the fields of delimited text, 0 to detect it from the input
+	Delimiter rune
+	// Since allows skipping the sessions that start before it without fully parsing them, the
+	// caller still has to filter as a source doesn't need to skip anything
+	Since time.Time
+	// Verbose receives diagnostics like how the columns of the input were read, nil to leave
+	// them out
+	Verbose io.Writer
+	// Fields names the columns or keys the sessions are read from instead of the usual names of
+	// the format, for the formats without fixed names like ndjson and the CSV export
+	Fields Fields
+	// MemoryMap reads a file memory-mapped instead of with reads, for the formats that support
+	// it like apple
+	MemoryMap bool
+}
+
+// Fields are the names of the columns or keys of the values of a session, empty names look for the
+// usual names of the format
+type Fields struct {
+	Start      string `json:"start"`
+	End        string `json:"end"`
+	Stage      string `json:"stage"`
+	SourceName string `json:"sourceName"`
+}
+
+// Factory creates a new unopened Source
+type Factory func(opts Options) Source
+
+var (
+	mu        sync.RWMutex
+	factories = make(map[string]Factory)
+)
+
+// Register makes a source available by name, it panics if the name is already taken
+func Register(name string, factory Factory) {
+	mu.Lock()
+	defer mu.Unlock()
+	if _, dup := factories[name]; dup {
+		panic("source: Register called twice for " + name)
+	}
+	factories[name] = factory
+}
+
+// New creates the source registered under the name
+func New(name string, opts Options) (Source, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	factory, ok := factories[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown source format %q, known formats are %v", name, names())
+	}
+	return factory(opts), nil
+}
+
+// Names returns the sorted names of the registered sources
+func Names() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	return names()
+}
+
+func names() []string {
+	list := maps.Keys(factories)
+	slices.Sort(list)
+	return list
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"time"
+
+	"sleep-stats/sleep"
+)
+
+// sourceSummary is what a device or app recorded
+type sourceSummary struct {
+	name, productType string
+	sessions          int
+	hours             float64
+	first, last       time.Time
+	days              map[string]bool // the dates the sessions started on
+}
+
+// sourcesCommand lists the devices and apps the sessions were recorded by, e.g.
+// sleep-stats sources -file export.csv
+func sourcesCommand(fs *flag.FlagSet) func(ctx context.Context) error {
+	input := addInputFlags(fs)
+	return func(ctx context.Context) error {
+		sessions, skipped, err := input.load(ctx)
+		if err != nil {
+			return err
+		}
+		if len(sessions) == 0 {
+			return &exitError{errors.New("no sleep data found"), exitNoNights}
+		}
+		writeSources(os.Stdout, summarizeSources(sessions))
+		printSkipped(input.diagnostics(), skipped)
+		return skippedRows(skipped)
+	}
+}
+
+// summarizeSources groups the sessions by their source name and product type, the ones with the
+// most sessions first and then by name
+func summarizeSources(sessions []sleep.Session) []*sourceSummary {
+	type key struct{ name, productType string }
+	byKey := make(map[key]*sourceSummary)
+	var summaries []*sourceSummary
+	for _, session := range sessions {
+		k := key{session.SourceName, session.ProductType}
+		s, ok := byKey[k]
+		if !ok {
+			s = &sourceSummary{name: k.name, productType: k.productType, first: session.Start, last: session.End, days: make(map[string]bool)}
+			byKey[k] = s
+			summaries = append(summaries, s)
+		}
+		s.sessions++
+		s.hours += session.Duration().Hours()
+		if session.Start.Before(s.first) {
+			s.first = session.Start
+		}
+		if session.End.After(s.last) {
+			s.last = session.End
+		}
+		s.days[session.Start.Format(sleep.DateLayout)] = true
+	}
+	slices.SortFunc(summaries, func(a, b *sourceSummary) int {
+		return cmp.Or(cmp.Compare(b.sessions, a.sessions), cmp.Compare(a.name, b.name), cmp.Compare(a.productType, b.productType))
+	})
+	return summaries
+}
+
+func writeSources(w io.Writer, summaries []*sourceSummary) {
+	fmt.Fprintf(w, "%-30s %-20s %8s %8s %6s  %-10s  %s\n", "Source", "Product type", "Sessions", "Hours", "Days", "First", "Last")
+	for _, s := range summaries {
+		name, productType := s.name, s.productType
+		if name == "" {
+			name = "(none)"
+		}
+		if productType == "" {
+			productType = "(none)"
+		}
+		fmt.Fprintf(w, "%-30s %-20s %8d %8.1f %6d  %-10s  %s\n", name, productType, s.sessions, s.hours, len(s.days),
+			s.first.Format(sleep.DateLayout), s.last.Format(sleep.DateLayout))
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"sleep-stats/sleep"
+)
+
+var sparkBars = []rune("▁▂▃▄▅▆▇█")
+
+// sparkCommand prints a one line sparkline of the total sleep for the last nights followed by the
+// numbers for the last night, short enough for a tmux status line or shell prompt
+func sparkCommand(fs *flag.FlagSet) func(ctx context.Context) error {
+	input := addInputFlags(fs)
+	nights := fs.Int("n", 14, "number of nights to include in the sparkline")
+	return func(ctx context.Context) error { return runSpark(ctx, input, *nights) }
+}
+
+func runSpark(ctx context.Context, input inputFlags, n int) error {
+	data, err := input.analyze(ctx)
+	if err != nil {
+		return err
+	}
+	nights := data.nights
+	if len(nights) == 0 {
+		return &exitError{errors.New("no sleep data found"), exitNoNights}
+	}
+	if len(nights) > n {
+		nights = nights[len(nights)-n:]
+	}
+
+	totals := make([]time.Duration, len(nights))
+	for i, night := range nights {
+		totals[i] = night.TotalAsleep()
+	}
+
+	last := nights[len(nights)-1]
+	fmt.Printf("%s %s (D %s R %s)\n", sparkline(totals), formatDuration(totals[len(totals)-1]),
+		formatDuration(last.Time(sleep.Deep)), formatDuration(last.Time(sleep.REM)))
+	data.printExcluded(input.diagnostics())
+	return skippedRows(data.skipped)
+}
+
+// scale the values between the smallest and largest into the block characters
+func sparkline(values []time.Duration) string {
+	low, high := slices.Min(values), slices.Max(values)
+	var sb strings.Builder
+	for _, v := range values {
+		i := len(sparkBars) - 1
+		if high > low {
+			i = int(float64(v-low) / float64(high-low) * float64(len(sparkBars)-1))
+		}
+		sb.WriteRune(sparkBars[i])
+	}
+	return sb.String()
+}
+
+// compact hours and minutes, e.g. 7h05m
+func formatDuration(d time.Duration) string {
+	d = d.Round(time.Minute)
+	return fmt.Sprintf("%dh%02dm", int(d.Hours()), int(d.Minutes())%60)
+}
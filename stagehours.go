@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image/color"
+	"io"
+	"slices"
+	"strings"
+	"time"
+
+	"gonum.org/v1/plot"
+	"gonum.org/v1/plot/plotter"
+	"gonum.org/v1/plot/vg"
+
+	"sleep-stats/sleep"
+)
+
+const (
+	// the minutes of a resampled night without a session
+	noStage sleep.Stage = -1
+	// sessions further apart than this are separate episodes of sleep, like a nap and the night
+	episodeGap = 2 * time.Hour
+)
+
+// the stages stacked in the charts of stage occupancy, from the bottom up
+var stackedStages = []sleep.Stage{sleep.Deep, sleep.Core, sleep.REM, sleep.Asleep, sleep.Awake, sleep.InBed}
+
+// the color of being in bed without a stage in the stacked charts, the others use stageColors
+var inBedColor = color.RGBA{R: 190, G: 190, B: 190, A: 255}
+
+// mainEpisode returns the sessions of the episode with the most sleep, the sessions of a night are
+// split into episodes where nothing was recorded for episodeGap
+func mainEpisode(night *sleep.Night) []sleep.Session {
+	var main []sleep.Session
+	var mainAsleep time.Duration
+	begin := 0
+	var end time.Time
+	for i, session := range night.Sessions {
+		if i > 0 && session.Start.Sub(end) > episodeGap {
+			begin = i
+		}
+		if i == begin || session.End.After(end) {
+			end = session.End
+		}
+		episode := &sleep.Night{Sessions: night.Sessions[begin : i+1]}
+		if asleep := episode.TotalAsleep(); asleep > mainAsleep || main == nil {
+			main, mainAsleep = episode.Sessions, asleep
+		}
+	}
+	return main
+}
+
+// minuteStages resamples the sessions to the stage of each minute from the minute of the first,
+// the sessions asleep or awake taking precedence over being in bed
+func minuteStages(sessions []sleep.Session) (time.Time, []sleep.Stage) {
+	if len(sessions) == 0 {
+		return time.Time{}, nil
+	}
+	start := sessions[0].Start.Truncate(time.Minute)
+	var end time.Time
+	for _, session := range sessions {
+		if session.End.After(end) {
+			end = session.End
+		}
+	}
+	minutes := make([]sleep.Stage, int(end.Sub(start)/time.Minute)+1)
+	for i := range minutes {
+		minutes[i] = noStage
+	}
+	for _, inBed := range []bool{true, false} {
+		for _, session := range sessions {
+			if (session.Stage == sleep.InBed) != inBed {
+				continue
+			}
+			from, to := int(session.Start.Sub(start)/time.Minute), int(session.End.Sub(start)/time.Minute)
+			for i := from; i < to; i++ {
+				minutes[i] = session.Stage
+			}
+		}
+	}
+	return start, minutes
+}
+
+// stackedAreas adds an area per stage of stackedStages to the plot, each stacked on the ones below
+// it, with the shares of the stage at the xs
+func stackedAreas(p *plot.Plot, xs []float64, shares map[sleep.Stage][]float64) error {
+	bottom := make([]float64, len(xs))
+	for _, stage := range stackedStages {
+		values, ok := shares[stage]
+		if !ok || slices.Max(values) == 0 {
+			continue
+		}
+		area := make(plotter.XYs, 0, 2*len(xs))
+		for i, x := range xs {
+			area = append(area, plotter.XY{X: x, Y: bottom[i] + values[i]})
+		}
+		for i := len(xs) - 1; i >= 0; i-- {
+			area = append(area, plotter.XY{X: xs[i], Y: bottom[i]})
+			bottom[i] += values[i]
+		}
+		polygon, err := plotter.NewPolygon(area)
+		if err != nil {
+			return err
+		}
+		c, ok := stageColors[stage]
+		if !ok {
+			c = inBedColor
+		}
+		polygon.Color = c
+		polygon.LineStyle.Width = 0
+		p.Add(polygon)
+		p.Legend.Add(stage.String(), polygon)
+	}
+	return nil
+}
+
+// analyzeHours prints which hours after going to bed each stage falls in over the main episodes of
+// sleep of the nights and charts the share of the nights in each stage by the minute since going
+// to bed as stacked areas
+func analyzeHours(ctx context.Context, w io.Writer, data *nightData, opts analyzeOptions) error {
+	// the nights in each stage by the minute since the first session of their main episode
+	counts := make(map[sleep.Stage][]float64)
+	length := 0
+	for _, night := range data.nights {
+		_, minutes := minuteStages(mainEpisode(night))
+		length = max(length, len(minutes))
+		for i, stage := range minutes {
+			if stage == noStage {
+				continue
+			}
+			if len(counts[stage]) < len(minutes) {
+				counts[stage] = append(counts[stage], make([]float64, len(minutes)-len(counts[stage]))...)
+			}
+			counts[stage][i]++
+		}
+	}
+	if length == 0 {
+		return errors.New("no sessions to analyze")
+	}
+
+	// the minutes of each stage in each hour
+	hours := (length + 59) / 60
+	byHour := make(map[sleep.Stage][]float64, len(counts))
+	for stage, perMinute := range counts {
+		byHour[stage] = make([]float64, hours)
+		for i, n := range perMinute {
+			byHour[stage][i/60] += n
+		}
+	}
+	shown := []sleep.Stage{sleep.Deep, sleep.Core, sleep.REM, sleep.Asleep, sleep.Awake}
+	shown = slices.DeleteFunc(shown, func(stage sleep.Stage) bool { return len(byHour[stage]) == 0 })
+
+	fmt.Fprintf(w, "Share of the time in each stage falling in each hour after going to bed, over %d nights:\n", len(data.nights))
+	fmt.Fprintf(w, "%-5s", "Hour")
+	for _, stage := range shown {
+		fmt.Fprintf(w, " %11s", stage)
+	}
+	fmt.Fprintln(w)
+	totals := make(map[sleep.Stage]float64, len(shown))
+	for _, stage := range shown {
+		for _, n := range byHour[stage] {
+			totals[stage] += n
+		}
+	}
+	for hour := range hours {
+		fmt.Fprintf(w, "%-5d", hour+1)
+		for _, stage := range shown {
+			fmt.Fprintf(w, " %10.0f%%", 100*byHour[stage][hour]/totals[stage])
+		}
+		fmt.Fprintln(w)
+	}
+	fmt.Fprintln(w)
+	for _, stage := range []sleep.Stage{sleep.Deep, sleep.REM} {
+		if slices.Contains(shown, stage) {
+			first, last := concentration(byHour[stage], totals[stage])
+			fmt.Fprintf(w, "Half of the %s sleep is in hours %d-%d.\n", strings.TrimPrefix(stage.String(), "asleep"), first+1, last+1)
+		}
+	}
+
+	if opts.chart == "" {
+		return nil
+	}
+	xs := make([]float64, length)
+	shares := make(map[sleep.Stage][]float64, len(counts))
+	for i := range xs {
+		xs[i] = float64(i) / 60
+	}
+	for stage, perMinute := range counts {
+		shares[stage] = make([]float64, length)
+		for i, n := range perMinute {
+			shares[stage][i] = n / float64(len(data.nights))
+		}
+	}
+	p := plot.New()
+	p.Title.Text = "Stages by Hour of the Night"
+	p.X.Label.Text = "Hours after going to bed"
+	p.Y.Label.Text = "Share of the nights"
+	p.Legend.Top = true
+	if err := stackedAreas(p, xs, shares); err != nil {
+		return err
+	}
+	p.X.Min, p.Y.Min, p.Y.Max = 0, 0, 1
+	return writeChart(ctx, p, 10*vg.Inch, 5*vg.Inch, opts.chart)
+}
+
+// concentration returns the shortest run of hours holding at least half of the total
+func concentration(hours []float64, total float64) (first, last int) {
+	first, last = 0, len(hours)-1
+	for i := range hours {
+		sum := 0.0
+		for j := i; j < len(hours); j++ {
+			sum += hours[j]
+			if sum >= total/2 {
+				if j-i < last-first {
+					first, last = i, j
+				}
+				break
+			}
+		}
+	}
+	return first, last
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+
+	"golang.org/x/exp/maps"
+
+	"sleep-stats/sleep"
+)
+
+// the stages with a target share, by their name in the config
+var targetStages = map[string]sleep.Stage{"core": sleep.Core, "deep": sleep.Deep, "rem": sleep.REM}
+
+// stageTarget is the range of the share of the time asleep in percent a stage should have
+type stageTarget struct {
+	stage  sleep.Stage
+	bounds [2]float64
+}
+
+// stageLabel names the stage in the warnings, e.g. Deep sleep
+func (t stageTarget) stageLabel() string {
+	if t.stage == sleep.REM {
+		return "REM sleep"
+	}
+	return strings.TrimPrefix(t.stage.String(), "asleep") + " sleep"
+}
+
+// parseStageTargets reads the stage targets of the config, in the order of the stages
+func parseStageTargets(config map[string][2]float64) ([]stageTarget, error) {
+	var targets []stageTarget
+	names := maps.Keys(config)
+	slices.Sort(names)
+	for _, name := range names {
+		bounds := config[name]
+		stage, ok := targetStages[strings.ToLower(name)]
+		if !ok {
+			return nil, fmt.Errorf("invalid stage target of %q, the stages with targets are core, deep and rem", name)
+		}
+		if bounds[0] < 0 || bounds[1] > 100 || bounds[0] > bounds[1] {
+			return nil, fmt.Errorf("invalid stage target of %s %g-%g, expected percentages from low to high", name, bounds[0], bounds[1])
+		}
+		targets = append(targets, stageTarget{stage, bounds})
+	}
+	slices.SortFunc(targets, func(a, b stageTarget) int { return int(a.stage - b.stage) })
+	return targets, nil
+}
+
+// stageWarnings compares the share of each stage with a target averaged over each week, from the
+// first day of the week. Only the nights recorded with stages count, weeks without any are left
+// out.
+func stageWarnings(nights []*sleep.Night, targets []stageTarget, weekStart time.Weekday) []string {
+	if len(targets) == 0 {
+		return nil
+	}
+	type week struct {
+		key    string
+		asleep time.Duration
+		stages map[sleep.Stage]time.Duration
+	}
+	var weeks []*week
+	for _, night := range nights {
+		if night.Time(sleep.Core)+night.Time(sleep.Deep)+night.Time(sleep.REM) == 0 {
+			continue
+		}
+		key := weekKey(night.Date, weekStart)
+		if len(weeks) == 0 || weeks[len(weeks)-1].key != key {
+			weeks = append(weeks, &week{key: key, stages: make(map[sleep.Stage]time.Duration)})
+		}
+		w := weeks[len(weeks)-1]
+		w.asleep += night.TotalAsleep()
+		for _, t := range targets {
+			w.stages[t.stage] += night.Time(t.stage)
+		}
+	}
+
+	var warnings []string
+	for _, w := range weeks {
+		for _, t := range targets {
+			share := 100 * w.stages[t.stage].Hours() / w.asleep.Hours()
+			if share < t.bounds[0] || share > t.bounds[1] {
+				warnings = append(warnings, fmt.Sprintf("%s: %s averaged %.1f%% of total (target %g–%g%%)",
+					w.key, t.stageLabel(), share, t.bounds[0], t.bounds[1]))
+			}
+		}
+	}
+	return warnings
+}
+
+// writeStageWarnings prints the weeks missing the stage targets of the config
+func writeStageWarnings(w io.Writer, warnings []string) {
+	if len(warnings) == 0 {
+		fmt.Fprintln(w, "\nEvery week met the stage targets.")
+		return
+	}
+	fmt.Fprintln(w, "\nStage targets missed:")
+	for _, warning := range warnings {
+		fmt.Fprintln(w, "  "+warning)
+	}
+}
@@ -0,0 +1,237 @@
+// Package store keeps normalized sessions from any source in an embedded bbolt database, which
+// importers write into and the analysis reads as the store source.
+//
+// The sessions are keyed by their interval, stage and source name, so a session imported again,
+// like from overlapping exports, is rejected as a duplicate instead of counted twice.
+package store
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"sleep-stats/sleep"
+	"sleep-stats/source"
+)
+
+var (
+	sessionsBucket = []byte("sessions")
+	importsBucket  = []byte("imports")
+)
+
+// the magic number of bbolt databases
+const boltMagic = 0xED0CDAED
+
+// how long to wait for another process writing to the store
+const lockTimeout = 10 * time.Second
+
+func init() {
+	source.Register("store", func(source.Options) source.Source { return &storeSource{} })
+	// a bbolt database starts with a meta page, which has the magic number after the page header
+	source.RegisterDetector("store", func(head []byte, dir bool) bool {
+		return !dir && len(head) >= 20 && binary.LittleEndian.Uint32(head[16:20]) == boltMagic
+	})
+}
+
+// DefaultPath is where the store is kept unless another file is given, next to the config
+func DefaultPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "sessions.db"
+	}
+	return filepath.Join(dir, "sleep-stats", "sessions.db")
+}
+
+func open(path string, readOnly bool) (*bolt.DB, error) {
+	if !readOnly {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return nil, err
+		}
+	}
+	db, err := bolt.Open(path, 0o644, &bolt.Options{Timeout: lockTimeout, ReadOnly: readOnly})
+	if err != nil {
+		return nil, fmt.Errorf("opening the store %s: %w", path, err)
+	}
+	return db, nil
+}
+
+// the start and end in nanoseconds so the keys sort by time, then the stage and source name
+func key(s sleep.Session) []byte {
+	k := make([]byte, 0, 17+len(s.SourceName))
+	k = binary.BigEndian.AppendUint64(k, uint64(s.Start.UnixNano()))
+	k = binary.BigEndian.AppendUint64(k, uint64(s.End.UnixNano()))
+	k = append(k, byte(s.Stage))
+	return append(k, s.SourceName...)
+}
+
+// the values start with this byte and the UTC offset of the session before the product type, the
+// stores written before the offset was kept only have the product type, which never starts with it
+const offsetMarker = 0
+
+// the UTC offset in seconds and the product type of the session
+func value(s sleep.Session) []byte {
+	v := make([]byte, 0, 5+len(s.ProductType))
+	v = append(v, offsetMarker)
+	v = binary.BigEndian.AppendUint32(v, uint32(int32(s.Offset)))
+	return append(v, s.ProductType...)
+}
+
+// the session of a key and its value, in the UTC offset it was recorded with
+func session(k, v []byte) (sleep.Session, error) {
+	if len(k) < 17 {
+		return sleep.Session{}, fmt.Errorf("invalid key %x", k)
+	}
+	var offset int
+	if len(v) >= 5 && v[0] == offsetMarker {
+		offset, v = int(int32(binary.BigEndian.Uint32(v[1:5]))), v[5:]
+	}
+	zone := time.UTC
+	if offset != 0 {
+		zone = time.FixedZone("", offset)
+	}
+	return sleep.Session{
+		Start:       time.Unix(0, int64(binary.BigEndian.Uint64(k))).In(zone),
+		End:         time.Unix(0, int64(binary.BigEndian.Uint64(k[8:]))).In(zone),
+		Stage:       sleep.Stage(k[16]),
+		SourceName:  string(k[17:]),
+		ProductType: string(v),
+		Offset:      offset,
+	}, nil
+}
+
+// Result counts what an import did
+type Result struct {
+	Added int
+	// the sessions with the interval, stage and source of one already in the store, from an
+	// earlier import of an overlapping export or repeated within the import
+	Duplicates int
+}
+
+// Import writes the sessions that aren't in the store at path yet into it, creating it if needed
+func Import(path string, sessions []sleep.Session) (Result, error) {
+	var result Result
+	db, err := open(path, false)
+	if err != nil {
+		return result, err
+	}
+	defer db.Close()
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(sessionsBucket)
+		if err != nil {
+			return err
+		}
+		for _, s := range sessions {
+			k := key(s)
+			if b.Get(k) != nil {
+				result.Duplicates++
+				continue
+			}
+			if err := b.Put(k, value(s)); err != nil {
+				return err
+			}
+			result.Added++
+		}
+		return nil
+	})
+	if err != nil {
+		return Result{}, err
+	}
+	return result, nil
+}
+
+// LastImported returns the start of the latest session imported from the input into the store at
+// path, zero if nothing was imported from it yet. Importing a newer export of the same input only
+// needs to read the sessions from then on.
+func LastImported(path, input string) (time.Time, error) {
+	var t time.Time
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return t, nil
+	}
+	db, err := open(path, true)
+	if err != nil {
+		return t, err
+	}
+	defer db.Close()
+	err = db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(importsBucket)
+		if b == nil {
+			return nil
+		}
+		if v := b.Get([]byte(input)); v != nil {
+			return t.UnmarshalText(v)
+		}
+		return nil
+	})
+	return t, err
+}
+
+// SetLastImported records the start of the latest session imported from the input
+func SetLastImported(path, input string, t time.Time) error {
+	db, err := open(path, false)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(importsBucket)
+		if err != nil {
+			return err
+		}
+		v, err := t.MarshalText()
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(input), v)
+	})
+}
+
+// storeSource reads the sessions of a store in the order they started, a store that doesn't exist
+// yet is empty. The sessions are read at once so the store isn't kept locked.
+type storeSource struct {
+	sessions []sleep.Session
+}
+
+func (s *storeSource) Open(name string) error {
+	if _, err := os.Stat(name); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	db, err := open(name, true)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(sessionsBucket)
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			session, err := session(k, v)
+			if err != nil {
+				return err
+			}
+			s.sessions = append(s.sessions, session)
+			return nil
+		})
+	})
+}
+
+func (s *storeSource) Next() (sleep.Session, error) {
+	if len(s.sessions) == 0 {
+		return sleep.Session{}, io.EOF
+	}
+	session := s.sessions[0]
+	s.sessions = s.sessions[1:]
+	return session, nil
+}
+
+func (s *storeSource) Close() error {
+	return nil
+}
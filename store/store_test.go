@@ -0,0 +1,96 @@
+package store
+
+import (
+	"io"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"sleep-stats/sleep"
+)
+
+func TestImport(t *testing.T) {
+	start := time.Date(2024, 1, 1, 22, 0, 0, 0, time.FixedZone("", 3600))
+	session := func(hours int, stage sleep.Stage, sourceName string) sleep.Session {
+		return sleep.Session{Start: start.Add(time.Duration(hours) * time.Hour), End: start.Add(time.Duration(hours+1) * time.Hour),
+			Stage: stage, SourceName: sourceName, ProductType: "Watch6,1", Offset: 3600}
+	}
+	tests := []struct {
+		name       string
+		sessions   []sleep.Session
+		added      int
+		duplicates int
+	}{
+		{"first export", []sleep.Session{session(0, sleep.Core, "Watch"), session(1, sleep.Deep, "Watch")}, 2, 0},
+		{"same export again", []sleep.Session{session(0, sleep.Core, "Watch"), session(1, sleep.Deep, "Watch")}, 0, 2},
+		{"overlapping export", []sleep.Session{session(1, sleep.Deep, "Watch"), session(2, sleep.REM, "Watch")}, 1, 1},
+		{"repeated within the import", []sleep.Session{session(3, sleep.Core, "Watch"), session(3, sleep.Core, "Watch")}, 1, 1},
+		{"other stage or source", []sleep.Session{session(0, sleep.Awake, "Watch"), session(0, sleep.Core, "Phone")}, 2, 0},
+	}
+	path := filepath.Join(t.TempDir(), "sessions.db")
+	total := 0
+	for _, test := range tests {
+		result, err := Import(path, test.sessions)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if result.Added != test.added || result.Duplicates != test.duplicates {
+			t.Errorf("%s: added %d and rejected %d duplicates, want %d and %d", test.name, result.Added, result.Duplicates, test.added, test.duplicates)
+		}
+		total += test.added
+	}
+
+	s := &storeSource{}
+	if err := s.Open(path); err != nil {
+		t.Fatal(err)
+	}
+	var read []sleep.Session
+	for {
+		session, err := s.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		read = append(read, session)
+	}
+	if len(read) != total {
+		t.Fatalf("read %d sessions, want the %d imported", len(read), total)
+	}
+	want := session(0, sleep.Awake, "Watch")
+	if got := read[0]; !got.Start.Equal(want.Start) || got.Stage != want.Stage || got.SourceName != want.SourceName ||
+		got.ProductType != want.ProductType || got.Offset != want.Offset || got.Start.Format(time.RFC3339) != want.Start.Format(time.RFC3339) {
+		t.Errorf("read the first session as %+v, want %+v", got, want)
+	}
+}
+
+func TestLastImported(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions.db")
+	first := time.Date(2024, 1, 1, 22, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name  string
+		input string
+		set   time.Time // recorded before looking it up unless zero
+		want  time.Time
+	}{
+		{"no store yet", "export.csv", time.Time{}, time.Time{}},
+		{"first import", "export.csv", first, first},
+		{"newer export", "export.csv", first.AddDate(0, 1, 0), first.AddDate(0, 1, 0)},
+		{"other input", "fitbit.json", time.Time{}, time.Time{}},
+	}
+	for _, test := range tests {
+		if !test.set.IsZero() {
+			if err := SetLastImported(path, test.input, test.set); err != nil {
+				t.Fatalf("%s: %v", test.name, err)
+			}
+		}
+		got, err := LastImported(path, test.input)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if !got.Equal(test.want) {
+			t.Errorf("%s: last imported %v, want %v", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"time"
+
+	"golang.org/x/exp/maps"
+
+	"sleep-stats/sleep"
+)
+
+// nightSink receives a night with its derived metrics as soon as it is complete
+type nightSink func(night *sleep.Night, derived derivedStats) error
+
+// nightStream groups the sessions into nights like sleep.GroupByNight while they are read,
+// passing each night on once a session starting two dates later shows it is complete. Exports are in the order of the start, the day of slack lets the in bed rows of a
+// night follow its stages.
+type nightStream struct {
+	open   map[string]*sleep.Night // the nights not passed on yet by their key
+	cutoff time.Duration           // the sessions starting before this time of day are of the night before
+	done   string                  // the key of the latest night passed on
+	emit   func(night *sleep.Night) error
+}
+
+func (s *nightStream) add(sessions ...sleep.Session) error {
+	for _, session := range sessions {
+		date := sleep.NightDate(session.Start, s.cutoff)
+		key := date.Format(sleep.DateLayout)
+		if key <= s.done {
+			return fmt.Errorf("the sessions of %s follow those of later nights in the file, which are written as they are read, sort the file by the start or use -output json", key)
+		}
+		if s.open == nil {
+			s.open = make(map[string]*sleep.Night)
+		}
+		night, ok := s.open[key]
+		if !ok {
+			night = &sleep.Night{Date: date}
+			s.open[key] = night
+		}
+		night.Sessions = append(night.Sessions, session)
+		if err := s.emitBefore(date.AddDate(0, 0, -1).Format(sleep.DateLayout)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// close passes on the nights left once all sessions are read
+func (s *nightStream) close() error {
+	return s.emitBefore("9999-12-31")
+}
+
+// emitBefore passes on the open nights before the key in order
+func (s *nightStream) emitBefore(key string) error {
+	keys := maps.Keys(s.open)
+	slices.Sort(keys)
+	for _, k := range keys {
+		if k >= key {
+			break
+		}
+		night := s.open[k]
+		delete(s.open, k)
+		sort.SliceStable(night.Sessions, func(i, j int) bool { return night.Sessions[i].Start.Before(night.Sessions[j].Start) })
+		s.done = k
+		if err := s.emit(night); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"sleep-stats/sleep"
+)
+
+func TestNightStream(t *testing.T) {
+	at := func(day, hour int) sleep.Session {
+		start := time.Date(2024, 1, day, hour, 0, 0, 0, time.UTC)
+		return sleep.Session{Start: start, End: start.Add(time.Hour), Stage: sleep.Core}
+	}
+	tests := []struct {
+		name     string
+		sessions []sleep.Session
+		cutoff   time.Duration
+		nights   []string // the keys of the nights in the order they are passed on
+		early    int      // the nights passed on before the stream is closed
+		err      string
+	}{
+		{"in order", []sleep.Session{at(1, 22), at(2, 1), at(2, 22), at(3, 22), at(4, 22)}, 0,
+			[]string{"2024-01-01", "2024-01-02", "2024-01-03", "2024-01-04"}, 2, ""},
+		{"in bed after the stages", []sleep.Session{at(1, 23), at(2, 22), at(1, 22)}, 0,
+			[]string{"2024-01-01", "2024-01-02"}, 0, ""},
+		{"noon cutoff", []sleep.Session{at(1, 22), at(2, 2), at(2, 11), at(2, 12), at(3, 3)}, 12 * time.Hour,
+			[]string{"2024-01-01", "2024-01-02"}, 0, ""},
+		{"noon cutoff in order", []sleep.Session{at(1, 22), at(2, 2), at(2, 22), at(3, 2), at(3, 22), at(5, 1)}, 12 * time.Hour,
+			[]string{"2024-01-01", "2024-01-02", "2024-01-03", "2024-01-04"}, 2, ""},
+		{"out of order", []sleep.Session{at(1, 22), at(3, 22), at(4, 22), at(1, 23)}, 0,
+			nil, 0, "the sessions of 2024-01-01 follow those of later nights"},
+	}
+	for _, test := range tests {
+		var nights []*sleep.Night
+		s := &nightStream{cutoff: test.cutoff, emit: func(night *sleep.Night) error {
+			nights = append(nights, night)
+			return nil
+		}}
+		var err error
+		for _, session := range test.sessions {
+			if err = s.add(session); err != nil {
+				break
+			}
+		}
+		early := len(nights)
+		if err == nil {
+			err = s.close()
+		}
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s: got the error %v, want %q", test.name, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+
+		var keys []string
+		for _, night := range nights {
+			keys = append(keys, night.Key())
+		}
+		if strings.Join(keys, " ") != strings.Join(test.nights, " ") {
+			t.Errorf("%s: passed on the nights %v, want %v", test.name, keys, test.nights)
+		}
+		if early != test.early {
+			t.Errorf("%s: passed on %d nights before closing, want %d", test.name, early, test.early)
+		}
+		// the same nights as grouping all sessions at once
+		grouped := sleep.GroupByNight(test.sessions, test.cutoff)
+		for i, night := range nights {
+			if i >= len(grouped) || len(night.Sessions) != len(grouped[i].Sessions) || !night.Sessions[0].Start.Equal(grouped[i].Sessions[0].Start) {
+				t.Errorf("%s: the night %s differs from grouping the sessions at once", test.name, night.Key())
+			}
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"slices"
+	"time"
+
+	"gonum.org/v1/gonum/stat"
+
+	"sleep-stats/sleep"
+)
+
+// the days the averages of the summary are over, ending with the last night
+const summaryDays = 7
+
+// the days the trend of the summary is fitted over, ending with the last night
+const summaryTrendDays = 28
+
+// summary is the fixed schema of -output json-compact, small enough for iOS Shortcuts, Scriptable
+// widgets and menu bar apps. The durations are whole minutes so they need no parsing, and the keys
+// are always there.
+type summary struct {
+	Date string       `json:"date"` // of the last night
+	Last summaryNight `json:"last"`
+	// the averages of the nights of the last 7 days up to the last night
+	Week summaryNight `json:"week"`
+	// the trend of the total sleep over the last 28 days, improving, stable or declining, unknown
+	// with fewer than 3 nights
+	Trend string `json:"trend"`
+}
+
+type summaryNight struct {
+	Nights int `json:"nights"`
+	Asleep int `json:"asleep"`
+	InBed  int `json:"inBed"`
+	Core   int `json:"core"`
+	Deep   int `json:"deep"`
+	REM    int `json:"rem"`
+	Awake  int `json:"awake"`
+	Score  int `json:"score"`
+}
+
+// summaryCommand prints the last night, the averages of the last week and the trend, e.g.
+// sleep-stats summary -output json-compact -file export.csv
+func summaryCommand(fs *flag.FlagSet) func(ctx context.Context) error {
+	input := addInputFlags(fs)
+	output := fs.String("output", "table", "format of the summary, table or json-compact for a single line of JSON with a fixed schema for widgets and shortcuts")
+	return func(ctx context.Context) error {
+		if *output != "json-compact" && !isTable(*output) {
+			return &exitError{fmt.Errorf("unknown output %q, use table or json-compact", *output), exitUsage}
+		}
+		data, err := input.analyze(ctx)
+		if err != nil {
+			return err
+		}
+		if len(data.nights) == 0 {
+			return &exitError{errors.New("no sleep data found"), exitNoNights}
+		}
+		s := summarize(data)
+		if *output == "json-compact" {
+			err = json.NewEncoder(os.Stdout).Encode(s)
+		} else {
+			writeSummary(os.Stdout, s)
+		}
+		if err != nil {
+			return err
+		}
+		data.printExcluded(input.diagnostics())
+		return skippedRows(data.skipped)
+	}
+}
+
+// summarize sums up the last night, the week up to it and the trend before it
+func summarize(data *nightData) summary {
+	last := data.nights[len(data.nights)-1]
+	end := last.Date.AddDate(0, 0, 1)
+	s := summary{
+		Date:  last.Key(),
+		Last:  summarizeNights([]*sleep.Night{last}, data.derived),
+		Week:  summarizeNights(nightsBetween(data.nights, end.AddDate(0, 0, -summaryDays), end), data.derived),
+		Trend: "unknown",
+	}
+	recent := *data
+	recent.nights = nightsBetween(data.nights, end.AddDate(0, 0, -summaryTrendDays), end)
+	trends := calculateTrends(&recent)
+	if i := slices.IndexFunc(trends, func(t trend) bool { return t.metric == "total" }); i >= 0 {
+		s.Trend = trends[i].class()
+	}
+	return s
+}
+
+// summarizeNights averages the nights in whole minutes and score points
+func summarizeNights(nights []*sleep.Night, derived derivedStats) summaryNight {
+	mean := func(value func(night *sleep.Night) float64) int {
+		values := make([]float64, len(nights))
+		for i, night := range nights {
+			values[i] = value(night)
+		}
+		return int(math.Round(stat.Mean(values, nil)))
+	}
+	minutes := func(stage sleep.Stage) int {
+		return mean(func(night *sleep.Night) float64 { return night.Time(stage).Minutes() })
+	}
+	return summaryNight{
+		Nights: len(nights),
+		Asleep: mean(func(night *sleep.Night) float64 { return night.TotalAsleep().Minutes() }),
+		InBed:  minutes(sleep.InBed),
+		Core:   minutes(sleep.Core),
+		Deep:   minutes(sleep.Deep),
+		REM:    minutes(sleep.REM),
+		Awake:  minutes(sleep.Awake),
+		Score:  mean(func(night *sleep.Night) float64 { return derived.scores[night.Key()] }),
+	}
+}
+
+// writeSummary prints the summary for reading
+func writeSummary(w io.Writer, s summary) {
+	fmt.Fprintf(w, "%-16s %8s %8s %8s %8s %8s %8s %6s\n", "", "Asleep", "In bed", "Core", "Deep", "REM", "Awake", "Score")
+	for _, row := range []struct {
+		label string
+		night summaryNight
+	}{
+		{"Last night " + s.Date[5:], s.Last},
+		{fmt.Sprintf("%d-day average", summaryDays), s.Week},
+	} {
+		n := row.night
+		duration := func(minutes int) string { return formatDuration(time.Duration(minutes) * time.Minute) }
+		fmt.Fprintf(w, "%-16s %8s %8s %8s %8s %8s %8s %6d\n", row.label, duration(n.Asleep), duration(n.InBed),
+			duration(n.Core), duration(n.Deep), duration(n.REM), duration(n.Awake), n.Score)
+	}
+	fmt.Fprintf(w, "Total sleep over the last %d days: %s\n", summaryTrendDays, s.Trend)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"io"
+
+	"gonum.org/v1/plot"
+	"gonum.org/v1/plot/vg"
+	"gonum.org/v1/plot/vg/draw"
+	"gonum.org/v1/plot/vg/vgsvg"
+)
+
+// the radius around a point that shows its tooltip when hovered
+const tooltipRadius = 4
+
+// tooltip is the text shown when hovering the point at x, y of a plot in a browser
+type tooltip struct {
+	x, y       float64
+	date, text string
+}
+
+// tooltips is a plotter that draws nothing, on an svgCanvas it places the tooltips where their
+// points end up so the SVG gets an invisible circle with a title over each
+type tooltips []tooltip
+
+func (t tooltips) Plot(c draw.Canvas, plt *plot.Plot) {
+	svg, ok := c.Canvas.(*svgCanvas)
+	if !ok {
+		return
+	}
+	trX, trY := plt.Transforms(&c)
+	for _, tip := range t {
+		at := vg.Point{X: trX(tip.x), Y: trY(tip.y)}
+		if c.Contains(at) {
+			svg.tooltips = append(svg.tooltips, placedTooltip{at, tip})
+		}
+	}
+}
+
+type placedTooltip struct {
+	at vg.Point
+	tooltip
+}
+
+// svgCanvas is an SVG canvas that adds the tooltips placed on it after everything drawn, so they
+// are on top and get the hovers
+type svgCanvas struct {
+	*vgsvg.Canvas
+	height   vg.Length
+	tooltips []placedTooltip
+}
+
+func newSVG(width, height vg.Length) *svgCanvas {
+	return &svgCanvas{Canvas: vgsvg.New(width, height), height: height}
+}
+
+func (c *svgCanvas) WriteTo(w io.Writer) (int64, error) {
+	var buf bytes.Buffer
+	if _, err := c.Canvas.WriteTo(&buf); err != nil {
+		return 0, err
+	}
+	if len(c.tooltips) > 0 {
+		buf.Truncate(len(bytes.TrimSuffix(buf.Bytes(), []byte("</svg>\n"))))
+		// the y axis of the SVG points down, the canvas' up
+		fmt.Fprintln(&buf, `<g fill="black" fill-opacity="0">`)
+		for _, tip := range c.tooltips {
+			fmt.Fprintf(&buf, "<circle cx=\"%.5g\" cy=\"%.5g\" r=\"%d\" data-date=\"%s\"><title>%s</title></circle>\n",
+				tip.at.X.Points(), (c.height - tip.at.Y).Points(), tooltipRadius, html.EscapeString(tip.date), html.EscapeString(tip.text))
+		}
+		fmt.Fprintln(&buf, "</g>\n</svg>")
+	}
+	return buf.WriteTo(w)
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+
+	"gonum.org/v1/plot"
+	"gonum.org/v1/plot/palette"
+	"gonum.org/v1/plot/plotter"
+	"gonum.org/v1/plot/text"
+	"gonum.org/v1/plot/vg"
+
+	"sleep-stats/sleep"
+)
+
+// the stages of the transition matrix in the order of its rows and columns, from deep to awake
+var transitionStages = []sleep.Stage{sleep.Deep, sleep.Core, sleep.REM, sleep.Asleep, sleep.Awake}
+
+// transitionMatrix counts how often a session of the stage of the row is followed by one of the
+// stage of the column
+type transitionMatrix [][]int
+
+// countTransitions counts the changes of stage from one session to the next in each night. Being
+// in bed overlaps the stages so it is left out, as are the sessions continuing the same stage.
+func countTransitions(nights []*sleep.Night) transitionMatrix {
+	m := make(transitionMatrix, len(transitionStages))
+	for i := range m {
+		m[i] = make([]int, len(transitionStages))
+	}
+	for _, night := range nights {
+		prev := -1
+		for _, session := range night.Sessions {
+			stage := slices.Index(transitionStages, session.Stage)
+			if stage < 0 {
+				continue
+			}
+			if prev >= 0 && prev != stage {
+				m[prev][stage]++
+			}
+			prev = stage
+		}
+	}
+	return m
+}
+
+// the transitions out of the stage of the row
+func (m transitionMatrix) from(row int) int {
+	total := 0
+	for _, n := range m[row] {
+		total += n
+	}
+	return total
+}
+
+// the share of the transitions out of the stage of the row going to the stage of the column
+func (m transitionMatrix) share(row, col int) float64 {
+	total := m.from(row)
+	if total == 0 {
+		return 0
+	}
+	return float64(m[row][col]) / float64(total)
+}
+
+// the stages that occur in the matrix, so nights with stages don't show a row for plain asleep
+func (m transitionMatrix) stages() []int {
+	var used []int
+	for i := range transitionStages {
+		occurs := m.from(i) > 0
+		for j := range m {
+			occurs = occurs || m[j][i] > 0
+		}
+		if occurs {
+			used = append(used, i)
+		}
+	}
+	return used
+}
+
+// transitionGrid is the heat map of the shares of the used stages
+type transitionGrid struct {
+	m    transitionMatrix
+	used []int
+}
+
+func (g transitionGrid) Dims() (c, r int) { return len(g.used), len(g.used) }
+func (g transitionGrid) X(c int) float64  { return float64(c) }
+func (g transitionGrid) Y(r int) float64  { return float64(r) }
+func (g transitionGrid) Z(c, r int) float64 {
+	// a stage can't follow itself, its cells are below the range of the colors and left blank
+	if c == r {
+		return -1
+	}
+	return g.m.share(g.used[r], g.used[c]) * 100
+}
+
+// analyzeTransitions prints how often each stage is followed by each other one over the nights, or
+// only the night of -date, and charts the shares as a heat map
+func analyzeTransitions(ctx context.Context, w io.Writer, data *nightData, opts analyzeOptions) error {
+	nights := data.nights
+	title := fmt.Sprintf("%d nights", len(nights))
+	if opts.date != "" {
+		i := slices.IndexFunc(nights, func(night *sleep.Night) bool { return night.Key() == opts.date || night.Day() == opts.date })
+		if i < 0 {
+			return fmt.Errorf("no night on %s", opts.date)
+		}
+		nights, title = nights[i:i+1], "the night of "+opts.date
+	}
+	m := countTransitions(nights)
+	used := m.stages()
+	if len(used) < 2 {
+		return errors.New("no changes of stage to analyze, the nights need sessions of their stages")
+	}
+
+	fmt.Fprintf(w, "Stage transitions over %s, the share of the changes from the stage of the row:\n", title)
+	fmt.Fprintf(w, "%-12s", "from \\ to")
+	for _, col := range used {
+		fmt.Fprintf(w, " %11s", transitionStages[col])
+	}
+	fmt.Fprintf(w, " %7s\n", "changes")
+	for _, row := range used {
+		fmt.Fprintf(w, "%-12s", transitionStages[row])
+		for _, col := range used {
+			if row == col {
+				fmt.Fprintf(w, " %11s", "-")
+				continue
+			}
+			fmt.Fprintf(w, " %10.0f%%", m.share(row, col)*100)
+		}
+		fmt.Fprintf(w, " %7d\n", m.from(row))
+	}
+	deep, awake, rem := slices.Index(transitionStages, sleep.Deep), slices.Index(transitionStages, sleep.Awake), slices.Index(transitionStages, sleep.REM)
+	if m.from(deep) > 0 {
+		fmt.Fprintf(w, "\nDeep sleep ends in waking up %.0f%% and in REM %.0f%% of the time.\n", m.share(deep, awake)*100, m.share(deep, rem)*100)
+	}
+
+	if opts.chart == "" {
+		return nil
+	}
+	p := plot.New()
+	p.Title.Text = "Stage Transitions (% of the changes from each stage)"
+	p.X.Label.Text = "To"
+	p.Y.Label.Text = "From"
+	names := make([]string, len(used))
+	for i, stage := range used {
+		names[i] = transitionStages[stage].String()
+	}
+	p.NominalX(names...)
+	p.NominalY(names...)
+	heat := plotter.NewHeatMap(transitionGrid{m: m, used: used}, palette.Heat(12, 1))
+	heat.Min, heat.Max = 0, 100
+	p.Add(heat)
+
+	var labels plotter.XYLabels
+	for r, row := range used {
+		for c, col := range used {
+			if r == c {
+				continue
+			}
+			labels.XYs = append(labels.XYs, plotter.XY{X: float64(c), Y: float64(r)})
+			labels.Labels = append(labels.Labels, fmt.Sprintf("%.0f%%\n%d", m.share(row, col)*100, m[row][col]))
+		}
+	}
+	cells, err := plotter.NewLabels(labels)
+	if err != nil {
+		return err
+	}
+	for i := range cells.TextStyle {
+		cells.TextStyle[i].XAlign, cells.TextStyle[i].YAlign = text.XCenter, text.YCenter
+	}
+	p.Add(cells)
+	return writeChart(ctx, p, 7*vg.Inch, 6*vg.Inch, opts.chart)
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"image/color"
+	"io"
+	"math"
+	"slices"
+	"time"
+
+	"gonum.org/v1/gonum/stat"
+
+	"sleep-stats/sleep"
+)
+
+// the shift of the sleep midpoint from the nights before, in hours, taken as travel
+const travelShift = 3.0
+
+// the nights at home the midpoint of a night is compared with
+const travelWindow = 7
+
+// a shift of the midpoint lasting longer than this many nights is a new schedule, not a trip
+const maxTravelNights = 28
+
+// travelSpan is a run of nights probably spent away, in another time zone
+type travelSpan struct {
+	first, last time.Time // the dates of the first and last night away
+	nights      int
+	reason      string // why the first night looked like travel
+}
+
+// detectTravel finds the nights recorded with another UTC offset than most nights, or with the
+// middle of the sleep shifted by travelShift hours or more from the median of the nights before.
+// A shift of a single night is a late night rather than a trip and is left out.
+func detectTravel(nights []*sleep.Night) []travelSpan {
+	if len(nights) == 0 {
+		return nil
+	}
+	counts := make(map[int]int)
+	for _, night := range nights {
+		counts[night.Sessions[0].Offset]++
+	}
+	home := nights[0].Sessions[0].Offset
+	for offset, n := range counts {
+		if n > counts[home] || n == counts[home] && offset < home {
+			home = offset
+		}
+	}
+
+	var spans []travelSpan
+	var recent, away []float64 // the midpoints of the last nights at home and of the current span
+	var span *travelSpan
+	shifted := false // whether the current span is only seen in the midpoints
+	closeSpan := func() {
+		if span != nil && (!shifted || span.nights > 1) {
+			spans = append(spans, *span)
+		}
+		span, away = nil, nil
+	}
+	for _, night := range nights {
+		offset, mid := night.Sessions[0].Offset, sleepMidpoint(night)
+		var reason string
+		switch {
+		case offset != home:
+			reason = "recorded at UTC" + formatOffset(offset)
+		case len(recent) >= 3:
+			// the shift around the clock, 11pm is an hour before midnight rather than 23 hours after
+			if shift := math.Remainder(mid-median(recent), 24); math.Abs(shift) >= travelShift {
+				reason = fmt.Sprintf("sleep shifted %+.1fh", shift)
+			}
+		}
+		if reason == "" {
+			closeSpan()
+			recent = append(recent, mid)
+			recent = recent[max(0, len(recent)-travelWindow):]
+			continue
+		}
+		if span == nil {
+			span = &travelSpan{first: night.Date, reason: reason}
+			shifted = true
+		}
+		shifted = shifted && offset == home
+		span.last = night.Date
+		span.nights++
+		away = append(away, mid)
+		if shifted && span.nights > maxTravelNights {
+			// the schedule changed, the nights of the span are the new normal
+			recent = away[len(away)-travelWindow:]
+			span, away = nil, nil
+		}
+	}
+	closeSpan()
+	return spans
+}
+
+// sleepMidpoint is the hour of the middle between falling asleep and the final waking, from -12
+// to 12 so nights around midnight don't jump
+func sleepMidpoint(n *sleep.Night) float64 {
+	night := calculateClinicalNight(n)
+	start, end := night.sleepOnset, night.finalWaking
+	if start.IsZero() {
+		start, end = n.Sessions[0].Start, n.Sessions[len(n.Sessions)-1].End
+	}
+	mid := start.Add(end.Sub(start) / 2)
+	hour := float64(mid.Hour()) + float64(mid.Minute())/60
+	if hour >= 12 {
+		hour -= 24
+	}
+	return hour
+}
+
+func median(values []float64) float64 {
+	sorted := slices.Clone(values)
+	slices.Sort(sorted)
+	return stat.Quantile(0.5, stat.Empirical, sorted, nil)
+}
+
+// formatOffset formats a UTC offset in seconds like +02:00
+func formatOffset(offset int) string {
+	sign := "+"
+	if offset < 0 {
+		sign, offset = "-", -offset
+	}
+	return fmt.Sprintf("%s%02d:%02d", sign, offset/3600, offset%3600/60)
+}
+
+// travelMarkers shade the nights of the trips on a plot of the nights
+func travelMarkers(spans []travelSpan) spanMarkers {
+	m := spanMarkers{fill: color.RGBA{R: 80, G: 160, B: 255, A: 50}, label: color.RGBA{R: 30, G: 90, B: 170, A: 255}}
+	for _, span := range spans {
+		m.spans = append(m.spans, nightSpan{"travel", span.first, span.last})
+	}
+	return m
+}
+
+// untrendedNights returns the keys of the nights of the spans, which the trend fits leave out
+func untrendedNights(nights []*sleep.Night, spans []travelSpan) map[string]bool {
+	keys := make(map[string]bool)
+	for _, night := range nights {
+		for _, span := range spans {
+			if !night.Date.Before(span.first) && !night.Date.After(span.last) {
+				keys[night.Key()] = true
+			}
+		}
+	}
+	return keys
+}
+
+// writeTravel prints the spans of probable travel
+func writeTravel(w io.Writer, spans []travelSpan) {
+	if len(spans) == 0 {
+		fmt.Fprintln(w, "\nNo travel detected.")
+		return
+	}
+	fmt.Fprintln(w, "\nProbable travel:")
+	for _, span := range spans {
+		fmt.Fprintf(w, "  %s to %s  %2d nights  %s\n", span.first.Format(sleep.DateLayout), span.last.Format(sleep.DateLayout), span.nights, span.reason)
+	}
+}
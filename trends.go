@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"slices"
+
+	"gonum.org/v1/gonum/stat"
+	"gonum.org/v1/gonum/stat/distuv"
+
+	"sleep-stats/sleep"
+)
+
+// the significance level below which a slope is a trend instead of noise
+const trendSignificance = 0.05
+
+// whether more of a metric is better (1) or worse (-1), the others only rise or fall
+var metricDirections = map[string]float64{
+	"total":      1,
+	"longest":    1,
+	"deep":       1,
+	"rem":        1,
+	"awake":      -1,
+	"awakeCount": -1,
+	"score":      1,
+}
+
+// trend is the linear change of a metric over the nights
+type trend struct {
+	metric string
+	slope  float64 // per week, in minutes for the durations
+	p      float64 // the probability of a slope at least as steep without a trend
+}
+
+// calculateTrends fits a line to each metric over the dates of the nights, weekday is left out as
+// it is no measurement and asleep as it is the same as total
+func calculateTrends(data *nightData) []trend {
+	if len(data.nights) == 0 {
+		return nil
+	}
+	first := data.nights[0].Date
+	var weeks []float64
+	var vars []map[string]float64
+	for _, night := range data.nights {
+		if data.untrended[night.Key()] {
+			continue
+		}
+		weeks = append(weeks, night.Date.Sub(first).Hours()/(7*24))
+		vars = append(vars, allNightVars(night, data.derived))
+	}
+	if len(vars) < 3 {
+		return nil
+	}
+
+	var trends []trend
+	for _, name := range append(slices.Clone(baseMetricNames), data.derived.names...) {
+		if name == "weekday" || name == "asleep" {
+			continue
+		}
+		ys := make([]float64, len(vars))
+		for i := range vars {
+			ys[i] = vars[i][name]
+		}
+		slope, p := regressionSlope(weeks, ys)
+		if isDuration(name) {
+			slope *= 60
+		}
+		trends = append(trends, trend{name, slope, p})
+	}
+	return trends
+}
+
+// regressionSlope returns the slope of the least squares line and its two-sided p-value
+func regressionSlope(xs, ys []float64) (float64, float64) {
+	alpha, beta := stat.LinearRegression(xs, ys, nil, false)
+	n := float64(len(xs))
+	var residuals, spread float64
+	meanX := stat.Mean(xs, nil)
+	for i := range xs {
+		r := ys[i] - alpha - beta*xs[i]
+		residuals += r * r
+		spread += (xs[i] - meanX) * (xs[i] - meanX)
+	}
+	if spread == 0 {
+		return 0, 1
+	}
+	se := math.Sqrt(residuals / (n - 2) / spread)
+	if se == 0 {
+		if beta == 0 {
+			return 0, 1
+		}
+		return beta, 0
+	}
+	t := distuv.StudentsT{Mu: 0, Sigma: 1, Nu: n - 2}
+	return beta, 2 * t.CDF(-math.Abs(beta/se))
+}
+
+// the base metrics in hours, the counts and score aren't
+func isDuration(name string) bool {
+	return slices.Contains(baseMetricNames, name) && name != "awakeCount" && name != "weekday" && name != "score"
+}
+
+func (t trend) class() string {
+	if t.p >= trendSignificance || t.slope == 0 {
+		return "stable"
+	}
+	direction, known := metricDirections[t.metric]
+	switch {
+	case !known && t.slope > 0:
+		return "rising"
+	case !known:
+		return "falling"
+	case t.slope*direction > 0:
+		return "improving"
+	}
+	return "declining"
+}
+
+// writeTrends prints whether each metric improved, stayed stable or declined over the nights
+func writeTrends(w io.Writer, data *nightData) {
+	trends := calculateTrends(data)
+	if len(trends) == 0 {
+		fmt.Fprintln(w, "\nTrends need at least 3 nights.")
+		return
+	}
+	first, last := data.nights[0].Date, data.nights[len(data.nights)-1].Date
+	nights := fmt.Sprintf("%d nights", len(data.nights))
+	if len(data.untrended) > 0 {
+		nights = fmt.Sprintf("%d nights, %d of travel left out", len(data.nights)-len(data.untrended), len(data.untrended))
+	}
+	fmt.Fprintf(w, "\nTrends from %s to %s (%s):\n", first.Format(sleep.DateLayout), last.Format(sleep.DateLayout), nights)
+	for _, t := range trends {
+		change := fmt.Sprintf("%+.2f/week", t.slope)
+		if isDuration(t.metric) {
+			change = fmt.Sprintf("%+.1f min/week", t.slope)
+		}
+		fmt.Fprintf(w, "  %-12s %-10s %16s  (p=%.3f)\n", t.metric, t.class(), change, t.p)
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"sleep-stats/sleep"
+)
+
+// the metrics that can be toggled as columns in the night list, in key order 1-7
+var tuiMetrics = []struct {
+	label string
+	value func(night *sleep.Night, derived derivedStats) string
+}{
+	{"Bed", stageColumn(sleep.InBed)},
+	{"Core", stageColumn(sleep.Core)},
+	{"REM", stageColumn(sleep.REM)},
+	{"Deep", stageColumn(sleep.Deep)},
+	{"Awake", stageColumn(sleep.Awake)},
+	{"Count", func(night *sleep.Night, _ derivedStats) string { return fmt.Sprint(night.InBedCount()) }},
+	{"Score", func(night *sleep.Night, derived derivedStats) string {
+		return fmt.Sprintf("%.0f", derived.scores[night.Key()])
+	}},
+}
+
+func stageColumn(stage sleep.Stage) func(night *sleep.Night, derived derivedStats) string {
+	return func(night *sleep.Night, _ derivedStats) string { return formatDuration(night.Time(stage)) }
+}
+
+const tuiListWidth = 12 // date column plus padding, each metric column adds 8
+
+type tuiModel struct {
+	nights    []*sleep.Night // all nights in date order
+	derived   derivedStats
+	visible   []*sleep.Night // nights matching the filter
+	cursor    int
+	offset    int
+	height    int
+	filter    string
+	filtering bool
+	shown     []bool
+}
+
+// tuiCommand starts an interactive terminal explorer of the nights
+func tuiCommand(fs *flag.FlagSet) func(ctx context.Context) error {
+	input := addInputFlags(fs)
+	return func(ctx context.Context) error { return runTUI(ctx, input) }
+}
+
+func runTUI(ctx context.Context, input inputFlags) error {
+	data, err := input.analyze(ctx)
+	if err != nil {
+		return err
+	}
+
+	m := &tuiModel{
+		nights:  data.nights,
+		derived: data.derived,
+		height:  20,
+		shown:   []bool{true, true, true, true, true, false, true},
+	}
+	m.applyFilter()
+
+	if _, err := tea.NewProgram(m, tea.WithAltScreen(), tea.WithContext(ctx)).Run(); err != nil {
+		return err
+	}
+	data.printExcluded(input.diagnostics())
+	return skippedRows(data.skipped)
+}
+
+func (m *tuiModel) Init() tea.Cmd {
+	return nil
+}
+
+func (m *tuiModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		// leave room for the header and help lines
+		m.height = max(1, msg.Height-4)
+		m.scroll()
+	case tea.KeyMsg:
+		if m.filtering {
+			m.updateFilter(msg)
+			return m, nil
+		}
+		switch msg.String() {
+		case "q", "ctrl+c":
+			return m, tea.Quit
+		case "up", "k":
+			m.cursor--
+		case "down", "j":
+			m.cursor++
+		case "pgup":
+			m.cursor -= m.height
+		case "pgdown":
+			m.cursor += m.height
+		case "home", "g":
+			m.cursor = 0
+		case "end", "G":
+			m.cursor = len(m.visible) - 1
+		case "/":
+			m.filtering = true
+		case "1", "2", "3", "4", "5", "6", "7":
+			i := int(msg.Runes[0] - '1')
+			m.shown[i] = !m.shown[i]
+		}
+		m.scroll()
+	}
+	return m, nil
+}
+
+// typing while filtering edits the date prefix, enter keeps it and esc clears it
+func (m *tuiModel) updateFilter(msg tea.KeyMsg) {
+	switch msg.Type {
+	case tea.KeyEnter:
+		m.filtering = false
+	case tea.KeyEsc:
+		m.filtering = false
+		m.filter = ""
+	case tea.KeyBackspace:
+		if len(m.filter) > 0 {
+			m.filter = m.filter[:len(m.filter)-1]
+		}
+	case tea.KeyRunes:
+		m.filter += string(msg.Runes)
+	}
+	m.applyFilter()
+}
+
+func (m *tuiModel) applyFilter() {
+	m.visible = m.visible[:0]
+	for _, night := range m.nights {
+		if strings.HasPrefix(night.Key(), m.filter) {
+			m.visible = append(m.visible, night)
+		}
+	}
+	m.scroll()
+}
+
+// keep the cursor in range and on screen
+func (m *tuiModel) scroll() {
+	m.cursor = max(0, min(m.cursor, len(m.visible)-1))
+	if m.cursor < m.offset {
+		m.offset = m.cursor
+	}
+	if m.cursor >= m.offset+m.height {
+		m.offset = m.cursor - m.height + 1
+	}
+}
+
+func (m *tuiModel) View() string {
+	var list []string
+	header := fmt.Sprintf("%-*s", tuiListWidth, "Date")
+	for i, metric := range tuiMetrics {
+		if m.shown[i] {
+			header += fmt.Sprintf("%-8s", metric.label)
+		}
+	}
+	list = append(list, header)
+
+	for i := m.offset; i < len(m.visible) && i < m.offset+m.height; i++ {
+		night := m.visible[i]
+		marker := "  "
+		if i == m.cursor {
+			marker = "> "
+		}
+		line := fmt.Sprintf("%s%-*s", marker, tuiListWidth-2, night.Key())
+		for j, metric := range tuiMetrics {
+			if m.shown[j] {
+				line += fmt.Sprintf("%-8s", metric.value(night, m.derived))
+			}
+		}
+		list = append(list, line)
+	}
+
+	var detail []string
+	if len(m.visible) > 0 {
+		detail = nightDetail(m.visible[m.cursor])
+		detail = detail[:min(len(detail), m.height+1)]
+	}
+
+	width := 0
+	for _, line := range list {
+		width = max(width, len(line))
+	}
+	var sb strings.Builder
+	for i := 0; i < max(len(list), len(detail)); i++ {
+		var left, right string
+		if i < len(list) {
+			left = list[i]
+		}
+		if i < len(detail) {
+			right = detail[i]
+		}
+		fmt.Fprintf(&sb, "%-*s  │ %s\n", width, left, right)
+	}
+
+	if m.filtering {
+		fmt.Fprintf(&sb, "\nfilter: %s█", m.filter)
+	} else {
+		fmt.Fprintf(&sb, "\n%d nights  filter: %q  ↑/↓ move  / filter  1-7 toggle columns  q quit", len(m.visible), m.filter)
+	}
+	return sb.String()
+}
+
+// the ordered sessions and totals of a night
+func nightDetail(night *sleep.Night) []string {
+	sessions := slices.Clone(night.Sessions)
+	sort.SliceStable(sessions, func(i, j int) bool { return sessions[i].Start.Before(sessions[j].Start) })
+
+	lines := []string{
+		night.Key(),
+		fmt.Sprintf("Asleep %s  Bed %s  Awake count %d", formatDuration(night.TotalAsleep()), formatDuration(night.Time(sleep.InBed)), night.InBedCount()),
+		"",
+	}
+	for _, session := range sessions {
+		lines = append(lines, fmt.Sprintf("%s-%s  %-10s %s", session.Start.Format("15:04"), session.End.Format("15:04"),
+			session.Stage, formatDuration(session.Duration())))
+	}
+	return lines
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"sleep-stats/sleep"
+	"sleep-stats/source"
+)
+
+// validateCommand reads the whole file and lists every row that can't be parsed, exiting with 1
+// when there is one so pipelines can check an export before using it, e.g.
+// sleep-stats validate -file export.csv || exit 1
+func validateCommand(fs *flag.FlagSet) func(ctx context.Context) error {
+	input := addInputFlags(fs)
+	return func(ctx context.Context) error {
+		*input.strict = false
+		sessions, skipped, err := input.load(ctx)
+		if err != nil {
+			return err
+		}
+		if !writeValidation(os.Stdout, sessions, skipped) {
+			return &exitError{code: 1}
+		}
+		return nil
+	}
+}
+
+// writeValidation lists the problems of the file and returns whether there are none
+func writeValidation(w io.Writer, sessions []sleep.Session, skipped []*source.RowError) bool {
+	for _, rowErr := range skipped {
+		fmt.Fprintf(w, "%v\n  %s\n", rowErr, rowErr.Text)
+		if hint := rowErrorHint(rowErr); hint != "" {
+			fmt.Fprintf(w, "  %s\n", hint)
+		}
+	}
+	_, implausible := excludeImplausible(sessions, time.Now())
+	for _, s := range implausible {
+		fmt.Fprintf(w, "implausible session %v\n", s)
+	}
+	if len(sessions) == 0 {
+		fmt.Fprintln(w, "no sleep sessions found, check -format and that the file has rows of a watch")
+	}
+
+	if len(skipped) > 0 || len(implausible) > 0 || len(sessions) == 0 {
+		fmt.Fprintf(w, "Invalid: %d rows can't be parsed, %d of the %d sessions read are implausible\n", len(skipped), len(implausible), len(sessions))
+		return false
+	}
+	fmt.Fprintf(w, "Valid: %d sessions read\n", len(sessions))
+	return true
+}
+
+// what to do about the error of a row, empty when there is nothing more to say than the error
+func rowErrorHint(rowErr *source.RowError) string {
+	switch {
+	case errors.Is(rowErr.Err, csv.ErrFieldCount):
+		return "the row has a different number of fields than the header, check -delimiter and the quoting of the fields"
+	case errors.Is(rowErr.Err, csv.ErrQuote), errors.Is(rowErr.Err, csv.ErrBareQuote):
+		return "a quote isn't closed or is inside an unquoted field, quotes in fields are written as two quotes"
+	case strings.HasSuffix(rowErr.Column, "Date"):
+		return "timestamps are expected like 2024-01-31 23:10:00 +0000"
+	case rowErr.Column == "value":
+		return fmt.Sprintf("stages are expected like asleepCore or HKCategoryValueSleepAnalysisAsleepCore, one of %v", sleep.Stages)
+	}
+	return ""
+}